@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// AccessMode controls who may read and edit a note
+type AccessMode string
+
+// Supported access modes
+const (
+	AccessPrivate AccessMode = "private" // only the owner and listed users
+	AccessTeam    AccessMode = "team"    // any signed-in user can read
+	AccessLink    AccessMode = "link"    // anyone with the link can read, editing needs the edit token
+	AccessPublic  AccessMode = "public"  // anyone can read and edit
+)
+
+// DefaultAccessMode is the access mode of new notes
+const DefaultAccessMode = AccessLink
+
+// maxACLEntries bounds the number of users listed as readers or editors
+const maxACLEntries = 50
+
+// ACLRequest represents the JSON payload for changing a note's access control list
+type ACLRequest struct {
+	NoteID    string     `json:"noteId"`
+	Access    AccessMode `json:"access"`
+	Readers   []string   `json:"readers"`
+	Editors   []string   `json:"editors"`
+	EditToken string     `json:"editToken,omitempty"`
+}
+
+// ACLResponse represents the JSON response of the ACL endpoint
+type ACLResponse struct {
+	Success bool       `json:"success"`
+	NoteID  string     `json:"noteId,omitempty"`
+	Owner   string     `json:"owner,omitempty"`
+	Access  AccessMode `json:"access,omitempty"`
+	Readers []string   `json:"readers,omitempty"`
+	Editors []string   `json:"editors,omitempty"`
+	Error   string     `json:"error,omitempty"`
+
+	// Only returned when the change gave a note without metadata its owner
+	EditToken string `json:"editToken,omitempty"`
+	ReadID    string `json:"readId,omitempty"`
+}
+
+// ValidAccessMode checks if mode is a supported access mode
+func ValidAccessMode(mode AccessMode) bool {
+	switch mode {
+	case AccessPrivate, AccessTeam, AccessLink, AccessPublic:
+		return true
+	}
+	return false
+}
+
+// AccessMode returns the effective access mode of a note. Notes without
+// metadata predate access control and stay public.
+func (m *NoteMeta) AccessMode() AccessMode {
+	switch {
+	case m == nil:
+		return AccessPublic
+	case m.Access == "":
+		return DefaultAccessMode
+	default:
+		return m.Access
+	}
+}
+
+// privileged reports whether p is the note's owner or an admin
+func (m *NoteMeta) privileged(p *Principal) bool {
+	if p.HasScope(ScopeAdmin) {
+		return true
+	}
+	return p != nil && m != nil && m.Owner != "" && strings.EqualFold(p.Name, m.Owner)
+}
+
+// listed reports whether p appears in one of the user lists
+func listed(p *Principal, lists ...[]string) bool {
+	if p == nil {
+		return false
+	}
+	for _, list := range lists {
+		if containsFold(list, p.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsRead reports whether p, holding token, may read the note
+func (m *NoteMeta) AllowsRead(p *Principal, token string) bool {
+	if m.privileged(p) || listed(p, m.readers(), m.editors()) {
+		return true
+	}
+	switch m.AccessMode() {
+	case AccessPublic, AccessLink:
+		return true
+	case AccessTeam:
+		return p != nil || (m.EditToken != "" && m.CanEdit(token))
+	default:
+		return false
+	}
+}
+
+// AllowsList reports whether the note may appear in namespace listings shown
+// to p. Random IDs are the only secret of link notes, so only public notes,
+// team notes for signed-in users and notes p may read by name are listed.
+func (m *NoteMeta) AllowsList(p *Principal) bool {
+	if m.privileged(p) || listed(p, m.readers(), m.editors()) {
+		return true
+	}
+	switch m.AccessMode() {
+	case AccessPublic:
+		return true
+	case AccessTeam:
+		return p != nil
+	default:
+		return false
+	}
+}
+
+// AllowsWrite reports whether p, holding token, may modify the note
+func (m *NoteMeta) AllowsWrite(p *Principal, token string) bool {
+	if m.privileged(p) || listed(p, m.editors()) {
+		return true
+	}
+	switch m.AccessMode() {
+	case AccessPublic:
+		return true
+	case AccessTeam, AccessLink:
+		return m.CanEdit(token)
+	default:
+		return false
+	}
+}
+
+// AllowsManage reports whether p, holding token, may change the note's ACL.
+// Notes without an owner are managed by the holders of their edit token;
+// notes without metadata can only be claimed by a signed-in user.
+func (m *NoteMeta) AllowsManage(p *Principal, token string) bool {
+	if m.privileged(p) {
+		return true
+	}
+	if m == nil {
+		return p != nil
+	}
+	return m.Owner == "" && m.CanEdit(token)
+}
+
+func (m *NoteMeta) readers() []string {
+	if m == nil {
+		return nil
+	}
+	return m.Readers
+}
+
+func (m *NoteMeta) editors() []string {
+	if m == nil {
+		return nil
+	}
+	return m.Editors
+}
+
+// normalizeUsers trims, deduplicates and validates a list of user names
+func normalizeUsers(users []string) ([]string, error) {
+	var result []string
+	for _, user := range users {
+		user = strings.TrimSpace(user)
+		if user == "" || containsFold(result, user) {
+			continue
+		}
+		if len(user) > 254 || strings.ContainsAny(user, ", \t\r\n") {
+			return nil, errors.New("invalid user name: " + user)
+		}
+		result = append(result, user)
+	}
+	if len(result) > maxACLEntries {
+		return nil, errors.New("too many users in access list")
+	}
+	return result, nil
+}
+
+// HandleACL handles POST requests to change who can read or edit a note
+func HandleACL(storage Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientIP := ClientIP(r)
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.Method != http.MethodPost {
+			writeACLError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSmallBodySize))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeACLError(w, http.StatusRequestEntityTooLarge, "Request too large")
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to read ACL request body", "error", err)
+			writeACLError(w, http.StatusInternalServerError, "Read error")
+			return
+		}
+		var req ACLRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			writeACLError(w, http.StatusBadRequest, "invalid JSON format")
+			return
+		}
+		if !ValidAccessMode(req.Access) {
+			writeACLError(w, http.StatusBadRequest, "Invalid access mode")
+			return
+		}
+		readers, err := normalizeUsers(req.Readers)
+		if err == nil {
+			req.Editors, err = normalizeUsers(req.Editors)
+		}
+		if err != nil {
+			writeACLError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		noteID, _, err := resolveNoteRef(r.Context(), storage, strings.TrimSpace(req.NoteID))
+		if errors.Is(err, errInvalidNoteRef) || (err == nil && noteID == "") {
+			writeACLError(w, http.StatusBadRequest, "Invalid note ID format")
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to resolve note", "note", req.NoteID, "error", err)
+			writeACLError(w, http.StatusInternalServerError, "Failed to update access")
+			return
+		}
+
+		meta, err := ReadNoteMeta(r.Context(), storage, noteID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to read note metadata", "note", noteID, "error", err)
+			writeACLError(w, http.StatusInternalServerError, "Failed to update access")
+			return
+		}
+		principal := PrincipalFromContext(r.Context())
+		token := req.EditToken
+		if token == "" {
+			token = editTokenFromRequest(r, NoteRequest{})
+		}
+		if !meta.AllowsManage(principal, token) {
+			slog.WarnContext(r.Context(), "Access change denied", "principal", principal.String(), "note", noteID, "ip", clientIP)
+			writeACLError(w, http.StatusForbidden, "Only the owner can change access")
+			return
+		}
+
+		if meta == nil {
+			// Notes created before metadata existed are claimed by the signed-in
+			// caller, who gets the new edit token and read-only link
+			content, err := storage.Read(r.Context(), noteID)
+			if err != nil || content == "" {
+				writeACLError(w, http.StatusNotFound, "Note not found")
+				return
+			}
+			claimed, status := claimNoteMeta(r.Context(), storage, noteID, req.Access, readers, req.Editors)
+			if claimed == nil {
+				writeACLError(w, status, "Failed to update access")
+				return
+			}
+			slog.InfoContext(r.Context(), "Note claimed", "note", noteID, "access", claimed.Access, "principal", principal.String())
+			_ = json.NewEncoder(w).Encode(ACLResponse{
+				Success:   true,
+				NoteID:    req.NoteID,
+				Owner:     claimed.Owner,
+				Access:    claimed.Access,
+				Readers:   claimed.Readers,
+				Editors:   claimed.Editors,
+				EditToken: claimed.EditToken,
+				ReadID:    claimed.ReadID,
+			})
+			return
+		}
+		if meta.Owner == "" && (req.Access == AccessPrivate || req.Access == AccessTeam) {
+			writeACLError(w, http.StatusBadRequest, "Sign in to make a note private or team-only")
+			return
+		}
+
+		meta.Access, meta.Readers, meta.Editors = req.Access, readers, req.Editors
+		if err := WriteNoteMeta(r.Context(), storage, noteID, meta); err != nil {
+			slog.ErrorContext(r.Context(), "Failed to write note metadata", "note", noteID, "error", err)
+			writeACLError(w, http.StatusInternalServerError, "Failed to update access")
+			return
+		}
+		slog.InfoContext(r.Context(), "Note access changed", "note", noteID, "access", meta.Access, "principal", principal.String(), "readers", len(readers), "editors", len(req.Editors))
+		_ = json.NewEncoder(w).Encode(ACLResponse{
+			Success: true,
+			NoteID:  req.NoteID,
+			Owner:   meta.Owner,
+			Access:  meta.Access,
+			Readers: meta.Readers,
+			Editors: meta.Editors,
+		})
+	}
+}
+
+// claimNoteMeta creates the metadata of a note without any, with the given
+// access, unless a concurrent request created it first. It returns the
+// metadata, or nil and the status to fail with.
+func claimNoteMeta(ctx context.Context, storage Storage, noteID string, access AccessMode, readers, editors []string) (*NoteMeta, int) {
+	meta, err := newNoteMeta(ctx, storage, noteID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to issue note metadata", "note", noteID, "error", err)
+		return nil, http.StatusInternalServerError
+	}
+	meta.Access, meta.Readers, meta.Editors = access, readers, editors
+	data, err := json.Marshal(meta)
+	if err == nil {
+		err = storage.Create(ctx, metaKey(noteID), string(data))
+	}
+	if err != nil {
+		_ = storage.Delete(ctx, shareKey(meta.ReadID))
+		if errors.Is(err, ErrNoteExists) {
+			return nil, http.StatusConflict
+		}
+		slog.ErrorContext(ctx, "Failed to write note metadata", "note", noteID, "error", err)
+		return nil, http.StatusInternalServerError
+	}
+	return meta, http.StatusOK
+}
+
+// writeACLError writes a JSON error response for the ACL endpoint
+func writeACLError(w http.ResponseWriter, status int, message string) {
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(ACLResponse{Success: false, Error: message})
+}
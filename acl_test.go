@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var (
+	testOwner  = &Principal{Name: "alice@example.com", Method: "oidc", Scopes: defaultScopes}
+	testReader = &Principal{Name: "bob@example.com", Method: "oidc", Scopes: defaultScopes}
+	testEditor = &Principal{Name: "carol@example.com", Method: "oidc", Scopes: defaultScopes}
+	testOther  = &Principal{Name: "eve@example.com", Method: "oidc", Scopes: defaultScopes}
+	testAdmin  = &Principal{Name: "root", Method: "token", Scopes: []Scope{ScopeAdmin}}
+)
+
+// TestNoteMetaAccess tests read, write and manage permissions for each access mode
+func TestNoteMetaAccess(t *testing.T) {
+	meta := func(mode AccessMode) *NoteMeta {
+		return &NoteMeta{
+			EditToken: "tok",
+			Owner:     "Alice@example.com",
+			Access:    mode,
+			Readers:   []string{"bob@example.com"},
+			Editors:   []string{"carol@example.com"},
+		}
+	}
+
+	tests := []struct {
+		name                string
+		meta                *NoteMeta
+		principal           *Principal
+		token               string
+		read, write, manage bool
+	}{
+		{"legacy anonymous", nil, nil, "", true, true, false},
+		{"legacy signed in", nil, testOther, "", true, true, true},
+		{"link anonymous", meta(""), nil, "", true, false, false},
+		{"link token", meta(AccessLink), nil, "tok", true, true, false},
+		{"link editor", meta(AccessLink), testEditor, "", true, true, false},
+		{"team anonymous", meta(AccessTeam), nil, "", false, false, false},
+		{"team token", meta(AccessTeam), nil, "tok", true, true, false},
+		{"team signed in", meta(AccessTeam), testOther, "", true, false, false},
+		{"private other", meta(AccessPrivate), testOther, "tok", false, false, false},
+		{"private reader", meta(AccessPrivate), testReader, "", true, false, false},
+		{"private editor", meta(AccessPrivate), testEditor, "", true, true, false},
+		{"private owner", meta(AccessPrivate), testOwner, "", true, true, true},
+		{"private admin", meta(AccessPrivate), testAdmin, "", true, true, true},
+		{"public anonymous", meta(AccessPublic), nil, "", true, true, false},
+		{"ownerless token", &NoteMeta{EditToken: "tok"}, nil, "tok", true, true, true},
+	}
+
+	for _, test := range tests {
+		if got := test.meta.AllowsRead(test.principal, test.token); got != test.read {
+			t.Errorf("%s: AllowsRead = %v, expected %v", test.name, got, test.read)
+		}
+		if got := test.meta.AllowsWrite(test.principal, test.token); got != test.write {
+			t.Errorf("%s: AllowsWrite = %v, expected %v", test.name, got, test.write)
+		}
+		if got := test.meta.AllowsManage(test.principal, test.token); got != test.manage {
+			t.Errorf("%s: AllowsManage = %v, expected %v", test.name, got, test.manage)
+		}
+	}
+}
+
+// postACL calls the ACL endpoint as principal
+func postACL(t *testing.T, storage Storage, principal *Principal, payload ACLRequest) (*httptest.ResponseRecorder, ACLResponse) {
+	t.Helper()
+	body, _ := json.Marshal(payload)
+	req := httptest.NewRequest("POST", "/acl", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req = req.WithContext(withPrincipal(req.Context(), principal))
+	rec := httptest.NewRecorder()
+	HandleACL(storage)(rec, req)
+	var resp ACLResponse
+	_ = json.Unmarshal(rec.Body.Bytes(), &resp)
+	return rec, resp
+}
+
+// getAs requests path as principal
+func getAs(storage Storage, principal *Principal, handler func(Storage) http.HandlerFunc, path string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", path, nil)
+	req = req.WithContext(withPrincipal(req.Context(), principal))
+	rec := httptest.NewRecorder()
+	handler(storage)(rec, req)
+	return rec
+}
+
+// TestHandleACL tests changing access through the ACL endpoint and its enforcement
+func TestHandleACL(t *testing.T) {
+	storage := NewMockStorage()
+	ctx := withPrincipal(context.Background(), testOwner)
+	noteID, _, _ := createNote(ctx, storage, "team plans")
+	meta, _ := issueNoteMeta(ctx, storage, noteID)
+
+	rec, _ := postACL(t, storage, testOther, ACLRequest{NoteID: noteID, Access: AccessPublic})
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for non-owner, got %d", rec.Code)
+	}
+	rec, _ = postACL(t, storage, testOwner, ACLRequest{NoteID: noteID, Access: "everyone"})
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for invalid mode, got %d", rec.Code)
+	}
+
+	rec, resp := postACL(t, storage, testOwner, ACLRequest{
+		NoteID:  noteID,
+		Access:  AccessPrivate,
+		Readers: []string{" bob@example.com", "BOB@example.com", ""},
+		Editors: []string{"carol@example.com"},
+	})
+	if rec.Code != http.StatusOK || resp.Access != AccessPrivate || len(resp.Readers) != 1 {
+		t.Fatalf("Expected ACL update to succeed, got %d %#v", rec.Code, resp)
+	}
+
+	// Private notes are hidden from everyone else, including the edit and read-only links
+	if rec := getAs(storage, testOther, HandleGet, "/noteid/"+noteID+"?token="+meta.EditToken); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for other user, got %d", rec.Code)
+	}
+	if rec := getAs(storage, nil, HandleReadOnly, "/r/"+meta.ReadID); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for read-only link of private note, got %d", rec.Code)
+	}
+	rec = getAs(storage, testReader, HandleGet, "/noteid/"+noteID)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "textarea id=\"content\" placeholder=\"Start typing your note...\" readonly") {
+		t.Errorf("Expected read-only view for reader, got %d", rec.Code)
+	}
+	rec = getAs(storage, testOwner, HandleGet, "/noteid/"+noteID)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "openShareDialog()") {
+		t.Errorf("Expected editable view with sharing dialog for owner, got %d", rec.Code)
+	}
+
+	// Editors can save, readers cannot
+	for _, test := range []struct {
+		principal *Principal
+		status    int
+	}{
+		{testReader, http.StatusForbidden},
+		{testEditor, http.StatusOK},
+	} {
+		body := `{"noteId":"` + noteID + `","content":"edited by ` + test.principal.Name + `"}`
+		req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req = req.WithContext(withPrincipal(req.Context(), test.principal))
+		rec := httptest.NewRecorder()
+		HandlePost(storage)(rec, req)
+		if rec.Code != test.status {
+			t.Errorf("%s: expected status %d, got %d", test.principal.Name, test.status, rec.Code)
+		}
+	}
+}
+
+// TestHandleACLOwnerless tests ACL changes on notes created without signing in
+func TestHandleACLOwnerless(t *testing.T) {
+	storage := NewMockStorage()
+	_, created := postNote(t, storage, NoteRequest{Content: "anonymous note"})
+
+	rec, _ := postACL(t, storage, nil, ACLRequest{NoteID: created.NoteID, Access: AccessPublic})
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 without edit token, got %d", rec.Code)
+	}
+	rec, _ = postACL(t, storage, nil, ACLRequest{NoteID: created.NoteID, Access: AccessPrivate, EditToken: created.EditToken})
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for private note without owner, got %d", rec.Code)
+	}
+	rec, _ = postACL(t, storage, nil, ACLRequest{NoteID: created.NoteID, Access: AccessPublic, EditToken: created.EditToken})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected ACL update with edit token to succeed, got %d", rec.Code)
+	}
+
+	// Public notes can be edited by anyone
+	rec, _ = postNote(t, storage, NoteRequest{NoteID: created.NoteID, Content: "anyone can edit"})
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected public note to be editable without token, got %d", rec.Code)
+	}
+}
+
+// TestHandleACLLegacy tests that notes without metadata can only be claimed
+// by signed-in users, who get the new edit token
+func TestHandleACLLegacy(t *testing.T) {
+	storage := NewMockStorage()
+	_ = storage.Write(context.Background(), "legacy", "old note")
+
+	rec, _ := postACL(t, storage, nil, ACLRequest{NoteID: "legacy", Access: AccessLink})
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for anonymous caller, got %d", rec.Code)
+	}
+	if meta, _ := ReadNoteMeta(context.Background(), storage, "legacy"); meta != nil {
+		t.Fatalf("Expected no metadata after denied change, got %#v", meta)
+	}
+
+	rec, resp := postACL(t, storage, testOwner, ACLRequest{NoteID: "legacy", Access: AccessLink})
+	if rec.Code != http.StatusOK || resp.Owner != testOwner.Name || resp.EditToken == "" || resp.ReadID == "" {
+		t.Fatalf("Expected note to be claimed, got %d %#v", rec.Code, resp)
+	}
+	if rec := getAs(storage, nil, HandleReadOnly, "/r/"+resp.ReadID); rec.Code != http.StatusOK {
+		t.Errorf("Expected returned read-only link to work, got %d", rec.Code)
+	}
+	rec, _ = postNote(t, storage, NoteRequest{NoteID: "legacy", Content: "edited", EditToken: resp.EditToken})
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected returned edit token to allow saves, got %d", rec.Code)
+	}
+
+	// Later changes don't hand out the token again
+	rec, resp = postACL(t, storage, testOwner, ACLRequest{NoteID: "legacy", Access: AccessPrivate})
+	if rec.Code != http.StatusOK || resp.EditToken != "" {
+		t.Errorf("Expected plain ACL update, got %d %#v", rec.Code, resp)
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// maxAliasLength bounds the length of alias slugs
+const maxAliasLength = 64
+
+// maxAliasHops bounds how many renames are followed when resolving an alias
+const maxAliasHops = 8
+
+var aliasPattern = regexp.MustCompile(`^[a-zA-Z0-9]+([._-][a-zA-Z0-9]+)*$`)
+
+var (
+	errInvalidNoteRef = errors.New("invalid note ID format")
+	errAliasNotFound  = errors.New("alias does not exist")
+	errAliasRenamed   = errors.New("alias was already renamed")
+)
+
+// Alias maps a human-readable slug to an underlying note ID
+type Alias struct {
+	NoteID    string    `json:"noteId"`
+	MovedTo   string    `json:"movedTo,omitempty"` // set when the alias has been renamed
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// AliasRequest represents the JSON payload for creating or renaming an alias
+type AliasRequest struct {
+	Alias     string `json:"alias"`
+	NoteID    string `json:"noteId,omitempty"`
+	From      string `json:"from,omitempty"`
+	EditToken string `json:"editToken,omitempty"`
+}
+
+// AliasResponse represents the JSON response of the alias endpoint
+type AliasResponse struct {
+	Success bool   `json:"success"`
+	Alias   string `json:"alias,omitempty"`
+	NoteID  string `json:"noteId,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// ValidateAlias checks if an alias slug is valid: letters and digits separated
+// by single dashes, underscores or dots. Plain alphanumeric slugs are rejected
+// because they would be indistinguishable from note IDs.
+func ValidateAlias(alias string) bool {
+	if len(alias) > maxAliasLength || !aliasPattern.MatchString(alias) {
+		return false
+	}
+	return !ValidateNoteID(alias)
+}
+
+// aliasKey returns the storage key of an alias record. The dot can never
+// appear in a note ID, so alias records cannot collide with notes.
+func aliasKey(alias string) string {
+	return ".alias." + alias
+}
+
+// ReadAlias loads an alias record, returning nil if the alias does not exist
+func ReadAlias(ctx context.Context, storage Storage, alias string) (*Alias, error) {
+	data, err := storage.Read(ctx, aliasKey(alias))
+	if err != nil {
+		return nil, err
+	}
+	if data == "" {
+		return nil, nil
+	}
+	var a Alias
+	if err := json.Unmarshal([]byte(data), &a); err != nil {
+		return nil, fmt.Errorf("corrupt alias record %s: %w", alias, err)
+	}
+	return &a, nil
+}
+
+// CreateAlias points a new alias at a note, failing with ErrNoteExists if the alias is taken
+func CreateAlias(ctx context.Context, storage Storage, alias string, noteID string) error {
+	data, _ := json.Marshal(Alias{NoteID: noteID, CreatedAt: time.Now().UTC()})
+	return storage.Create(ctx, aliasKey(alias), string(data))
+}
+
+// RenameAlias moves an alias to a new slug. The old slug keeps pointing at the
+// note and redirects to the new one, so existing links do not break.
+func RenameAlias(ctx context.Context, storage Storage, from string, to string) (*Alias, error) {
+	old, err := ReadAlias(ctx, storage, from)
+	if err != nil {
+		return nil, err
+	}
+	if old == nil {
+		return nil, fmt.Errorf("%w: %s", errAliasNotFound, from)
+	}
+	if old.MovedTo != "" {
+		return nil, fmt.Errorf("%w to %s", errAliasRenamed, old.MovedTo)
+	}
+	if err := CreateAlias(ctx, storage, to, old.NoteID); err != nil {
+		return nil, err
+	}
+	old.MovedTo = to
+	data, _ := json.Marshal(old)
+	if err := storage.Write(ctx, aliasKey(from), string(data)); err != nil {
+		return nil, err
+	}
+	return old, nil
+}
+
+// resolveNoteRef turns a note reference from a URL (note ID or alias) into the
+// underlying note ID. It also returns the canonical reference, which differs
+// from ref when the alias has been renamed. An unknown alias resolves to an
+// empty note ID.
+func resolveNoteRef(ctx context.Context, storage Storage, ref string) (noteID string, canonical string, err error) {
+	if ValidateNoteID(ref) {
+		return ref, ref, nil
+	}
+	if !ValidateAlias(ref) {
+		return "", "", errInvalidNoteRef
+	}
+	canonical = ref
+	for hop := 0; hop < maxAliasHops; hop++ {
+		a, err := ReadAlias(ctx, storage, canonical)
+		if err != nil {
+			return "", "", err
+		}
+		if a == nil {
+			return "", canonical, nil
+		}
+		if a.MovedTo == "" {
+			return a.NoteID, canonical, nil
+		}
+		canonical = a.MovedTo
+	}
+	return "", "", fmt.Errorf("alias %s has too many renames", ref)
+}
+
+// HandleAlias handles POST requests that create or rename aliases
+func HandleAlias(storage Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientIP := ClientIP(r)
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.Method != http.MethodPost {
+			writeAliasError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSmallBodySize))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeAliasError(w, http.StatusRequestEntityTooLarge, "Request too large")
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to read alias request body", "error", err)
+			writeAliasError(w, http.StatusInternalServerError, "Read error")
+			return
+		}
+		var req AliasRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			writeAliasError(w, http.StatusBadRequest, "invalid JSON format")
+			return
+		}
+
+		req.Alias = strings.TrimSpace(req.Alias)
+		if !ValidateAlias(req.Alias) {
+			slog.WarnContext(r.Context(), "Invalid alias format", "alias", req.Alias)
+			writeAliasError(w, http.StatusBadRequest, "Invalid alias format")
+			return
+		}
+		token := req.EditToken
+		if token == "" {
+			token = editTokenFromRequest(r, NoteRequest{})
+		}
+
+		if req.From != "" {
+			slog.DebugContext(r.Context(), "Renaming alias", "from", req.From, "alias", req.Alias, "ip", clientIP)
+			if !ValidateAlias(req.From) {
+				writeAliasError(w, http.StatusBadRequest, "Invalid alias format")
+				return
+			}
+			old, err := ReadAlias(r.Context(), storage, req.From)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "Failed to read alias", "alias", req.From, "error", err)
+				writeAliasError(w, http.StatusInternalServerError, "Failed to update alias")
+				return
+			}
+			if old != nil && !aliasAllowed(w, r, storage, old.NoteID, token) {
+				return
+			}
+			a, err := RenameAlias(r.Context(), storage, req.From, req.Alias)
+			if err != nil {
+				slog.WarnContext(r.Context(), "Failed to rename alias", "from", req.From, "error", err)
+				status, message := aliasErrorStatus(err)
+				writeAliasError(w, status, message)
+				return
+			}
+			slog.InfoContext(r.Context(), "Alias renamed", "from", req.From, "alias", req.Alias)
+			_ = json.NewEncoder(w).Encode(AliasResponse{Success: true, Alias: req.Alias, NoteID: a.NoteID})
+			return
+		}
+
+		if !ValidateNoteID(req.NoteID) {
+			writeAliasError(w, http.StatusBadRequest, "Invalid note ID format")
+			return
+		}
+		slog.DebugContext(r.Context(), "Creating alias", "alias", req.Alias, "note", req.NoteID, "ip", clientIP)
+		if !aliasAllowed(w, r, storage, req.NoteID, token) {
+			return
+		}
+		if err := CreateAlias(r.Context(), storage, req.Alias, req.NoteID); err != nil {
+			slog.WarnContext(r.Context(), "Failed to create alias", "alias", req.Alias, "error", err)
+			status, message := aliasErrorStatus(err)
+			writeAliasError(w, status, message)
+			return
+		}
+		slog.InfoContext(r.Context(), "Alias created", "alias", req.Alias, "note", req.NoteID)
+		_ = json.NewEncoder(w).Encode(AliasResponse{Success: true, Alias: req.Alias, NoteID: req.NoteID})
+	}
+}
+
+// aliasAllowed reports whether the caller, holding token, may point aliases at
+// a note, as they may if they could save it. Otherwise it answers the request.
+func aliasAllowed(w http.ResponseWriter, r *http.Request, storage Storage, noteID string, token string) bool {
+	meta, err := ReadNoteMeta(r.Context(), storage, noteID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to read note metadata", "note", noteID, "error", err)
+		writeAliasError(w, http.StatusInternalServerError, "Failed to update alias")
+		return false
+	}
+	if principal := PrincipalFromContext(r.Context()); !meta.AllowsWrite(principal, token) {
+		slog.WarnContext(r.Context(), "Alias change denied", "principal", principal.String(), "access", meta.AccessMode(), "note", noteID, "ip", ClientIP(r))
+		if meta.AccessMode() == AccessPrivate {
+			writeAliasError(w, http.StatusForbidden, "Access denied")
+		} else {
+			writeAliasError(w, http.StatusForbidden, "Edit token required")
+		}
+		return false
+	}
+	return true
+}
+
+// aliasErrorStatus maps alias errors to HTTP status codes and messages
+func aliasErrorStatus(err error) (int, string) {
+	switch {
+	case errors.Is(err, ErrNoteExists):
+		return http.StatusConflict, "Alias already exists"
+	case errors.Is(err, errAliasNotFound), errors.Is(err, errAliasRenamed):
+		return http.StatusBadRequest, err.Error()
+	default:
+		return http.StatusInternalServerError, "Failed to update alias"
+	}
+}
+
+// writeAliasError writes a JSON error response for the alias endpoint
+func writeAliasError(w http.ResponseWriter, status int, message string) {
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(AliasResponse{Success: false, Error: message})
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestValidateAlias tests alias slug validation
+func TestValidateAlias(t *testing.T) {
+	tests := []struct {
+		alias string
+		valid bool
+	}{
+		{"oncall-runbook", true},
+		{"team.standup", true},
+		{"release_notes", true},
+		{"a-b.c_d", true},
+		{"runbook", false}, // indistinguishable from a note ID
+		{"", false},
+		{"-leading", false},
+		{"trailing.", false},
+		{"double--dash", false},
+		{"..", false},
+		{"../etc", false},
+		{"a/b", false},
+		{"with space", false},
+		{strings.Repeat("a-", 40) + "a", false},
+	}
+
+	for _, test := range tests {
+		result := ValidateAlias(test.alias)
+		if result != test.valid {
+			t.Errorf("ValidateAlias(%s) = %v, expected %v", test.alias, result, test.valid)
+		}
+	}
+}
+
+// TestResolveNoteRef tests resolution of note IDs, aliases and renamed aliases
+func TestResolveNoteRef(t *testing.T) {
+	storage := NewMockStorage()
+	ctx := context.Background()
+
+	if err := CreateAlias(ctx, storage, "oncall-runbook", "ABCDE"); err != nil {
+		t.Fatalf("Failed to create alias: %v", err)
+	}
+	if err := CreateAlias(ctx, storage, "oncall-runbook", "OTHER"); err == nil {
+		t.Fatalf("Expected error when creating a duplicate alias")
+	}
+
+	noteID, canonical, err := resolveNoteRef(ctx, storage, "oncall-runbook")
+	if err != nil || noteID != "ABCDE" || canonical != "oncall-runbook" {
+		t.Fatalf("unexpected resolution: %s %s %v", noteID, canonical, err)
+	}
+
+	if _, err := RenameAlias(ctx, storage, "oncall-runbook", "team.oncall"); err != nil {
+		t.Fatalf("Failed to rename alias: %v", err)
+	}
+	noteID, canonical, err = resolveNoteRef(ctx, storage, "oncall-runbook")
+	if err != nil || noteID != "ABCDE" || canonical != "team.oncall" {
+		t.Fatalf("unexpected resolution after rename: %s %s %v", noteID, canonical, err)
+	}
+
+	noteID, _, err = resolveNoteRef(ctx, storage, "XYZ12")
+	if err != nil || noteID != "XYZ12" {
+		t.Fatalf("note IDs should resolve to themselves, got %s %v", noteID, err)
+	}
+
+	noteID, _, err = resolveNoteRef(ctx, storage, "no-such-alias")
+	if err != nil || noteID != "" {
+		t.Fatalf("unknown alias should resolve to empty ID, got %s %v", noteID, err)
+	}
+
+	if _, _, err := resolveNoteRef(ctx, storage, "../etc"); err != errInvalidNoteRef {
+		t.Fatalf("expected errInvalidNoteRef, got %v", err)
+	}
+}
+
+// TestHandleGetAlias tests GET through an alias and redirect of a renamed alias
+func TestHandleGetAlias(t *testing.T) {
+	storage := NewMockStorage()
+	ctx := context.Background()
+	_ = storage.Write(ctx, "ABCDE", "runbook content")
+	_ = CreateAlias(ctx, storage, "oncall-runbook", "ABCDE")
+
+	req := httptest.NewRequest("GET", "/noteid/oncall-runbook", nil)
+	rec := httptest.NewRecorder()
+	HandleGet(storage)(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "runbook content") {
+		t.Errorf("Expected note content in response")
+	}
+
+	_, _ = RenameAlias(ctx, storage, "oncall-runbook", "team.oncall")
+	req = httptest.NewRequest("GET", "/app/noteid/oncall-runbook", nil)
+	rec = httptest.NewRecorder()
+	HandleGet(storage)(rec, req)
+
+	if rec.Code != http.StatusMovedPermanently {
+		t.Fatalf("Expected status 301, got %d", rec.Code)
+	}
+	if loc := rec.Header().Get("Location"); loc != "/app/noteid/team.oncall" {
+		t.Errorf("Expected redirect to /app/noteid/team.oncall, got %s", loc)
+	}
+
+	req = httptest.NewRequest("GET", "/?note=../etc/passwd", nil)
+	rec = httptest.NewRecorder()
+	HandleGet(storage)(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for invalid reference, got %d", rec.Code)
+	}
+}
+
+// TestHandlePostAlias tests saving through a new and an existing alias
+func TestHandlePostAlias(t *testing.T) {
+	storage := NewMockStorage()
+	ctx := context.Background()
+
+	body := `{"content":"standup notes"}`
+	req := httptest.NewRequest("POST", "/noteid/team.standup", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	HandlePost(storage)(rec, req)
+
+	var resp NoteResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if !resp.Success || resp.NoteID != "team.standup" {
+		t.Fatalf("unexpected response: %#v", resp)
+	}
+
+	a, _ := ReadAlias(ctx, storage, "team.standup")
+	if a == nil {
+		t.Fatalf("Expected alias to be created")
+	}
+	if content, _ := storage.Read(ctx, a.NoteID); content != "standup notes" {
+		t.Errorf("Expected content under the underlying note ID, got %q", content)
+	}
+
+	body = `{"noteId":"team.standup","content":"updated","editToken":"` + resp.EditToken + `"}`
+	req = httptest.NewRequest("POST", "/", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec = httptest.NewRecorder()
+	HandlePost(storage)(rec, req)
+
+	if content, _ := storage.Read(ctx, a.NoteID); content != "updated" {
+		t.Errorf("Expected alias save to update the note, got %q", content)
+	}
+}
+
+// TestHandleAlias tests the alias create and rename endpoint
+func TestHandleAlias(t *testing.T) {
+	storage := NewMockStorage()
+	handler := HandleAlias(storage)
+
+	post := func(body string) (*httptest.ResponseRecorder, AliasResponse) {
+		req := httptest.NewRequest("POST", "/alias", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		var resp AliasResponse
+		_ = json.NewDecoder(rec.Body).Decode(&resp)
+		return rec, resp
+	}
+
+	rec, resp := post(`{"alias":"oncall-runbook","noteId":"ABCDE"}`)
+	if rec.Code != http.StatusOK || !resp.Success {
+		t.Fatalf("Expected alias creation to succeed, got %d %#v", rec.Code, resp)
+	}
+
+	rec, _ = post(`{"alias":"oncall-runbook","noteId":"OTHER"}`)
+	if rec.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 for duplicate alias, got %d", rec.Code)
+	}
+
+	rec, _ = post(`{"alias":"runbook","noteId":"ABCDE"}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for invalid alias, got %d", rec.Code)
+	}
+
+	rec, resp = post(`{"alias":"runbook.oncall","from":"oncall-runbook"}`)
+	if rec.Code != http.StatusOK || resp.NoteID != "ABCDE" {
+		t.Fatalf("Expected rename to succeed, got %d %#v", rec.Code, resp)
+	}
+
+	rec, _ = post(`{"alias":"another-name","from":"oncall-runbook"}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 when renaming a renamed alias, got %d", rec.Code)
+	}
+}
+
+// TestHandleAliasAccess tests that aliases can only be pointed at notes the
+// caller may save
+func TestHandleAliasAccess(t *testing.T) {
+	ctx := context.Background()
+	storage := NewMockStorage()
+	_ = storage.Write(ctx, "ABCDE", "secret")
+	_ = WriteNoteMeta(ctx, storage, "ABCDE", &NoteMeta{EditToken: "tok", Owner: testOwner.Name, Access: AccessPrivate})
+	_ = storage.Write(ctx, "LINKS", "shared")
+	_ = WriteNoteMeta(ctx, storage, "LINKS", &NoteMeta{EditToken: "tok"})
+
+	post := func(principal *Principal, payload AliasRequest) int {
+		body, _ := json.Marshal(payload)
+		req := httptest.NewRequest("POST", "/alias", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req = req.WithContext(withPrincipal(req.Context(), principal))
+		rec := httptest.NewRecorder()
+		HandleAlias(storage)(rec, req)
+		return rec.Code
+	}
+
+	if code := post(testOther, AliasRequest{Alias: "stolen-note", NoteID: "ABCDE"}); code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for alias to another user's private note, got %d", code)
+	}
+	if code := post(nil, AliasRequest{Alias: "link-note", NoteID: "LINKS"}); code != http.StatusForbidden {
+		t.Errorf("Expected status 403 without edit token, got %d", code)
+	}
+	if a, _ := ReadAlias(ctx, storage, "stolen-note"); a != nil {
+		t.Errorf("Expected denied alias not to be created, got %#v", a)
+	}
+	if code := post(nil, AliasRequest{Alias: "link-note", NoteID: "LINKS", EditToken: "tok"}); code != http.StatusOK {
+		t.Errorf("Expected status 200 with edit token, got %d", code)
+	}
+	if code := post(testOwner, AliasRequest{Alias: "my-note", NoteID: "ABCDE"}); code != http.StatusOK {
+		t.Errorf("Expected status 200 for owner, got %d", code)
+	}
+
+	// Renames are authorized against the note the alias points at
+	if code := post(testOther, AliasRequest{Alias: "their-note", From: "my-note"}); code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for renaming another user's alias, got %d", code)
+	}
+	if code := post(nil, AliasRequest{Alias: "link-renamed", From: "link-note"}); code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for rename without edit token, got %d", code)
+	}
+	if code := post(testOwner, AliasRequest{Alias: "my-renamed", From: "my-note"}); code != http.StatusOK {
+		t.Errorf("Expected status 200 for owner rename, got %d", code)
+	}
+}
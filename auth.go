@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Scope is a permission granted to an authenticated principal
+type Scope string
+
+// Supported scopes. Admin implies write, write implies read.
+const (
+	ScopeRead  Scope = "read"
+	ScopeWrite Scope = "write"
+	ScopeAdmin Scope = "admin"
+)
+
+// defaultScopes are granted when a token or user does not list any scopes
+var defaultScopes = []Scope{ScopeRead, ScopeWrite}
+
+// Principal is an authenticated caller
+type Principal struct {
+	Name   string
+	Method string // how the principal authenticated, e.g. "token" or "basic"
+	Scopes []Scope
+}
+
+// HasScope reports whether the principal was granted scope
+func (p *Principal) HasScope(scope Scope) bool {
+	if p == nil {
+		return false
+	}
+	for _, s := range p.Scopes {
+		if s == scope || s == ScopeAdmin || (s == ScopeWrite && scope == ScopeRead) {
+			return true
+		}
+	}
+	return false
+}
+
+// String formats the principal for log lines
+func (p *Principal) String() string {
+	if p == nil {
+		return "anonymous"
+	}
+	return p.Name + " (" + p.Method + ")"
+}
+
+type principalContextKey struct{}
+
+// withPrincipal returns a copy of ctx carrying the authenticated principal
+func withPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalContextKey{}, p)
+}
+
+// PrincipalFromContext returns the authenticated principal, or nil for anonymous requests
+func PrincipalFromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalContextKey{}).(*Principal)
+	return p
+}
+
+// htpasswdUser is a user loaded from a htpasswd-style file
+type htpasswdUser struct {
+	hash   string
+	scopes []Scope
+}
+
+// Authenticator authenticates requests with static bearer tokens or HTTP Basic
+// credentials and enforces per-principal scopes
+type Authenticator struct {
+	tokens   map[[32]byte]*Principal // keyed by SHA-256 of the token
+	users    map[string]htpasswdUser
+	verified sync.Map // cache of successful Basic logins, keyed by user and password hash
+	oidc     *OIDCLogin
+	certs    bool // accept verified TLS client certificates
+	realm    string
+}
+
+// globalAuth is the authenticator wrapping all routes; nil disables authentication
+var globalAuth *Authenticator
+
+// NewAuthenticator creates an Authenticator with the given realm
+func NewAuthenticator(realm string) *Authenticator {
+	return &Authenticator{
+		tokens: make(map[[32]byte]*Principal),
+		users:  make(map[string]htpasswdUser),
+		realm:  realm,
+	}
+}
+
+// NewAuthenticatorFromConfig builds the authenticator from the configured
+// tokens, htpasswd file, client certificates and OIDC provider. It returns nil
+// when none is set, leaving the app open.
+func NewAuthenticatorFromConfig(cfg AuthConfig) (*Authenticator, error) {
+	if len(cfg.Tokens) == 0 && cfg.HtpasswdFile == "" && !cfg.ClientCerts && cfg.OIDC.Issuer == "" {
+		return nil, nil
+	}
+
+	a := NewAuthenticator("Note")
+	a.certs = cfg.ClientCerts
+	if err := a.AddTokens(strings.Join(cfg.Tokens, ",")); err != nil {
+		return nil, err
+	}
+	if cfg.HtpasswdFile != "" {
+		if err := a.LoadHtpasswd(cfg.HtpasswdFile); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.OIDC.Issuer != "" {
+		var err error
+		if a.oidc, err = NewOIDCLogin(context.Background(), cfg.OIDC); err != nil {
+			return nil, err
+		}
+	}
+	slog.Info("Authentication enabled", "tokens", len(a.tokens), "users", len(a.users), "client_certs", a.certs, "oidc", a.oidc != nil)
+	return a, nil
+}
+
+// SetOIDC enables OpenID Connect login sessions
+func (a *Authenticator) SetOIDC(o *OIDCLogin) {
+	a.oidc = o
+}
+
+// LoginHandler returns the handler for the /auth/ routes, which respond with
+// 404 when OIDC login is not configured
+func (a *Authenticator) LoginHandler() http.Handler {
+	if a == nil || a.oidc == nil {
+		return http.NotFoundHandler()
+	}
+	return a.oidc
+}
+
+// AddTokens registers bearer tokens from a comma-separated list of
+// name:token[:scopes] entries, with scopes joined by "+" (e.g. ci:s3cret:read+write)
+func (a *Authenticator) AddTokens(spec string) error {
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+			return fmt.Errorf("invalid token entry %q (expected name:token[:scopes])", entry)
+		}
+		scopes := defaultScopes
+		if len(parts) == 3 {
+			var err error
+			if scopes, err = parseScopes(parts[2]); err != nil {
+				return fmt.Errorf("token %s: %w", parts[0], err)
+			}
+		}
+		a.tokens[sha256.Sum256([]byte(parts[1]))] = &Principal{Name: parts[0], Method: "token", Scopes: scopes}
+	}
+	return nil
+}
+
+// LoadHtpasswd loads HTTP Basic users from a htpasswd-style file with lines of
+// the form user:hash[:scopes]. Hashes may be bcrypt ($2y$...) or {SHA}.
+func (a *Authenticator) LoadHtpasswd(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open htpasswd file: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) < 2 || parts[0] == "" {
+			return fmt.Errorf("%s:%d: expected user:hash[:scopes]", path, lineNo)
+		}
+		if !strings.HasPrefix(parts[1], "$2") && !strings.HasPrefix(parts[1], "{SHA}") {
+			return fmt.Errorf("%s:%d: unsupported hash for user %s (use bcrypt or {SHA})", path, lineNo, parts[0])
+		}
+		scopes := defaultScopes
+		if len(parts) == 3 {
+			if scopes, err = parseScopes(parts[2]); err != nil {
+				return fmt.Errorf("%s:%d: %w", path, lineNo, err)
+			}
+		}
+		a.users[parts[0]] = htpasswdUser{hash: parts[1], scopes: scopes}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read htpasswd file: %w", err)
+	}
+	return nil
+}
+
+// parseScopes parses a "+"-separated scope list
+func parseScopes(spec string) ([]Scope, error) {
+	var scopes []Scope
+	for _, s := range strings.Split(spec, "+") {
+		switch scope := Scope(strings.ToLower(strings.TrimSpace(s))); scope {
+		case ScopeRead, ScopeWrite, ScopeAdmin:
+			scopes = append(scopes, scope)
+		default:
+			return nil, fmt.Errorf("unknown scope %q", s)
+		}
+	}
+	return scopes, nil
+}
+
+// Authenticate returns the principal for the request's credentials, client
+// certificate or login session. It returns nil without error when no
+// credentials were sent.
+func (a *Authenticator) Authenticate(r *http.Request) (*Principal, error) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		if p := clientCertPrincipal(r); a.certs && p != nil {
+			return p, nil
+		}
+		if a.oidc != nil {
+			return a.oidc.Principal(r), nil
+		}
+		return nil, nil
+	}
+
+	if token, ok := strings.CutPrefix(header, "Bearer "); ok {
+		if p, ok := a.tokens[sha256.Sum256([]byte(strings.TrimSpace(token)))]; ok {
+			return p, nil
+		}
+		return nil, fmt.Errorf("invalid bearer token")
+	}
+
+	if user, password, ok := r.BasicAuth(); ok {
+		u, exists := a.users[user]
+		if !exists || !a.checkPassword(user, password, u.hash) {
+			return nil, fmt.Errorf("invalid credentials for user %q", user)
+		}
+		return &Principal{Name: user, Method: "basic", Scopes: u.scopes}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported authorization scheme")
+}
+
+// checkPassword verifies a password against a htpasswd hash. Successful bcrypt
+// checks are cached so auto-save requests don't pay the bcrypt cost each time.
+func (a *Authenticator) checkPassword(user string, password string, hash string) bool {
+	cacheKey := user + ":" + hash + ":" + fmt.Sprintf("%x", sha256.Sum256([]byte(password)))
+	if _, ok := a.verified.Load(cacheKey); ok {
+		return true
+	}
+
+	var ok bool
+	if encoded, isSHA := strings.CutPrefix(hash, "{SHA}"); isSHA {
+		sum := sha1.Sum([]byte(password))
+		ok = subtle.ConstantTimeCompare([]byte(base64.StdEncoding.EncodeToString(sum[:])), []byte(encoded)) == 1
+	} else {
+		ok = bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	}
+	if ok {
+		a.verified.Store(cacheKey, true)
+	}
+	return ok
+}
+
+// requiredScope returns the scope needed for a request, or "" if none is needed
+func requiredScope(r *http.Request) Scope {
+	if r.URL.Path == "/favicon.ico" || strings.HasPrefix(r.URL.Path, "/auth/") || isProbePath(r.URL.Path) {
+		return ""
+	}
+	switch r.Method {
+	case http.MethodOptions:
+		return "" // CORS preflight requests carry no credentials
+	case http.MethodGet, http.MethodHead:
+		return ScopeRead
+	default:
+		return ScopeWrite
+	}
+}
+
+// Middleware wraps a handler with authentication and scope checks. A nil
+// Authenticator passes every request through unchanged.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	if a == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scope := requiredScope(r)
+		if scope == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		clientIP := ClientIP(r)
+		principal, err := a.Authenticate(r)
+		if err != nil || principal == nil {
+			if err != nil {
+				slog.WarnContext(r.Context(), "Authentication rejected", "method", r.Method, "path", r.URL.Path, "ip", clientIP, "error", err)
+			} else if a.oidc != nil && r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html") {
+				// Send browsers to the identity provider instead of a Basic auth prompt
+				http.Redirect(w, r, a.oidc.LoginURL(r), http.StatusFound)
+				return
+			}
+			a.challenge(w)
+			return
+		}
+		if !principal.HasScope(scope) {
+			slog.WarnContext(r.Context(), "Missing scope", "method", r.Method, "path", r.URL.Path, "principal", principal.String(), "ip", clientIP, "scope", scope)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		slog.DebugContext(r.Context(), "Authenticated", "method", r.Method, "path", r.URL.Path, "principal", principal.String(), "ip", clientIP)
+		next.ServeHTTP(w, r.WithContext(withPrincipal(r.Context(), principal)))
+	})
+}
+
+// challenge writes a 401 response asking for credentials
+func (a *Authenticator) challenge(w http.ResponseWriter) {
+	if len(a.users) > 0 {
+		w.Header().Add("WWW-Authenticate", `Basic realm="`+a.realm+`", charset="UTF-8"`)
+	}
+	if len(a.tokens) > 0 {
+		w.Header().Add("WWW-Authenticate", `Bearer realm="`+a.realm+`"`)
+	}
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+}
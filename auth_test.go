@@ -0,0 +1,149 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// newTestAuthenticator creates an authenticator with tokens and a htpasswd file
+func newTestAuthenticator(t *testing.T) *Authenticator {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte("alicepw"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+	htpasswd := "# users\n" +
+		"alice:" + string(hash) + "\n" +
+		"bob:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=:read\n" // password: password
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	if err := os.WriteFile(path, []byte(htpasswd), 0600); err != nil {
+		t.Fatalf("Failed to write htpasswd file: %v", err)
+	}
+
+	a := NewAuthenticator("Note")
+	if err := a.AddTokens("ci:citoken:read+write, viewer:viewtoken:read, root:roottoken:admin"); err != nil {
+		t.Fatalf("Failed to add tokens: %v", err)
+	}
+	if err := a.LoadHtpasswd(path); err != nil {
+		t.Fatalf("Failed to load htpasswd: %v", err)
+	}
+	return a
+}
+
+// TestAuthMiddleware tests authentication and scope enforcement
+func TestAuthMiddleware(t *testing.T) {
+	a := newTestAuthenticator(t)
+	var seen *Principal
+	handler := a.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = PrincipalFromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name      string
+		method    string
+		path      string
+		setup     func(r *http.Request)
+		status    int
+		principal string
+	}{
+		{"anonymous read", "GET", "/", nil, http.StatusUnauthorized, ""},
+		{"anonymous favicon", "GET", "/favicon.ico", nil, http.StatusOK, ""},
+		{"preflight", "OPTIONS", "/", nil, http.StatusOK, ""},
+		{"bearer write", "POST", "/", func(r *http.Request) { r.Header.Set("Authorization", "Bearer citoken") }, http.StatusOK, "ci"},
+		{"bearer invalid", "GET", "/", func(r *http.Request) { r.Header.Set("Authorization", "Bearer nope") }, http.StatusUnauthorized, ""},
+		{"read-only token write", "POST", "/", func(r *http.Request) { r.Header.Set("Authorization", "Bearer viewtoken") }, http.StatusForbidden, ""},
+		{"read-only token read", "GET", "/noteid/x", func(r *http.Request) { r.Header.Set("Authorization", "Bearer viewtoken") }, http.StatusOK, "viewer"},
+		{"admin token write", "POST", "/alias", func(r *http.Request) { r.Header.Set("Authorization", "Bearer roottoken") }, http.StatusOK, "root"},
+		{"basic bcrypt", "POST", "/", func(r *http.Request) { r.SetBasicAuth("alice", "alicepw") }, http.StatusOK, "alice"},
+		{"basic wrong password", "GET", "/", func(r *http.Request) { r.SetBasicAuth("alice", "wrong") }, http.StatusUnauthorized, ""},
+		{"basic sha read", "GET", "/", func(r *http.Request) { r.SetBasicAuth("bob", "password") }, http.StatusOK, "bob"},
+		{"basic sha write", "POST", "/", func(r *http.Request) { r.SetBasicAuth("bob", "password") }, http.StatusForbidden, ""},
+		{"unknown user", "GET", "/", func(r *http.Request) { r.SetBasicAuth("mallory", "password") }, http.StatusUnauthorized, ""},
+	}
+
+	for _, test := range tests {
+		seen = nil
+		req := httptest.NewRequest(test.method, test.path, nil)
+		if test.setup != nil {
+			test.setup(req)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != test.status {
+			t.Errorf("%s: expected status %d, got %d", test.name, test.status, rec.Code)
+		}
+		if test.principal != "" && (seen == nil || seen.Name != test.principal) {
+			t.Errorf("%s: expected principal %s in context, got %v", test.name, test.principal, seen)
+		}
+		if rec.Code == http.StatusUnauthorized && len(rec.Header().Values("WWW-Authenticate")) != 2 {
+			t.Errorf("%s: expected Basic and Bearer challenges, got %v", test.name, rec.Header().Values("WWW-Authenticate"))
+		}
+	}
+}
+
+// TestAuthMiddlewareDisabled tests that a nil authenticator leaves requests open
+func TestAuthMiddlewareDisabled(t *testing.T) {
+	var a *Authenticator
+	handler := a.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("POST", "/", nil))
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("Expected request to pass through, got %d", rec.Code)
+	}
+}
+
+// TestAuthConfigErrors tests rejection of malformed token and htpasswd entries
+func TestAuthConfigErrors(t *testing.T) {
+	a := NewAuthenticator("Note")
+	for _, spec := range []string{"nameonly", ":token", "ci:token:superuser"} {
+		if err := a.AddTokens(spec); err == nil {
+			t.Errorf("AddTokens(%q): expected error", spec)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	_ = os.WriteFile(path, []byte("carol:$apr1$abc$def\n"), 0600)
+	if err := a.LoadHtpasswd(path); err == nil {
+		t.Errorf("Expected error for unsupported apr1 hash")
+	}
+
+	if a, err := NewAuthenticatorFromConfig(DefaultConfig().Auth); a != nil || err != nil {
+		t.Errorf("Expected authentication to be disabled without configuration, got %v %v", a, err)
+	}
+}
+
+// TestAdminBypassesEditToken tests that admins can edit notes without the edit token
+func TestAdminBypassesEditToken(t *testing.T) {
+	storage := NewMockStorage()
+	_, created := postNote(t, storage, NoteRequest{Content: "locked"})
+
+	handler := newTestAuthenticator(t).Middleware(HandlePost(storage))
+	for _, test := range []struct {
+		token  string
+		status int
+	}{
+		{"citoken", http.StatusForbidden},
+		{"roottoken", http.StatusOK},
+	} {
+		body := `{"noteId":"` + created.NoteID + `","content":"changed"}`
+		req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+test.token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != test.status {
+			t.Errorf("token %s: expected status %d, got %d", test.token, test.status, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,528 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// Config holds all settings of the app. Each source overrides the previous
+// one: built-in defaults, the config file, environment variables and
+// command-line flags. Tracing is configured separately through the standard
+// OTEL_* variables.
+type Config struct {
+	Server         ServerConfig   `yaml:"server"`
+	Storage        StorageConfig  `yaml:"storage"`
+	NoteID         NoteIDConfig   `yaml:"note_id"`
+	Auth           AuthConfig     `yaml:"auth"`
+	Limits         LimitsConfig   `yaml:"limits"`
+	Logging        LoggingConfig  `yaml:"logging"`
+	Metrics        MetricsConfig  `yaml:"metrics"`
+	Lambda         LambdaConfig   `yaml:"lambda"`
+	Webhooks       WebhooksConfig `yaml:"webhooks"`
+	TrustedProxies []string       `yaml:"trusted_proxies"` // proxies allowed to report the client IP
+}
+
+// ServerConfig configures the HTTP server
+type ServerConfig struct {
+	Port        int       `yaml:"port"`
+	Listen      string    `yaml:"listen"` // host:port or unix:/path; overrides the port
+	SocketMode  string    `yaml:"socket_mode"`
+	SocketGroup string    `yaml:"socket_group"`
+	URL         string    `yaml:"url"` // public app URL; detected from requests when empty
+	TLS         TLSConfig `yaml:"tls"`
+}
+
+// StorageConfig configures local storage in HTTP server mode and S3 or
+// DynamoDB storage in Lambda mode
+type StorageConfig struct {
+	Dir            string        `yaml:"dir"`
+	Backend        string        `yaml:"backend"` // Lambda mode: s3 or dynamodb
+	S3Bucket       string        `yaml:"s3_bucket"`
+	S3Prefix       string        `yaml:"s3_prefix"`
+	DynamoTable    string        `yaml:"dynamodb_table"`
+	DynamoTTL      time.Duration `yaml:"dynamodb_ttl"`      // notes expire this long after their last save; 0 keeps them
+	DynamoEndpoint string        `yaml:"dynamodb_endpoint"` // e.g. DynamoDB Local
+}
+
+// NoteIDConfig configures the generation of note IDs
+type NoteIDConfig struct {
+	Style    string `yaml:"style"` // random or words
+	Length   int    `yaml:"length"`
+	Alphabet string `yaml:"alphabet"`
+	Words    int    `yaml:"words"`
+}
+
+// AuthConfig configures authentication; it is disabled when no method is set
+type AuthConfig struct {
+	Tokens       []string   `yaml:"tokens"` // name:token[:scopes] entries
+	HtpasswdFile string     `yaml:"htpasswd_file"`
+	ClientCerts  bool       `yaml:"client_certs"` // accept verified TLS client certificates
+	OIDC         OIDCConfig `yaml:"oidc"`
+}
+
+// LimitsConfig configures note size limits, quotas and rate limiting
+type LimitsConfig struct {
+	MaxNoteSize    string          `yaml:"max_note_size"`
+	QuotaTotal     string          `yaml:"quota_total"`      // empty means unlimited
+	QuotaPerClient string          `yaml:"quota_per_client"` // empty means unlimited
+	RateLimit      RateLimitConfig `yaml:"rate_limit"`
+}
+
+// RateLimitConfig configures per-client rate limiting
+type RateLimitConfig struct {
+	Enabled    bool     `yaml:"enabled"`
+	Read       string   `yaml:"read"`
+	Write      string   `yaml:"write"`
+	Create     string   `yaml:"create"`
+	Allowlist  []string `yaml:"allowlist"`
+	MaxClients int      `yaml:"max_clients"`
+}
+
+// LoggingConfig configures the default logger
+type LoggingConfig struct {
+	Level  string `yaml:"level"`
+	Format string `yaml:"format"` // text or json
+}
+
+// MetricsConfig configures Prometheus metrics, or EMF metrics in Lambda mode
+type MetricsConfig struct {
+	Enabled   bool   `yaml:"enabled"`
+	Namespace string `yaml:"namespace"` // CloudWatch namespace in Lambda mode
+}
+
+// LambdaConfig configures Lambda mode and the local Lambda emulator
+type LambdaConfig struct {
+	Streaming    bool   `yaml:"streaming"`     // stream Function URL responses; needs invoke mode RESPONSE_STREAM
+	LocalEvent   string `yaml:"local_event"`   // event shape sent by lambda-local
+	LocalStorage string `yaml:"local_storage"` // storage of lambda-local: memory or local
+}
+
+// DefaultConfig returns the built-in defaults
+func DefaultConfig() *Config {
+	return &Config{
+		Server:  ServerConfig{Port: 8080, SocketMode: DefaultSocketMode},
+		Storage: StorageConfig{Dir: "/note", Backend: "s3", S3Prefix: "note"},
+		NoteID: NoteIDConfig{
+			Style:    "random",
+			Length:   DefaultIDLength,
+			Alphabet: DefaultIDAlphabet,
+			Words:    DefaultIDWords,
+		},
+		Auth: AuthConfig{OIDC: OIDCConfig{SessionTTL: DefaultSessionTTL}},
+		Limits: LimitsConfig{
+			MaxNoteSize: formatSize(DefaultMaxNoteSize),
+			RateLimit: RateLimitConfig{
+				Enabled:    true,
+				Read:       DefaultReadLimit,
+				Write:      DefaultWriteLimit,
+				Create:     DefaultCreateLimit,
+				MaxClients: DefaultMaxRateClients,
+			},
+		},
+		Logging: LoggingConfig{Level: "info", Format: "text"},
+		Metrics: MetricsConfig{Enabled: true, Namespace: DefaultMetricsNamespace},
+		Lambda:  LambdaConfig{LocalEvent: "apigw-v2", LocalStorage: "memory"},
+		Webhooks: WebhooksConfig{
+			QueueSize:   DefaultWebhookQueueSize,
+			MaxAttempts: DefaultWebhookMaxAttempts,
+			Timeout:     DefaultWebhookTimeout,
+		},
+		TrustedProxies: splitList(defaultTrustedProxies),
+	}
+}
+
+// setting binds a config field to its environment variable and flag
+type setting struct {
+	key   string // path in the config file
+	env   string
+	usage string
+	value flag.Value
+}
+
+// flagName derives the command-line flag of a setting from its key, e.g.
+// limits.rate_limit.read becomes -limits-rate-limit-read
+func (s setting) flagName() string {
+	return strings.NewReplacer(".", "-", "_", "-").Replace(s.key)
+}
+
+// settings lists every field of c that can be set by environment variables
+// and flags
+func (c *Config) settings() []setting {
+	return []setting{
+		{"server.port", "PORT", "HTTP server port", (*intValue)(&c.Server.Port)},
+		{"server.listen", "LISTEN", "listen address, host:port or unix:/path/to/socket", (*stringValue)(&c.Server.Listen)},
+		{"server.socket_mode", "SOCKET_MODE", "permissions of a Unix socket", (*stringValue)(&c.Server.SocketMode)},
+		{"server.socket_group", "SOCKET_GROUP", "group owning a Unix socket", (*stringValue)(&c.Server.SocketGroup)},
+		{"server.url", "URL", "public app URL", (*stringValue)(&c.Server.URL)},
+		{"server.tls.cert_file", "TLS_CERT_FILE", "TLS certificate file, enables HTTPS", (*stringValue)(&c.Server.TLS.CertFile)},
+		{"server.tls.key_file", "TLS_KEY_FILE", "TLS private key file", (*stringValue)(&c.Server.TLS.KeyFile)},
+		{"server.tls.client_ca_file", "TLS_CLIENT_CA_FILE", "CA bundle verifying TLS client certificates", (*stringValue)(&c.Server.TLS.ClientCAFile)},
+		{"server.tls.client_auth", "TLS_CLIENT_AUTH", "TLS client certificates: none, optional or require", (*stringValue)(&c.Server.TLS.ClientAuth)},
+		{"server.tls.redirect_port", "TLS_REDIRECT_PORT", "HTTP port redirecting to HTTPS", (*intValue)(&c.Server.TLS.RedirectPort)},
+		{"storage.dir", "NOTE_DIR", "directory of notes in HTTP server mode", (*stringValue)(&c.Storage.Dir)},
+		{"storage.backend", "STORAGE_BACKEND", "storage in Lambda mode: s3 or dynamodb", (*stringValue)(&c.Storage.Backend)},
+		{"storage.s3_bucket", "S3_BUCKET", "S3 bucket of notes in Lambda mode", (*stringValue)(&c.Storage.S3Bucket)},
+		{"storage.s3_prefix", "S3_PREFIX", "S3 key prefix of notes", (*stringValue)(&c.Storage.S3Prefix)},
+		{"storage.dynamodb_table", "DYNAMODB_TABLE", "DynamoDB table of notes", (*stringValue)(&c.Storage.DynamoTable)},
+		{"storage.dynamodb_ttl", "DYNAMODB_TTL", "time after the last save when notes in DynamoDB expire, 0 for never", (*durationValue)(&c.Storage.DynamoTTL)},
+		{"storage.dynamodb_endpoint", "DYNAMODB_ENDPOINT", "DynamoDB endpoint URL, e.g. of DynamoDB Local", (*stringValue)(&c.Storage.DynamoEndpoint)},
+		{"note_id.style", "NOTE_ID_STYLE", "note ID style: random or words", (*stringValue)(&c.NoteID.Style)},
+		{"note_id.length", "NOTE_ID_LENGTH", "length of random note IDs", (*intValue)(&c.NoteID.Length)},
+		{"note_id.alphabet", "NOTE_ID_ALPHABET", "characters of random note IDs", (*stringValue)(&c.NoteID.Alphabet)},
+		{"note_id.words", "NOTE_ID_WORDS", "number of words in note IDs", (*intValue)(&c.NoteID.Words)},
+		{"auth.tokens", "AUTH_TOKENS", "comma-separated bearer tokens as name:token[:scopes]", &listValue{&c.Auth.Tokens, splitList}},
+		{"auth.htpasswd_file", "AUTH_HTPASSWD_FILE", "htpasswd file of Basic auth users", (*stringValue)(&c.Auth.HtpasswdFile)},
+		{"auth.client_certs", "AUTH_CLIENT_CERTS", "authenticate TLS client certificates: on or off", (*switchValue)(&c.Auth.ClientCerts)},
+		{"auth.oidc.issuer", "OIDC_ISSUER", "OpenID Connect issuer URL", (*stringValue)(&c.Auth.OIDC.Issuer)},
+		{"auth.oidc.client_id", "OIDC_CLIENT_ID", "OpenID Connect client ID", (*stringValue)(&c.Auth.OIDC.ClientID)},
+		{"auth.oidc.client_secret", "OIDC_CLIENT_SECRET", "OpenID Connect client secret", (*stringValue)(&c.Auth.OIDC.ClientSecret)},
+		{"auth.oidc.redirect_url", "OIDC_REDIRECT_URL", "OpenID Connect callback URL", (*stringValue)(&c.Auth.OIDC.RedirectURL)},
+		{"auth.oidc.scopes", "OIDC_SCOPES", "space-separated OpenID Connect scopes", &listValue{&c.Auth.OIDC.Scopes, strings.Fields}},
+		{"auth.oidc.allowed_domains", "OIDC_ALLOWED_DOMAINS", "comma-separated email domains allowed to sign in", &listValue{&c.Auth.OIDC.AllowedDomains, splitList}},
+		{"auth.oidc.allowed_groups", "OIDC_ALLOWED_GROUPS", "comma-separated groups allowed to sign in", &listValue{&c.Auth.OIDC.AllowedGroups, splitList}},
+		{"auth.oidc.groups_claim", "OIDC_GROUPS_CLAIM", "ID token claim listing groups", (*stringValue)(&c.Auth.OIDC.GroupsClaim)},
+		{"auth.oidc.session_secret", "SESSION_SECRET", "key signing session cookies", (*stringValue)(&c.Auth.OIDC.SessionSecret)},
+		{"auth.oidc.session_ttl", "SESSION_TTL", "login session lifetime", (*durationValue)(&c.Auth.OIDC.SessionTTL)},
+		{"limits.max_note_size", "MAX_NOTE_SIZE", "maximum size of a note", (*stringValue)(&c.Limits.MaxNoteSize)},
+		{"limits.quota_total", "QUOTA_TOTAL", "maximum total size of all notes", (*stringValue)(&c.Limits.QuotaTotal)},
+		{"limits.quota_per_client", "QUOTA_PER_CLIENT", "maximum bytes added per user or client IP", (*stringValue)(&c.Limits.QuotaPerClient)},
+		{"limits.rate_limit.enabled", "RATE_LIMIT", "rate limiting: on or off", (*switchValue)(&c.Limits.RateLimit.Enabled)},
+		{"limits.rate_limit.read", "RATE_LIMIT_READ", "read budget per client IP", (*stringValue)(&c.Limits.RateLimit.Read)},
+		{"limits.rate_limit.write", "RATE_LIMIT_WRITE", "write budget per client IP", (*stringValue)(&c.Limits.RateLimit.Write)},
+		{"limits.rate_limit.create", "RATE_LIMIT_CREATE", "note creation budget per client IP", (*stringValue)(&c.Limits.RateLimit.Create)},
+		{"limits.rate_limit.allowlist", "RATE_LIMIT_ALLOWLIST", "comma-separated CIDRs never throttled", &listValue{&c.Limits.RateLimit.Allowlist, splitList}},
+		{"limits.rate_limit.max_clients", "RATE_LIMIT_MAX_CLIENTS", "maximum clients tracked by the rate limiter", (*intValue)(&c.Limits.RateLimit.MaxClients)},
+		{"logging.level", "LOG_LEVEL", "log level: debug, info, warn or error", (*stringValue)(&c.Logging.Level)},
+		{"logging.format", "LOG_FORMAT", "log format: text or json", (*stringValue)(&c.Logging.Format)},
+		{"metrics.enabled", "METRICS", "metrics: on or off", (*switchValue)(&c.Metrics.Enabled)},
+		{"metrics.namespace", "METRICS_NAMESPACE", "CloudWatch metrics namespace", (*stringValue)(&c.Metrics.Namespace)},
+		{"lambda.streaming", "LAMBDA_STREAMING", "stream Function URL responses: on or off", (*switchValue)(&c.Lambda.Streaming)},
+		{"lambda.local_event", "LAMBDA_LOCAL_EVENT", "event sent by lambda-local: apigw-v1, apigw-v2, function-url, alb or alb-multivalue", (*stringValue)(&c.Lambda.LocalEvent)},
+		{"lambda.local_storage", "LAMBDA_LOCAL_STORAGE", "storage of lambda-local: memory or local", (*stringValue)(&c.Lambda.LocalStorage)},
+		{"webhooks.subscriptions", "WEBHOOKS", "space-separated webhook URLs as [notes=]url, notes being comma-separated note IDs or namespaces ending in /", (*webhooksValue)(&c.Webhooks.Subscriptions)},
+		{"webhooks.secret", "WEBHOOK_SECRET", "key signing webhook payloads", (*stringValue)(&c.Webhooks.Secret)},
+		{"webhooks.queue_size", "WEBHOOK_QUEUE_SIZE", "webhook deliveries waiting to be sent; more are dropped", (*intValue)(&c.Webhooks.QueueSize)},
+		{"webhooks.max_attempts", "WEBHOOK_MAX_ATTEMPTS", "attempts per webhook delivery", (*intValue)(&c.Webhooks.MaxAttempts)},
+		{"webhooks.timeout", "WEBHOOK_TIMEOUT", "timeout of a webhook attempt", (*durationValue)(&c.Webhooks.Timeout)},
+		{"trusted_proxies", "TRUSTED_PROXIES", "comma-separated CIDRs of trusted proxies, or none", &listValue{&c.TrustedProxies, splitList}},
+	}
+}
+
+// LoadConfig builds the configuration from the command-line arguments
+// (without the program name) and environment variables looked up with
+// getenv, reading the config file named by -config or CONFIG_FILE. It
+// reports whether -print-config was given.
+func LoadConfig(args []string, getenv func(string) string) (cfg *Config, printConfig bool, err error) {
+	cfg = DefaultConfig()
+	settings := cfg.settings()
+
+	fs := flag.NewFlagSet("note", flag.ContinueOnError)
+	configFile := fs.String("config", getenv("CONFIG_FILE"), "YAML config file (env CONFIG_FILE)")
+	fs.BoolVar(&printConfig, "print-config", false, "print the effective configuration and exit")
+	// Flags are applied last, after the config file and environment
+	var overrides []func() error
+	for _, s := range settings {
+		name := s.flagName()
+		fs.Func(name, fmt.Sprintf("%s (env %s)", s.usage, s.env), func(v string) error {
+			overrides = append(overrides, func() error {
+				if err := s.value.Set(v); err != nil {
+					return fmt.Errorf("-%s: %w", name, err)
+				}
+				return nil
+			})
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, false, err
+	}
+	if fs.NArg() > 0 {
+		return nil, false, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+
+	if *configFile != "" {
+		if err := cfg.loadFile(*configFile); err != nil {
+			return nil, false, err
+		}
+	}
+	for _, s := range settings {
+		if v := getenv(s.env); v != "" {
+			if err := s.value.Set(v); err != nil {
+				return nil, false, fmt.Errorf("%s: %w", s.env, err)
+			}
+		}
+	}
+	for _, apply := range overrides {
+		if err := apply(); err != nil {
+			return nil, false, err
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, false, err
+	}
+	return cfg, printConfig, nil
+}
+
+// loadFile merges the YAML config file at path into c, rejecting unknown keys
+func (c *Config) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open config file: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate checks every setting, reporting all invalid ones at once
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(key string, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+		}
+	}
+
+	if c.Server.Port <= 0 || c.Server.Port > 65535 {
+		check("server.port", fmt.Errorf("invalid port %d", c.Server.Port))
+	}
+	_, err := parseListenAddress(c.Server.Listen, c.Server.Port)
+	check("server.listen", err)
+	_, err = parseSocketMode(c.Server.SocketMode)
+	check("server.socket_mode", err)
+	if c.Server.URL != "" {
+		if u, err := url.Parse(c.Server.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			check("server.url", fmt.Errorf("invalid URL %q (expected http(s)://host[/path])", c.Server.URL))
+		}
+	}
+
+	check("server.tls", c.Server.TLS.validate(c.Server.Port))
+
+	switch c.Storage.Backend {
+	case "s3":
+	case "dynamodb":
+		if c.Storage.DynamoTable == "" {
+			check("storage.dynamodb_table", errors.New("required with the dynamodb backend"))
+		}
+	default:
+		check("storage.backend", fmt.Errorf("unknown backend %q (expected s3 or dynamodb)", c.Storage.Backend))
+	}
+	if c.Storage.DynamoTTL < 0 {
+		check("storage.dynamodb_ttl", fmt.Errorf("invalid TTL %s", c.Storage.DynamoTTL))
+	}
+
+	_, err = NewIDGeneratorFromConfig(c.NoteID)
+	check("note_id", err)
+
+	if len(c.Auth.Tokens) > 0 {
+		check("auth.tokens", NewAuthenticator("Note").AddTokens(strings.Join(c.Auth.Tokens, ",")))
+	}
+	if c.Auth.HtpasswdFile != "" {
+		_, err := os.Stat(c.Auth.HtpasswdFile)
+		check("auth.htpasswd_file", err)
+	}
+	if c.Auth.ClientCerts && c.Server.TLS.ClientCAFile == "" {
+		check("auth.client_certs", errors.New("a client CA file is required (set server.tls.client_ca_file)"))
+	}
+	if c.Auth.OIDC.Issuer != "" {
+		if c.Auth.OIDC.ClientID == "" {
+			check("auth.oidc.client_id", errors.New("required when an issuer is set"))
+		}
+		if c.Auth.OIDC.SessionTTL <= 0 {
+			check("auth.oidc.session_ttl", fmt.Errorf("invalid session lifetime %s", c.Auth.OIDC.SessionTTL))
+		}
+	}
+
+	if size, err := ParseSize(c.Limits.MaxNoteSize); err != nil || size == 0 {
+		check("limits.max_note_size", fmt.Errorf("invalid size %q", c.Limits.MaxNoteSize))
+	}
+	for _, v := range []struct{ key, spec string }{
+		{"limits.quota_total", c.Limits.QuotaTotal},
+		{"limits.quota_per_client", c.Limits.QuotaPerClient},
+	} {
+		if v.spec != "" {
+			_, err := ParseSize(v.spec)
+			check(v.key, err)
+		}
+	}
+	for _, v := range []struct{ key, spec string }{
+		{"limits.rate_limit.read", c.Limits.RateLimit.Read},
+		{"limits.rate_limit.write", c.Limits.RateLimit.Write},
+		{"limits.rate_limit.create", c.Limits.RateLimit.Create},
+	} {
+		_, err := ParseRateLimit(v.spec)
+		check(v.key, err)
+	}
+	_, err = ParsePrefixes(strings.Join(c.Limits.RateLimit.Allowlist, ","))
+	check("limits.rate_limit.allowlist", err)
+	if c.Limits.RateLimit.MaxClients <= 0 {
+		check("limits.rate_limit.max_clients", fmt.Errorf("invalid number of clients %d", c.Limits.RateLimit.MaxClients))
+	}
+
+	_, err = ParseLogLevel(c.Logging.Level)
+	check("logging.level", err)
+	_, err = NewLogger(io.Discard, c.Logging.Format, 0)
+	check("logging.format", err)
+
+	if !slices.Contains(localEvents, c.Lambda.LocalEvent) {
+		check("lambda.local_event", fmt.Errorf("unknown event %q (expected one of %s)", c.Lambda.LocalEvent, strings.Join(localEvents, ", ")))
+	}
+	if c.Lambda.LocalStorage != "memory" && c.Lambda.LocalStorage != "local" {
+		check("lambda.local_storage", fmt.Errorf("unknown storage %q (expected memory or local)", c.Lambda.LocalStorage))
+	}
+
+	check("webhooks", c.Webhooks.validate())
+
+	_, err = ParsePrefixes(strings.Join(c.TrustedProxies, ","))
+	check("trusted_proxies", err)
+
+	return errors.Join(errs...)
+}
+
+// redactedValue replaces secrets in printed configurations
+const redactedValue = "REDACTED"
+
+// Redacted returns a copy of c with tokens and secrets replaced
+func (c *Config) Redacted() *Config {
+	r := *c
+	r.Auth.Tokens = make([]string, len(c.Auth.Tokens))
+	for i, entry := range c.Auth.Tokens {
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) > 1 {
+			parts[1] = redactedValue
+		}
+		r.Auth.Tokens[i] = strings.Join(parts, ":")
+	}
+	if r.Auth.OIDC.ClientSecret != "" {
+		r.Auth.OIDC.ClientSecret = redactedValue
+	}
+	if r.Auth.OIDC.SessionSecret != "" {
+		r.Auth.OIDC.SessionSecret = redactedValue
+	}
+	if r.Webhooks.Secret != "" {
+		r.Webhooks.Secret = redactedValue
+	}
+	r.Webhooks.Subscriptions = slices.Clone(c.Webhooks.Subscriptions)
+	for i := range r.Webhooks.Subscriptions {
+		if r.Webhooks.Subscriptions[i].Secret != "" {
+			r.Webhooks.Subscriptions[i].Secret = redactedValue
+		}
+	}
+	return &r
+}
+
+// WriteYAML writes c as a config file with secrets redacted
+func (c *Config) WriteYAML(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(c.Redacted()); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// stringValue is a flag.Value setting a string field
+type stringValue string
+
+func (v *stringValue) Set(s string) error { *v = stringValue(s); return nil }
+func (v *stringValue) String() string     { return string(*v) }
+
+// intValue is a flag.Value setting an int field
+type intValue int
+
+func (v *intValue) Set(s string) error {
+	n, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		return fmt.Errorf("invalid number %q", s)
+	}
+	*v = intValue(n)
+	return nil
+}
+func (v *intValue) String() string { return strconv.Itoa(int(*v)) }
+
+// switchValue is a flag.Value setting a bool field from on/off or true/false
+type switchValue bool
+
+func (v *switchValue) Set(s string) error {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "on", "true", "yes", "1":
+		*v = true
+	case "off", "false", "no", "0":
+		*v = false
+	default:
+		return fmt.Errorf("invalid switch %q (use on or off)", s)
+	}
+	return nil
+}
+func (v *switchValue) String() string { return strconv.FormatBool(bool(*v)) }
+
+// durationValue is a flag.Value setting a time.Duration field
+type durationValue time.Duration
+
+func (v *durationValue) Set(s string) error {
+	d, err := time.ParseDuration(strings.TrimSpace(s))
+	if err != nil {
+		return fmt.Errorf("invalid duration %q", s)
+	}
+	*v = durationValue(d)
+	return nil
+}
+func (v *durationValue) String() string { return time.Duration(*v).String() }
+
+// webhooksValue is a flag.Value setting webhook subscriptions; "none" sets
+// an empty list
+type webhooksValue []WebhookSubscription
+
+func (v *webhooksValue) Set(s string) error {
+	if strings.EqualFold(strings.TrimSpace(s), "none") {
+		*v = []WebhookSubscription{}
+		return nil
+	}
+	*v = ParseWebhookSubscriptions(s)
+	return nil
+}
+func (v *webhooksValue) String() string {
+	if v == nil {
+		return ""
+	}
+	return formatWebhookSubscriptions(*v)
+}
+
+// listValue is a flag.Value setting a list field; "none" sets an empty list
+type listValue struct {
+	list  *[]string
+	split func(string) []string
+}
+
+func (v *listValue) Set(s string) error {
+	if strings.EqualFold(strings.TrimSpace(s), "none") {
+		*v.list = []string{}
+		return nil
+	}
+	*v.list = v.split(s)
+	return nil
+}
+func (v *listValue) String() string {
+	if v.list == nil {
+		return ""
+	}
+	return strings.Join(*v.list, ",")
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// envMap returns a getenv function over a fixed set of variables
+func envMap(vars map[string]string) func(string) string {
+	return func(name string) string { return vars[name] }
+}
+
+// writeConfigFile writes a config file in a temporary directory
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "note.yaml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	return path
+}
+
+// TestLoadConfigDefaults tests the built-in defaults
+func TestLoadConfigDefaults(t *testing.T) {
+	cfg, printConfig, err := LoadConfig(nil, envMap(nil))
+	if err != nil {
+		t.Fatalf("Failed to load defaults: %v", err)
+	}
+	if printConfig {
+		t.Errorf("Expected print-config to be off")
+	}
+	if cfg.Server.Port != 8080 || cfg.Storage.Dir != "/note" || cfg.Storage.S3Prefix != "note" {
+		t.Errorf("Unexpected defaults %+v %+v", cfg.Server, cfg.Storage)
+	}
+	if !cfg.Limits.RateLimit.Enabled || !cfg.Metrics.Enabled || cfg.Auth.OIDC.SessionTTL != DefaultSessionTTL {
+		t.Errorf("Expected rate limiting and metrics on by default")
+	}
+	if size, _ := MaxNoteSizeFromConfig(cfg.Limits); size != DefaultMaxNoteSize {
+		t.Errorf("Expected default note size %d, got %d", DefaultMaxNoteSize, size)
+	}
+	if a, err := NewAuthenticatorFromConfig(cfg.Auth); a != nil || err != nil {
+		t.Errorf("Expected authentication to be disabled by default, got %v %v", a, err)
+	}
+}
+
+// TestLoadConfigPrecedence tests that env overrides the file and flags override env
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := writeConfigFile(t, `
+server:
+  port: 9000
+  url: https://notes.example.com
+storage:
+  dir: /srv/file
+limits:
+  max_note_size: 2MB
+  rate_limit:
+    enabled: false
+    allowlist: [10.0.0.0/8]
+auth:
+  oidc:
+    session_ttl: 1h
+trusted_proxies: []
+`)
+	env := map[string]string{
+		"CONFIG_FILE": path,
+		"NOTE_DIR":    "/srv/env",
+		"PORT":        "9001",
+	}
+	cfg, _, err := LoadConfig([]string{"-server-port", "9002", "-metrics-enabled=off"}, envMap(env))
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if cfg.Server.Port != 9002 {
+		t.Errorf("Expected flag to override env, got port %d", cfg.Server.Port)
+	}
+	if cfg.Storage.Dir != "/srv/env" {
+		t.Errorf("Expected env to override the file, got dir %s", cfg.Storage.Dir)
+	}
+	if cfg.Server.URL != "https://notes.example.com" || cfg.Limits.MaxNoteSize != "2MB" || cfg.Auth.OIDC.SessionTTL != time.Hour {
+		t.Errorf("Expected file values to override defaults, got %+v", cfg)
+	}
+	if cfg.Limits.RateLimit.Enabled || cfg.Metrics.Enabled {
+		t.Errorf("Expected rate limiting and metrics to be switched off")
+	}
+	if len(cfg.TrustedProxies) != 0 || len(cfg.Limits.RateLimit.Allowlist) != 1 {
+		t.Errorf("Unexpected lists %v %v", cfg.TrustedProxies, cfg.Limits.RateLimit.Allowlist)
+	}
+	// Settings the file leaves out keep their defaults
+	if cfg.Limits.RateLimit.Read != DefaultReadLimit || cfg.NoteID.Length != DefaultIDLength {
+		t.Errorf("Expected defaults for unset keys, got %+v", cfg.Limits.RateLimit)
+	}
+
+	// The file named by the flag wins over CONFIG_FILE
+	other := writeConfigFile(t, "server:\n  port: 7000\n")
+	if cfg, _, err = LoadConfig([]string{"-config", other}, envMap(map[string]string{"CONFIG_FILE": path})); err != nil || cfg.Server.Port != 7000 {
+		t.Errorf("Expected -config to select the file, got %v %v", cfg, err)
+	}
+}
+
+// TestLoadConfigErrors tests that invalid settings are rejected at startup
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		file    string
+		message string
+	}{
+		{"unknown file key", nil, nil, "server:\n  prot: 80\n", "field prot not found"},
+		{"malformed env", nil, map[string]string{"PORT": "eighty"}, "", "PORT: invalid number"},
+		{"malformed flag", []string{"-limits-rate-limit-enabled", "maybe"}, nil, "", "-limits-rate-limit-enabled: invalid switch"},
+		{"unknown flag", []string{"-verbose"}, nil, "", "flag provided but not defined"},
+		{"stray argument", []string{"serve"}, nil, "", "unexpected argument"},
+		{"invalid port", nil, map[string]string{"PORT": "70000"}, "", "server.port"},
+		{"invalid listen address", nil, map[string]string{"LISTEN": "/run/note.sock"}, "", "server.listen"},
+		{"invalid socket mode", nil, map[string]string{"SOCKET_MODE": "0999"}, "", "server.socket_mode"},
+		{"relative URL", nil, map[string]string{"URL": "notes.example.com"}, "", "server.url"},
+		{"invalid size", nil, map[string]string{"MAX_NOTE_SIZE": "big"}, "", "limits.max_note_size"},
+		{"invalid rate limit", nil, map[string]string{"RATE_LIMIT_WRITE": "10/d"}, "", "limits.rate_limit.write"},
+		{"invalid proxy", nil, map[string]string{"TRUSTED_PROXIES": "proxy.local"}, "", "trusted_proxies"},
+		{"invalid log level", nil, map[string]string{"LOG_LEVEL": "verbose"}, "", "logging.level"},
+		{"OIDC without client", nil, map[string]string{"OIDC_ISSUER": "https://idp.example.com"}, "", "auth.oidc.client_id"},
+		{"invalid token", nil, map[string]string{"AUTH_TOKENS": "ci"}, "", "auth.tokens"},
+		{"key without certificate", nil, map[string]string{"TLS_KEY_FILE": "/etc/note/key.pem"}, "", "server.tls"},
+		{"redirect without TLS", nil, map[string]string{"TLS_REDIRECT_PORT": "80"}, "", "server.tls"},
+		{"invalid client auth", nil, map[string]string{"TLS_CERT_FILE": "c.pem", "TLS_KEY_FILE": "k.pem", "TLS_CLIENT_AUTH": "always"}, "", "server.tls"},
+		{"unknown local event", []string{"-lambda-local-event", "sqs"}, nil, "", "lambda.local_event"},
+		{"dynamodb without table", nil, map[string]string{"STORAGE_BACKEND": "dynamodb"}, "", "storage.dynamodb_table"},
+		{"invalid webhook URL", nil, map[string]string{"WEBHOOKS": "runbook=ftp://example.com/hook"}, "", "webhooks"},
+		{"unknown storage backend", []string{"-storage-backend", "redis"}, nil, "", "storage.backend"},
+		{"client certs without CA", nil, map[string]string{"AUTH_CLIENT_CERTS": "on"}, "", "auth.client_certs"},
+	}
+	for _, test := range tests {
+		env := map[string]string{}
+		for k, v := range test.env {
+			env[k] = v
+		}
+		if test.file != "" {
+			env["CONFIG_FILE"] = writeConfigFile(t, test.file)
+		}
+		_, _, err := LoadConfig(test.args, envMap(env))
+		if err == nil || !strings.Contains(err.Error(), test.message) {
+			t.Errorf("%s: expected error containing %q, got %v", test.name, test.message, err)
+		}
+	}
+
+	// All invalid settings are reported at once
+	_, _, err := LoadConfig(nil, envMap(map[string]string{"PORT": "0", "LOG_FORMAT": "xml"}))
+	if err == nil || !strings.Contains(err.Error(), "server.port") || !strings.Contains(err.Error(), "logging.format") {
+		t.Errorf("Expected both invalid settings to be reported, got %v", err)
+	}
+}
+
+// TestPrintConfig tests that the printed configuration can be loaded again
+// and has its secrets redacted
+func TestPrintConfig(t *testing.T) {
+	env := map[string]string{
+		"AUTH_TOKENS":        "ci:s3cret:read+write",
+		"OIDC_ISSUER":        "https://idp.example.com",
+		"OIDC_CLIENT_ID":     "note",
+		"OIDC_CLIENT_SECRET": "client-secret",
+		"SESSION_SECRET":     "session-secret",
+	}
+	cfg, printConfig, err := LoadConfig([]string{"-print-config"}, envMap(env))
+	if err != nil || !printConfig {
+		t.Fatalf("Expected print-config mode, got %v %v", printConfig, err)
+	}
+
+	var buf bytes.Buffer
+	if err := cfg.WriteYAML(&buf); err != nil {
+		t.Fatalf("Failed to print config: %v", err)
+	}
+	out := buf.String()
+	for _, secret := range []string{"s3cret", "client-secret", "session-secret"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("Printed config contains secret %q:\n%s", secret, out)
+		}
+	}
+	if !strings.Contains(out, "ci:REDACTED:read+write") || !strings.Contains(out, "session_ttl: 12h0m0s") {
+		t.Errorf("Unexpected printed config:\n%s", out)
+	}
+	if cfg.Auth.Tokens[0] != "ci:s3cret:read+write" {
+		t.Errorf("Redaction must not change the loaded config")
+	}
+
+	reloaded, _, err := LoadConfig(nil, envMap(map[string]string{"CONFIG_FILE": writeConfigFile(t, out)}))
+	if err != nil {
+		t.Fatalf("Failed to load printed config: %v", err)
+	}
+	if reloaded.Server != cfg.Server || reloaded.Limits.RateLimit.Read != cfg.Limits.RateLimit.Read || len(reloaded.TrustedProxies) != len(cfg.TrustedProxies) {
+		t.Errorf("Printed config does not round-trip: %+v", reloaded)
+	}
+}
@@ -2,59 +2,125 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
-	"os"
 	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // NoteRequest represents the JSON payload for saving a note
 type NoteRequest struct {
-	NoteID  string `json:"noteId"`
-	Content string `json:"content"`
+	NoteID    string `json:"noteId"`
+	Content   string `json:"content"`
+	EditToken string `json:"editToken,omitempty"`
+	Version   int64  `json:"version,omitempty"` // version the content is based on; 0 saves unconditionally
 }
 
 // NoteResponse represents the JSON response
 type NoteResponse struct {
-	Success bool   `json:"success"`
-	NoteID  string `json:"noteId,omitempty"`
-	Error   string `json:"error,omitempty"`
+	Success   bool   `json:"success"`
+	NoteID    string `json:"noteId,omitempty"`
+	EditToken string `json:"editToken,omitempty"` // only returned when the note is created
+	ReadID    string `json:"readId,omitempty"`    // only returned when the note is created
+	Version   int64  `json:"version,omitempty"`   // saved version, on storages that keep versions
+	Error     string `json:"error,omitempty"`
 }
 
 // HandleGet handles GET requests to retrieve a note
 func HandleGet(storage Storage) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		noteID := extractNoteID(r)
+		ctx, span := startSpan(r.Context(), "HandleGet")
+		defer span.End()
+		r = r.WithContext(ctx)
+
+		noteRef := extractNoteID(r)
+		span.SetAttributes(attribute.String("note.ref", noteRef))
 		clientIP := ClientIP(r)
 
-		if noteID != "" {
-			log.Printf("[GET] Retrieving note: %s from %s", noteID, clientIP)
+		if noteRef != "" {
+			slog.InfoContext(r.Context(), "Retrieving note", "note", noteRef, "ip", clientIP)
 		} else {
 			// Don't log for local requests, they are not interesting.
 			if clientIP != "127.0.0.1" && clientIP != "::1" {
-				log.Printf("[GET] Creating new note from %s", clientIP)
+				slog.InfoContext(r.Context(), "Creating new note", "ip", clientIP)
 			}
 		}
 
-		// Read note content from storage
+		// Resolve aliases to the underlying note ID
+		noteID := ""
+		if noteRef != "" {
+			var canonical string
+			var err error
+			noteID, canonical, err = resolveNoteRef(r.Context(), storage, noteRef)
+			if errors.Is(err, errInvalidNoteRef) {
+				slog.WarnContext(r.Context(), "Invalid note ID format", "note", noteRef)
+				http.Error(w, "Invalid note ID format", http.StatusBadRequest)
+				return
+			}
+			if err != nil {
+				slog.ErrorContext(r.Context(), "Failed to resolve note", "note", noteRef, "error", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			if canonical != noteRef {
+				slog.InfoContext(r.Context(), "Alias was renamed, redirecting", "alias", noteRef, "canonical", canonical)
+				http.Redirect(w, r, appPath(r)+"noteid/"+canonical, http.StatusMovedPermanently)
+				return
+			}
+			if noteID != noteRef {
+				slog.DebugContext(r.Context(), "Alias resolved", "alias", noteRef, "note", noteID)
+			}
+		}
+
+		// Read note content from storage, enforcing the note's access control list;
+		// notes the caller can read but not edit are shown read-only
 		content := ""
+		view := noteView{Access: DefaultAccessMode}
 		if noteID != "" {
-			var err error
-			content, err = storage.Read(r.Context(), noteID)
+			meta, err := ReadNoteMeta(r.Context(), storage, noteID)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "Failed to read note metadata", "note", noteID, "error", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			principal := PrincipalFromContext(r.Context())
+			token := r.URL.Query().Get("token")
+			if !meta.AllowsRead(principal, token) {
+				slog.WarnContext(r.Context(), "Read denied", "principal", principal.String(), "access", meta.AccessMode(), "note", noteID, "ip", clientIP)
+				http.Error(w, "Access denied", http.StatusForbidden)
+				return
+			}
+			if meta != nil {
+				view.ReadID = meta.ReadID
+				view.Access, view.Readers, view.Editors = meta.AccessMode(), meta.Readers, meta.Editors
+				switch {
+				case meta.privileged(principal):
+					view.EditToken = meta.EditToken
+				case meta.CanEdit(token) && meta.AccessMode() != AccessPrivate:
+					view.EditToken = token
+				}
+				view.ReadOnly = !meta.AllowsWrite(principal, token)
+			}
+
+			content, view.Version, err = readVersion(r.Context(), storage, noteID)
 			if err != nil {
-				log.Printf("[ERROR] Failed to read note %s: %v", noteID, err)
+				slog.ErrorContext(r.Context(), "Failed to read note", "note", noteID, "error", err)
 				http.Error(w, "Internal server error", http.StatusInternalServerError)
 				return
 			}
-			log.Printf("[SUCCESS] Note %s retrieved successfully", noteID)
+			slog.InfoContext(r.Context(), "Note retrieved", "note", noteID)
 		}
 
 		// If the client is curl and a note ID was requested, return raw text
-		if isCurlRequest(r) && noteID != "" {
+		if isCurlRequest(r) && noteRef != "" {
 			if content == "" {
 				http.Error(w, "Note not found", http.StatusNotFound)
 				return
@@ -66,30 +132,41 @@ func HandleGet(storage Storage) http.HandlerFunc {
 
 		// Render HTML with note content
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		renderHTML(w, noteID, content, r)
+		renderHTML(w, noteRef, content, r, view)
 	}
 }
 
 // HandlePost handles POST requests to save a note (refactored)
 func HandlePost(storage Storage) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := startSpan(r.Context(), "HandlePost")
+		defer span.End()
+		r = r.WithContext(ctx)
+
 		clientIP := ClientIP(r)
-		log.Printf("[POST] Request from %s", clientIP)
+		slog.DebugContext(r.Context(), "Save request", "ip", clientIP)
 
 		// CORS and preflight handling
 		setCORSHeaders(w)
 		if r.Method == http.MethodOptions {
-			log.Printf("[POST] Preflight OPTIONS request from %s", clientIP)
+			slog.DebugContext(r.Context(), "Preflight OPTIONS request", "ip", clientIP)
 			w.WriteHeader(http.StatusOK)
 			return
 		}
 
 		w.Header().Set("Content-Type", "application/json")
-
-		// Read body
-		bodyBytes, err := io.ReadAll(r.Body)
+		r = r.WithContext(withQuotaSubject(r.Context(), quotaSubject(r)))
+
+		// Read body, refusing uploads larger than a note can be
+		bodyBytes, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestSize()))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			slog.WarnContext(r.Context(), "Request body too large", "ip", clientIP, "limit", tooLarge.Limit)
+			writeJSONError(w, http.StatusRequestEntityTooLarge, "Note too large (max "+formatSize(maxNoteSize)+")")
+			return
+		}
 		if err != nil {
-			log.Printf("[ERROR] Failed to read request body: %v", err)
+			slog.ErrorContext(r.Context(), "Failed to read request body", "error", err)
 			writeJSONError(w, http.StatusInternalServerError, "Read error")
 			return
 		}
@@ -98,64 +175,271 @@ func HandlePost(storage Storage) http.HandlerFunc {
 		// Parse request
 		req, contentType, err := parseNoteRequest(r, bodyBytes, clientIP)
 		if err != nil {
-			log.Printf("[ERROR] Failed to parse request from %s: %v", clientIP, err)
+			slog.WarnContext(r.Context(), "Failed to parse request", "ip", clientIP, "error", err)
 			writeJSONError(w, http.StatusBadRequest, err.Error())
 			return
 		}
+		if int64(len(req.Content)) > maxNoteSize {
+			slog.WarnContext(r.Context(), "Note too large", "ip", clientIP, "size", len(req.Content))
+			writeJSONError(w, http.StatusRequestEntityTooLarge, "Note too large (max "+formatSize(maxNoteSize)+")")
+			return
+		}
 
-		// Ensure note ID
-		noteID := strings.TrimSpace(req.NoteID)
-		if noteID == "" {
-			noteID = GenerateNoteID()
-			log.Printf("[INFO] Generated new note ID: %s", noteID)
-		} else {
-			log.Printf("[INFO] Using provided note ID: %s", noteID)
+		// Resolve aliases; an unknown alias is created along with a new note
+		noteRef := strings.TrimSpace(req.NoteID)
+		noteID := noteRef
+		if noteRef != "" && !ValidateNoteID(noteRef) {
+			noteID, _, err = resolveNoteRef(r.Context(), storage, noteRef)
+			if errors.Is(err, errInvalidNoteRef) {
+				slog.WarnContext(r.Context(), "Invalid note ID format", "note", noteRef)
+				writeJSONError(w, http.StatusBadRequest, "Invalid note ID format")
+				return
+			}
+			if err != nil {
+				slog.ErrorContext(r.Context(), "Failed to resolve alias", "alias", noteRef, "error", err)
+				writeJSONError(w, http.StatusInternalServerError, "Failed to save note")
+				return
+			}
 		}
 
-		if !ValidateNoteID(noteID) {
-			log.Printf("[ERROR] Invalid note ID format: %s", noteID)
-			writeJSONError(w, http.StatusBadRequest, "Invalid note ID format")
-			return
+		// Existing notes can only be modified as permitted by their access control list
+		var meta *NoteMeta
+		var existing string // current content, "" if the note does not exist
+		if noteID != "" {
+			meta, err = ReadNoteMeta(r.Context(), storage, noteID)
+			if err == nil {
+				existing, err = storage.Read(r.Context(), noteID)
+			}
+			// A note that expired or was removed outside the app leaves its
+			// metadata behind, which must not apply to a new note with the same ID
+			if err == nil && meta != nil && existing == "" {
+				slog.InfoContext(r.Context(), "Removing metadata of missing note", "note", noteID)
+				err = DeleteNoteMeta(r.Context(), storage, noteID, meta)
+				meta = nil
+			}
+			if err != nil {
+				slog.ErrorContext(r.Context(), "Failed to read note metadata", "note", noteID, "error", err)
+				writeJSONError(w, http.StatusInternalServerError, "Failed to save note")
+				return
+			}
+			if principal := PrincipalFromContext(r.Context()); !meta.AllowsWrite(principal, editTokenFromRequest(r, req)) {
+				slog.WarnContext(r.Context(), "Edit denied", "principal", principal.String(), "access", meta.AccessMode(), "note", noteID, "ip", clientIP)
+				if meta.AccessMode() == AccessPrivate {
+					writeJSONError(w, http.StatusForbidden, "Access denied")
+				} else {
+					writeJSONError(w, http.StatusForbidden, "Edit token required")
+				}
+				return
+			}
 		}
 
-		// Save or delete
-		if strings.TrimSpace(req.Content) == "" {
-			log.Printf("[DELETE] Attempting to delete note: %s (Client: %s)", noteID, clientIP)
+		// Create, save or delete
+		var issued *NoteMeta     // metadata of a note created by this request
+		var version int64        // version saved by this request, if the storage keeps them
+		var change NoteEventType // reported to observers once saved
+		emptyContent := strings.TrimSpace(req.Content) == ""
+		switch {
+		case noteID == "" && emptyContent:
+			// Nothing to save and nothing to delete for a brand new note
+			if noteRef == "" {
+				noteRef = GenerateNoteID()
+			}
+			slog.InfoContext(r.Context(), "Empty new note, nothing saved", "note", noteRef)
+		case noteID == "":
+			if ok, wait := globalRateLimiter.AllowCreate(r); !ok {
+				setRetryAfter(w, wait)
+				writeJSONError(w, http.StatusTooManyRequests, "Too many new notes, please wait a moment")
+				return
+			}
+			slog.DebugContext(r.Context(), "Creating note", "size", len(req.Content), "ip", clientIP)
+			noteID, version, err = createNote(r.Context(), storage, req.Content)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "Failed to create note", "error", err)
+				writeSaveError(w, err)
+				return
+			}
+			slog.InfoContext(r.Context(), "Note created", "note", noteID, "size", len(req.Content))
+			change = NoteCreated
+			if issued, err = issueNoteMeta(r.Context(), storage, noteID); err != nil {
+				slog.ErrorContext(r.Context(), "Failed to issue edit token", "note", noteID, "error", err)
+				_ = storage.Delete(r.Context(), noteID)
+				writeJSONError(w, http.StatusInternalServerError, "Failed to save note")
+				return
+			}
+			if noteRef != "" {
+				if err := CreateAlias(r.Context(), storage, noteRef, noteID); err != nil {
+					slog.ErrorContext(r.Context(), "Failed to create alias", "alias", noteRef, "note", noteID, "error", err)
+					writeJSONError(w, http.StatusInternalServerError, "Failed to create alias")
+					return
+				}
+				slog.InfoContext(r.Context(), "Alias created", "alias", noteRef, "note", noteID)
+			} else {
+				noteRef = noteID
+			}
+		case emptyContent:
+			slog.DebugContext(r.Context(), "Deleting note", "note", noteID, "ip", clientIP)
 			if err := storage.Delete(r.Context(), noteID); err != nil {
-				log.Printf("[ERROR] Failed to delete note %s: %v", noteID, err)
+				slog.ErrorContext(r.Context(), "Failed to delete note", "note", noteID, "error", err)
 				writeJSONError(w, http.StatusInternalServerError, "Failed to delete note")
 				return
 			}
-			log.Printf("[SUCCESS] Note %s deleted successfully", noteID)
-		} else {
+			if err := DeleteNoteMeta(r.Context(), storage, noteID, meta); err != nil {
+				slog.ErrorContext(r.Context(), "Failed to delete note metadata", "note", noteID, "error", err)
+			}
+			slog.InfoContext(r.Context(), "Note deleted", "note", noteID)
+			change = NoteDeleted
+		default:
+			// A note saved for the first time under a chosen ID gets an edit token too;
+			// existing notes without metadata predate edit tokens and stay open
 			contentSize := len(req.Content)
-			log.Printf("[SAVE] Attempting to save note: %s (size: %d bytes, Client: %s)", noteID, contentSize, clientIP)
-			if err := storage.Write(r.Context(), noteID, req.Content); err != nil {
-				log.Printf("[ERROR] Failed to write note %s: %v", noteID, err)
-				writeJSONError(w, http.StatusInternalServerError, "Failed to save note")
-				return
+			slog.DebugContext(r.Context(), "Saving note", "note", noteID, "size", contentSize, "ip", clientIP)
+			isNew := false
+			if meta == nil && existing == "" {
+				if ok, wait := globalRateLimiter.AllowCreate(r); !ok {
+					setRetryAfter(w, wait)
+					writeJSONError(w, http.StatusTooManyRequests, "Too many new notes, please wait a moment")
+					return
+				}
+				// Of two racing first saves only one creates the note and gets the edit token
+				version, err = createVersion(r.Context(), storage, noteID, req.Content)
+				switch {
+				case err == nil:
+					isNew = true
+				case errors.Is(err, ErrNoteExists):
+					slog.InfoContext(r.Context(), "Note created concurrently, saving as update", "note", noteID)
+					if meta, err = ReadNoteMeta(r.Context(), storage, noteID); err != nil {
+						slog.ErrorContext(r.Context(), "Failed to read note metadata", "note", noteID, "error", err)
+						writeJSONError(w, http.StatusInternalServerError, "Failed to save note")
+						return
+					}
+					if !meta.AllowsWrite(PrincipalFromContext(r.Context()), editTokenFromRequest(r, req)) {
+						writeJSONError(w, http.StatusForbidden, "Edit token required")
+						return
+					}
+				default:
+					slog.ErrorContext(r.Context(), "Failed to create note", "note", noteID, "error", err)
+					writeSaveError(w, err)
+					return
+				}
+			}
+			// Editors send the version they loaded, so a save over someone
+			// else's newer one is refused instead of silently overwriting it
+			if !isNew {
+				vs, versioned := versionedStorage(storage)
+				if versioned && req.Version != 0 {
+					version, err = vs.WriteVersion(r.Context(), noteID, req.Content, req.Version)
+				} else {
+					err = storage.Write(r.Context(), noteID, req.Content)
+				}
+				if errors.Is(err, ErrVersionConflict) {
+					slog.InfoContext(r.Context(), "Note changed concurrently, save refused", "note", noteID, "version", req.Version, "ip", clientIP)
+					writeJSONError(w, http.StatusConflict, "This note was changed elsewhere, reload to see the latest version")
+					return
+				}
+				if err != nil {
+					slog.ErrorContext(r.Context(), "Failed to write note", "note", noteID, "error", err)
+					writeSaveError(w, err)
+					return
+				}
+			}
+			slog.InfoContext(r.Context(), "Note saved", "note", noteID, "size", contentSize)
+			change = NoteUpdated
+			if isNew {
+				change = NoteCreated
+				if issued, err = issueNoteMeta(r.Context(), storage, noteID); err != nil {
+					slog.ErrorContext(r.Context(), "Failed to issue edit token", "note", noteID, "error", err)
+					writeJSONError(w, http.StatusInternalServerError, "Failed to save note")
+					return
+				}
+			}
+		}
+
+		// Observers such as webhooks work asynchronously and log their own failures
+		if change != "" {
+			event := NoteEvent{Type: change, NoteID: noteID, Source: "app", Time: time.Now()}
+			if change != NoteDeleted {
+				event.Size = int64(len(req.Content))
 			}
-			log.Printf("[SUCCESS] Note %s saved successfully (size: %d bytes)", noteID, contentSize)
+			_ = notifyNoteChanged(r.Context(), event)
 		}
 
 		// Return success response
+		resp := NoteResponse{Success: true, NoteID: noteRef, Version: version}
+		if issued != nil {
+			resp.EditToken = issued.EditToken
+			resp.ReadID = issued.ReadID
+		}
+
 		if isCurlRequest(r) {
 			w.Header().Set("Content-Type", "text/plain")
-			fullURL := getBaseURL(r) + "noteid/" + noteID
+			fullURL := getBaseURL(r) + "noteid/" + noteRef
+			if issued != nil {
+				fullURL += "?token=" + issued.EditToken
+			}
 			_, _ = fmt.Fprintln(w, fullURL)
+			if issued != nil {
+				_, _ = fmt.Fprintln(w, getBaseURL(r)+"r/"+issued.ReadID)
+			}
 			return
 		}
 
 		if strings.Contains(contentType, "application/x-www-form-urlencoded") {
 			w.Header().Set("Content-Type", "text/plain")
-			_, _ = fmt.Fprintf(w, "OK: %s\n", noteID)
+			_, _ = fmt.Fprintf(w, "OK: %s\n", noteRef)
+			if issued != nil {
+				_, _ = fmt.Fprintf(w, "Edit token: %s\nRead-only ID: %s\n", issued.EditToken, issued.ReadID)
+			}
 			return
 		}
 
-		_ = json.NewEncoder(w).Encode(NoteResponse{Success: true, NoteID: noteID})
+		_ = json.NewEncoder(w).Encode(resp)
 	}
 }
 
+// maxCreateAttempts bounds how many generated IDs are tried before giving up
+const maxCreateAttempts = 5
+
+// createNote stores content under a freshly generated note ID, retrying with a
+// new ID whenever the generated one is already taken
+func createNote(ctx context.Context, storage Storage, content string) (string, int64, error) {
+	for attempt := 1; attempt <= maxCreateAttempts; attempt++ {
+		noteID := GenerateNoteID()
+		version, err := createVersion(ctx, storage, noteID, content)
+		if err == nil {
+			return noteID, version, nil
+		}
+		if !errors.Is(err, ErrNoteExists) {
+			return "", 0, err
+		}
+		slog.WarnContext(ctx, "Generated note ID already exists", "note", noteID, "attempt", attempt, "max_attempts", maxCreateAttempts)
+	}
+	return "", 0, fmt.Errorf("no free note ID found after %d attempts", maxCreateAttempts)
+}
+
+// createVersion saves a new note like Storage.Create, returning its version
+// on storages that keep versions and 0 otherwise
+func createVersion(ctx context.Context, storage Storage, noteID string, content string) (int64, error) {
+	vs, ok := versionedStorage(storage)
+	if !ok {
+		return 0, storage.Create(ctx, noteID, content)
+	}
+	version, err := vs.WriteVersion(ctx, noteID, content, 0)
+	if errors.Is(err, ErrVersionConflict) {
+		return 0, ErrNoteExists
+	}
+	return version, err
+}
+
+// readVersion reads a note with its version on storages that keep versions,
+// and with version 0 otherwise
+func readVersion(ctx context.Context, storage Storage, noteID string) (string, int64, error) {
+	if vs, ok := versionedStorage(storage); ok {
+		return vs.ReadVersion(ctx, noteID)
+	}
+	content, err := storage.Read(ctx, noteID)
+	return content, 0, err
+}
+
 // setCORSHeaders sets common CORS response headers
 func setCORSHeaders(w http.ResponseWriter) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -170,9 +454,13 @@ func writeJSONError(w http.ResponseWriter, status int, message string) {
 }
 
 // parseNoteRequest parses the request body into NoteRequest and returns the content type
-func parseNoteRequest(r *http.Request, bodyBytes []byte, clientIP string) (NoteRequest, string, error) {
-	var req NoteRequest
-	contentType := r.Header.Get("Content-Type")
+func parseNoteRequest(r *http.Request, bodyBytes []byte, clientIP string) (req NoteRequest, contentType string, err error) {
+	contentType = r.Header.Get("Content-Type")
+	_, span := startSpan(r.Context(), "parseNoteRequest",
+		attribute.String("http.request.header.content-type", contentType),
+		attribute.Int("http.request.body.size", len(bodyBytes)),
+	)
+	defer func() { endSpan(span, err) }()
 
 	if strings.Contains(contentType, "application/json") {
 		if err := json.Unmarshal(bodyBytes, &req); err != nil {
@@ -190,11 +478,12 @@ func parseNoteRequest(r *http.Request, bodyBytes []byte, clientIP string) (NoteR
 		if err == nil && (values.Has("text") || values.Has("noteId")) {
 			req.Content = values.Get("text")
 			req.NoteID = values.Get("noteId")
-			log.Printf("[INFO] Parsed form data from %s: noteId=%s, content_length=%d", clientIP, req.NoteID, len(req.Content))
+			req.EditToken = values.Get("token")
+			slog.DebugContext(r.Context(), "Parsed form data", "ip", clientIP, "note", req.NoteID, "size", len(req.Content))
 			return req, contentType, nil
 		}
 		req.Content = string(bodyBytes)
-		log.Printf("[INFO] Received %d bytes from raw form body from %s", len(bodyBytes), clientIP)
+		slog.DebugContext(r.Context(), "Received raw form body", "size", len(bodyBytes), "ip", clientIP)
 		return req, contentType, nil
 	}
 
@@ -205,7 +494,7 @@ func parseNoteRequest(r *http.Request, bodyBytes []byte, clientIP string) (NoteR
 	if req.NoteID == "" {
 		req.NoteID = extractPathNoteID(r)
 	}
-	log.Printf("[INFO] Received %d bytes from raw request body from %s", len(bodyBytes), clientIP)
+	slog.DebugContext(r.Context(), "Received raw request body", "size", len(bodyBytes), "ip", clientIP)
 	return req, contentType, nil
 }
 
@@ -215,8 +504,36 @@ func isCurlRequest(r *http.Request) bool {
 	return strings.Contains(strings.ToLower(userAgent), "curl")
 }
 
+// noteView controls how renderHTML presents a note
+type noteView struct {
+	ReadOnly  bool       // render without editing and auto-save
+	EditToken string     // edit token to send with saves
+	ReadID    string     // public ID of the note's read-only link
+	Access    AccessMode // access mode shown in the sharing dialog
+	Readers   []string   // users listed as readers in the sharing dialog
+	Editors   []string   // users listed as editors in the sharing dialog
+	Version   int64      // version of the note sent with saves; 0 if unknown
+}
+
 // renderHTML renders the main HTML template with note content
-func renderHTML(w http.ResponseWriter, noteID string, content string, r *http.Request) {
+func renderHTML(w http.ResponseWriter, noteID string, content string, r *http.Request, view noteView) {
+	readOnlyAttr, readOnlyBadge, linkAction, editor := "", "", "toggleLinkMenu(event)", editorScript+shareScript
+	shareButton := `<button class="btn" onclick="openShareDialog()" title="Share">
+                    <svg xmlns="http://www.w3.org/2000/svg" fill="none" viewBox="0 0 24 24" stroke-width="2" stroke="currentColor"><path stroke-linecap="round" stroke-linejoin="round" d="M15 19.128a9.38 9.38 0 0 0 2.625.372 9.337 9.337 0 0 0 4.121-.952 4.125 4.125 0 0 0-7.533-2.493M15 19.128v-.003c0-1.113-.285-2.16-.786-3.07M15 19.128v.106A12.318 12.318 0 0 1 8.624 21c-2.331 0-4.512-.645-6.374-1.766l-.001-.109a6.375 6.375 0 0 1 11.964-3.07M12 6.375a3.375 3.375 0 1 1-6.75 0 3.375 3.375 0 0 1 6.75 0Zm8.25 2.25a2.625 2.625 0 1 1-5.25 0 2.625 2.625 0 0 1 5.25 0Z"/></svg>
+                    <span class="btn-label">Share</span>
+                </button>`
+	if view.ReadOnly {
+		readOnlyAttr = " readonly"
+		readOnlyBadge = `<span class="badge">Read-only</span>`
+		linkAction = "copyNoteLink('read')"
+		editor = ""
+		shareButton = ""
+	}
+	if view.Access != "" && view.Access != DefaultAccessMode {
+		readOnlyBadge += `<span class="badge">` + EscapeHTML(accessLabels[view.Access]) + `</span>`
+	}
+	aclJSON, _ := json.Marshal(map[string]any{"access": view.Access, "readers": view.Readers, "editors": view.Editors})
+
 	html := `<!DOCTYPE html>
 <html lang="en">
 <head>
@@ -450,6 +767,113 @@ func renderHTML(w http.ResponseWriter, noteID string, content string, r *http.Re
             white-space: nowrap;
         }
 
+        /* ---- Link menu ---- */
+        .link-wrap {
+            position: relative;
+        }
+
+        .link-menu {
+            display: none;
+            position: absolute;
+            right: 0;
+            top: calc(100% + 6px);
+            min-width: 180px;
+            padding: 4px;
+            background: var(--white);
+            border: 1px solid var(--border);
+            border-radius: var(--radius);
+            box-shadow: var(--shadow-md);
+            z-index: 20;
+        }
+
+        .link-menu.show {
+            display: block;
+        }
+
+        .link-menu button {
+            display: block;
+            width: 100%;
+            padding: 8px 10px;
+            border: none;
+            background: none;
+            border-radius: 6px;
+            color: var(--text-secondary);
+            font-family: inherit;
+            font-size: 13px;
+            text-align: left;
+            cursor: pointer;
+        }
+
+        .link-menu button:hover {
+            background: var(--blue-50);
+            color: var(--blue-600);
+        }
+
+        .badge {
+            font-size: 11px;
+            font-weight: 600;
+            color: var(--blue-700);
+            background: var(--blue-100);
+            padding: 2px 8px;
+            border-radius: 4px;
+            white-space: nowrap;
+        }
+
+        /* ---- Share dialog ---- */
+        .dialog-backdrop {
+            display: none;
+            position: fixed;
+            inset: 0;
+            background: rgba(15, 23, 42, 0.35);
+            align-items: center;
+            justify-content: center;
+            z-index: 50;
+        }
+
+        .dialog-backdrop.show {
+            display: flex;
+        }
+
+        .dialog {
+            width: min(420px, calc(100% - 32px));
+            padding: 20px;
+            background: var(--white);
+            border-radius: var(--radius-lg);
+            box-shadow: var(--shadow-md);
+            display: flex;
+            flex-direction: column;
+            gap: 12px;
+        }
+
+        .dialog h2 {
+            font-size: 16px;
+            font-weight: 600;
+        }
+
+        .dialog label {
+            display: flex;
+            flex-direction: column;
+            gap: 4px;
+            font-size: 12px;
+            font-weight: 500;
+            color: var(--text-secondary);
+        }
+
+        .dialog select, .dialog input {
+            padding: 7px 10px;
+            border: 1px solid var(--border);
+            border-radius: 6px;
+            font-family: inherit;
+            font-size: 13px;
+            color: var(--text-primary);
+        }
+
+        .dialog-actions {
+            display: flex;
+            justify-content: flex-end;
+            gap: 6px;
+        }
+
         /* ---- Printable ---- */
         #printable {
             display: none;
@@ -577,6 +1001,7 @@ func renderHTML(w http.ResponseWriter, noteID string, content string, r *http.Re
             <div class="header-left">
                 <h1><span class="logo-icon">✎</span> Note</h1>
                 <span class="note-id" id="noteInfo">` + EscapeHTML(noteID) + `</span>
+                ` + readOnlyBadge + `
             </div>
             <div class="controls">
                 <button class="btn btn-primary" onclick="newNote()" title="New Note">
@@ -587,10 +1012,17 @@ func renderHTML(w http.ResponseWriter, noteID string, content string, r *http.Re
                     <svg xmlns="http://www.w3.org/2000/svg" fill="none" viewBox="0 0 24 24" stroke-width="2" stroke="currentColor"><path stroke-linecap="round" stroke-linejoin="round" d="M15.666 3.888A2.25 2.25 0 0 0 13.5 2.25h-3a2.25 2.25 0 0 0-2.166 1.638m7.332 0c.055.194.084.4.084.612v0a.75.75 0 0 1-.75.75H9.334a.75.75 0 0 1-.75-.75v0c0-.212.03-.418.084-.612m7.332 0c.646.049 1.288.11 1.927.184 1.1.128 1.907 1.077 1.907 2.185V19.5a2.25 2.25 0 0 1-2.25 2.25H6.416a2.25 2.25 0 0 1-2.25-2.25V6.846c0-1.108.806-2.057 1.907-2.185a48.507 48.507 0 0 1 1.927-.184"/></svg>
                     <span class="btn-label">Copy</span>
                 </button>
-                <button class="btn" onclick="copyNoteLink()" title="Copy Link">
+                <div class="link-wrap">
+                <button class="btn" onclick="` + linkAction + `" title="Copy Link">
                     <svg xmlns="http://www.w3.org/2000/svg" fill="none" viewBox="0 0 24 24" stroke-width="2" stroke="currentColor"><path stroke-linecap="round" stroke-linejoin="round" d="M13.19 8.688a4.5 4.5 0 0 1 1.242 7.244l-4.5 4.5a4.5 4.5 0 0 1-6.364-6.364l1.757-1.757m9.86-2.54a4.5 4.5 0 0 0-1.242-7.244l-4.5-4.5a4.5 4.5 0 0 0-6.364 6.364L4.34 8.374" transform="translate(1,1) scale(0.92)"/></svg>
                     <span class="btn-label">Link</span>
                 </button>
+                <div class="link-menu" id="linkMenu">
+                    <button onclick="copyNoteLink('edit')">Copy edit link</button>
+                    <button onclick="copyNoteLink('read')">Copy read-only link</button>
+                </div>
+                </div>
+                ` + shareButton + `
                 <button class="btn" onclick="window.print()" title="Print">
                     <svg xmlns="http://www.w3.org/2000/svg" fill="none" viewBox="0 0 24 24" stroke-width="2" stroke="currentColor"><path stroke-linecap="round" stroke-linejoin="round" d="M6.72 13.829c-.24.03-.48.062-.72.096m.72-.096a42.415 42.415 0 0 1 10.56 0m-10.56 0L6.34 18m10.94-4.171c.24.03.48.062.72.096m-.72-.096L17.66 18m0 0 .229 2.523a1.125 1.125 0 0 1-1.12 1.227H7.231c-.662 0-1.18-.568-1.12-1.227L6.34 18m11.318 0h1.091A2.25 2.25 0 0 0 21 15.75V9.456c0-1.081-.768-2.015-1.837-2.175a48.055 48.055 0 0 0-1.913-.247M6.34 18H5.25A2.25 2.25 0 0 1 3 15.75V9.456c0-1.081.768-2.015 1.837-2.175a48.041 48.041 0 0 1 1.913-.247m10.5 0a48.536 48.536 0 0 0-10.5 0m10.5 0V3.375c0-.621-.504-1.125-1.125-1.125h-8.25c-.621 0-1.125.504-1.125 1.125v3.659M18.75 7.281H5.25"/></svg>
                     <span class="btn-label">Print</span>
@@ -599,7 +1031,7 @@ func renderHTML(w http.ResponseWriter, noteID string, content string, r *http.Re
         </div>
 
         <div class="editor-wrap">
-            <textarea id="content" placeholder="Start typing your note...">` + EscapeHTML(content) + `</textarea>
+            <textarea id="content" placeholder="Start typing your note..."` + readOnlyAttr + `>` + EscapeHTML(content) + `</textarea>
         </div>
 
         <div class="status-bar">
@@ -611,14 +1043,43 @@ func renderHTML(w http.ResponseWriter, noteID string, content string, r *http.Re
         </div>
     </div>
 
+    <div class="dialog-backdrop" id="shareDialog">
+        <div class="dialog">
+            <h2>Share note</h2>
+            <label>Who can access
+                <select id="shareAccess">
+                    <option value="private">` + accessLabels[AccessPrivate] + ` – only you and the people below</option>
+                    <option value="team">` + accessLabels[AccessTeam] + ` – anyone signed in can read</option>
+                    <option value="link">` + accessLabels[AccessLink] + ` – anyone with the link can read</option>
+                    <option value="public">` + accessLabels[AccessPublic] + ` – anyone with the link can edit</option>
+                </select>
+            </label>
+            <label>Can read (comma-separated users)
+                <input id="shareReaders" placeholder="alice@example.com, bob@example.com">
+            </label>
+            <label>Can edit (comma-separated users)
+                <input id="shareEditors" placeholder="carol@example.com">
+            </label>
+            <div class="dialog-actions">
+                <button class="btn" onclick="closeShareDialog()">Cancel</button>
+                <button class="btn btn-primary" onclick="saveSharing()">Save</button>
+            </div>
+        </div>
+    </div>
+
     <div id="printable"></div>
     <div class="toast" id="toast"></div>
 
     <script>
-        const basePath = window.location.pathname.replace(/\/noteid\/.*$/, '');
+        const basePath = window.location.pathname.replace(/\/(noteid|r)\/.*$/, '');
         const appBase = basePath.endsWith('/') ? basePath : basePath + '/';
         let lastSaved = ` + "`" + EscapeHTML(content) + "`" + `;
         let currentNoteId = "` + EscapeHTML(noteID) + `";
+        let editToken = "` + EscapeHTML(view.EditToken) + `";
+        let readId = "` + EscapeHTML(view.ReadID) + `";
+        let noteVersion = ` + fmt.Sprint(view.Version) + `;
+        const readOnly = ` + fmt.Sprint(view.ReadOnly) + `;
+        let acl = ` + string(aclJSON) + `;
         const textarea = document.getElementById("content");
         const statusText = document.getElementById("statusText");
         const statusDot = document.getElementById("statusDot");
@@ -647,59 +1108,7 @@ func renderHTML(w http.ResponseWriter, noteID string, content string, r *http.Re
             window.location.href = appBase;
         }
 
-        // Auto-save
-        function autoSave() {
-            if (textarea.value !== lastSaved) {
-                setStatus('Saving...', 'saving');
-
-                const saveUrl = currentNoteId ? appBase + 'noteid/' + currentNoteId : appBase;
-                fetch(saveUrl, {
-                    method: 'POST',
-                    headers: { 'Content-Type': 'application/json' },
-                    body: JSON.stringify({ noteId: currentNoteId, content: textarea.value })
-                })
-                .then(function(response) {
-                    if (!response.ok) throw new Error('HTTP ' + response.status + ': ' + response.statusText);
-                    return response.json();
-                })
-                .then(function(data) {
-                    if (data.success) {
-                        lastSaved = textarea.value;
-                        currentNoteId = data.noteId;
-
-                        var newPath = appBase + 'noteid/' + data.noteId;
-                        if (window.location.pathname !== newPath && currentNoteId) {
-                            window.history.replaceState({}, '', newPath);
-                            document.getElementById('noteInfo').textContent = data.noteId;
-                        }
-
-                        setStatus('Saved', 'saved');
-                        setTimeout(function() {
-                            if (statusText.textContent === 'Saved') setStatus('Ready', 'ready');
-                        }, 2000);
-                    } else {
-                        setStatus('Error: ' + (data.error || 'Save failed'), 'error');
-                    }
-                })
-                .catch(function(err) {
-                    console.error('Save error:', err);
-                    setStatus('Error: ' + (err.message || 'Network error'), 'error');
-                });
-            }
-        }
-
-        setInterval(autoSave, 1000);
-
-        // TAB key
-        textarea.addEventListener('keydown', function(e) {
-            if (e.key === 'Tab') {
-                e.preventDefault();
-                var start = this.selectionStart;
-                var end = this.selectionEnd;
-                this.value = this.value.substring(0, start) + '\t' + this.value.substring(end);
-                this.selectionStart = this.selectionEnd = start + 1;
-            }
-        });
+` + editor + `
 
         textarea.addEventListener('input', function() {
             printableEl.textContent = this.value;
@@ -733,11 +1142,33 @@ func renderHTML(w http.ResponseWriter, noteID string, content string, r *http.Re
             return ok;
         }
 
-        function copyNoteLink() {
-            if (!currentNoteId) { showToast('Save a note first'); return; }
-            var link = window.location.origin + appBase + 'noteid/' + currentNoteId;
+        function toggleLinkMenu(e) {
+            e.stopPropagation();
+            document.getElementById('linkMenu').classList.toggle('show');
+        }
+
+        document.addEventListener('click', function() {
+            document.getElementById('linkMenu').classList.remove('show');
+        });
+
+        function copyNoteLink(kind) {
+            var path;
+            if (kind === 'read') {
+                if (readId) {
+                    path = 'r/' + readId;
+                } else if (readOnly && currentNoteId) {
+                    path = 'noteid/' + currentNoteId;
+                } else {
+                    showToast(currentNoteId ? 'No read-only link for this note' : 'Save a note first');
+                    return;
+                }
+            } else {
+                if (!currentNoteId) { showToast('Save a note first'); return; }
+                path = 'noteid/' + currentNoteId + (editToken ? '?token=' + encodeURIComponent(editToken) : '');
+            }
+            var link = window.location.origin + appBase + path;
             copyToClipboard(link).then(function(ok) {
-                showToast(ok ? 'Link copied!' : 'Could not copy link');
+                showToast(ok ? (kind === 'read' ? 'Read-only link copied!' : 'Edit link copied!') : 'Could not copy link');
             });
         }
 
@@ -749,6 +1180,7 @@ func renderHTML(w http.ResponseWriter, noteID string, content string, r *http.Re
             });
         }
 
+        if (readOnly) setStatus('Read-only', 'ready');
         textarea.focus();
     </script>
 </body>
@@ -757,6 +1189,138 @@ func renderHTML(w http.ResponseWriter, noteID string, content string, r *http.Re
 	_, _ = fmt.Fprint(w, html)
 }
 
+// editorScript holds the auto-save and editing behaviour of the note page,
+// left out entirely for read-only views
+const editorScript = `        // Auto-save; a note rejected as too large, over quota or changed elsewhere
+        // is not retried until it changes, and throttled saves wait as long as
+        // the server asks
+        var blockedContent = null;
+        var retryAt = 0;
+
+        function autoSave() {
+            if (textarea.value !== lastSaved && textarea.value !== blockedContent && Date.now() >= retryAt) {
+                setStatus('Saving...', 'saving');
+
+                const saveUrl = currentNoteId ? appBase + 'noteid/' + currentNoteId : appBase;
+                const content = textarea.value;
+                fetch(saveUrl, {
+                    method: 'POST',
+                    headers: { 'Content-Type': 'application/json', 'X-Edit-Token': editToken },
+                    body: JSON.stringify({ noteId: currentNoteId, content: content, version: noteVersion })
+                })
+                .then(function(response) {
+                    return response.json().catch(function() {
+                        return { success: false, error: 'HTTP ' + response.status + ': ' + response.statusText };
+                    }).then(function(data) {
+                        if (response.status === 409 || response.status === 413 || response.status === 507) {
+                            blockedContent = content;
+                        } else if (response.status === 429) {
+                            retryAt = Date.now() + 1000 * (parseInt(response.headers.get('Retry-After'), 10) || 5);
+                        }
+                        if (!response.ok && !data.error) data.error = 'HTTP ' + response.status + ': ' + response.statusText;
+                        return data;
+                    });
+                })
+                .then(function(data) {
+                    if (data.success) {
+                        lastSaved = content;
+                        currentNoteId = data.noteId;
+                        noteVersion = data.version || 0;
+                        if (data.editToken) {
+                            editToken = data.editToken;
+                            readId = data.readId || '';
+                        }
+
+                        var newPath = appBase + 'noteid/' + data.noteId + (editToken ? '?token=' + encodeURIComponent(editToken) : '');
+                        if (window.location.pathname + window.location.search !== newPath && currentNoteId) {
+                            window.history.replaceState({}, '', newPath);
+                            document.getElementById('noteInfo').textContent = data.noteId;
+                        }
+
+                        setStatus('Saved', 'saved');
+                        setTimeout(function() {
+                            if (statusText.textContent === 'Saved') setStatus('Ready', 'ready');
+                        }, 2000);
+                    } else {
+                        setStatus('Error: ' + (data.error || 'Save failed'), 'error');
+                    }
+                })
+                .catch(function(err) {
+                    console.error('Save error:', err);
+                    setStatus('Error: ' + (err.message || 'Network error'), 'error');
+                });
+            }
+        }
+
+        setInterval(autoSave, 1000);
+
+        // TAB key
+        textarea.addEventListener('keydown', function(e) {
+            if (e.key === 'Tab') {
+                e.preventDefault();
+                var start = this.selectionStart;
+                var end = this.selectionEnd;
+                this.value = this.value.substring(0, start) + '\t' + this.value.substring(end);
+                this.selectionStart = this.selectionEnd = start + 1;
+            }
+        });`
+
+// accessLabels are the display names of access modes
+var accessLabels = map[AccessMode]string{
+	AccessPrivate: "Private",
+	AccessTeam:    "Team",
+	AccessLink:    "Link",
+	AccessPublic:  "Public",
+}
+
+// shareScript holds the sharing dialog behaviour of the note page
+const shareScript = `
+
+        // Sharing dialog
+        function openShareDialog() {
+            if (!currentNoteId) { showToast('Save a note first'); return; }
+            document.getElementById('shareAccess').value = acl.access || 'link';
+            document.getElementById('shareReaders').value = (acl.readers || []).join(', ');
+            document.getElementById('shareEditors').value = (acl.editors || []).join(', ');
+            document.getElementById('shareDialog').classList.add('show');
+        }
+
+        function closeShareDialog() {
+            document.getElementById('shareDialog').classList.remove('show');
+        }
+
+        function splitUsers(value) {
+            return value.split(',').map(function(s) { return s.trim(); }).filter(Boolean);
+        }
+
+        function saveSharing() {
+            fetch(appBase + 'acl', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json', 'X-Edit-Token': editToken },
+                body: JSON.stringify({
+                    noteId: currentNoteId,
+                    access: document.getElementById('shareAccess').value,
+                    readers: splitUsers(document.getElementById('shareReaders').value),
+                    editors: splitUsers(document.getElementById('shareEditors').value)
+                })
+            })
+            .then(function(response) { return response.json(); })
+            .then(function(data) {
+                if (!data.success) throw new Error(data.error || 'Could not update sharing');
+                acl = data;
+                if (data.editToken) {
+                    editToken = data.editToken;
+                    readId = data.readId || '';
+                    window.history.replaceState({}, '', appBase + 'noteid/' + currentNoteId + '?token=' + encodeURIComponent(editToken));
+                }
+                closeShareDialog();
+                showToast('Sharing updated');
+            })
+            .catch(function(err) {
+                showToast(err.message || 'Could not update sharing');
+            });
+        }`
+
 // extractPathNoteID extracts a note ID from a path of the form /.../noteid/{id}
 func extractPathNoteID(r *http.Request) string {
 	path := r.URL.Path
@@ -776,12 +1340,17 @@ func extractNoteID(r *http.Request) string {
 	}
 	return extractPathNoteID(r)
 }
+
+// publicURL is the configured public app URL; empty detects it from requests
+var publicURL string
+
+// getBaseURL returns the public app root URL, honouring the configured URL
 func getBaseURL(r *http.Request) string {
-	if urlEnv := os.Getenv("URL"); urlEnv != "" {
-		if !strings.HasSuffix(urlEnv, "/") {
-			urlEnv += "/"
+	if publicURL != "" {
+		if !strings.HasSuffix(publicURL, "/") {
+			return publicURL + "/"
 		}
-		return urlEnv
+		return publicURL
 	}
 	scheme := "http"
 	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
@@ -791,13 +1360,21 @@ func getBaseURL(r *http.Request) string {
 	if fwdHost := r.Header.Get("X-Forwarded-Host"); fwdHost != "" {
 		host = fwdHost
 	}
-	// Remove any trailing /noteid/{id} from the path to get the app root (supports reverse proxy subpaths)
+	return scheme + "://" + host + appPath(r)
+}
+
+// appPath returns the app root path with a trailing slash by removing any
+// trailing /noteid/{id}, /ns/{namespace}, /r/{readId} or /auth/... from the request path
+// (supports reverse proxy subpaths)
+func appPath(r *http.Request) string {
 	path := r.URL.Path
-	if idx := strings.Index(path, "/noteid/"); idx != -1 {
-		path = path[:idx]
+	for _, marker := range []string{"/noteid/", "/ns/", "/r/", "/auth/"} {
+		if idx := strings.Index(path, marker); idx != -1 {
+			path = path[:idx]
+		}
 	}
 	if !strings.HasSuffix(path, "/") {
 		path += "/"
 	}
-	return scheme + "://" + host + path
+	return path
 }
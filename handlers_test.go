@@ -6,6 +6,8 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"sort"
+	"strings"
 	"testing"
 )
 
@@ -35,12 +37,84 @@ func (ms *MockStorage) Write(ctx context.Context, noteID string, content string)
 	return nil
 }
 
+// Create saves note content unless the note already exists
+func (ms *MockStorage) Create(ctx context.Context, noteID string, content string) error {
+	if _, ok := ms.data[noteID]; ok {
+		return ErrNoteExists
+	}
+	ms.data[noteID] = content
+	return nil
+}
+
 // Delete removes a note
 func (ms *MockStorage) Delete(ctx context.Context, noteID string) error {
 	delete(ms.data, noteID)
 	return nil
 }
 
+// List returns notes and sub-namespaces directly inside a namespace
+func (ms *MockStorage) List(ctx context.Context, namespace string) ([]string, error) {
+	prefix := ""
+	if namespace != "" {
+		prefix = namespace + "/"
+	}
+	seen := make(map[string]bool)
+	names := []string{}
+	for key := range ms.data {
+		if !strings.HasPrefix(key, prefix) || !ValidateNoteID(key) {
+			continue
+		}
+		name := strings.TrimPrefix(key, prefix)
+		if idx := strings.Index(name, "/"); idx != -1 {
+			name = name[:idx+1]
+		}
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// versionedMockStorage adds versions and an owner index to MockStorage
+type versionedMockStorage struct {
+	*MockStorage
+	versions map[string]int64
+	owners   map[string][]string
+}
+
+// newVersionedMockStorage creates an empty versionedMockStorage
+func newVersionedMockStorage() *versionedMockStorage {
+	return &versionedMockStorage{MockStorage: NewMockStorage(), versions: map[string]int64{}, owners: map[string][]string{}}
+}
+
+// ReadVersion retrieves note content with its version
+func (vs *versionedMockStorage) ReadVersion(ctx context.Context, noteID string) (string, int64, error) {
+	content, err := vs.Read(ctx, noteID)
+	return content, vs.versions[noteID], err
+}
+
+// WriteVersion saves note content if the note is still at version
+func (vs *versionedMockStorage) WriteVersion(ctx context.Context, noteID string, content string, version int64) (int64, error) {
+	if vs.versions[noteID] != version {
+		return 0, ErrVersionConflict
+	}
+	err := vs.Write(ctx, noteID, content)
+	return vs.versions[noteID], err
+}
+
+// Write saves note content as a new version
+func (vs *versionedMockStorage) Write(ctx context.Context, noteID string, content string) error {
+	vs.versions[noteID]++
+	return vs.MockStorage.Write(ctx, noteID, content)
+}
+
+// ListByOwner returns the notes listed for owner
+func (vs *versionedMockStorage) ListByOwner(ctx context.Context, owner string) ([]string, error) {
+	return vs.owners[owner], nil
+}
+
 // TestHandleGetEmpty tests GET request for empty note
 func TestHandleGetEmpty(t *testing.T) {
 	storage := NewMockStorage()
@@ -125,6 +199,59 @@ func TestHandlePostNewNote(t *testing.T) {
 	}
 }
 
+// sequenceIDGenerator returns IDs from a fixed list, for collision tests
+type sequenceIDGenerator struct {
+	ids []string
+	pos int
+}
+
+func (g *sequenceIDGenerator) NewID() string {
+	id := g.ids[g.pos%len(g.ids)]
+	g.pos++
+	return id
+}
+
+// TestHandlePostNewNoteCollision tests that a generated ID colliding with an
+// existing note is retried instead of overwriting the note
+func TestHandlePostNewNoteCollision(t *testing.T) {
+	storage := NewMockStorage()
+	_ = storage.Write(context.Background(), "TAKEN", "someone else's note")
+
+	previous := noteIDGenerator
+	noteIDGenerator = &sequenceIDGenerator{ids: []string{"TAKEN", "FREE1"}}
+	defer func() { noteIDGenerator = previous }()
+
+	body, _ := json.Marshal(NoteRequest{Content: "new content"})
+	req := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+
+	HandlePost(storage)(rec, req)
+
+	var resp NoteResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.NoteID != "FREE1" {
+		t.Errorf("Expected noteId FREE1, got %s", resp.NoteID)
+	}
+	if content, _ := storage.Read(context.Background(), "TAKEN"); content != "someone else's note" {
+		t.Errorf("Existing note was overwritten: %q", content)
+	}
+
+	// Every candidate taken: the request must fail rather than overwrite
+	noteIDGenerator = &sequenceIDGenerator{ids: []string{"TAKEN"}}
+	req = httptest.NewRequest("POST", "/", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec = httptest.NewRecorder()
+
+	HandlePost(storage)(rec, req)
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("Expected status 500, got %d", rec.Code)
+	}
+}
+
 // TestHandlePostExisting tests POST request to update existing note
 func TestHandlePostExisting(t *testing.T) {
 	storage := NewMockStorage()
@@ -160,6 +287,100 @@ func TestHandlePostExisting(t *testing.T) {
 	}
 }
 
+// racingStorage lets a competing first save win between a note being read
+// as missing and being created
+type racingStorage struct {
+	*MockStorage
+	race func()
+}
+
+// Create runs the competing save first
+func (rs *racingStorage) Create(ctx context.Context, noteID string, content string) error {
+	if race := rs.race; race != nil {
+		rs.race = nil
+		race()
+	}
+	return rs.MockStorage.Create(ctx, noteID, content)
+}
+
+// TestHandlePostFirstSaveRace tests that only one of two racing first saves
+// under a chosen ID gets an edit token
+func TestHandlePostFirstSaveRace(t *testing.T) {
+	storage := &racingStorage{MockStorage: NewMockStorage()}
+	handler := HandlePost(storage)
+	save := func(content, token string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(NoteRequest{NoteID: "runbook", Content: content, EditToken: token})
+		req := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	var winner NoteResponse
+	storage.race = func() {
+		_ = json.Unmarshal(save("first", "").Body.Bytes(), &winner)
+	}
+	rec := save("second", "")
+	if winner.EditToken == "" {
+		t.Fatalf("Expected the first save to get an edit token")
+	}
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected the losing save to need the edit token, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if content, _ := storage.Read(context.Background(), "runbook"); content != "first" {
+		t.Errorf("Expected the first save to be kept, got %q", content)
+	}
+
+	// With the winner's token the save is an ordinary update
+	if rec := save("third", winner.EditToken); rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "editToken") {
+		t.Errorf("Expected update without new token, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+// TestHandlePostVersionConflict tests that saves based on an outdated version
+// are refused, also through storage wrappers
+func TestHandlePostVersionConflict(t *testing.T) {
+	versioned := newVersionedMockStorage()
+	storage := NewQuotaStorage(InstrumentStorage(versioned, NewPrometheusMetrics()), 0, 0)
+
+	rec, created := postNote(t, storage, NoteRequest{Content: "first"})
+	if rec.Code != http.StatusOK || created.Version != 1 {
+		t.Fatalf("Expected note at version 1, got %d: %s", rec.Code, rec.Body.String())
+	}
+	rec, saved := postNote(t, storage, NoteRequest{NoteID: created.NoteID, Content: "second", EditToken: created.EditToken, Version: 1})
+	if rec.Code != http.StatusOK || saved.Version != 2 {
+		t.Fatalf("Expected note at version 2, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// Another editor still at version 1 would overwrite the second save
+	rec, _ = postNote(t, storage, NoteRequest{NoteID: created.NoteID, Content: "stale", EditToken: created.EditToken, Version: 1})
+	if rec.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for an outdated version, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if content, _ := versioned.Read(context.Background(), created.NoteID); content != "second" {
+		t.Errorf("Expected the second save to be kept, got %q", content)
+	}
+
+	// Clients without a version save unconditionally
+	if rec, _ := postNote(t, storage, NoteRequest{NoteID: created.NoteID, Content: "third", EditToken: created.EditToken}); rec.Code != http.StatusOK {
+		t.Errorf("Expected unversioned save to succeed, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// The page hands the loaded version to the editor
+	req := httptest.NewRequest("GET", "/noteid/"+created.NoteID+"?token="+created.EditToken, nil)
+	rec = httptest.NewRecorder()
+	HandleGet(storage)(rec, req)
+	if !strings.Contains(rec.Body.String(), "let noteVersion = 3;") {
+		t.Errorf("Expected version 3 in the page")
+	}
+
+	// Wrappers only report versions their storage keeps
+	if _, ok := versionedStorage(NewQuotaStorage(NewMockStorage(), 0, 0)); ok {
+		t.Errorf("Expected storage without versions to be detected")
+	}
+}
+
 // TestHandlePostInvalidID tests POST request with invalid note ID
 func TestHandlePostInvalidID(t *testing.T) {
 	storage := NewMockStorage()
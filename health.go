@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// readinessTimeout bounds the storage check of the readiness probe
+const readinessTimeout = 5 * time.Second
+
+// readinessCacheTTL is how long a storage check result answers readiness
+// probes, so that unauthenticated probes can't make every request a write
+const readinessCacheTTL = 10 * time.Second
+
+// probeKey is the internal record written by readiness checks. Each instance
+// uses its own key so concurrent checks don't read each other's values.
+var probeKey = ".health." + newRequestID()
+
+// isProbePath reports whether path is a health, readiness or version endpoint.
+// Probes need no credentials and are left out of request logs and traces.
+func isProbePath(path string) bool {
+	switch path {
+	case "/healthz", "/readyz", "/version":
+		return true
+	}
+	return false
+}
+
+// VersionResponse represents the JSON response of the version endpoint
+type VersionResponse struct {
+	Version    string `json:"version"`
+	BuildTime  string `json:"buildTime"`
+	CommitHash string `json:"commitHash"`
+	Storage    string `json:"storage"`
+}
+
+// HandleHealth reports that the process is alive
+func HandleHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	_, _ = fmt.Fprintln(w, "ok")
+}
+
+// HandleReady reports whether the storage backend is readable and writable.
+// The storage is checked at most once per readinessCacheTTL; concurrent
+// probes wait for the same check.
+func HandleReady(storage Storage) http.HandlerFunc {
+	var (
+		mu      sync.Mutex
+		checked time.Time
+		lastErr error
+	)
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+
+		mu.Lock()
+		if time.Since(checked) >= readinessCacheTTL {
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), readinessTimeout)
+			lastErr = checkStorage(ctx, storage)
+			cancel()
+			checked = time.Now()
+			if lastErr != nil {
+				slog.ErrorContext(r.Context(), "Readiness check failed", "error", lastErr)
+			}
+		}
+		err := lastErr
+		mu.Unlock()
+
+		if err != nil {
+			http.Error(w, "storage unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = fmt.Fprintln(w, "ok")
+	}
+}
+
+// checkStorage writes, reads back and deletes the probe record
+func checkStorage(ctx context.Context, storage Storage) error {
+	value := strconv.FormatInt(time.Now().UnixNano(), 10)
+	if err := storage.Write(ctx, probeKey, value); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+	got, err := storage.Read(ctx, probeKey)
+	if err != nil {
+		return fmt.Errorf("read: %w", err)
+	}
+	if got != value {
+		return fmt.Errorf("read back %q, expected %q", got, value)
+	}
+	if err := storage.Delete(ctx, probeKey); err != nil {
+		return fmt.Errorf("delete: %w", err)
+	}
+	return nil
+}
+
+// HandleVersion reports the build information and storage backend
+func HandleVersion(storageType string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(VersionResponse{
+			Version:    Version,
+			BuildTime:  BuildTime,
+			CommitHash: CommitHash,
+			Storage:    storageType,
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// readOnlyStorage is a Storage that rejects writes
+type readOnlyStorage struct {
+	*MockStorage
+}
+
+func (readOnlyStorage) Write(ctx context.Context, noteID string, content string) error {
+	return errors.New("read-only file system")
+}
+
+// TestHandleReady tests the storage check of the readiness probe
+func TestHandleReady(t *testing.T) {
+	storage := NewMockStorage()
+	rec := httptest.NewRecorder()
+	HandleReady(storage)(rec, httptest.NewRequest("GET", "/readyz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected status 200 with working storage, got %d", rec.Code)
+	}
+	if content, _ := storage.Read(context.Background(), probeKey); content != "" {
+		t.Errorf("Expected probe record to be removed, got %q", content)
+	}
+
+	rec = httptest.NewRecorder()
+	HandleReady(readOnlyStorage{NewMockStorage()})(rec, httptest.NewRequest("GET", "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503 with read-only storage, got %d", rec.Code)
+	}
+}
+
+// countingStorage counts writes to a Storage
+type countingStorage struct {
+	*MockStorage
+	writes int
+}
+
+func (cs *countingStorage) Write(ctx context.Context, noteID string, content string) error {
+	cs.writes++
+	return cs.MockStorage.Write(ctx, noteID, content)
+}
+
+// TestHandleReadyCached tests that repeated probes reuse the last storage check
+func TestHandleReadyCached(t *testing.T) {
+	storage := &countingStorage{MockStorage: NewMockStorage()}
+	handler := HandleReady(storage)
+	for range 5 {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest("GET", "/readyz", nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("Expected status 200, got %d", rec.Code)
+		}
+	}
+	if storage.writes != 1 {
+		t.Errorf("Expected a single storage check, got %d writes", storage.writes)
+	}
+}
+
+// TestProbesWithoutCredentials tests that probes pass authentication and report build info
+func TestProbesWithoutCredentials(t *testing.T) {
+	auth := NewAuthenticator("Note")
+	if err := auth.AddTokens("ci:citoken:read+write"); err != nil {
+		t.Fatalf("Failed to add tokens: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", HandleHealth)
+	mux.HandleFunc("/readyz", HandleReady(NewMockStorage()))
+	mux.HandleFunc("/version", HandleVersion("local"))
+	handler := auth.Middleware(mux)
+
+	for _, path := range []string{"/healthz", "/readyz", "/version"} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: expected status 200 without credentials, got %d", path, rec.Code)
+		}
+		if path != "/version" {
+			continue
+		}
+		var resp VersionResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Version != Version || resp.CommitHash != CommitHash || resp.Storage != "local" {
+			t.Errorf("Unexpected version response %s", rec.Body.String())
+		}
+	}
+}
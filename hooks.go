@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// NoteEventType is the kind of change to a note
+type NoteEventType string
+
+// Note changes reported to observers
+const (
+	NoteCreated NoteEventType = "created"
+	NoteUpdated NoteEventType = "updated"
+	NoteDeleted NoteEventType = "deleted"
+)
+
+// NoteEvent describes a change to a note
+type NoteEvent struct {
+	Type   NoteEventType `json:"type"`
+	NoteID string        `json:"noteId"`
+	Size   int64         `json:"size,omitempty"` // content size after the change, or of an expired note
+	Source string        `json:"source"`         // "app", "s3" for objects changed in the bucket directly, or "ttl" for notes expired in DynamoDB
+	Time   time.Time     `json:"time"`
+	// ID identifies the change at its source, such as an S3 notification or a
+	// stream record, so retries of the same notification carry the same ID.
+	// Changes made by the app have none.
+	ID string `json:"-"`
+	// UsageKey is the quota usage record of the client that saved an expired
+	// note last, if the storage recorded it
+	UsageKey string `json:"-"`
+}
+
+// NoteObserver reacts to changed notes
+type NoteObserver interface {
+	NoteChanged(ctx context.Context, event NoteEvent) error
+}
+
+// noteObservers are notified of every changed note
+var noteObservers []NoteObserver
+
+// notifyNoteChanged passes event to all observers, returning their errors
+// once all of them ran
+func notifyNoteChanged(ctx context.Context, event NoteEvent) error {
+	var errs []error
+	for _, o := range noteObservers {
+		if err := o.NoteChanged(ctx, event); err != nil {
+			slog.ErrorContext(ctx, "Note observer failed", "note", event.NoteID, "event", event.Type, "observer", fmt.Sprintf("%T", o), "error", err)
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// MetaObserver keeps note metadata consistent with notes changed outside the
+// app: a deleted note loses its metadata and read-only link, so neither
+// outlives it nor applies to a later note with the same ID
+type MetaObserver struct {
+	storage Storage
+}
+
+// NewMetaObserver creates a MetaObserver maintaining metadata in storage
+func NewMetaObserver(storage Storage) *MetaObserver {
+	return &MetaObserver{storage: storage}
+}
+
+// NoteChanged removes the metadata of notes deleted outside the app; the
+// app removes it along with the note itself
+func (m *MetaObserver) NoteChanged(ctx context.Context, event NoteEvent) error {
+	if event.Type != NoteDeleted || event.Source == "app" {
+		return nil
+	}
+	// Notifications may arrive late; skip notes that exist again
+	content, err := m.storage.Read(ctx, event.NoteID)
+	if err != nil || content != "" {
+		return err
+	}
+	meta, err := ReadNoteMeta(ctx, m.storage, event.NoteID)
+	if err != nil || meta == nil {
+		return err
+	}
+	slog.InfoContext(ctx, "Removing metadata of deleted note", "note", event.NoteID, "source", event.Source)
+	return DeleteNoteMeta(ctx, m.storage, event.NoteID, meta)
+}
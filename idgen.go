@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strings"
+)
+
+// Defaults for generated note IDs
+const (
+	DefaultIDAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	DefaultIDLength   = 5
+	DefaultIDWords    = 3
+)
+
+// IDGenerator produces candidate note IDs. Generated IDs are not guaranteed
+// to be unique; callers must check for collisions (see Storage.Create).
+type IDGenerator interface {
+	NewID() string
+}
+
+// noteIDGenerator is the generator used by GenerateNoteID
+var noteIDGenerator IDGenerator = &RandomIDGenerator{length: DefaultIDLength, alphabet: DefaultIDAlphabet}
+
+// GenerateNoteID creates a new random note ID using the configured generator
+func GenerateNoteID() string {
+	return noteIDGenerator.NewID()
+}
+
+// RandomIDGenerator generates fixed-length IDs from an alphabet using crypto/rand
+type RandomIDGenerator struct {
+	length   int
+	alphabet string
+}
+
+// NewRandomIDGenerator creates a RandomIDGenerator. The alphabet must consist of
+// at least two distinct alphanumeric characters so generated IDs stay valid.
+func NewRandomIDGenerator(length int, alphabet string) (*RandomIDGenerator, error) {
+	if length < 1 || length > 64 {
+		return nil, fmt.Errorf("note ID length must be between 1 and 64, got %d", length)
+	}
+	if len(alphabet) < 2 {
+		return nil, fmt.Errorf("note ID alphabet must have at least 2 characters")
+	}
+	seen := make(map[rune]bool)
+	for _, c := range alphabet {
+		if !isAlphanumeric(c) {
+			return nil, fmt.Errorf("note ID alphabet contains invalid character %q", c)
+		}
+		if seen[c] {
+			return nil, fmt.Errorf("note ID alphabet contains duplicate character %q", c)
+		}
+		seen[c] = true
+	}
+	return &RandomIDGenerator{length: length, alphabet: alphabet}, nil
+}
+
+// NewID returns a random ID with each character drawn uniformly from the alphabet
+func (g *RandomIDGenerator) NewID() string {
+	b := make([]byte, g.length)
+	for i := range b {
+		b[i] = g.alphabet[randomIndex(len(g.alphabet))]
+	}
+	return string(b)
+}
+
+// WordIDGenerator generates human-readable IDs such as "BraveOtterRiver"
+type WordIDGenerator struct {
+	words    int
+	wordlist []string
+}
+
+// NewWordIDGenerator creates a WordIDGenerator joining the given number of words
+func NewWordIDGenerator(words int) (*WordIDGenerator, error) {
+	if words < 1 || words > 8 {
+		return nil, fmt.Errorf("note ID word count must be between 1 and 8, got %d", words)
+	}
+	return &WordIDGenerator{words: words, wordlist: idWordlist}, nil
+}
+
+// NewID returns randomly chosen capitalized words joined together
+func (g *WordIDGenerator) NewID() string {
+	var sb strings.Builder
+	for i := 0; i < g.words; i++ {
+		w := g.wordlist[randomIndex(len(g.wordlist))]
+		sb.WriteString(strings.ToUpper(w[:1]) + w[1:])
+	}
+	return sb.String()
+}
+
+// NewIDGeneratorFromConfig builds the note ID generator of the given style,
+// random or words
+func NewIDGeneratorFromConfig(cfg NoteIDConfig) (IDGenerator, error) {
+	switch style := strings.ToLower(cfg.Style); style {
+	case "", "random":
+		alphabet := cfg.Alphabet
+		if alphabet == "" {
+			alphabet = DefaultIDAlphabet
+		}
+		return NewRandomIDGenerator(cfg.Length, alphabet)
+	case "words":
+		return NewWordIDGenerator(cfg.Words)
+	default:
+		return nil, fmt.Errorf("unknown note ID style %q (expected random or words)", style)
+	}
+}
+
+// randomIndex returns a uniformly distributed index in [0, n) using crypto/rand.
+// Rejection sampling avoids the modulo bias of a plain byte % n.
+func randomIndex(n int) int {
+	limit := 256 - 256%n
+	var b [1]byte
+	for {
+		_, _ = rand.Read(b[:])
+		if int(b[0]) < limit {
+			return int(b[0]) % n
+		}
+	}
+}
+
+// isAlphanumeric reports whether c is an ASCII letter or digit
+func isAlphanumeric(c rune) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// idWordlist holds short, unambiguous words for human-readable IDs
+var idWordlist = []string{
+	"amber", "apple", "arrow", "aspen", "atlas", "autumn", "badger", "basil",
+	"beacon", "birch", "bison", "blossom", "bold", "brave", "breeze", "brook",
+	"cactus", "calm", "canyon", "cedar", "cherry", "cider", "clever", "cloud",
+	"cobalt", "comet", "copper", "coral", "cosmic", "crane", "crisp", "dawn",
+	"delta", "desert", "dune", "eagle", "echo", "ember", "falcon", "fern",
+	"fiery", "fjord", "forest", "fossil", "fox", "frost", "gentle", "glacier",
+	"golden", "granite", "gravel", "harbor", "hazel", "heron", "hollow", "honey",
+	"indigo", "iris", "island", "ivory", "jade", "jolly", "juniper", "kelp",
+	"kind", "lagoon", "lark", "lemon", "lilac", "lively", "lotus", "lucky",
+	"lunar", "maple", "marble", "meadow", "mellow", "mint", "misty", "moss",
+	"nimble", "noble", "north", "oak", "ocean", "olive", "onyx", "orbit",
+	"otter", "owl", "pebble", "pepper", "pine", "plum", "polar", "prairie",
+	"quartz", "quiet", "rapid", "raven", "reef", "ridge", "river", "robin",
+	"ruby", "rustic", "sage", "salmon", "sandy", "silver", "sleek", "solar",
+	"spruce", "steady", "stone", "summit", "sunny", "swift", "thistle", "thunder",
+	"tidal", "timber", "topaz", "tulip", "tundra", "valley", "velvet", "violet",
+	"walnut", "willow", "winter", "wise", "yellow", "zephyr", "zesty", "zinc",
+}
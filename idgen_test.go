@@ -0,0 +1,116 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestRandomIDGenerator tests length and alphabet of generated IDs
+func TestRandomIDGenerator(t *testing.T) {
+	gen, err := NewRandomIDGenerator(12, "abc")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for i := 0; i < 100; i++ {
+		id := gen.NewID()
+		if len(id) != 12 {
+			t.Errorf("Generated ID has length %d, expected 12", len(id))
+		}
+		if strings.Trim(id, "abc") != "" {
+			t.Errorf("Generated ID %s uses characters outside the alphabet", id)
+		}
+		if !ValidateNoteID(id) {
+			t.Errorf("Generated ID %s is not valid", id)
+		}
+	}
+}
+
+// TestNewRandomIDGeneratorInvalid tests rejection of bad settings
+func TestNewRandomIDGeneratorInvalid(t *testing.T) {
+	tests := []struct {
+		length   int
+		alphabet string
+	}{
+		{0, DefaultIDAlphabet},
+		{65, DefaultIDAlphabet},
+		{5, "a"},
+		{5, "ab-"},
+		{5, "abca"},
+	}
+
+	for _, test := range tests {
+		if _, err := NewRandomIDGenerator(test.length, test.alphabet); err == nil {
+			t.Errorf("NewRandomIDGenerator(%d, %q) expected error", test.length, test.alphabet)
+		}
+	}
+}
+
+// TestWordIDGenerator tests human-readable IDs are valid note IDs
+func TestWordIDGenerator(t *testing.T) {
+	gen, err := NewWordIDGenerator(3)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for i := 0; i < 100; i++ {
+		id := gen.NewID()
+		if !ValidateNoteID(id) {
+			t.Errorf("Generated ID %s is not valid", id)
+		}
+		upper := 0
+		for _, c := range id {
+			if c >= 'A' && c <= 'Z' {
+				upper++
+			}
+		}
+		if upper != 3 {
+			t.Errorf("Generated ID %s should consist of 3 capitalized words", id)
+		}
+	}
+}
+
+// newIDGeneratorFromEnv builds the note ID generator configured by the environment
+func newIDGeneratorFromEnv() (IDGenerator, error) {
+	cfg, _, err := LoadConfig(nil, os.Getenv)
+	if err != nil {
+		return nil, err
+	}
+	return NewIDGeneratorFromConfig(cfg.NoteID)
+}
+
+// TestNewIDGeneratorFromEnv tests environment-based configuration
+func TestNewIDGeneratorFromEnv(t *testing.T) {
+	t.Setenv("NOTE_ID_STYLE", "random")
+	t.Setenv("NOTE_ID_LENGTH", "8")
+	t.Setenv("NOTE_ID_ALPHABET", "xyz")
+	gen, err := newIDGeneratorFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if id := gen.NewID(); len(id) != 8 || strings.Trim(id, "xyz") != "" {
+		t.Errorf("unexpected ID %s", id)
+	}
+
+	t.Setenv("NOTE_ID_STYLE", "words")
+	t.Setenv("NOTE_ID_WORDS", "2")
+	gen, err = newIDGeneratorFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := gen.(*WordIDGenerator); !ok {
+		t.Errorf("expected WordIDGenerator, got %T", gen)
+	}
+
+	t.Setenv("NOTE_ID_STYLE", "emoji")
+	if _, err := newIDGeneratorFromEnv(); err == nil {
+		t.Errorf("expected error for unknown style")
+	}
+
+	t.Setenv("NOTE_ID_STYLE", "random")
+	t.Setenv("NOTE_ID_LENGTH", "five")
+	if _, err := newIDGeneratorFromEnv(); err == nil {
+		t.Errorf("expected error for non-numeric length")
+	}
+}
@@ -5,109 +5,306 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"maps"
+	"mime"
 	"net/http"
+	"net/url"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// LambdaHandler handles AWS Lambda events from API Gateway v1 (REST) or v2 (HTTP)
-func LambdaHandler(ctx context.Context, request interface{}) (interface{}, error) {
-	eventData, _ := json.Marshal(request)
-
-	// Detect v2 format (HTTP API)
-	var v2Event events.APIGatewayV2HTTPRequest
-	if json.Unmarshal(eventData, &v2Event) == nil && v2Event.RequestContext.HTTP.Method != "" {
-		log.Printf("[DEBUG] Lambda v2: %s %s (IP: %s)",
-			v2Event.RequestContext.HTTP.Method,
-			v2Event.RawPath,
-			v2Event.RequestContext.HTTP.SourceIP,
-		)
-		return handleAPIGatewayV2(ctx, v2Event)
-	}
-
-	// Detect v1 format (REST API)
-	var v1Event events.APIGatewayProxyRequest
-	if json.Unmarshal(eventData, &v1Event) == nil && v1Event.HTTPMethod != "" {
-		log.Printf("[DEBUG] Lambda v1: %s %s (IP: %s)",
-			v1Event.HTTPMethod,
-			v1Event.Path,
-			v1Event.RequestContext.Identity.SourceIP,
-		)
-		return handleAPIGatewayV1(ctx, v1Event)
-	}
-
-	log.Printf("[ERROR] Unsupported event format: %s", string(eventData))
+// lambdaRouter serves the requests of Lambda events; it is built once per
+// container by initLambda
+var lambdaRouter http.Handler
+
+// Lambda event shapes served as HTTP requests
+const (
+	eventAPIGatewayV1 = "APIGatewayV1"
+	eventAPIGatewayV2 = "APIGatewayV2"
+	eventFunctionURL  = "FunctionURL"
+	eventALB          = "ALB"
+)
+
+// eventProbe decodes only the fields that tell the supported event shapes apart
+type eventProbe struct {
+	Records []struct {
+		EventSource string `json:"eventSource"`
+	} `json:"Records"`
+	HTTPMethod     string `json:"httpMethod"`
+	RequestContext struct {
+		ELB        json.RawMessage `json:"elb"`
+		DomainName string          `json:"domainName"`
+		HTTP       struct {
+			Method string `json:"method"`
+		} `json:"http"`
+	} `json:"requestContext"`
+}
+
+// detectEventType returns the shape of a Lambda event, or "" when it is not
+// supported
+func detectEventType(payload []byte) string {
+	var probe eventProbe
+	if json.Unmarshal(payload, &probe) != nil {
+		return ""
+	}
+	switch {
+	case len(probe.Records) > 0 && probe.Records[0].EventSource == "aws:s3":
+		return eventS3
+	case len(probe.Records) > 0 && probe.Records[0].EventSource == "aws:dynamodb":
+		return eventDynamoDB
+	case probe.HTTPMethod != "" && len(probe.RequestContext.ELB) > 0:
+		return eventALB
+	case probe.RequestContext.HTTP.Method != "" && strings.Contains(probe.RequestContext.DomainName, ".lambda-url."):
+		return eventFunctionURL
+	case probe.RequestContext.HTTP.Method != "":
+		return eventAPIGatewayV2
+	case probe.HTTPMethod != "":
+		return eventAPIGatewayV1
+	}
+	return ""
+}
+
+// eventKeys returns the sorted top-level keys of an event, which identify its
+// shape without revealing its content
+func eventKeys(payload []byte) []string {
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(payload, &fields) != nil {
+		return nil
+	}
+	return slices.Sorted(maps.Keys(fields))
+}
+
+// LambdaHandler handles AWS Lambda events from API Gateway v1 (REST) or v2
+// (HTTP), Lambda Function URLs and Application Load Balancer target groups,
+// S3 notifications of notes changed in the bucket, and DynamoDB stream records
+// of expired notes.
+// The payload is decoded once to detect its shape and once into the event.
+func LambdaHandler(ctx context.Context, payload json.RawMessage) (any, error) {
+	defer flushTraces(ctx)
+
+	eventType := detectEventType(payload)
+	var err error
+	switch eventType {
+	case eventAPIGatewayV2, eventFunctionURL:
+		// Function URLs use the payload format 2.0 of HTTP APIs
+		var event events.APIGatewayV2HTTPRequest
+		if err = json.Unmarshal(payload, &event); err == nil {
+			ctx, span := startLambdaSpan(ctx, eventType, event.Headers)
+			defer span.End()
+			if eventType == eventFunctionURL && lambdaStreaming {
+				return handleFunctionURLStream(ctx, event)
+			}
+			if eventType == eventFunctionURL {
+				return handleFunctionURL(ctx, event)
+			}
+			return handleAPIGatewayV2(ctx, event)
+		}
+	case eventAPIGatewayV1:
+		var event events.APIGatewayProxyRequest
+		if err = json.Unmarshal(payload, &event); err == nil {
+			ctx, span := startLambdaSpan(ctx, eventType, event.Headers)
+			defer span.End()
+			return handleAPIGatewayV1(ctx, event)
+		}
+	case eventS3:
+		var event events.S3Event
+		if err = json.Unmarshal(payload, &event); err == nil {
+			ctx, span := startSpan(ctx, "LambdaHandler", attribute.String("faas.trigger", "datasource"), attribute.String("note.lambda.event", eventType))
+			defer span.End()
+			return nil, handleS3Event(ctx, event)
+		}
+	case eventDynamoDB:
+		var event events.DynamoDBEvent
+		if err = json.Unmarshal(payload, &event); err == nil {
+			ctx, span := startSpan(ctx, "LambdaHandler", attribute.String("faas.trigger", "datasource"), attribute.String("note.lambda.event", eventType))
+			defer span.End()
+			return handleDynamoEvent(ctx, event)
+		}
+	case eventALB:
+		var event events.ALBTargetGroupRequest
+		if err = json.Unmarshal(payload, &event); err == nil {
+			headers := event.Headers
+			if event.MultiValueHeaders != nil {
+				headers = firstValues(event.MultiValueHeaders)
+			}
+			ctx, span := startLambdaSpan(ctx, eventType, headers)
+			defer span.End()
+			return handleALB(ctx, event)
+		}
+	}
+
+	// The payload itself may carry note content, tokens or cookies
+	slog.ErrorContext(ctx, "Unsupported event format", "type", eventType, "size", len(payload), "keys", eventKeys(payload), "error", err)
 	return events.APIGatewayProxyResponse{
 		StatusCode: 400,
 		Body:       `{"error":"Unsupported event format"}`,
 	}, nil
 }
 
-func handleAPIGatewayV2(ctx context.Context, event events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
-	req, _ := createRequestFromV2(event)
-	rec := &responseRecorder{
-		headers: make(http.Header),
-		body:    bytes.NewBuffer([]byte{}),
+// webhookFlushBudget is how long an invocation waits for its webhook
+// deliveries at most, and webhookFlushMargin the time left to return from
+// it after that
+const (
+	webhookFlushBudget = 2 * time.Second
+	webhookFlushMargin = time.Second
+)
+
+// flushWebhooks gives the webhook deliveries of an invocation a short time to
+// be attempted, since Lambda freezes the instance once it returns. Failures
+// are logged and returned; deliveries that are not done by then, or wait to
+// be retried, continue when the instance runs again.
+func flushWebhooks(ctx context.Context) error {
+	if globalWebhooks == nil {
+		return nil
+	}
+	deadline := time.Now().Add(webhookFlushBudget)
+	if invocation, ok := ctx.Deadline(); ok && invocation.Add(-webhookFlushMargin).Before(deadline) {
+		deadline = invocation.Add(-webhookFlushMargin)
 	}
+	flushCtx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+	err := globalWebhooks.Flush(flushCtx)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		slog.WarnContext(ctx, "Invocation ends before webhook deliveries finished", "queued", globalWebhooks.Queued(), "error", err)
+	case err != nil:
+		slog.WarnContext(ctx, "Webhook deliveries failed", "error", err)
+	}
+	return err
+}
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == http.MethodGet {
-			HandleGet(globalStorage)(w, r)
-		} else {
-			HandlePost(globalStorage)(w, r)
-		}
-	})
-	mux.ServeHTTP(rec, req)
+// startLambdaSpan starts the span of a Lambda invocation, continuing the trace
+// of the event's headers
+func startLambdaSpan(ctx context.Context, eventType string, headers map[string]string) (context.Context, trace.Span) {
+	ctx = extractTraceContext(ctx, propagation.MapCarrier(lowerKeys(headers)))
+	ctx, span := startSpan(ctx, "LambdaHandler", attribute.String("faas.trigger", "http"), attribute.String("note.lambda.event", eventType))
+	if lc, ok := lambdacontext.FromContext(ctx); ok {
+		span.SetAttributes(attribute.String("faas.invocation_id", lc.AwsRequestID))
+	}
+	return ctx, span
+}
 
-	headers := make(map[string]string)
-	for k, v := range rec.headers {
+// firstValues returns the first value of every multi-value header
+func firstValues(headers map[string][]string) map[string]string {
+	first := make(map[string]string, len(headers))
+	for k, v := range headers {
 		if len(v) > 0 {
-			headers[k] = v[0]
+			first[k] = v[0]
 		}
 	}
+	return first
+}
+
+// lowerKeys returns headers with lower-case names, as the propagators expect
+func lowerKeys(headers map[string]string) map[string]string {
+	lowered := make(map[string]string, len(headers))
+	for k, v := range headers {
+		lowered[strings.ToLower(k)] = v
+	}
+	return lowered
+}
+
+func handleAPIGatewayV2(ctx context.Context, event events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	ctx = withRequestID(ctx, lambdaRequestID(ctx, event.RequestContext.RequestID))
+	slog.DebugContext(ctx, "Lambda v2 request",
+		"method", event.RequestContext.HTTP.Method,
+		"path", event.RawPath,
+		"ip", event.RequestContext.HTTP.SourceIP,
+	)
+	_, span := startSpan(ctx, "createRequestFromV2")
+	req, err := createRequestFromV2(event)
+	endSpan(span, err)
+	rec := serveLambdaEvent(ctx, req, err)
+
+	// Payload format 2.0 returns cookies separately from the other headers
+	cookies := rec.headers.Values("Set-Cookie")
+	rec.headers.Del("Set-Cookie")
+	body, isBase64 := rec.lambdaBody()
 
 	return events.APIGatewayV2HTTPResponse{
-		StatusCode: rec.statusCode,
-		Body:       rec.body.String(),
-		Headers:    headers,
+		StatusCode:      rec.statusCode,
+		Body:            body,
+		IsBase64Encoded: isBase64,
+		Headers:         joinHeaders(rec.headers),
+		Cookies:         cookies,
 	}, nil
 }
 
 func handleAPIGatewayV1(ctx context.Context, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	req, _ := createRequestFromV1(event)
-	rec := &responseRecorder{
-		headers: make(http.Header),
-		body:    bytes.NewBuffer([]byte{}),
-	}
+	ctx = withRequestID(ctx, lambdaRequestID(ctx, event.RequestContext.RequestID))
+	slog.DebugContext(ctx, "Lambda v1 request",
+		"method", event.HTTPMethod,
+		"path", event.Path,
+		"ip", event.RequestContext.Identity.SourceIP,
+	)
+	_, span := startSpan(ctx, "createRequestFromV1")
+	req, err := createRequestFromV1(event)
+	endSpan(span, err)
+	rec := serveLambdaEvent(ctx, req, err)
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == http.MethodGet {
-			HandleGet(globalStorage)(w, r)
-		} else {
-			HandlePost(globalStorage)(w, r)
-		}
-	})
-	mux.ServeHTTP(rec, req)
-
-	headers := make(map[string]string)
-	for k, v := range rec.headers {
-		if len(v) > 0 {
-			headers[k] = v[0]
-		}
-	}
+	body, isBase64 := rec.lambdaBody()
 
 	return events.APIGatewayProxyResponse{
-		StatusCode: rec.statusCode,
-		Body:       rec.body.String(),
-		Headers:    headers,
+		StatusCode:        rec.statusCode,
+		Body:              body,
+		IsBase64Encoded:   isBase64,
+		MultiValueHeaders: rec.headers,
 	}, nil
 }
 
+// handleFunctionURL serves a Lambda Function URL request, which shares the
+// payload format of HTTP APIs
+func handleFunctionURL(ctx context.Context, event events.APIGatewayV2HTTPRequest) (events.LambdaFunctionURLResponse, error) {
+	resp, err := handleAPIGatewayV2(ctx, event)
+	return events.LambdaFunctionURLResponse{
+		StatusCode:      resp.StatusCode,
+		Headers:         resp.Headers,
+		Body:            resp.Body,
+		IsBase64Encoded: resp.IsBase64Encoded,
+		Cookies:         resp.Cookies,
+	}, err
+}
+
+// handleALB serves an Application Load Balancer request. With multi-value
+// headers enabled on the target group, the response must use them as well.
+func handleALB(ctx context.Context, event events.ALBTargetGroupRequest) (events.ALBTargetGroupResponse, error) {
+	ctx = withRequestID(ctx, lambdaRequestID(ctx, ""))
+	slog.DebugContext(ctx, "Lambda ALB request",
+		"method", event.HTTPMethod,
+		"path", event.Path,
+		"target_group", event.RequestContext.ELB.TargetGroupArn,
+	)
+	_, span := startSpan(ctx, "createRequestFromALB")
+	req, err := createRequestFromALB(event)
+	endSpan(span, err)
+	rec := serveLambdaEvent(ctx, req, err)
+
+	body, isBase64 := rec.lambdaBody()
+	resp := events.ALBTargetGroupResponse{
+		StatusCode:        rec.statusCode,
+		StatusDescription: fmt.Sprintf("%d %s", rec.statusCode, http.StatusText(rec.statusCode)),
+		Body:              body,
+		IsBase64Encoded:   isBase64,
+	}
+	if event.MultiValueHeaders != nil {
+		resp.MultiValueHeaders = rec.headers
+	} else {
+		resp.Headers = joinHeaders(rec.headers)
+	}
+	return resp, nil
+}
+
 func createRequestFromV2(event events.APIGatewayV2HTTPRequest) (*http.Request, error) {
 	method := event.RequestContext.HTTP.Method
 	path := event.RawPath
@@ -117,14 +314,23 @@ func createRequestFromV2(event events.APIGatewayV2HTTPRequest) (*http.Request, e
 
 	var body io.Reader = strings.NewReader(event.Body)
 	if event.IsBase64Encoded {
-		d, _ := base64.StdEncoding.DecodeString(event.Body)
+		d, err := base64.StdEncoding.DecodeString(event.Body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64 body: %w", err)
+		}
 		body = bytes.NewReader(d)
 	}
 
-	req, _ := http.NewRequest(method, path, body)
+	req, err := http.NewRequest(method, path, body)
+	if err != nil {
+		return nil, err
+	}
 	for k, v := range event.Headers {
 		req.Header.Set(k, v)
 	}
+	if len(event.Cookies) > 0 {
+		req.Header.Set("Cookie", strings.Join(event.Cookies, "; "))
+	}
 
 	// Set RemoteAddr so ClientIP() can fall back to it
 	req.RemoteAddr = event.RequestContext.HTTP.SourceIP
@@ -133,24 +339,42 @@ func createRequestFromV2(event events.APIGatewayV2HTTPRequest) (*http.Request, e
 }
 
 func createRequestFromV1(event events.APIGatewayProxyRequest) (*http.Request, error) {
-	path := event.Path
-	if len(event.QueryStringParameters) > 0 {
-		var params []string
+	// API Gateway passes query parameters decoded, so they must be encoded again
+	query := url.Values(event.MultiValueQueryStringParameters)
+	if query == nil {
+		query = make(url.Values, len(event.QueryStringParameters))
 		for k, v := range event.QueryStringParameters {
-			params = append(params, k+"="+v)
+			query.Set(k, v)
 		}
-		path += "?" + strings.Join(params, "&")
+	}
+	path := event.Path
+	if len(query) > 0 {
+		path += "?" + query.Encode()
 	}
 
 	var body io.Reader = strings.NewReader(event.Body)
 	if event.IsBase64Encoded {
-		d, _ := base64.StdEncoding.DecodeString(event.Body)
+		d, err := base64.StdEncoding.DecodeString(event.Body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64 body: %w", err)
+		}
 		body = bytes.NewReader(d)
 	}
 
-	req, _ := http.NewRequest(event.HTTPMethod, path, body)
-	for k, v := range event.Headers {
-		req.Header.Set(k, v)
+	req, err := http.NewRequest(event.HTTPMethod, path, body)
+	if err != nil {
+		return nil, err
+	}
+	if event.MultiValueHeaders != nil {
+		for k, values := range event.MultiValueHeaders {
+			for _, v := range values {
+				req.Header.Add(k, v)
+			}
+		}
+	} else {
+		for k, v := range event.Headers {
+			req.Header.Set(k, v)
+		}
 	}
 
 	// Set RemoteAddr so ClientIP() can fall back to it
@@ -159,23 +383,238 @@ func createRequestFromV1(event events.APIGatewayProxyRequest) (*http.Request, er
 	return req, nil
 }
 
+// maxBufferedBody is the largest body of a buffered Lambda response: the
+// 6 MB invocation payload limit, less room for the headers and the JSON
+// envelope
+const maxBufferedBody = 6*1024*1024 - 64*1024
+
+// serveLambdaEvent serves the request built from an event, or answers 400 if
+// the event could not be turned into a request
+func serveLambdaEvent(ctx context.Context, req *http.Request, err error) *responseRecorder {
+	if err != nil {
+		slog.WarnContext(ctx, "Invalid Lambda request", "error", err)
+		rec := &responseRecorder{headers: make(http.Header), body: bytes.NewBuffer([]byte{})}
+		rec.invalid(RequestIDFromContext(ctx))
+		return rec
+	}
+	rec := serveLambda(req.WithContext(ctx))
+	// Lambda freezes the instance once the invocation returns; failed
+	// deliveries are logged and leave the response alone
+	_ = flushWebhooks(ctx)
+	return rec
+}
+
+// serveLambda serves req through the Lambda router and records the response.
+// A response too large to be returned by Lambda is replaced with an error.
+func serveLambda(req *http.Request) *responseRecorder {
+	rec := &responseRecorder{
+		headers: make(http.Header),
+		body:    bytes.NewBuffer([]byte{}),
+	}
+	lambdaRouter.ServeHTTP(rec, req)
+	if rec.statusCode == 0 {
+		rec.statusCode = http.StatusOK
+	}
+	if req.Method == http.MethodHead {
+		rec.body.Reset()
+	}
+	if size := rec.encodedSize(); size > maxBufferedBody {
+		slog.ErrorContext(req.Context(), "Response too large for Lambda", "size", size, "limit", maxBufferedBody)
+		rec.tooLarge(size)
+	}
+	return rec
+}
+
+func createRequestFromALB(event events.ALBTargetGroupRequest) (*http.Request, error) {
+	// The load balancer passes query strings on as sent, still URL-encoded
+	query := event.MultiValueQueryStringParameters
+	if query == nil {
+		query = make(map[string][]string, len(event.QueryStringParameters))
+		for k, v := range event.QueryStringParameters {
+			query[k] = []string{v}
+		}
+	}
+	path := event.Path
+	if len(query) > 0 {
+		var params []string
+		for _, k := range slices.Sorted(maps.Keys(query)) {
+			for _, v := range query[k] {
+				params = append(params, k+"="+v)
+			}
+		}
+		path += "?" + strings.Join(params, "&")
+	}
+
+	var body io.Reader = strings.NewReader(event.Body)
+	if event.IsBase64Encoded {
+		d, err := base64.StdEncoding.DecodeString(event.Body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64 body: %w", err)
+		}
+		body = bytes.NewReader(d)
+	}
+
+	req, err := http.NewRequest(event.HTTPMethod, path, body)
+	if err != nil {
+		return nil, err
+	}
+	if event.MultiValueHeaders != nil {
+		for k, values := range event.MultiValueHeaders {
+			for _, v := range values {
+				req.Header.Add(k, v)
+			}
+		}
+	} else {
+		for k, v := range event.Headers {
+			req.Header.Set(k, v)
+		}
+	}
+	req.Host = req.Header.Get("Host")
+	req.RemoteAddr = popForwardedFor(req.Header)
+
+	return req, nil
+}
+
+// popForwardedFor removes the last X-Forwarded-For hop and returns it. The
+// load balancer appends the address it received the request from, so that
+// hop is the peer; earlier hops are only trusted as far as TRUSTED_PROXIES
+// allows.
+func popForwardedFor(header http.Header) string {
+	hops := splitList(strings.Join(header.Values("X-Forwarded-For"), ","))
+	if len(hops) == 0 {
+		return ""
+	}
+	if len(hops) == 1 {
+		header.Del("X-Forwarded-For")
+	} else {
+		header.Set("X-Forwarded-For", strings.Join(hops[:len(hops)-1], ", "))
+	}
+	return hops[len(hops)-1]
+}
+
 type responseRecorder struct {
 	statusCode int
 	headers    http.Header
 	body       *bytes.Buffer
+	written    int // bytes written by the handler, including discarded ones
+}
+
+// isText reports whether the recorded body is returned as a string
+func (r *responseRecorder) isText() bool {
+	contentType := r.headers.Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(r.body.Bytes())
+	}
+	return isTextContent(contentType)
+}
+
+// encodedSize returns the size of the body once encoded for Lambda
+func (r *responseRecorder) encodedSize() int {
+	if r.body.Len() == 0 || r.isText() {
+		return r.written
+	}
+	return base64.StdEncoding.EncodedLen(r.written)
+}
+
+// tooLarge replaces the recorded response with an error explaining that the
+// body exceeded the Lambda response limit
+func (r *responseRecorder) tooLarge(size int) {
+	requestID := r.headers.Get(RequestIDHeader)
+	r.statusCode = http.StatusInternalServerError
+	r.headers = make(http.Header)
+	r.headers.Set("Content-Type", "text/plain; charset=utf-8")
+	r.headers.Set(RequestIDHeader, requestID)
+	r.body.Reset()
+	fmt.Fprintf(r.body, "Response of %s exceeds the Lambda response limit of %s; serve it through a Function URL with response streaming\n",
+		formatSize(int64(size)), formatSize(maxBufferedBody))
+	r.written = r.body.Len()
+}
+
+// invalid replaces the response with a 400 for an event that is not a valid
+// HTTP request
+func (r *responseRecorder) invalid(requestID string) {
+	r.statusCode = http.StatusBadRequest
+	r.headers.Set("Content-Type", "text/plain; charset=utf-8")
+	if requestID != "" {
+		r.headers.Set(RequestIDHeader, requestID)
+	}
+	r.body.Reset()
+	r.body.WriteString("Bad request\n")
+	r.written = r.body.Len()
+}
+
+// lambdaBody returns the response body for a Lambda response, base64
+// encoded unless its content type is text
+func (r *responseRecorder) lambdaBody() (string, bool) {
+	if r.body.Len() == 0 {
+		return "", false
+	}
+	if r.isText() {
+		return r.body.String(), false
+	}
+	return base64.StdEncoding.EncodeToString(r.body.Bytes()), true
+}
+
+// isTextContent reports whether a content type can be returned as a string
+// without corrupting it
+func isTextContent(contentType string) bool {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if charset, ok := params["charset"]; ok && !strings.EqualFold(charset, "utf-8") && !strings.EqualFold(charset, "us-ascii") {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "+json"),
+		strings.HasSuffix(mediaType, "+xml"):
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/xml", "application/javascript",
+		"application/x-www-form-urlencoded":
+		return true
+	}
+	return false
+}
+
+// joinHeaders flattens headers to one value each, as payload format 2.0 and
+// single-value ALB targets expect. Repeated values are joined with commas,
+// except Set-Cookie, whose values may contain commas and keeps the first.
+func joinHeaders(headers http.Header) map[string]string {
+	joined := make(map[string]string, len(headers))
+	for k, v := range headers {
+		switch {
+		case len(v) == 0:
+		case k == "Set-Cookie":
+			joined[k] = v[0]
+		default:
+			joined[k] = strings.Join(v, ", ")
+		}
+	}
+	return joined
 }
 
 func (r *responseRecorder) Header() http.Header {
 	return r.headers
 }
 
+// Write buffers the body up to one byte past maxBufferedBody, enough to
+// tell that it is too large without holding all of it in memory
 func (r *responseRecorder) Write(b []byte) (int, error) {
 	if r.statusCode == 0 {
 		r.statusCode = http.StatusOK
 	}
-	return r.body.Write(b)
+	r.written += len(b)
+	if room := maxBufferedBody + 1 - r.body.Len(); room > 0 {
+		r.body.Write(b[:min(len(b), room)])
+	}
+	return len(b), nil
 }
 
 func (r *responseRecorder) WriteHeader(statusCode int) {
-	r.statusCode = statusCode
+	if r.statusCode == 0 {
+		r.statusCode = statusCode
+	}
 }
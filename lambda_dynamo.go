@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// eventDynamoDB is the shape of DynamoDB stream events
+const eventDynamoDB = "DynamoDB"
+
+// lambdaDynamoStorage is the storage whose table stream is accepted, nil
+// outside Lambda mode or with S3 storage
+var lambdaDynamoStorage *DynamoStorage
+
+// handleDynamoEvent notifies observers of notes that expired through the
+// table's TTL, so their metadata, read-only links and quota usage go with
+// them. A note expires either when DynamoDB deletes it, or when it is saved
+// again before that happens. Records of other tables, internal records and
+// all other changes, which the app reports itself, are ignored.
+// A record whose offloaded content or observers fail is reported as a batch
+// item failure, so Lambda retries the batch from it on; later records wait
+// for the retry. Observers tolerate seeing a record again. Webhook deliveries
+// are queued by then, so their failures are only logged.
+func handleDynamoEvent(ctx context.Context, event events.DynamoDBEvent) (events.DynamoDBEventResponse, error) {
+	var resp events.DynamoDBEventResponse
+	if lambdaDynamoStorage == nil {
+		return resp, errors.New("DynamoDB streams need DynamoDB storage")
+	}
+	for _, record := range event.Records {
+		old := record.Change.OldImage
+		noteID := streamString(old, "id")
+		if !lambdaDynamoStorage.streamOf(record.EventSourceArn) || !ValidateNoteID(noteID) || !expiredRecord(record) {
+			slog.DebugContext(ctx, "Ignoring DynamoDB stream record", "event", record.EventName, "source", record.EventSourceArn, "key", noteID)
+			continue
+		}
+
+		if err := expireNote(ctx, record, noteID); err != nil {
+			slog.ErrorContext(ctx, "Failed to process expired note", "note", noteID, "sequence", record.Change.SequenceNumber, "error", err)
+			resp.BatchItemFailures = []events.DynamoDBBatchItemFailure{{ItemIdentifier: record.Change.SequenceNumber}}
+			break
+		}
+	}
+	// Lambda freezes the instance once the invocation returns
+	_ = flushWebhooks(ctx)
+	return resp, nil
+}
+
+// expireNote removes the offloaded content of an expired note and notifies
+// observers
+func expireNote(ctx context.Context, record events.DynamoDBEventRecord, noteID string) error {
+	old := record.Change.OldImage
+	// Replaced content was already removed by the save
+	if offloaded := streamString(old, "offloaded"); offloaded != "" && record.EventName == "REMOVE" && lambdaDynamoStorage.offload != nil {
+		if err := lambdaDynamoStorage.offload.Delete(ctx, offloaded); err != nil {
+			return err
+		}
+	}
+
+	size := streamNumber(old, "size")
+	slog.InfoContext(ctx, "Note expired in DynamoDB", "note", noteID, "event", record.EventName, "size", size)
+	return notifyNoteChanged(ctx, NoteEvent{
+		Type:     NoteDeleted,
+		NoteID:   noteID,
+		Size:     size,
+		Source:   "ttl",
+		Time:     record.Change.ApproximateCreationDateTime.Time,
+		ID:       record.EventID,
+		UsageKey: streamString(old, "client"),
+	})
+}
+
+// expiredRecord reports whether a stream record is the end of an expired
+// note: its deletion by the TTL process, or a save over it before that
+func expiredRecord(record events.DynamoDBEventRecord) bool {
+	switch record.EventName {
+	case "REMOVE":
+		identity := record.UserIdentity
+		return identity != nil && identity.Type == "Service" && identity.PrincipalID == "dynamodb.amazonaws.com"
+	case "MODIFY":
+		expires := streamNumber(record.Change.OldImage, "expires")
+		return expires != 0 && expires <= record.Change.ApproximateCreationDateTime.Unix()
+	}
+	return false
+}
+
+// streamOf reports whether a stream ARN belongs to the storage's table
+func (ds *DynamoStorage) streamOf(arn string) bool {
+	return strings.Contains(arn, ":table/"+ds.table+"/stream/")
+}
+
+// streamString returns a string attribute of a stream image, or "" if it is
+// missing or of another type
+func streamString(image map[string]events.DynamoDBAttributeValue, name string) string {
+	if av, ok := image[name]; ok && av.DataType() == events.DataTypeString {
+		return av.String()
+	}
+	return ""
+}
+
+// streamNumber returns a numeric attribute of a stream image, or 0 if it is
+// missing or of another type
+func streamNumber(image map[string]events.DynamoDBAttributeValue, name string) int64 {
+	if av, ok := image[name]; ok && av.DataType() == events.DataTypeNumber {
+		n, _ := av.Integer()
+		return n
+	}
+	return 0
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// TestDynamoStreamExpiry tests that notes expired in DynamoDB reach
+// observers, and other stream records are ignored
+func TestDynamoStreamExpiry(t *testing.T) {
+	storage := NewMockStorage()
+	observer := &recordingObserver{}
+	lambdaDynamoStorage = NewDynamoStorage(nil, "notes", 0, nil)
+	noteObservers = []NoteObserver{NewMetaObserver(storage), observer}
+	t.Cleanup(func() {
+		lambdaDynamoStorage = nil
+		noteObservers = nil
+	})
+	ctx := context.Background()
+	_ = WriteNoteMeta(ctx, storage, "infra/dns/cutover", &NoteMeta{EditToken: "secret", ReadID: "READONLYABCDEFGH"})
+	_ = storage.Write(ctx, shareKey("READONLYABCDEFGH"), `{"noteId":"infra/dns/cutover"}`)
+
+	payload := readFixture(t, "dynamodb-ttl.json")
+	if eventType := detectEventType(payload); eventType != eventDynamoDB {
+		t.Fatalf("Expected DynamoDB event, got %q", eventType)
+	}
+	resp, err := LambdaHandler(ctx, payload)
+	if r, ok := resp.(events.DynamoDBEventResponse); err != nil || !ok || len(r.BatchItemFailures) != 0 {
+		t.Fatalf("Handler failed: %+v %v", resp, err)
+	}
+
+	// Deleted by the TTL process, and saved again after expiring; removals
+	// by the app, unexpired notes, internal records and other tables are not
+	var got []string
+	for _, event := range observer.events {
+		if event.Type != NoteDeleted || event.Source != "ttl" || event.Time.IsZero() {
+			t.Errorf("Unexpected event %+v", event)
+		}
+		got = append(got, event.NoteID)
+	}
+	if len(got) != 2 || got[0] != "infra/dns/cutover" || got[1] != "ABCDE" {
+		t.Fatalf("Expected expiry of infra/dns/cutover and ABCDE, got %v", got)
+	}
+	if event := observer.events[0]; event.Size != 1024 || event.UsageKey != ".usage.client.0123456789abcdef0123456789abcdef" {
+		t.Errorf("Expected size and client of the expired note, got %+v", event)
+	}
+
+	if meta, _ := ReadNoteMeta(ctx, storage, "infra/dns/cutover"); meta != nil {
+		t.Errorf("Expected metadata of expired note to be removed, got %+v", meta)
+	}
+	if share, _ := storage.Read(ctx, shareKey("READONLYABCDEFGH")); share != "" {
+		t.Errorf("Expected read-only link of expired note to be removed")
+	}
+
+	// A failed observer reports its record, so Lambda retries from there on
+	observer.err = errors.New("index unavailable")
+	observer.events = nil
+	resp, err = LambdaHandler(ctx, payload)
+	r, _ := resp.(events.DynamoDBEventResponse)
+	if err != nil || len(r.BatchItemFailures) != 1 || r.BatchItemFailures[0].ItemIdentifier != "111" {
+		t.Errorf("Expected the first expired record to be reported, got %+v %v", resp, err)
+	}
+	if len(observer.events) != 1 || observer.events[0].ID != "c81e728d9d4c2f636f067f89cc14862c" {
+		t.Errorf("Expected later records to wait for the retry, got %+v", observer.events)
+	}
+}
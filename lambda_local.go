@@ -0,0 +1,335 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+)
+
+// lambdaLocalCommand is the command starting the local Lambda emulator
+const lambdaLocalCommand = "lambda-local"
+
+// localEvents are the event shapes the emulator can send
+var localEvents = []string{"apigw-v1", "apigw-v2", "function-url", "alb", "alb-multivalue"}
+
+// maxLocalRequestBody is Lambda's limit for invocation payloads
+const maxLocalRequestBody = 6 * 1024 * 1024
+
+// localTargetGroupARN identifies the emulated load balancer target group
+const localTargetGroupARN = "arn:aws:elasticloadbalancing:local:000000000000:targetgroup/note-local/0000000000000000"
+
+// LambdaEmulator is an HTTP handler that converts requests into Lambda
+// events of one shape and invokes LambdaHandler in-process, so the Lambda
+// translation can be exercised with a browser or curl
+type LambdaEmulator struct {
+	event string // one of localEvents
+}
+
+// NewLambdaEmulator creates an emulator sending events of the given shape
+func NewLambdaEmulator(event string) *LambdaEmulator {
+	return &LambdaEmulator{event: event}
+}
+
+func (e *LambdaEmulator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxLocalRequestBody))
+	if err != nil {
+		http.Error(w, "Request too large for Lambda", http.StatusRequestEntityTooLarge)
+		return
+	}
+	payload, err := e.Event(r, body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	requestID := newRequestID()
+	ctx := lambdacontext.NewContext(r.Context(), &lambdacontext.LambdaContext{AwsRequestID: requestID})
+	resp, err := LambdaHandler(ctx, payload)
+	if err != nil {
+		slog.ErrorContext(ctx, "Lambda handler failed", "request_id", requestID, "error", err)
+		http.Error(w, "Lambda handler failed: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	if err := writeLambdaResponse(w, resp); err != nil {
+		slog.ErrorContext(ctx, "Invalid Lambda response", "request_id", requestID, "error", err)
+	}
+}
+
+// Event builds the Lambda event for a request
+func (e *LambdaEmulator) Event(r *http.Request, body []byte) ([]byte, error) {
+	switch e.event {
+	case "apigw-v1":
+		return json.Marshal(localV1Event(r, body))
+	case "apigw-v2":
+		return json.Marshal(localV2Event(r, body, r.Host))
+	case "function-url":
+		// Function URLs are told apart from HTTP APIs by their domain
+		return json.Marshal(localV2Event(r, body, "local.lambda-url.localhost"))
+	case "alb", "alb-multivalue":
+		return json.Marshal(localALBEvent(r, body, e.event == "alb-multivalue"))
+	}
+	return nil, fmt.Errorf("unknown event %q", e.event)
+}
+
+// encodeLocalBody returns a request body as API Gateway passes it on, base64
+// encoded unless it is text
+func encodeLocalBody(body []byte) (string, bool) {
+	if utf8.Valid(body) {
+		return string(body), false
+	}
+	return base64.StdEncoding.EncodeToString(body), true
+}
+
+func localV1Event(r *http.Request, body []byte) events.APIGatewayProxyRequest {
+	event := events.APIGatewayProxyRequest{
+		Resource:          "/{proxy+}",
+		Path:              r.URL.Path,
+		HTTPMethod:        r.Method,
+		Headers:           map[string]string{"Host": r.Host},
+		MultiValueHeaders: map[string][]string{"Host": {r.Host}},
+	}
+	// Single-value maps hold the last value, as with API Gateway
+	for k, v := range r.Header {
+		event.Headers[k] = v[len(v)-1]
+		event.MultiValueHeaders[k] = v
+	}
+	if query := r.URL.Query(); len(query) > 0 {
+		event.QueryStringParameters = make(map[string]string, len(query))
+		event.MultiValueQueryStringParameters = query
+		for k, v := range query {
+			event.QueryStringParameters[k] = v[len(v)-1]
+		}
+	}
+	event.Body, event.IsBase64Encoded = encodeLocalBody(body)
+	event.RequestContext = events.APIGatewayProxyRequestContext{
+		RequestID:    newRequestID(),
+		Stage:        "local",
+		HTTPMethod:   r.Method,
+		ResourcePath: "/{proxy+}",
+		Identity: events.APIGatewayRequestIdentity{
+			SourceIP:  stripPort(r.RemoteAddr),
+			UserAgent: r.UserAgent(),
+		},
+	}
+	return event
+}
+
+func localV2Event(r *http.Request, body []byte, domainName string) events.APIGatewayV2HTTPRequest {
+	event := events.APIGatewayV2HTTPRequest{
+		Version:        "2.0",
+		RouteKey:       "$default",
+		RawPath:        r.URL.Path,
+		RawQueryString: r.URL.RawQuery,
+		Headers:        map[string]string{"host": r.Host},
+	}
+	// Payload format 2.0 has lower-case headers, repeated values joined with
+	// commas, and cookies on their own
+	for k, v := range r.Header {
+		if k == "Cookie" {
+			for _, line := range v {
+				event.Cookies = append(event.Cookies, strings.Split(line, "; ")...)
+			}
+			continue
+		}
+		event.Headers[strings.ToLower(k)] = strings.Join(v, ",")
+	}
+	event.Body, event.IsBase64Encoded = encodeLocalBody(body)
+	event.RequestContext = events.APIGatewayV2HTTPRequestContext{
+		RouteKey:   "$default",
+		Stage:      "$default",
+		RequestID:  newRequestID(),
+		DomainName: domainName,
+		HTTP: events.APIGatewayV2HTTPRequestContextHTTPDescription{
+			Method:    r.Method,
+			Path:      r.URL.Path,
+			Protocol:  r.Proto,
+			SourceIP:  stripPort(r.RemoteAddr),
+			UserAgent: r.UserAgent(),
+		},
+	}
+	return event
+}
+
+func localALBEvent(r *http.Request, body []byte, multiValue bool) events.ALBTargetGroupRequest {
+	event := events.ALBTargetGroupRequest{
+		HTTPMethod: r.Method,
+		Path:       r.URL.Path,
+	}
+	event.RequestContext.ELB.TargetGroupArn = localTargetGroupARN
+
+	// The load balancer appends the peer to X-Forwarded-For and passes query
+	// strings on still encoded
+	headers := r.Header.Clone()
+	headers.Set("Host", r.Host)
+	forwarded := append(headers.Values("X-Forwarded-For"), stripPort(r.RemoteAddr))
+	headers.Set("X-Forwarded-For", strings.Join(forwarded, ", "))
+	query := make(map[string][]string)
+	for _, param := range strings.Split(r.URL.RawQuery, "&") {
+		if param != "" {
+			k, v, _ := strings.Cut(param, "=")
+			query[k] = append(query[k], v)
+		}
+	}
+
+	if multiValue {
+		event.MultiValueHeaders = make(map[string][]string, len(headers))
+		for k, v := range headers {
+			event.MultiValueHeaders[strings.ToLower(k)] = v
+		}
+		event.MultiValueQueryStringParameters = query
+	} else {
+		// Single-value mode passes the last value
+		event.Headers = make(map[string]string, len(headers))
+		for k, v := range headers {
+			event.Headers[strings.ToLower(k)] = v[len(v)-1]
+		}
+		event.QueryStringParameters = make(map[string]string, len(query))
+		for k, v := range query {
+			event.QueryStringParameters[k] = v[len(v)-1]
+		}
+	}
+	event.Body, event.IsBase64Encoded = encodeLocalBody(body)
+	return event
+}
+
+// writeLambdaResponse writes a response returned by LambdaHandler the way
+// API Gateway, the Function URL or the load balancer would
+func writeLambdaResponse(w http.ResponseWriter, resp any) error {
+	var status int
+	var body string
+	var isBase64 bool
+	h := w.Header()
+	switch r := resp.(type) {
+	case events.APIGatewayProxyResponse:
+		status, body, isBase64 = r.StatusCode, r.Body, r.IsBase64Encoded
+		setHeaders(h, r.Headers, r.MultiValueHeaders, nil)
+	case events.APIGatewayV2HTTPResponse:
+		status, body, isBase64 = r.StatusCode, r.Body, r.IsBase64Encoded
+		setHeaders(h, r.Headers, r.MultiValueHeaders, r.Cookies)
+	case events.LambdaFunctionURLResponse:
+		status, body, isBase64 = r.StatusCode, r.Body, r.IsBase64Encoded
+		setHeaders(h, r.Headers, nil, r.Cookies)
+	case events.ALBTargetGroupResponse:
+		status, body, isBase64 = r.StatusCode, r.Body, r.IsBase64Encoded
+		setHeaders(h, r.Headers, r.MultiValueHeaders, nil)
+	case *events.LambdaFunctionURLStreamingResponse:
+		defer func() { _ = r.Close() }()
+		setHeaders(h, r.Headers, nil, r.Cookies)
+		w.WriteHeader(r.StatusCode)
+		_, err := io.Copy(flushWriter{w}, r.Body)
+		return err
+	default:
+		w.WriteHeader(http.StatusBadGateway)
+		return fmt.Errorf("unexpected response type %T", resp)
+	}
+
+	data := []byte(body)
+	if isBase64 {
+		var err error
+		if data, err = base64.StdEncoding.DecodeString(body); err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return fmt.Errorf("invalid base64 body: %w", err)
+		}
+	}
+	w.WriteHeader(status)
+	_, err := w.Write(data)
+	return err
+}
+
+// setHeaders copies single-value, multi-value and cookie headers of a
+// Lambda response
+func setHeaders(h http.Header, single map[string]string, multi map[string][]string, cookies []string) {
+	for k, v := range single {
+		h.Set(k, v)
+	}
+	for k, v := range multi {
+		h[http.CanonicalHeaderKey(k)] = v
+	}
+	for _, c := range cookies {
+		h.Add("Set-Cookie", c)
+	}
+}
+
+// flushWriter flushes every write, passing streamed responses on as they arrive
+type flushWriter struct {
+	w http.ResponseWriter
+}
+
+func (f flushWriter) Write(b []byte) (int, error) {
+	n, err := f.w.Write(b)
+	_ = http.NewResponseController(f.w).Flush()
+	return n, err
+}
+
+// initLambdaLocal runs the Lambda handler behind a local HTTP server
+func initLambdaLocal(cfg *Config) {
+	slog.Info("Initializing Lambda emulator", "event", cfg.Lambda.LocalEvent, "storage", cfg.Lambda.LocalStorage)
+
+	var err error
+	if cfg.Lambda.LocalStorage == "local" {
+		globalStorage, err = NewLocalStorage(cfg.Storage.Dir)
+		if err != nil {
+			fatal("Failed to initialize local storage", "error", err)
+		}
+	} else {
+		globalStorage = NewMemoryStorage()
+	}
+
+	// Same metrics, tracing and quotas as in Lambda mode
+	if cfg.Metrics.Enabled {
+		globalMetrics = NewEMFMetrics(os.Stdout, cfg.Metrics.Namespace)
+		globalStorage = InstrumentStorage(globalStorage, globalMetrics)
+	}
+	globalStorage = TraceStorage(globalStorage)
+	globalStorage, err = WithQuotas(globalStorage, cfg.Limits)
+	if err != nil {
+		fatal("Invalid quota configuration", "error", err)
+	}
+	lambdaRouter = NewRouter(globalStorage, RouterConfig{StorageType: cfg.Lambda.LocalStorage})
+	lambdaStreaming = cfg.Lambda.Streaming
+
+	ln, err := Listen(cfg.Server)
+	if err != nil {
+		fatal("Failed to listen", "error", err)
+	}
+	server := &http.Server{
+		Handler:     NewLambdaEmulator(cfg.Lambda.LocalEvent),
+		ReadTimeout: 15 * time.Second,
+		IdleTimeout: 60 * time.Second,
+	}
+
+	go func() {
+		sigChan := make(chan os.Signal, 1)
+		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+		<-sigChan
+
+		slog.Info("Shutting down Lambda emulator")
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			slog.Error("Shutdown error", "error", err)
+		}
+		if err := globalWebhooks.Close(ctx); err != nil {
+			slog.Error("Webhook deliveries abandoned", "error", err)
+		}
+		shutdownTracing(ctx)
+	}()
+
+	slog.Info("Starting Lambda emulator", "address", ln.Addr().String(), "event", cfg.Lambda.LocalEvent)
+	if err := server.Serve(ln); err != nil && err != http.ErrServerClosed {
+		fatal("Server error", "error", err)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestLambdaEmulator tests saving and reading notes through every event
+// shape of the emulator
+func TestLambdaEmulator(t *testing.T) {
+	favicon, _ := embeddedAssets.ReadFile("favicon.ico")
+
+	for _, event := range localEvents {
+		lambdaRouter = NewRouter(NewMemoryStorage(), RouterConfig{StorageType: "memory"})
+		server := httptest.NewServer(NewLambdaEmulator(event))
+
+		body, _ := json.Marshal(NoteRequest{Content: "hello lambda"})
+		resp, err := http.Post(server.URL+"/", "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("%s: request failed: %v", event, err)
+		}
+		var saved NoteResponse
+		_ = json.NewDecoder(resp.Body).Decode(&saved)
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || !saved.Success || resp.Header.Get(RequestIDHeader) == "" {
+			t.Errorf("%s: expected note to be saved, got %d %+v", event, resp.StatusCode, saved)
+		}
+
+		req, _ := http.NewRequest("GET", server.URL+"/noteid/"+saved.NoteID+"?token="+saved.EditToken, nil)
+		req.Header.Set("User-Agent", "curl/8.5.0")
+		resp, err = http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s: request failed: %v", event, err)
+		}
+		content, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || !strings.Contains(string(content), "hello lambda") {
+			t.Errorf("%s: expected note content, got %d %q", event, resp.StatusCode, content)
+		}
+
+		resp, err = http.Get(server.URL + "/favicon.ico")
+		if err != nil {
+			t.Fatalf("%s: request failed: %v", event, err)
+		}
+		icon, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if !bytes.Equal(icon, favicon) {
+			t.Errorf("%s: favicon corrupted, got %d bytes, expected %d", event, len(icon), len(favicon))
+		}
+		server.Close()
+	}
+}
+
+// TestLambdaEmulatorStreaming tests that streamed responses are passed on
+func TestLambdaEmulatorStreaming(t *testing.T) {
+	lambdaStreaming = true
+	t.Cleanup(func() { lambdaStreaming = false })
+	lambdaRouter = NewRouter(NewMemoryStorage(), RouterConfig{StorageType: "memory"})
+	server := httptest.NewServer(NewLambdaEmulator("function-url"))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/version")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), `"storage":"memory"`) {
+		t.Errorf("Unexpected response %d %q", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// eventS3 is the shape of S3 event notifications
+const eventS3 = "S3"
+
+// lambdaS3Storage is the storage whose bucket notifications are accepted,
+// nil outside Lambda mode
+var lambdaS3Storage *S3Storage
+
+// lambdaPrincipal returns the principal ID of the function's role as S3
+// notifications report it, nil outside Lambda mode
+var lambdaPrincipal func(ctx context.Context) (string, error)
+
+// cachedPrincipal caches the first successful principal lookup
+func cachedPrincipal(lookup func(ctx context.Context) (string, error)) func(ctx context.Context) (string, error) {
+	var (
+		mu        sync.Mutex
+		principal string
+	)
+	return func(ctx context.Context) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if principal != "" {
+			return principal, nil
+		}
+		p, err := lookup(ctx)
+		if err != nil {
+			return "", err
+		}
+		principal = p
+		return p, nil
+	}
+}
+
+// handleS3Event notifies observers of notes created or removed in the bucket
+// outside the app. Objects of other buckets, outside S3_PREFIX or holding
+// internal records are ignored, as are changes made by the app, which it
+// reports itself. A failed observer fails the invocation, so Lambda retries
+// the notification; S3 sends one record per notification, and observers
+// tolerate seeing a change again. Webhook deliveries are queued by then, so
+// their failures are only logged.
+func handleS3Event(ctx context.Context, event events.S3Event) error {
+	if lambdaS3Storage == nil {
+		return errors.New("S3 notifications need S3 storage")
+	}
+	var errs []error
+	for _, record := range event.Records {
+		object := record.S3.Object
+		noteID, ok := lambdaS3Storage.noteIDFromKey(object.URLDecodedKey)
+		if record.S3.Bucket.Name != lambdaS3Storage.bucket || !ok {
+			slog.DebugContext(ctx, "Ignoring S3 notification", "bucket", record.S3.Bucket.Name, "key", object.URLDecodedKey)
+			continue
+		}
+
+		var eventType NoteEventType
+		switch {
+		case strings.HasPrefix(record.EventName, "ObjectCreated:"):
+			// S3 does not tell new objects from overwritten ones
+			eventType = NoteUpdated
+		case strings.HasPrefix(record.EventName, "ObjectRemoved:"):
+			eventType = NoteDeleted
+		default:
+			slog.DebugContext(ctx, "Ignoring S3 notification", "event", record.EventName, "key", object.URLDecodedKey)
+			continue
+		}
+
+		own, err := changedByApp(ctx, record, eventType)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if own {
+			slog.DebugContext(ctx, "Ignoring S3 notification of the app's own change", "event", record.EventName, "key", object.URLDecodedKey)
+			continue
+		}
+
+		slog.InfoContext(ctx, "Note changed in S3", "note", noteID, "event", record.EventName, "size", object.Size)
+		errs = append(errs, notifyNoteChanged(ctx, NoteEvent{
+			Type:   eventType,
+			NoteID: noteID,
+			Size:   object.Size,
+			Source: "s3",
+			Time:   record.EventTime,
+			ID:     record.S3.Bucket.Name + "/" + object.Key + "@" + object.Sequencer,
+		}))
+	}
+	// Lambda freezes the instance once the invocation returns
+	_ = flushWebhooks(ctx)
+	return errors.Join(errs...)
+}
+
+// changedByApp reports whether a notification is about a change the app made.
+// Objects written by the app carry its metadata; removals carry none, so they
+// are recognized by the requesting principal being the function's role.
+func changedByApp(ctx context.Context, record events.S3EventRecord, eventType NoteEventType) (bool, error) {
+	if eventType != NoteDeleted {
+		return lambdaS3Storage.writtenByApp(ctx, record.S3.Object.URLDecodedKey, record.S3.Object.VersionID)
+	}
+	if lambdaPrincipal == nil {
+		return false, nil
+	}
+	principal, err := lambdaPrincipal(ctx)
+	if err != nil {
+		// Reporting a removal twice beats losing it
+		slog.WarnContext(ctx, "Failed to look up the function's principal", "error", err)
+		return false, nil
+	}
+	return record.PrincipalID.PrincipalID == principal, nil
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// recordingObserver records note events for tests
+type recordingObserver struct {
+	events []NoteEvent
+	err    error
+}
+
+func (o *recordingObserver) NoteChanged(ctx context.Context, event NoteEvent) error {
+	o.events = append(o.events, event)
+	return o.err
+}
+
+// useS3Notifications sets up Lambda to accept notifications of note-bucket,
+// whose objects have the given metadata; other objects don't exist
+func useS3Notifications(t *testing.T, objects map[string]map[string]string, observers ...NoteObserver) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		metadata, ok := objects[strings.TrimPrefix(r.URL.Path, "/note-bucket/")]
+		if r.Method != http.MethodHead || !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		for k, v := range metadata {
+			w.Header().Set("X-Amz-Meta-"+k, v)
+		}
+	}))
+	t.Cleanup(server.Close)
+	client := s3.New(s3.Options{
+		Region:           "us-east-1",
+		BaseEndpoint:     aws.String(server.URL),
+		Credentials:      aws.AnonymousCredentials{},
+		UsePathStyle:     true,
+		RetryMaxAttempts: 1,
+	})
+
+	lambdaS3Storage = NewS3Storage(client, "note-bucket", "note")
+	noteObservers = observers
+	t.Cleanup(func() {
+		lambdaS3Storage = nil
+		lambdaPrincipal = nil
+		noteObservers = nil
+	})
+}
+
+// TestS3NoteIDFromKey tests the mapping of object keys back to notes
+func TestS3NoteIDFromKey(t *testing.T) {
+	storage := NewS3Storage(nil, "note-bucket", "note/")
+	tests := []struct {
+		key    string
+		noteID string
+	}{
+		{"note/ABCDE", "ABCDE"},
+		{"note/infra/dns/cutover", "infra/dns/cutover"},
+		{"note/ABCDE.meta", ""},
+		{"note/.alias.runbook", ""},
+		{"note/.usage.total", ""},
+		{"other/ABCDE", ""},
+		{"ABCDE", ""},
+	}
+	for _, test := range tests {
+		noteID, ok := storage.noteIDFromKey(test.key)
+		if noteID != test.noteID || ok != (test.noteID != "") {
+			t.Errorf("%s: expected %q, got %q %v", test.key, test.noteID, noteID, ok)
+		}
+		if ok && storage.objectKey(noteID) != test.key {
+			t.Errorf("%s: does not round-trip through objectKey", test.key)
+		}
+	}
+}
+
+// TestS3Notifications tests that notes changed in the bucket reach observers
+// and internal records are ignored
+func TestS3Notifications(t *testing.T) {
+	observer := &recordingObserver{}
+	useS3Notifications(t, map[string]map[string]string{"note/infra/dns/cutover": nil}, observer)
+
+	payload := readFixture(t, "s3-put.json")
+	if eventType := detectEventType(payload); eventType != eventS3 {
+		t.Fatalf("Expected S3 event, got %q", eventType)
+	}
+	if _, err := LambdaHandler(context.Background(), payload); err != nil {
+		t.Fatalf("Handler failed: %v", err)
+	}
+	if len(observer.events) != 1 {
+		t.Fatalf("Expected one event, got %+v", observer.events)
+	}
+	event := observer.events[0]
+	if event.Type != NoteUpdated || event.NoteID != "infra/dns/cutover" || event.Size != 1024 || event.Source != "s3" || event.Time.IsZero() {
+		t.Errorf("Unexpected event %+v", event)
+	}
+
+	// Notifications of another bucket are ignored
+	client := lambdaS3Storage.client
+	lambdaS3Storage = NewS3Storage(client, "other-bucket", "note")
+	_, _ = LambdaHandler(context.Background(), payload)
+	if len(observer.events) != 1 {
+		t.Errorf("Expected notifications of another bucket to be ignored")
+	}
+
+	// A failed observer fails the invocation so Lambda retries it
+	lambdaS3Storage = NewS3Storage(client, "note-bucket", "note")
+	observer.err = errors.New("index unavailable")
+	if _, err := LambdaHandler(context.Background(), readFixture(t, "s3-delete.json")); err == nil {
+		t.Errorf("Expected observer error to be returned")
+	}
+}
+
+// TestMetaObserver tests that metadata of notes deleted in the bucket is removed
+func TestMetaObserver(t *testing.T) {
+	storage := NewMockStorage()
+	useS3Notifications(t, nil, NewMetaObserver(storage))
+	ctx := context.Background()
+
+	_ = WriteNoteMeta(ctx, storage, "ABCDE", &NoteMeta{EditToken: "secret", ReadID: "READONLYABCDEFGH"})
+	_ = storage.Write(ctx, shareKey("READONLYABCDEFGH"), `{"noteId":"ABCDE"}`)
+
+	// A late notification for a note that exists again keeps its metadata
+	_ = storage.Write(ctx, "ABCDE", "recreated")
+	if _, err := LambdaHandler(ctx, readFixture(t, "s3-delete.json")); err != nil {
+		t.Fatalf("Handler failed: %v", err)
+	}
+	if meta, _ := ReadNoteMeta(ctx, storage, "ABCDE"); meta == nil {
+		t.Fatalf("Expected metadata of existing note to be kept")
+	}
+
+	_ = storage.Delete(ctx, "ABCDE")
+	if _, err := LambdaHandler(ctx, readFixture(t, "s3-delete.json")); err != nil {
+		t.Fatalf("Handler failed: %v", err)
+	}
+	if meta, _ := ReadNoteMeta(ctx, storage, "ABCDE"); meta != nil {
+		t.Errorf("Expected metadata to be removed, got %+v", meta)
+	}
+	if share, _ := storage.Read(ctx, shareKey("READONLYABCDEFGH")); share != "" {
+		t.Errorf("Expected read-only link to be removed")
+	}
+}
+
+// TestS3NotificationsOwnChanges tests that notifications of the app's own
+// saves and deletions are skipped, as the app reports them itself
+func TestS3NotificationsOwnChanges(t *testing.T) {
+	observer := &recordingObserver{}
+	useS3Notifications(t, map[string]map[string]string{"note/infra/dns/cutover": appWriter}, observer)
+	lambdaPrincipal = func(ctx context.Context) (string, error) { return "AWS:AIDAEXAMPLE", nil }
+
+	for _, fixture := range []string{"s3-put.json", "s3-delete.json"} {
+		if _, err := LambdaHandler(context.Background(), readFixture(t, fixture)); err != nil {
+			t.Fatalf("%s: handler failed: %v", fixture, err)
+		}
+	}
+	if len(observer.events) != 0 {
+		t.Errorf("Expected the app's own changes to be skipped, got %+v", observer.events)
+	}
+
+	// Removals by anyone else are reported
+	lambdaPrincipal = func(ctx context.Context) (string, error) { return "AWS:AROAEXAMPLE:note-app", nil }
+	_, _ = LambdaHandler(context.Background(), readFixture(t, "s3-delete.json"))
+	if len(observer.events) != 1 || observer.events[0].Type != NoteDeleted {
+		t.Errorf("Expected removal by another principal to be reported, got %+v", observer.events)
+	}
+}
+
+// TestS3NotificationsWebhooks tests that S3 notifications wait for webhook
+// deliveries, but do not fail with them
+func TestS3NotificationsWebhooks(t *testing.T) {
+	rejecting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer rejecting.Close()
+	receiver := &webhookReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	globalWebhooks = newTestWebhooks(t, WebhooksConfig{Subscriptions: []WebhookSubscription{{URL: server.URL}}})
+	t.Cleanup(func() { globalWebhooks = nil })
+	useS3Notifications(t, map[string]map[string]string{"note/infra/dns/cutover": nil}, globalWebhooks)
+
+	if _, err := LambdaHandler(context.Background(), readFixture(t, "s3-put.json")); err != nil {
+		t.Fatalf("Handler failed: %v", err)
+	}
+	receiver.mu.Lock()
+	delivered := len(receiver.requests)
+	receiver.mu.Unlock()
+	if delivered != 1 {
+		t.Errorf("Expected the delivery to finish within the invocation, got %d requests", delivered)
+	}
+
+	// A retried notification is delivered with the same ID
+	if _, err := LambdaHandler(context.Background(), readFixture(t, "s3-put.json")); err != nil {
+		t.Fatalf("Handler failed: %v", err)
+	}
+	receiver.mu.Lock()
+	if len(receiver.requests) != 2 || receiver.requests[0].Header.Get("X-Note-Delivery") != receiver.requests[1].Header.Get("X-Note-Delivery") {
+		t.Errorf("Expected the retried notification to keep its delivery ID")
+	}
+	receiver.mu.Unlock()
+
+	// The delivery was queued, so a retry would only send it again
+	globalWebhooks.subscriptions[0].URL = rejecting.URL
+	if _, err := LambdaHandler(context.Background(), readFixture(t, "s3-put.json")); err != nil {
+		t.Errorf("Expected failed delivery to be logged only, got %v", err)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// lambdaStreaming streams Function URL responses instead of buffering them.
+// It requires the Function URL's invoke mode RESPONSE_STREAM.
+var lambdaStreaming bool
+
+// streamWriter is an http.ResponseWriter that sends the body through a pipe
+// as it is written. The status and headers are sent with the first write,
+// flush or when the handler returns, whichever comes first.
+type streamWriter struct {
+	headers    http.Header
+	statusCode int
+	body       *io.PipeWriter
+	head       bool // HEAD requests get no body
+
+	once    sync.Once
+	sent    http.Header   // headers as committed
+	started chan struct{} // closed once the headers are committed
+}
+
+func newStreamWriter(body *io.PipeWriter, head bool) *streamWriter {
+	return &streamWriter{
+		headers: make(http.Header),
+		body:    body,
+		head:    head,
+		started: make(chan struct{}),
+	}
+}
+
+func (w *streamWriter) Header() http.Header {
+	return w.headers
+}
+
+func (w *streamWriter) WriteHeader(statusCode int) {
+	if w.statusCode == 0 {
+		w.statusCode = statusCode
+	}
+}
+
+func (w *streamWriter) Write(b []byte) (int, error) {
+	w.commit()
+	if w.head {
+		return len(b), nil
+	}
+	return w.body.Write(b)
+}
+
+// Flush commits the headers; written data is passed on unbuffered
+func (w *streamWriter) Flush() {
+	w.commit()
+}
+
+// fail turns the response into a 500, reporting false if the headers were
+// already sent
+func (w *streamWriter) fail() bool {
+	select {
+	case <-w.started:
+		return false
+	default:
+	}
+	w.statusCode = http.StatusInternalServerError
+	requestID := w.headers.Get(RequestIDHeader)
+	w.headers = make(http.Header)
+	w.headers.Set("Content-Type", "text/plain; charset=utf-8")
+	if requestID != "" {
+		w.headers.Set(RequestIDHeader, requestID)
+	}
+	w.commit()
+	return true
+}
+
+// commit fixes the status and headers sent to the client
+func (w *streamWriter) commit() {
+	w.once.Do(func() {
+		if w.statusCode == 0 {
+			w.statusCode = http.StatusOK
+		}
+		w.sent = w.headers.Clone()
+		close(w.started)
+	})
+}
+
+// handleFunctionURLStream serves a Function URL request with response
+// streaming. The handler keeps writing the body after this returns, while
+// the Lambda runtime reads it.
+func handleFunctionURLStream(ctx context.Context, event events.APIGatewayV2HTTPRequest) (*events.LambdaFunctionURLStreamingResponse, error) {
+	ctx = withRequestID(ctx, lambdaRequestID(ctx, event.RequestContext.RequestID))
+	slog.DebugContext(ctx, "Lambda streaming request",
+		"method", event.RequestContext.HTTP.Method,
+		"path", event.RawPath,
+		"ip", event.RequestContext.HTTP.SourceIP,
+	)
+	_, span := startSpan(ctx, "createRequestFromV2")
+	req, err := createRequestFromV2(event)
+	endSpan(span, err)
+	if err != nil {
+		slog.WarnContext(ctx, "Invalid Lambda request", "error", err)
+		return &events.LambdaFunctionURLStreamingResponse{
+			StatusCode: http.StatusBadRequest,
+			Headers:    map[string]string{"Content-Type": "text/plain; charset=utf-8", RequestIDHeader: RequestIDFromContext(ctx)},
+			Body:       strings.NewReader("Bad request\n"),
+		}, nil
+	}
+	req = req.WithContext(ctx)
+
+	pr, pw := io.Pipe()
+	w := newStreamWriter(pw, req.Method == http.MethodHead)
+	go func() {
+		defer func() {
+			// Unlike a buffered invocation, a panic here would take down the
+			// whole runtime, so it is answered with a 500 where still possible
+			if p := recover(); p != nil {
+				slog.ErrorContext(ctx, "Panic serving streamed request", "panic", p, "stack", string(debug.Stack()))
+				if w.fail() {
+					_, _ = w.Write([]byte("Internal server error\n"))
+				} else {
+					_ = pw.CloseWithError(fmt.Errorf("panic: %v", p))
+				}
+			}
+			// The response ends with the invocation, so webhook deliveries get
+			// their short flush once the headers and body were sent; failures
+			// are logged and leave the response alone
+			w.commit()
+			_ = flushWebhooks(ctx)
+			_ = pw.Close()
+		}()
+		lambdaRouter.ServeHTTP(w, req)
+	}()
+	<-w.started
+
+	// Cookies are sent separately from the other headers, as in payload format 2.0
+	cookies := w.sent.Values("Set-Cookie")
+	w.sent.Del("Set-Cookie")
+	return &events.LambdaFunctionURLStreamingResponse{
+		StatusCode: w.statusCode,
+		Headers:    joinHeaders(w.sent),
+		Body:       pr,
+		Cookies:    cookies,
+	}, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// TestLambdaStreaming tests that Function URL responses are streamed while
+// the handler is still writing
+func TestLambdaStreaming(t *testing.T) {
+	lambdaStreaming = true
+	t.Cleanup(func() { lambdaStreaming = false })
+
+	proceed := make(chan struct{})
+	lambdaRouter = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Add("Set-Cookie", "a=1")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = io.WriteString(w, "first ")
+		<-proceed
+		w.Header().Set("X-Late", "ignored")
+		_, _ = io.WriteString(w, "second")
+	})
+
+	resp, err := LambdaHandler(context.Background(), readFixture(t, "function-url.json"))
+	if err != nil {
+		t.Fatalf("Handler failed: %v", err)
+	}
+	stream, ok := resp.(*events.LambdaFunctionURLStreamingResponse)
+	if !ok {
+		t.Fatalf("Expected a streaming response, got %T", resp)
+	}
+	if stream.StatusCode != http.StatusCreated || stream.Headers["Content-Type"] != "text/plain" || len(stream.Cookies) != 1 {
+		t.Errorf("Unexpected response head %d %v %v", stream.StatusCode, stream.Headers, stream.Cookies)
+	}
+
+	// The first chunk is readable before the handler finishes
+	first := make([]byte, len("first "))
+	if _, err := io.ReadFull(stream.Body, first); err != nil || string(first) != "first " {
+		t.Fatalf("Expected first chunk, got %q %v", first, err)
+	}
+	close(proceed)
+	rest, _ := io.ReadAll(stream.Body)
+	if string(rest) != "second" || stream.Headers["X-Late"] != "" {
+		t.Errorf("Unexpected rest %q or late header %v", rest, stream.Headers)
+	}
+
+	// The runtime reads a JSON prelude followed by the body
+	lambdaRouter = NewRouter(NewMockStorage(), RouterConfig{StorageType: "s3"})
+	resp, _ = LambdaHandler(context.Background(), readFixture(t, "function-url.json"))
+	raw, _ := io.ReadAll(resp.(io.Reader))
+	prelude, body, found := bytes.Cut(raw, make([]byte, 8))
+	if !found || !strings.Contains(string(prelude), `"statusCode":200`) || !strings.Contains(string(body), `"storage"`) {
+		t.Errorf("Unexpected stream %q", raw)
+	}
+
+	// API Gateway keeps buffered responses
+	resp, _ = LambdaHandler(context.Background(), readFixture(t, "apigw-v2.json"))
+	if _, ok := resp.(events.APIGatewayV2HTTPResponse); !ok {
+		t.Errorf("Expected a buffered API Gateway response, got %T", resp)
+	}
+}
+
+// TestLambdaResponseLimit tests that buffered responses over the Lambda
+// payload limit are replaced with an error
+func TestLambdaResponseLimit(t *testing.T) {
+	tests := []struct {
+		contentType string
+		size        int
+		status      int
+	}{
+		{"text/plain", maxBufferedBody, http.StatusOK},
+		{"text/plain", maxBufferedBody + 1, http.StatusInternalServerError},
+		{"application/zip", maxBufferedBody / 4 * 3, http.StatusOK},
+		{"application/zip", maxBufferedBody/4*3 + 3, http.StatusInternalServerError},
+	}
+	for _, test := range tests {
+		lambdaRouter = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", test.contentType)
+			w.Header().Set(RequestIDHeader, "limit")
+			for written := 0; written < test.size; written += 1 << 20 {
+				_, _ = w.Write(make([]byte, min(1<<20, test.size-written)))
+			}
+		})
+		event := events.APIGatewayV2HTTPRequest{RawPath: "/export"}
+		event.RequestContext.HTTP.Method = "GET"
+		resp, _ := handleAPIGatewayV2(context.Background(), event)
+		if resp.StatusCode != test.status {
+			t.Errorf("%s of %d bytes: expected %d, got %d", test.contentType, test.size, test.status, resp.StatusCode)
+		}
+		if test.status != http.StatusOK && (!strings.Contains(resp.Body, "response streaming") || resp.Headers["X-Request-Id"] != "limit") {
+			t.Errorf("Expected an explanation, got %q %v", resp.Body, resp.Headers)
+		}
+	}
+}
+
+// TestLambdaStreamingPanic tests that a panicking handler gets a 500
+// instead of crashing the runtime
+func TestLambdaStreamingPanic(t *testing.T) {
+	lambdaRouter = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		panic("boom")
+	})
+	event := events.APIGatewayV2HTTPRequest{RawPath: "/"}
+	event.RequestContext.HTTP.Method = "GET"
+	resp, err := handleFunctionURLStream(context.Background(), event)
+	if err != nil {
+		t.Fatalf("Handler failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusInternalServerError || resp.Headers["Content-Type"] != "text/plain; charset=utf-8" || string(body) != "Internal server error\n" {
+		t.Errorf("Unexpected response %d %v %q", resp.StatusCode, resp.Headers, body)
+	}
+
+	// Once the headers are sent, the stream is cut off
+	lambdaRouter = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "partial")
+		panic("boom")
+	})
+	resp, _ = handleFunctionURLStream(context.Background(), event)
+	body, err = io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "partial" || err == nil {
+		t.Errorf("Expected truncated stream, got %d %q %v", resp.StatusCode, body, err)
+	}
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// readFixture reads a Lambda event from testdata/lambda
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	payload, err := os.ReadFile(filepath.Join("testdata", "lambda", name))
+	if err != nil {
+		t.Fatalf("Failed to read fixture: %v", err)
+	}
+	return payload
+}
+
+// TestLambdaEvents tests that every supported event shape is detected and
+// answered in its own response format
+func TestLambdaEvents(t *testing.T) {
+	lambdaRouter = NewRouter(NewMockStorage(), RouterConfig{StorageType: "s3"})
+	key := http.CanonicalHeaderKey(RequestIDHeader)
+
+	tests := []struct {
+		fixture   string
+		eventType string
+		requestID string
+	}{
+		{"apigw-v1.json", eventAPIGatewayV1, "41b45ea3-70b5-11e6-b7bd-69b5aaebc7d9"},
+		{"apigw-v2.json", eventAPIGatewayV2, "JKJaXmPLvHcESHA="},
+		{"function-url.json", eventFunctionURL, "8f1d3c0a-4f2b-4bd1-9f77-0c2c4b6f1e3a"},
+		{"alb.json", eventALB, "fixture-alb"},
+		{"alb-multivalue.json", eventALB, "fixture-alb-multivalue"},
+	}
+	for _, test := range tests {
+		payload := readFixture(t, test.fixture)
+		if eventType := detectEventType(payload); eventType != test.eventType {
+			t.Errorf("%s: expected event type %s, got %q", test.fixture, test.eventType, eventType)
+		}
+
+		resp, err := LambdaHandler(context.Background(), payload)
+		if err != nil {
+			t.Fatalf("%s: handler failed: %v", test.fixture, err)
+		}
+		var status int
+		var requestID string
+		switch r := resp.(type) {
+		case events.APIGatewayProxyResponse:
+			status, requestID = r.StatusCode, strings.Join(r.MultiValueHeaders[key], ",")
+		case events.APIGatewayV2HTTPResponse:
+			status, requestID = r.StatusCode, r.Headers[key]
+		case events.LambdaFunctionURLResponse:
+			status, requestID = r.StatusCode, r.Headers[key]
+		case events.ALBTargetGroupResponse:
+			status, requestID = r.StatusCode, r.Headers[key]
+			if test.fixture == "alb-multivalue.json" {
+				if r.Headers != nil || len(r.MultiValueHeaders[key]) != 1 {
+					t.Errorf("%s: expected multi-value headers only, got %v %v", test.fixture, r.Headers, r.MultiValueHeaders)
+				}
+				requestID = r.MultiValueHeaders[key][0]
+			}
+			if r.StatusDescription != "200 OK" {
+				t.Errorf("%s: expected status description, got %q", test.fixture, r.StatusDescription)
+			}
+		}
+		if status != http.StatusOK || requestID != test.requestID {
+			t.Errorf("%s: expected 200 with request ID %s, got %T %d %q", test.fixture, test.requestID, resp, status, requestID)
+		}
+	}
+
+	// Unsupported events are logged by their shape, not their content
+	var logs bytes.Buffer
+	prevLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	defer slog.SetDefault(prevLogger)
+	resp, err := LambdaHandler(context.Background(), readFixture(t, "sqs.json"))
+	if r, ok := resp.(events.APIGatewayProxyResponse); err != nil || !ok || r.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 for unsupported event, got %+v %v", resp, err)
+	}
+	if !strings.Contains(logs.String(), "keys=[Records]") || strings.Contains(logs.String(), "receiptHandle") {
+		t.Errorf("Expected only the event's top-level keys to be logged, got %s", logs.String())
+	}
+}
+
+// TestCreateRequestFromALB tests the translation of load balancer requests
+func TestCreateRequestFromALB(t *testing.T) {
+	event := events.ALBTargetGroupRequest{
+		HTTPMethod: "POST",
+		Path:       "/noteid/ABCDE",
+		MultiValueQueryStringParameters: map[string][]string{
+			"tag": {"a%20b", "c"},
+			"raw": {"1"},
+		},
+		MultiValueHeaders: map[string][]string{
+			"host":            {"note.example.com"},
+			"accept":          {"text/plain", "application/json"},
+			"x-forwarded-for": {"198.51.100.1, 10.0.0.1", "203.0.113.7"},
+		},
+		Body:            base64.StdEncoding.EncodeToString([]byte("hello")),
+		IsBase64Encoded: true,
+	}
+	req, err := createRequestFromALB(event)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	if req.URL.RawQuery != "raw=1&tag=a%20b&tag=c" || req.URL.Query().Get("tag") != "a b" {
+		t.Errorf("Expected query to be passed on encoded, got %q", req.URL.RawQuery)
+	}
+	if req.Host != "note.example.com" || len(req.Header.Values("Accept")) != 2 {
+		t.Errorf("Unexpected headers: host %q, %v", req.Host, req.Header)
+	}
+	if req.RemoteAddr != "203.0.113.7" || req.Header.Get("X-Forwarded-For") != "198.51.100.1, 10.0.0.1" {
+		t.Errorf("Expected last hop as peer, got %q and %q", req.RemoteAddr, req.Header.Get("X-Forwarded-For"))
+	}
+	if body, _ := io.ReadAll(req.Body); string(body) != "hello" {
+		t.Errorf("Expected decoded body, got %q", body)
+	}
+
+	// Single-value mode
+	event = events.ALBTargetGroupRequest{
+		HTTPMethod:            "GET",
+		Path:                  "/r/ABCDE",
+		QueryStringParameters: map[string]string{"raw": "1"},
+		Headers:               map[string]string{"x-forwarded-for": "203.0.113.7"},
+	}
+	req, _ = createRequestFromALB(event)
+	if req.URL.RawQuery != "raw=1" || req.RemoteAddr != "203.0.113.7" || req.Header.Get("X-Forwarded-For") != "" {
+		t.Errorf("Unexpected request %q %q %v", req.URL.RawQuery, req.RemoteAddr, req.Header)
+	}
+}
+
+// TestLambdaResponses tests that repeated headers, cookies and binary bodies
+// survive the translation into every response format
+func TestLambdaResponses(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00")
+	lambdaRouter = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept")
+		w.Header().Add("Vary", "Cookie")
+		w.Header().Add("Set-Cookie", "a=1; Expires=Wed, 21 Oct 2026 07:28:00 GMT")
+		w.Header().Add("Set-Cookie", "b=2")
+		if r.URL.Path == "/text" {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			_, _ = io.WriteString(w, "hello")
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write(png)
+	})
+	binary := base64.StdEncoding.EncodeToString(png)
+
+	v1, _ := handleAPIGatewayV1(context.Background(), events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/image"})
+	if !v1.IsBase64Encoded || v1.Body != binary {
+		t.Errorf("v1: expected base64 body, got %v %q", v1.IsBase64Encoded, v1.Body)
+	}
+	if len(v1.MultiValueHeaders["Set-Cookie"]) != 2 || len(v1.MultiValueHeaders["Vary"]) != 2 {
+		t.Errorf("v1: expected repeated headers, got %v", v1.MultiValueHeaders)
+	}
+
+	v2 := events.APIGatewayV2HTTPRequest{RawPath: "/text"}
+	v2.RequestContext.HTTP.Method = "GET"
+	resp2, _ := handleAPIGatewayV2(context.Background(), v2)
+	if resp2.IsBase64Encoded || resp2.Body != "hello" {
+		t.Errorf("v2: expected text body, got %v %q", resp2.IsBase64Encoded, resp2.Body)
+	}
+	if len(resp2.Cookies) != 2 || resp2.Headers["Set-Cookie"] != "" || resp2.Headers["Vary"] != "Accept, Cookie" {
+		t.Errorf("v2: unexpected cookies %v and headers %v", resp2.Cookies, resp2.Headers)
+	}
+
+	alb, _ := handleALB(context.Background(), events.ALBTargetGroupRequest{HTTPMethod: "GET", Path: "/image", Headers: map[string]string{}})
+	if !alb.IsBase64Encoded || alb.Body != binary || alb.Headers["Vary"] != "Accept, Cookie" {
+		t.Errorf("ALB: unexpected response %+v", alb)
+	}
+
+	if body, isBase64 := (&responseRecorder{headers: http.Header{}, body: bytes.NewBufferString("{}")}).lambdaBody(); isBase64 || body != "{}" {
+		t.Errorf("Expected sniffed text body, got %v %q", isBase64, body)
+	}
+	for contentType, text := range map[string]bool{
+		"application/json":                  true,
+		"application/problem+json":          true,
+		"text/html; charset=utf-8":          true,
+		"text/plain; charset=utf-16":        false,
+		"application/octet-stream":          false,
+		"application/zip":                   false,
+		"image/svg+xml":                     true,
+		"application/x-www-form-urlencoded": true,
+	} {
+		if isTextContent(contentType) != text {
+			t.Errorf("%s: expected text %v", contentType, text)
+		}
+	}
+}
+
+// TestCreateRequestFromV1 tests that query parameters are encoded again
+func TestCreateRequestFromV1(t *testing.T) {
+	event := events.APIGatewayProxyRequest{
+		HTTPMethod:            "GET",
+		Path:                  "/ns/team",
+		QueryStringParameters: map[string]string{"q": "a&b c", "raw": "1"},
+		MultiValueQueryStringParameters: map[string][]string{
+			"q":   {"a&b c", "d"},
+			"raw": {"1"},
+		},
+		MultiValueHeaders: map[string][]string{"Accept": {"text/html", "application/json"}},
+	}
+	req, err := createRequestFromV1(event)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	if q := req.URL.Query()["q"]; len(q) != 2 || q[0] != "a&b c" || req.URL.Query().Get("raw") != "1" {
+		t.Errorf("Unexpected query %q", req.URL.RawQuery)
+	}
+	if len(req.Header.Values("Accept")) != 2 {
+		t.Errorf("Expected multi-value headers, got %v", req.Header)
+	}
+
+	event.MultiValueQueryStringParameters = nil
+	req, _ = createRequestFromV1(event)
+	if req.URL.RawQuery != "q=a%26b+c&raw=1" {
+		t.Errorf("Unexpected query %q", req.URL.RawQuery)
+	}
+}
+
+// TestLambdaInvalidRequests tests that events that are not valid HTTP
+// requests get a 400 in the shape of their event
+func TestLambdaInvalidRequests(t *testing.T) {
+	lambdaRouter = NewRouter(NewMockStorage(), RouterConfig{StorageType: "s3"})
+
+	v1, err := handleAPIGatewayV1(context.Background(), events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/a%zz"})
+	if err != nil || v1.StatusCode != http.StatusBadRequest {
+		t.Errorf("v1: expected status 400, got %d %v", v1.StatusCode, err)
+	}
+
+	event := events.APIGatewayV2HTTPRequest{RawPath: "/a%zz"}
+	event.RequestContext.HTTP.Method = "GET"
+	v2, err := handleAPIGatewayV2(context.Background(), event)
+	if err != nil || v2.StatusCode != http.StatusBadRequest || v2.Body != "Bad request\n" {
+		t.Errorf("v2: expected status 400, got %d %q %v", v2.StatusCode, v2.Body, err)
+	}
+	stream, err := handleFunctionURLStream(context.Background(), event)
+	if err != nil || stream.StatusCode != http.StatusBadRequest {
+		t.Errorf("stream: expected status 400, got %d %v", stream.StatusCode, err)
+	}
+
+	alb, err := handleALB(context.Background(), events.ALBTargetGroupRequest{HTTPMethod: "GET", Path: "/a%zz"})
+	if err != nil || alb.StatusCode != http.StatusBadRequest || alb.StatusDescription != "400 Bad Request" {
+		t.Errorf("ALB: expected status 400, got %d %v", alb.StatusCode, err)
+	}
+
+	// A body that is not valid base64 must not reach the handler and blank the note
+	storage := NewMockStorage()
+	_ = storage.Write(context.Background(), "ABCDE", "keep")
+	lambdaRouter = NewRouter(storage, RouterConfig{StorageType: "s3"})
+	body := "not base64!"
+	v1, _ = handleAPIGatewayV1(context.Background(), events.APIGatewayProxyRequest{HTTPMethod: "POST", Path: "/noteid/ABCDE", Body: body, IsBase64Encoded: true})
+	event = events.APIGatewayV2HTTPRequest{RawPath: "/noteid/ABCDE", Body: body, IsBase64Encoded: true}
+	event.RequestContext.HTTP.Method = "POST"
+	v2, _ = handleAPIGatewayV2(context.Background(), event)
+	stream, _ = handleFunctionURLStream(context.Background(), event)
+	alb, _ = handleALB(context.Background(), events.ALBTargetGroupRequest{HTTPMethod: "POST", Path: "/noteid/ABCDE", Body: body, IsBase64Encoded: true})
+	if v1.StatusCode != http.StatusBadRequest || v2.StatusCode != http.StatusBadRequest || stream.StatusCode != http.StatusBadRequest || alb.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for invalid base64, got %d %d %d %d", v1.StatusCode, v2.StatusCode, stream.StatusCode, alb.StatusCode)
+	}
+	if content, _ := storage.Read(context.Background(), "ABCDE"); content != "keep" {
+		t.Errorf("Expected note to be unchanged, got %q", content)
+	}
+}
+
+// TestLambdaWebhooks tests that HTTP invocations return only once the
+// webhook deliveries of their changes are finished, buffered or streamed
+func TestLambdaWebhooks(t *testing.T) {
+	// A slow receiver, so deliveries cannot finish before the invocation by chance
+	receiver := &webhookReceiver{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		receiver.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	globalWebhooks = newTestWebhooks(t, WebhooksConfig{Subscriptions: []WebhookSubscription{{URL: server.URL}}})
+	noteObservers = []NoteObserver{globalWebhooks}
+	lambdaRouter = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = notifyNoteChanged(r.Context(), NoteEvent{Type: NoteUpdated, NoteID: "ABCDE", Source: "app", Time: time.Now()})
+		_, _ = io.WriteString(w, "saved")
+	})
+	t.Cleanup(func() {
+		globalWebhooks = nil
+		noteObservers = nil
+		lambdaStreaming = false
+	})
+	delivered := func() int {
+		receiver.mu.Lock()
+		defer receiver.mu.Unlock()
+		return len(receiver.requests)
+	}
+
+	if _, err := LambdaHandler(context.Background(), readFixture(t, "apigw-v2.json")); err != nil {
+		t.Fatalf("Handler failed: %v", err)
+	}
+	if n := delivered(); n != 1 {
+		t.Errorf("Expected the delivery to finish within the buffered invocation, got %d requests", n)
+	}
+
+	lambdaStreaming = true
+	resp, err := LambdaHandler(context.Background(), readFixture(t, "function-url.json"))
+	if err != nil {
+		t.Fatalf("Handler failed: %v", err)
+	}
+	_, _ = io.ReadAll(resp.(io.Reader))
+	if n := delivered(); n != 2 {
+		t.Errorf("Expected the delivery to finish before the stream ends, got %d requests", n)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// DefaultSocketMode is the permission of Unix sockets: owner and group may connect
+const DefaultSocketMode = "0660"
+
+// systemdFirstFD is the first file descriptor passed by socket activation
+const systemdFirstFD = 3
+
+// redirectSocketName names the systemd socket serving HTTPS redirects
+const redirectSocketName = "redirect"
+
+// listenAddress is a parsed listen address
+type listenAddress struct {
+	network string // tcp or unix
+	address string
+}
+
+// parseListenAddress parses a LISTEN address: host:port, :port or
+// unix:/path/to/socket. An empty address listens on port on all interfaces.
+func parseListenAddress(addr string, port int) (listenAddress, error) {
+	if addr == "" {
+		return listenAddress{"tcp", ":" + strconv.Itoa(port)}, nil
+	}
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		if path == "" {
+			return listenAddress{}, fmt.Errorf("invalid listen address %q (expected unix:/path/to/socket)", addr)
+		}
+		return listenAddress{"unix", path}, nil
+	}
+	if _, p, err := net.SplitHostPort(addr); err != nil || p == "" {
+		return listenAddress{}, fmt.Errorf("invalid listen address %q (expected host:port or unix:/path)", addr)
+	}
+	return listenAddress{"tcp", addr}, nil
+}
+
+// parseSocketMode parses an octal permission such as 0660
+func parseSocketMode(mode string) (os.FileMode, error) {
+	m, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || m > 0o777 {
+		return 0, fmt.Errorf("invalid socket mode %q (expected octal, e.g. 0660)", mode)
+	}
+	return os.FileMode(m), nil
+}
+
+// lookupGroup resolves a group name or numeric ID
+func lookupGroup(group string) (int, error) {
+	if gid, err := strconv.Atoi(group); err == nil {
+		return gid, nil
+	}
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(g.Gid)
+}
+
+// Listen opens the listener of the HTTP server on the configured address
+func Listen(cfg ServerConfig) (net.Listener, error) {
+	addr, err := parseListenAddress(cfg.Listen, cfg.Port)
+	if err != nil {
+		return nil, err
+	}
+	if addr.network == "unix" {
+		return listenUnix(addr.address, cfg.SocketMode, cfg.SocketGroup)
+	}
+	return net.Listen(addr.network, addr.address)
+}
+
+// listenUnix listens on a Unix socket with the given permissions, replacing
+// a socket left behind by a previous run. The socket file is removed when the
+// listener is closed.
+func listenUnix(path string, mode string, group string) (net.Listener, error) {
+	perm, err := parseSocketMode(mode)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, perm); err != nil {
+		_ = ln.Close()
+		return nil, fmt.Errorf("failed to set socket mode: %w", err)
+	}
+	if group != "" {
+		gid, err := lookupGroup(group)
+		if err == nil {
+			err = os.Chown(path, -1, gid)
+		}
+		if err != nil {
+			_ = ln.Close()
+			return nil, fmt.Errorf("failed to set socket group %s: %w", group, err)
+		}
+	}
+	return ln, nil
+}
+
+// SystemdListeners returns the sockets passed by systemd socket activation,
+// keyed by their FileDescriptorName= (the unit name when unset). It returns
+// nil when the process was not socket activated. The LISTEN_* variables are
+// cleared so child processes don't inherit them.
+func SystemdListeners() (map[string]net.Listener, error) {
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", os.Getenv("LISTEN_FDS"))
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	for _, env := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		_ = os.Unsetenv(env)
+	}
+
+	listeners := make(map[string]net.Listener, n)
+	for i := 0; i < n; i++ {
+		fd := systemdFirstFD + i
+		name := "socket" + strconv.Itoa(i)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(fd), name)
+		ln, err := net.FileListener(f)
+		_ = f.Close() // FileListener holds its own duplicate
+		if err != nil {
+			return nil, fmt.Errorf("systemd socket %s: %w", name, err)
+		}
+		if _, exists := listeners[name]; exists {
+			return nil, fmt.Errorf("duplicate systemd socket name %s", name)
+		}
+		listeners[name] = ln
+	}
+	return listeners, nil
+}
+
+// serverListeners opens the listener of the app and, when HTTPS redirects
+// are enabled, of the redirect server. Sockets passed by systemd take
+// precedence over the configured addresses: the one named "redirect" serves
+// redirects and any other serves the app.
+func serverListeners(cfg ServerConfig) (app net.Listener, redirect net.Listener, err error) {
+	sockets, err := SystemdListeners()
+	if err != nil {
+		return nil, nil, err
+	}
+	if sockets != nil {
+		redirect = sockets[redirectSocketName]
+		delete(sockets, redirectSocketName)
+		if len(sockets) != 1 {
+			return nil, nil, fmt.Errorf("expected one app socket from systemd, got %d", len(sockets))
+		}
+		for _, ln := range sockets {
+			app = ln
+		}
+		if redirect != nil && !cfg.TLS.Enabled() {
+			return nil, nil, errors.New("systemd passed a redirect socket but TLS is not configured")
+		}
+		slog.Info("Using systemd socket activation", "address", app.Addr().String(), "redirect", redirect != nil)
+		return app, redirect, nil
+	}
+
+	if app, err = Listen(cfg); err != nil {
+		return nil, nil, err
+	}
+	if cfg.TLS.Enabled() && cfg.TLS.RedirectPort != 0 {
+		if redirect, err = net.Listen("tcp", ":"+strconv.Itoa(cfg.TLS.RedirectPort)); err != nil {
+			_ = app.Close()
+			return nil, nil, err
+		}
+	}
+	return app, redirect, nil
+}
+
+// httpsPort returns the port clients reach the HTTPS server on: the port of
+// a TCP listener, else the configured port
+func httpsPort(ln net.Listener, port int) int {
+	if addr, ok := ln.Addr().(*net.TCPAddr); ok {
+		return addr.Port
+	}
+	return port
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// TestParseListenAddress tests TCP and Unix socket listen addresses
+func TestParseListenAddress(t *testing.T) {
+	tests := []struct {
+		addr    string
+		network string
+		address string
+	}{
+		{"", "tcp", ":8080"},
+		{":9000", "tcp", ":9000"},
+		{"127.0.0.1:9000", "tcp", "127.0.0.1:9000"},
+		{"[::1]:9000", "tcp", "[::1]:9000"},
+		{"unix:/run/note.sock", "unix", "/run/note.sock"},
+	}
+	for _, test := range tests {
+		got, err := parseListenAddress(test.addr, 8080)
+		if err != nil || got.network != test.network || got.address != test.address {
+			t.Errorf("parseListenAddress(%q) = %v, %v; expected %s %s", test.addr, got, err, test.network, test.address)
+		}
+	}
+	for _, addr := range []string{"unix:", "localhost", "9000", "127.0.0.1:"} {
+		if _, err := parseListenAddress(addr, 8080); err == nil {
+			t.Errorf("parseListenAddress(%q): expected error", addr)
+		}
+	}
+}
+
+// TestListenUnix tests serving on a Unix socket with configured permissions
+func TestListenUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "note.sock")
+
+	// A socket left behind by a crashed process is replaced
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatalf("Failed to create stale socket: %v", err)
+	}
+	stale.SetUnlinkOnClose(false)
+	_ = stale.Close()
+
+	ln, err := Listen(ServerConfig{Listen: "unix:" + path, SocketMode: "0600", SocketGroup: strconv.Itoa(os.Getgid())})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("Expected socket mode 0600, got %v %v", info.Mode(), err)
+	}
+
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, ClientIP(r))
+	})}
+	go func() { _ = server.Serve(ln) }()
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	req, _ := http.NewRequest("GET", "http://note/", nil)
+	req.Header.Set("X-Forwarded-For", "198.51.100.9")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Request over Unix socket failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if string(body) != "198.51.100.9" {
+		t.Errorf("Expected the proxy on the socket to report the client IP, got %q", body)
+	}
+
+	_ = server.Close()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected socket file to be removed on close, got %v", err)
+	}
+
+	// Other files are never replaced
+	file := filepath.Join(t.TempDir(), "notes.txt")
+	_ = os.WriteFile(file, []byte("keep"), 0600)
+	if _, err := Listen(ServerConfig{Listen: "unix:" + file, SocketMode: DefaultSocketMode}); err == nil {
+		t.Errorf("Expected listening on a regular file to fail")
+	}
+	if _, err := Listen(ServerConfig{Listen: "unix:" + path, SocketMode: "rw-rw----"}); err == nil {
+		t.Errorf("Expected invalid socket mode to fail")
+	}
+}
+
+// TestSystemdListenersInactive tests that sockets meant for another process are ignored
+func TestSystemdListenersInactive(t *testing.T) {
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "1")
+	if listeners, err := SystemdListeners(); listeners != nil || err != nil {
+		t.Errorf("Expected no systemd sockets, got %v %v", listeners, err)
+	}
+
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "zero")
+	if _, err := SystemdListeners(); err == nil {
+		t.Errorf("Expected invalid LISTEN_FDS to fail")
+	}
+}
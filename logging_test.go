@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+)
+
+// TestParseLogLevel tests parsing of log level names
+func TestParseLogLevel(t *testing.T) {
+	tests := []struct {
+		name  string
+		level slog.Level
+		valid bool
+	}{
+		{"debug", slog.LevelDebug, true},
+		{"INFO", slog.LevelInfo, true},
+		{"warn", slog.LevelWarn, true},
+		{"error", slog.LevelError, true},
+		{"verbose", 0, false},
+	}
+
+	for _, test := range tests {
+		level, err := ParseLogLevel(test.name)
+		if (err == nil) != test.valid || level != test.level {
+			t.Errorf("ParseLogLevel(%s) = %v, %v; expected %v, valid=%v", test.name, level, err, test.level, test.valid)
+		}
+	}
+}
+
+// TestNewLogger tests JSON output, level filtering and request IDs from the context
+func TestNewLogger(t *testing.T) {
+	if _, err := NewLogger(&bytes.Buffer{}, "xml", slog.LevelInfo); err == nil {
+		t.Errorf("Expected error for unknown log format")
+	}
+
+	var buf bytes.Buffer
+	logger, err := NewLogger(&buf, "json", slog.LevelInfo)
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+	ctx := withRequestID(context.Background(), "req-1")
+	logger.DebugContext(ctx, "hidden")
+	logger.InfoContext(ctx, "Note saved", "note", "abc12")
+
+	var record map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("Expected a single JSON record, got %q: %v", buf.String(), err)
+	}
+	if record["msg"] != "Note saved" || record["note"] != "abc12" || record["request_id"] != "req-1" {
+		t.Errorf("Unexpected log record: %v", record)
+	}
+}
+
+// TestRequestIDMiddleware tests generating, reusing and returning request IDs
+func TestRequestIDMiddleware(t *testing.T) {
+	var seen string
+	handler := RequestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = RequestIDFromContext(r.Context())
+	}))
+
+	tests := []struct {
+		name   string
+		header string
+		ctxID  string
+		expect string
+	}{
+		{"generated", "", "", ""},
+		{"from header", "proxy-abc", "", "proxy-abc"},
+		{"invalid header", "bad id\n", "", ""},
+		{"from context", "proxy-abc", "lambda-123", "lambda-123"},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		if test.header != "" {
+			req.Header.Set(RequestIDHeader, test.header)
+		}
+		if test.ctxID != "" {
+			req = req.WithContext(withRequestID(req.Context(), test.ctxID))
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if seen == "" || rec.Header().Get(RequestIDHeader) != seen {
+			t.Errorf("%s: expected request ID in context and response, got %q and %q", test.name, seen, rec.Header().Get(RequestIDHeader))
+		}
+		if test.expect != "" && seen != test.expect {
+			t.Errorf("%s: expected request ID %q, got %q", test.name, test.expect, seen)
+		}
+		if test.expect == "" && (seen == test.header || len(seen) != 16) {
+			t.Errorf("%s: expected a generated request ID, got %q", test.name, seen)
+		}
+	}
+}
+
+// TestLambdaRequestID tests that Lambda responses carry the invocation's request ID
+func TestLambdaRequestID(t *testing.T) {
+	lambdaRouter = NewRouter(NewMockStorage(), RouterConfig{})
+	ctx := lambdacontext.NewContext(context.Background(), &lambdacontext.LambdaContext{AwsRequestID: "lambda-123"})
+
+	event := events.APIGatewayV2HTTPRequest{RawPath: "/r/missing"}
+	event.RequestContext.HTTP.Method = "GET"
+	event.RequestContext.RequestID = "gateway-456"
+
+	resp, err := handleAPIGatewayV2(ctx, event)
+	if err != nil {
+		t.Fatalf("Lambda handler failed: %v", err)
+	}
+	if id := resp.Headers[http.CanonicalHeaderKey(RequestIDHeader)]; id != "lambda-123" {
+		t.Errorf("Expected Lambda request ID, got %q", id)
+	}
+
+	resp, _ = handleAPIGatewayV2(context.Background(), event)
+	if id := resp.Headers[http.CanonicalHeaderKey(RequestIDHeader)]; id != "gateway-456" {
+		t.Errorf("Expected API Gateway request ID without a Lambda context, got %q", id)
+	}
+}
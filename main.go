@@ -2,7 +2,9 @@ package main
 
 import (
 	"context"
-	"log"
+	"errors"
+	"flag"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
@@ -10,8 +12,11 @@ import (
 	"time"
 
 	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 )
 
 // Build variables - injected at build time
@@ -24,118 +29,257 @@ var (
 // Global storage instance
 var globalStorage Storage
 
-func init() {
-	// Print build information
-	log.Printf("Note App - Version: %s, BuildTime: %s, CommitHash: %s", Version, BuildTime, CommitHash)
-}
-
 func main() {
+	// The lambda-local command runs the Lambda handler behind a local server
+	args := os.Args[1:]
+	lambdaLocal := len(args) > 0 && args[0] == lambdaLocalCommand
+	if lambdaLocal {
+		args = args[1:]
+	}
+
+	// Load configuration from the config file, environment and flags
+	cfg, printConfig, err := LoadConfig(args, os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fatal("Invalid configuration", "error", err)
+	}
+	if printConfig {
+		if err := cfg.WriteYAML(os.Stdout); err != nil {
+			fatal("Failed to print configuration", "error", err)
+		}
+		return
+	}
+
+	// Configure logging
+	if err := ConfigureLogging(cfg.Logging); err != nil {
+		fatal("Invalid logging configuration", "error", err)
+	}
+	slog.Info("Note App", "version", Version, "build_time", BuildTime, "commit", CommitHash)
+
+	// Configure tracing
+	if err := InitTracingFromEnv(context.Background()); err != nil {
+		fatal("Invalid tracing configuration", "error", err)
+	}
+
+	// Configure note ID generation
+	noteIDGenerator, err = NewIDGeneratorFromConfig(cfg.NoteID)
+	if err != nil {
+		fatal("Invalid note ID configuration", "error", err)
+	}
+
+	// Configure authentication
+	globalAuth, err = NewAuthenticatorFromConfig(cfg.Auth)
+	if err != nil {
+		fatal("Invalid authentication configuration", "error", err)
+	}
+
+	// Configure which proxies may report the client IP
+	trustedProxies, err = ParseTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		fatal("Invalid trusted proxies", "error", err)
+	}
+	publicURL = cfg.Server.URL
+
+	// Configure rate limiting
+	globalRateLimiter, err = NewRateLimiterFromConfig(cfg.Limits.RateLimit)
+	if err != nil {
+		fatal("Invalid rate limit configuration", "error", err)
+	}
+
+	// Configure note size limit
+	maxNoteSize, err = MaxNoteSizeFromConfig(cfg.Limits)
+	if err != nil {
+		fatal("Invalid note size configuration", "error", err)
+	}
+
+	// Configure outgoing webhooks
+	globalWebhooks, err = NewWebhookDispatcher(cfg.Webhooks)
+	if err != nil {
+		fatal("Invalid webhook configuration", "error", err)
+	}
+	if globalWebhooks != nil {
+		noteObservers = append(noteObservers, globalWebhooks)
+		slog.Info("Webhooks configured", "subscriptions", len(cfg.Webhooks.Subscriptions))
+	}
+
 	// Detect runtime environment
-	if os.Getenv("AWS_LAMBDA_FUNCTION_NAME") != "" {
+	switch {
+	case lambdaLocal:
+		// Lambda emulator mode
+		initLambdaLocal(cfg)
+	case os.Getenv("AWS_LAMBDA_FUNCTION_NAME") != "":
 		// Lambda mode
-		initLambda()
-	} else {
+		initLambda(cfg)
+	default:
 		// HTTP server mode
-		initHTTPServer()
+		initHTTPServer(cfg)
 	}
 }
 
-// initLambda initializes Lambda mode with S3 storage
-func initLambda() {
-	log.Println("Initializing Lambda mode with S3 storage")
+// initLambda initializes Lambda mode with S3 or DynamoDB storage
+func initLambda(cfg *Config) {
+	slog.Info("Initializing Lambda mode", "storage", cfg.Storage.Backend)
 
 	// Load AWS configuration
-	cfg, err := config.LoadDefaultConfig(context.Background())
+	awsConfig, err := config.LoadDefaultConfig(context.Background())
 	if err != nil {
-		log.Fatalf("Failed to load AWS config: %v", err)
+		fatal("Failed to load AWS config", "error", err)
 	}
 
 	// Get S3 configuration
-	s3Bucket := os.Getenv("S3_BUCKET")
-	if s3Bucket == "" {
-		log.Fatal("S3_BUCKET environment variable is required")
+	s3Bucket, s3Prefix := cfg.Storage.S3Bucket, cfg.Storage.S3Prefix
+	if s3Bucket == "" && cfg.Storage.Backend == "s3" {
+		fatal("S3 bucket is required in Lambda mode (set S3_BUCKET)")
 	}
+	s3Client := s3.NewFromConfig(awsConfig)
+
+	switch cfg.Storage.Backend {
+	case "dynamodb":
+		// Notes over the item size limit go to S3, if a bucket is configured
+		var offload *S3Storage
+		if s3Bucket != "" {
+			offload = NewS3Storage(s3Client, s3Bucket, s3Prefix)
+		}
+		dynamoClient := dynamodb.NewFromConfig(awsConfig, func(o *dynamodb.Options) {
+			if cfg.Storage.DynamoEndpoint != "" {
+				o.BaseEndpoint = aws.String(cfg.Storage.DynamoEndpoint)
+			}
+		})
+		lambdaDynamoStorage = NewDynamoStorage(dynamoClient, cfg.Storage.DynamoTable, cfg.Storage.DynamoTTL, offload)
+		globalStorage = lambdaDynamoStorage
+
+		slog.Info("DynamoDB storage configured", "table", cfg.Storage.DynamoTable, "ttl", cfg.Storage.DynamoTTL, "offload_bucket", s3Bucket)
+	default:
+		lambdaS3Storage = NewS3Storage(s3Client, s3Bucket, s3Prefix)
+		globalStorage = lambdaS3Storage
 
-	s3Prefix := os.Getenv("S3_PREFIX")
-	if s3Prefix == "" {
-		s3Prefix = "note"
+		// Removals notified by S3 are recognized as the app's own by the role
+		// that made them, looked up on the first notification
+		stsClient := sts.NewFromConfig(awsConfig)
+		lambdaPrincipal = cachedPrincipal(func(ctx context.Context) (string, error) {
+			identity, err := stsClient.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+			if err != nil {
+				return "", err
+			}
+			return "AWS:" + aws.ToString(identity.UserId), nil
+		})
+
+		slog.Info("S3 storage configured", "bucket", s3Bucket, "prefix", s3Prefix)
 	}
 
-	// Create S3 storage
-	s3Client := s3.NewFromConfig(cfg)
-	globalStorage = NewS3Storage(s3Client, s3Bucket, s3Prefix)
+	// Report metrics in CloudWatch embedded metric format
+	if cfg.Metrics.Enabled {
+		globalMetrics = NewEMFMetrics(os.Stdout, cfg.Metrics.Namespace)
+		globalStorage = InstrumentStorage(globalStorage, globalMetrics)
+	}
+	globalStorage = TraceStorage(globalStorage)
 
-	log.Printf("S3 storage configured: bucket=%s, prefix=%s", s3Bucket, s3Prefix)
+	// Enforce storage quotas
+	globalStorage, err = WithQuotas(globalStorage, cfg.Limits)
+	if err != nil {
+		fatal("Invalid quota configuration", "error", err)
+	}
+
+	// Build the router once per container
+	lambdaRouter = NewRouter(globalStorage, RouterConfig{StorageType: cfg.Storage.Backend})
+	lambdaStreaming = cfg.Lambda.Streaming
+
+	// React to notes changed in the bucket directly or expired in the table
+	noteObservers = append(noteObservers, NewMetaObserver(globalStorage))
+	if quota, ok := globalStorage.(*QuotaStorage); ok {
+		noteObservers = append(noteObservers, quota)
+	}
 
 	// Start Lambda handler
 	lambda.Start(LambdaHandler)
 }
 
 // initHTTPServer initializes HTTP server mode with local storage
-func initHTTPServer() {
-	log.Println("Initializing HTTP server mode with local disk storage")
+func initHTTPServer(cfg *Config) {
+	slog.Info("Initializing HTTP server mode with local disk storage")
 
-	// Get configuration
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	// Create local storage
+	var err error
+	globalStorage, err = NewLocalStorage(cfg.Storage.Dir)
+	if err != nil {
+		fatal("Failed to initialize local storage", "error", err)
 	}
 
-	noteDir := os.Getenv("NOTE_DIR")
-	if noteDir == "" {
-		noteDir = "/note"
+	slog.Info("Local storage configured", "directory", cfg.Storage.Dir)
+
+	// Collect metrics for Prometheus
+	routerConfig := RouterConfig{StorageType: "local"}
+	if cfg.Metrics.Enabled {
+		prom := NewPrometheusMetrics()
+		globalMetrics = prom
+		globalStorage = InstrumentStorage(globalStorage, globalMetrics)
+		routerConfig.Metrics = prom.Handler()
 	}
+	globalStorage = TraceStorage(globalStorage)
 
-	// Create local storage
-	var err error
-	globalStorage, err = NewLocalStorage(noteDir)
+	// Enforce storage quotas
+	globalStorage, err = WithQuotas(globalStorage, cfg.Limits)
 	if err != nil {
-		log.Fatalf("Failed to initialize local storage: %v", err)
-	}
-
-	log.Printf("Local storage configured: directory=%s", noteDir)
-
-	// Setup HTTP routes
-	mux := http.NewServeMux()
-	mux.HandleFunc("/favicon.ico", serveFavicon)
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodGet:
-			HandleGet(globalStorage)(w, r)
-		case http.MethodPost:
-			HandlePost(globalStorage)(w, r)
-		default:
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		}
-	})
+		fatal("Invalid quota configuration", "error", err)
+	}
 
 	// Create server
 	server := &http.Server{
-		Addr:         ":" + port,
-		Handler:      mux,
+		Handler:      NewRouter(globalStorage, routerConfig),
 		ReadTimeout:  15 * time.Second,
 		WriteTimeout: 15 * time.Second,
 		IdleTimeout:  60 * time.Second,
 	}
 
-	// Setup graceful shutdown
+	// Terminate TLS natively, with HTTP/2
+	if cfg.Server.TLS.Enabled() {
+		server.TLSConfig, err = NewServerTLSConfig(cfg.Server.TLS)
+		if err != nil {
+			fatal("Invalid TLS configuration", "error", err)
+		}
+	}
+
+	// Listen on the sockets passed by systemd, a Unix socket or a TCP port
+	ln, redirectLn, err := serverListeners(cfg.Server)
+	if err != nil {
+		fatal("Failed to listen", "error", err)
+	}
+	stopRedirect := startRedirectServer(redirectLn, httpsPort(ln, cfg.Server.Port))
+
+	// Setup graceful shutdown; Serve returns as soon as shutdown begins, so
+	// main waits for done before exiting
+	done := make(chan struct{})
 	go func() {
+		defer close(done)
 		sigChan := make(chan os.Signal, 1)
 		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 		<-sigChan
 
-		log.Println("Shutting down server...")
+		slog.Info("Shutting down server")
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 
+		stopRedirect(ctx)
 		if err := server.Shutdown(ctx); err != nil {
-			log.Printf("Shutdown error: %v", err)
+			slog.Error("Shutdown error", "error", err)
 		}
+		if err := globalWebhooks.Close(ctx); err != nil {
+			slog.Error("Webhook deliveries abandoned", "error", err)
+		}
+		shutdownTracing(ctx)
 	}()
 
 	// Start server
-	log.Printf("Starting HTTP server on port %s", port)
-	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		log.Fatalf("Server error: %v", err)
+	slog.Info("Starting HTTP server", "address", ln.Addr().String(), "tls", server.TLSConfig != nil)
+	if server.TLSConfig != nil {
+		err = server.ServeTLS(ln, "", "")
+	} else {
+		err = server.Serve(ln)
+	}
+	if err != nil && err != http.ErrServerClosed {
+		fatal("Server error", "error", err)
 	}
+	<-done
 }
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// readIDLength is the length of public read-only IDs
+const readIDLength = 16
+
+// readIDGenerator generates the public read-only IDs of notes
+var readIDGenerator IDGenerator = &RandomIDGenerator{length: readIDLength, alphabet: DefaultIDAlphabet}
+
+// NoteMeta holds per-note metadata, stored next to the note content
+type NoteMeta struct {
+	EditToken string     `json:"editToken,omitempty"` // secret required to modify the note
+	ReadID    string     `json:"readId,omitempty"`    // public ID of the read-only link
+	Owner     string     `json:"owner,omitempty"`     // principal that created the note
+	Access    AccessMode `json:"access,omitempty"`    // who may read and edit; see AccessMode
+	Readers   []string   `json:"readers,omitempty"`   // users allowed to read regardless of access mode
+	Editors   []string   `json:"editors,omitempty"`   // users allowed to edit without the edit token
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+// shareRecord maps a read-only ID back to its note
+type shareRecord struct {
+	NoteID string `json:"noteId"`
+}
+
+// metaKey returns the storage key of a note's metadata. The dot can never
+// appear in a note ID, so metadata cannot collide with notes.
+func metaKey(noteID string) string {
+	return noteID + ".meta"
+}
+
+// shareKey returns the storage key of a read-only ID record
+func shareKey(readID string) string {
+	return ".share." + readID
+}
+
+// ReadNoteMeta loads a note's metadata, returning nil if the note has none
+func ReadNoteMeta(ctx context.Context, storage Storage, noteID string) (*NoteMeta, error) {
+	data, err := storage.Read(ctx, metaKey(noteID))
+	if err != nil {
+		return nil, err
+	}
+	if data == "" {
+		return nil, nil
+	}
+	var meta NoteMeta
+	if err := json.Unmarshal([]byte(data), &meta); err != nil {
+		return nil, fmt.Errorf("corrupt metadata for note %s: %w", noteID, err)
+	}
+	return &meta, nil
+}
+
+// WriteNoteMeta saves a note's metadata
+func WriteNoteMeta(ctx context.Context, storage Storage, noteID string, meta *NoteMeta) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return storage.Write(ctx, metaKey(noteID), string(data))
+}
+
+// DeleteNoteMeta removes a note's metadata and its read-only link
+func DeleteNoteMeta(ctx context.Context, storage Storage, noteID string, meta *NoteMeta) error {
+	if meta == nil {
+		return nil
+	}
+	if meta.ReadID != "" {
+		if err := storage.Delete(ctx, shareKey(meta.ReadID)); err != nil {
+			return err
+		}
+	}
+	return storage.Delete(ctx, metaKey(noteID))
+}
+
+// issueNoteMeta creates metadata with a fresh edit token and read-only ID for a
+// new note, recording the authenticated principal (if any) as its owner
+func issueNoteMeta(ctx context.Context, storage Storage, noteID string) (*NoteMeta, error) {
+	meta, err := newNoteMeta(ctx, storage, noteID)
+	if err != nil {
+		return nil, err
+	}
+	if err := WriteNoteMeta(ctx, storage, noteID, meta); err != nil {
+		_ = storage.Delete(ctx, shareKey(meta.ReadID))
+		return nil, err
+	}
+	return meta, nil
+}
+
+// newNoteMeta returns unsaved metadata with a fresh edit token and a reserved
+// read-only ID, owned by the authenticated principal (if any)
+func newNoteMeta(ctx context.Context, storage Storage, noteID string) (*NoteMeta, error) {
+	meta := &NoteMeta{
+		EditToken: rand.Text(),
+		CreatedAt: time.Now().UTC(),
+	}
+	if p := PrincipalFromContext(ctx); p != nil {
+		meta.Owner = p.Name
+	}
+	for attempt := 1; attempt <= maxCreateAttempts; attempt++ {
+		readID := readIDGenerator.NewID()
+		data, _ := json.Marshal(shareRecord{NoteID: noteID})
+		err := storage.Create(ctx, shareKey(readID), string(data))
+		if err == nil {
+			meta.ReadID = readID
+			break
+		}
+		if !errors.Is(err, ErrNoteExists) {
+			return nil, err
+		}
+	}
+	if meta.ReadID == "" {
+		return nil, fmt.Errorf("no free read-only ID found after %d attempts", maxCreateAttempts)
+	}
+	return meta, nil
+}
+
+// CanEdit reports whether token grants edit access. Notes without metadata or
+// without an edit token (created before edit tokens existed) stay open.
+func (m *NoteMeta) CanEdit(token string) bool {
+	if m == nil || m.EditToken == "" {
+		return true
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(m.EditToken)) == 1
+}
+
+// editTokenFromRequest returns the edit token sent with a request, from the
+// JSON body, the X-Edit-Token header or the token query parameter
+func editTokenFromRequest(r *http.Request, req NoteRequest) string {
+	if req.EditToken != "" {
+		return req.EditToken
+	}
+	if token := r.Header.Get("X-Edit-Token"); token != "" {
+		return token
+	}
+	return r.URL.Query().Get("token")
+}
+
+// validateReadID checks if a read-only ID is well-formed
+func validateReadID(readID string) bool {
+	if len(readID) < 8 || len(readID) > 64 {
+		return false
+	}
+	for _, c := range readID {
+		if !isAlphanumeric(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// extractReadID extracts a read-only ID from a path of the form /.../r/{readId}
+func extractReadID(r *http.Request) string {
+	path := r.URL.Path
+	if idx := strings.Index(path, "/r/"); idx != -1 {
+		return strings.Trim(path[idx+len("/r/"):], "/")
+	}
+	return ""
+}
+
+// HandleReadOnly handles GET requests to the read-only link of a note
+func HandleReadOnly(storage Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		readID := extractReadID(r)
+		slog.InfoContext(r.Context(), "Retrieving read-only note", "read_id", readID, "ip", ClientIP(r))
+		if !validateReadID(readID) {
+			http.Error(w, "Note not found", http.StatusNotFound)
+			return
+		}
+
+		data, err := storage.Read(r.Context(), shareKey(readID))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to read share record", "read_id", readID, "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		var share shareRecord
+		if data == "" || json.Unmarshal([]byte(data), &share) != nil || share.NoteID == "" {
+			http.Error(w, "Note not found", http.StatusNotFound)
+			return
+		}
+
+		meta, err := ReadNoteMeta(r.Context(), storage, share.NoteID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to read note metadata", "note", share.NoteID, "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		// A link outlives its note if the note expired or was replaced; it
+		// must not open a later note with the same ID
+		if meta == nil || meta.ReadID != readID {
+			http.Error(w, "Note not found", http.StatusNotFound)
+			return
+		}
+		if !meta.AllowsRead(PrincipalFromContext(r.Context()), "") {
+			slog.WarnContext(r.Context(), "Read-only access denied", "read_id", readID, "access", meta.AccessMode(), "ip", ClientIP(r))
+			http.Error(w, "Access denied", http.StatusForbidden)
+			return
+		}
+
+		content, err := storage.Read(r.Context(), share.NoteID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to read note", "note", share.NoteID, "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if content == "" {
+			http.Error(w, "Note not found", http.StatusNotFound)
+			return
+		}
+		slog.InfoContext(r.Context(), "Read-only note retrieved", "read_id", readID)
+
+		if isCurlRequest(r) {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			_, _ = fmt.Fprint(w, content)
+			return
+		}
+
+		// The note ID itself is not revealed on the read-only page
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		renderHTML(w, "", content, r, noteView{ReadOnly: true, ReadID: readID})
+	}
+}
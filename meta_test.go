@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// postNote sends a JSON save request and decodes the response
+func postNote(t *testing.T, storage Storage, payload NoteRequest) (*httptest.ResponseRecorder, NoteResponse) {
+	t.Helper()
+	body, _ := json.Marshal(payload)
+	req := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	HandlePost(storage)(rec, req)
+	var resp NoteResponse
+	_ = json.Unmarshal(rec.Body.Bytes(), &resp)
+	return rec, resp
+}
+
+// TestEditTokenRequired tests that new notes can only be edited with their edit token
+func TestEditTokenRequired(t *testing.T) {
+	storage := NewMockStorage()
+	ctx := context.Background()
+
+	_, created := postNote(t, storage, NoteRequest{Content: "secret plans"})
+	if created.EditToken == "" || created.ReadID == "" {
+		t.Fatalf("Expected edit token and read-only ID for a new note, got %#v", created)
+	}
+
+	rec, _ := postNote(t, storage, NoteRequest{NoteID: created.NoteID, Content: "defaced"})
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 without edit token, got %d", rec.Code)
+	}
+	rec, _ = postNote(t, storage, NoteRequest{NoteID: created.NoteID, Content: "", EditToken: "wrong"})
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for delete with wrong token, got %d", rec.Code)
+	}
+	if content, _ := storage.Read(ctx, created.NoteID); content != "secret plans" {
+		t.Fatalf("Note was modified without edit token: %q", content)
+	}
+
+	rec, resp := postNote(t, storage, NoteRequest{NoteID: created.NoteID, Content: "updated", EditToken: created.EditToken})
+	if rec.Code != http.StatusOK || resp.EditToken != "" {
+		t.Errorf("Expected save with token to succeed without re-issuing the token, got %d %#v", rec.Code, resp)
+	}
+
+	// Deleting removes the metadata and read-only link as well
+	postNote(t, storage, NoteRequest{NoteID: created.NoteID, Content: "", EditToken: created.EditToken})
+	if meta, _ := ReadNoteMeta(ctx, storage, created.NoteID); meta != nil {
+		t.Errorf("Expected metadata to be deleted")
+	}
+	if share, _ := storage.Read(ctx, shareKey(created.ReadID)); share != "" {
+		t.Errorf("Expected read-only link to be deleted")
+	}
+}
+
+// TestLegacyNoteStaysEditable tests that notes without metadata remain open
+func TestLegacyNoteStaysEditable(t *testing.T) {
+	storage := NewMockStorage()
+	_ = storage.Write(context.Background(), "legacy", "old note")
+
+	rec, resp := postNote(t, storage, NoteRequest{NoteID: "legacy", Content: "still editable"})
+	if rec.Code != http.StatusOK || resp.EditToken != "" {
+		t.Errorf("Expected legacy note to be editable without token, got %d %#v", rec.Code, resp)
+	}
+}
+
+// TestReadOnlyViews tests the rendering of notes with and without the edit token
+func TestReadOnlyViews(t *testing.T) {
+	storage := NewMockStorage()
+	_, created := postNote(t, storage, NoteRequest{NoteID: "shared", Content: "shared content"})
+	if created.EditToken == "" {
+		t.Fatalf("Expected a note saved under a new ID to get an edit token")
+	}
+
+	get := func(handler http.HandlerFunc, target string) string {
+		req := httptest.NewRequest("GET", target, nil)
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s: expected status 200, got %d", target, rec.Code)
+		}
+		return rec.Body.String()
+	}
+
+	editor := get(HandleGet(storage), "/noteid/shared?token="+created.EditToken)
+	if !strings.Contains(editor, "setInterval(autoSave") || strings.Contains(editor, "readonly>") {
+		t.Errorf("Expected editable view with the edit token")
+	}
+
+	plain := get(HandleGet(storage), "/noteid/shared")
+	if strings.Contains(plain, "autoSave") || !strings.Contains(plain, "readonly>") {
+		t.Errorf("Expected read-only view without the edit token")
+	}
+
+	shared := get(HandleReadOnly(storage), "/r/"+created.ReadID)
+	if !strings.Contains(shared, "shared content") || strings.Contains(shared, "autoSave") {
+		t.Errorf("Expected read-only view with content on the read-only link")
+	}
+	if strings.Contains(shared, `id="noteInfo">shared<`) {
+		t.Errorf("Read-only link should not reveal the note ID")
+	}
+
+	// A link of another note with the same ID, e.g. one that expired, is dead
+	_ = storage.Write(context.Background(), shareKey("STALEREADID12345"), `{"noteId":"shared"}`)
+	for _, readID := range []string{"STALEREADID12345", "UNKNOWNREADID123"} {
+		req := httptest.NewRequest("GET", "/r/"+readID, nil)
+		rec := httptest.NewRecorder()
+		HandleReadOnly(storage)(rec, req)
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404 for read-only ID %s, got %d", readID, rec.Code)
+		}
+	}
+}
+
+// TestStaleMetaDiscarded tests that a note saved under the ID of one that
+// expired or was removed outside the app does not inherit its metadata
+func TestStaleMetaDiscarded(t *testing.T) {
+	storage := NewMockStorage()
+	ctx := context.Background()
+	_, old := postNote(t, storage, NoteRequest{NoteID: "reused", Content: "old content"})
+	_ = storage.Delete(ctx, "reused")
+
+	rec, created := postNote(t, storage, NoteRequest{NoteID: "reused", Content: "new content"})
+	if rec.Code != http.StatusOK || created.EditToken == "" || created.EditToken == old.EditToken || created.ReadID == old.ReadID {
+		t.Fatalf("Expected new note with its own edit token and link, got %d %#v", rec.Code, created)
+	}
+	if share, _ := storage.Read(ctx, shareKey(old.ReadID)); share != "" {
+		t.Errorf("Expected read-only link of the old note to be removed")
+	}
+}
+
+// readCountingStorage counts the reads of each key
+type readCountingStorage struct {
+	Storage
+	reads map[string]int
+}
+
+func (rs *readCountingStorage) Read(ctx context.Context, key string) (string, error) {
+	rs.reads[key]++
+	return rs.Storage.Read(ctx, key)
+}
+
+// TestSaveReadsNoteOnce tests that checking for stale metadata does not cost
+// a save another read of the note
+func TestSaveReadsNoteOnce(t *testing.T) {
+	storage := &readCountingStorage{Storage: NewMockStorage(), reads: map[string]int{}}
+	_, created := postNote(t, storage, NoteRequest{NoteID: "counted", Content: "first"})
+	for _, content := range []string{"second", ""} {
+		storage.reads = map[string]int{}
+		rec, _ := postNote(t, storage, NoteRequest{NoteID: "counted", Content: content, EditToken: created.EditToken})
+		if rec.Code != http.StatusOK || storage.reads["counted"] != 1 {
+			t.Errorf("Expected one read of the note, got %d reads and status %d", storage.reads["counted"], rec.Code)
+		}
+	}
+}
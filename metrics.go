@@ -0,0 +1,418 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// DefaultMetricsNamespace is the CloudWatch namespace of metrics in Lambda mode
+const DefaultMetricsNamespace = "Note"
+
+// Metrics records request, storage and note size measurements
+type Metrics interface {
+	// ObserveRequest records a finished HTTP request
+	ObserveRequest(route string, method string, status int, duration time.Duration)
+	// ObserveStorage records a Storage operation; err is nil on success
+	ObserveStorage(op string, duration time.Duration, err error)
+	// ObserveNoteSize records the size of a note read or written
+	ObserveNoteSize(op string, size int)
+}
+
+// globalMetrics receives all measurements; nil disables metrics
+var globalMetrics Metrics
+
+// noteSizeBuckets are the histogram buckets of note sizes in bytes, from
+// 64 bytes to 4 MB
+var noteSizeBuckets = prometheus.ExponentialBuckets(64, 4, 9)
+
+// PrometheusMetrics exposes measurements in the Prometheus text format
+type PrometheusMetrics struct {
+	registry        *prometheus.Registry
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	storageDuration *prometheus.HistogramVec
+	storageErrors   *prometheus.CounterVec
+	noteSize        *prometheus.HistogramVec
+}
+
+// NewPrometheusMetrics creates the collectors in a fresh registry, along with
+// build information and the Go runtime and process collectors
+func NewPrometheusMetrics() *PrometheusMetrics {
+	m := &PrometheusMetrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "note_http_requests_total",
+			Help: "HTTP requests by route, method and status code.",
+		}, []string{"route", "method", "code"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "note_http_request_duration_seconds",
+			Help:    "HTTP request latency by route and method.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "method"}),
+		storageDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "note_storage_operation_duration_seconds",
+			Help:    "Storage operation latency by operation.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"operation"}),
+		storageErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "note_storage_errors_total",
+			Help: "Failed storage operations by operation.",
+		}, []string{"operation"}),
+		noteSize: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "note_size_bytes",
+			Help:    "Size of notes read and written.",
+			Buckets: noteSizeBuckets,
+		}, []string{"operation"}),
+	}
+	buildInfo := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "note_build_info",
+		Help: "Build information of the running binary.",
+	}, []string{"version", "commit", "build_time"})
+	buildInfo.WithLabelValues(Version, CommitHash, BuildTime).Set(1)
+
+	m.registry.MustRegister(
+		m.requests, m.requestDuration, m.storageDuration, m.storageErrors, m.noteSize, buildInfo,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// Handler serves the metrics for scraping
+func (m *PrometheusMetrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// ObserveRequest implements Metrics
+func (m *PrometheusMetrics) ObserveRequest(route string, method string, status int, duration time.Duration) {
+	m.requests.WithLabelValues(route, method, strconv.Itoa(status)).Inc()
+	m.requestDuration.WithLabelValues(route, method).Observe(duration.Seconds())
+}
+
+// ObserveStorage implements Metrics
+func (m *PrometheusMetrics) ObserveStorage(op string, duration time.Duration, err error) {
+	m.storageDuration.WithLabelValues(op).Observe(duration.Seconds())
+	if err != nil {
+		m.storageErrors.WithLabelValues(op).Inc()
+	}
+}
+
+// ObserveNoteSize implements Metrics
+func (m *PrometheusMetrics) ObserveNoteSize(op string, size int) {
+	m.noteSize.WithLabelValues(op).Observe(float64(size))
+}
+
+// EMFMetrics writes measurements as CloudWatch embedded metric format log
+// lines, which CloudWatch turns into metrics without an agent
+type EMFMetrics struct {
+	namespace string
+	mu        sync.Mutex
+	w         io.Writer
+	now       func() time.Time
+}
+
+// NewEMFMetrics creates an EMF writer using namespace
+func NewEMFMetrics(w io.Writer, namespace string) *EMFMetrics {
+	if namespace == "" {
+		namespace = DefaultMetricsNamespace
+	}
+	return &EMFMetrics{namespace: namespace, w: w, now: time.Now}
+}
+
+// emfMetric declares a metric in an EMF document
+type emfMetric struct {
+	Name string `json:"Name"`
+	Unit string `json:"Unit"`
+}
+
+// emit writes one EMF document holding values, dimensioned by dimensions
+func (m *EMFMetrics) emit(dimensions map[string]string, metrics []emfMetric, values map[string]any) {
+	keys := make([]string, 0, len(dimensions))
+	doc := map[string]any{
+		"Version":    Version,
+		"CommitHash": CommitHash,
+	}
+	for k, v := range dimensions {
+		keys = append(keys, k)
+		doc[k] = v
+	}
+	for k, v := range values {
+		doc[k] = v
+	}
+	doc["_aws"] = map[string]any{
+		"Timestamp": m.now().UnixMilli(),
+		"CloudWatchMetrics": []map[string]any{{
+			"Namespace":  m.namespace,
+			"Dimensions": [][]string{keys},
+			"Metrics":    metrics,
+		}},
+	}
+
+	line, err := json.Marshal(doc)
+	if err != nil {
+		slog.Error("Failed to encode metrics", "error", err)
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, _ = m.w.Write(append(line, '\n'))
+}
+
+// ObserveRequest implements Metrics
+func (m *EMFMetrics) ObserveRequest(route string, method string, status int, duration time.Duration) {
+	m.emit(
+		map[string]string{"Route": route, "Method": method, "StatusCode": strconv.Itoa(status)},
+		[]emfMetric{{"Requests", "Count"}, {"Latency", "Milliseconds"}},
+		map[string]any{"Requests": 1, "Latency": float64(duration.Microseconds()) / 1000},
+	)
+}
+
+// ObserveStorage implements Metrics
+func (m *EMFMetrics) ObserveStorage(op string, duration time.Duration, err error) {
+	errorCount := 0
+	if err != nil {
+		errorCount = 1
+	}
+	m.emit(
+		map[string]string{"Operation": op},
+		[]emfMetric{{"StorageLatency", "Milliseconds"}, {"StorageErrors", "Count"}},
+		map[string]any{"StorageLatency": float64(duration.Microseconds()) / 1000, "StorageErrors": errorCount},
+	)
+}
+
+// ObserveNoteSize implements Metrics
+func (m *EMFMetrics) ObserveNoteSize(op string, size int) {
+	m.emit(
+		map[string]string{"Operation": op},
+		[]emfMetric{{"NoteSize", "Bytes"}},
+		map[string]any{"NoteSize": size},
+	)
+}
+
+// routeLabel returns the low-cardinality route of a request for metrics: the
+// mux pattern it is served by, with note pages reported as /noteid/
+func routeLabel(mux *http.ServeMux, r *http.Request) string {
+	_, pattern := mux.Handler(r)
+	switch {
+	case pattern == "":
+		return "unmatched"
+	case pattern == "/" && strings.Contains(r.URL.Path, "/noteid/"):
+		return "/noteid/"
+	default:
+		return pattern
+	}
+}
+
+// methodLabel returns the request method for labels, with anything but the
+// standard methods counted as OTHER so clients cannot create label values
+func methodLabel(r *http.Request) string {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return r.Method
+	default:
+		return "OTHER"
+	}
+}
+
+// statusRecorder captures the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader implements http.ResponseWriter
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// Write implements http.ResponseWriter
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// MetricsMiddleware records every request with the route it is served by in
+// mux. With nil metrics it returns next unchanged.
+func MetricsMiddleware(metrics Metrics, mux *http.ServeMux, next http.Handler) http.Handler {
+	if metrics == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		metrics.ObserveRequest(routeLabel(mux, r), methodLabel(r), rec.status, time.Since(start))
+	})
+}
+
+// InstrumentedStorage wraps a Storage and records the latency and errors of
+// every operation and the size of notes read and written
+type InstrumentedStorage struct {
+	Storage
+	metrics Metrics
+}
+
+// InstrumentStorage wraps storage with metrics, returning it unchanged when
+// metrics is nil
+func InstrumentStorage(storage Storage, metrics Metrics) Storage {
+	if metrics == nil {
+		return storage
+	}
+	return &InstrumentedStorage{Storage: storage, metrics: metrics}
+}
+
+// observe records one operation. A note that already exists or was changed
+// concurrently, or a counter at its limit, is an expected outcome of Create,
+// WriteVersion and AddCounter rather than a failure.
+func (s *InstrumentedStorage) observe(op string, start time.Time, err error) {
+	if errors.Is(err, ErrNoteExists) || errors.Is(err, ErrVersionConflict) || errors.Is(err, ErrCounterLimit) {
+		err = nil
+	}
+	s.metrics.ObserveStorage(op, time.Since(start), err)
+}
+
+// Read implements Storage
+func (s *InstrumentedStorage) Read(ctx context.Context, noteID string) (string, error) {
+	start := time.Now()
+	content, err := s.Storage.Read(ctx, noteID)
+	s.observe("read", start, err)
+	if err == nil && content != "" && ValidateNoteID(noteID) {
+		s.metrics.ObserveNoteSize("read", len(content))
+	}
+	return content, err
+}
+
+// Write implements Storage
+func (s *InstrumentedStorage) Write(ctx context.Context, noteID string, content string) error {
+	start := time.Now()
+	err := s.Storage.Write(ctx, noteID, content)
+	s.observe("write", start, err)
+	if err == nil && ValidateNoteID(noteID) {
+		s.metrics.ObserveNoteSize("write", len(content))
+	}
+	return err
+}
+
+// Create implements Storage
+func (s *InstrumentedStorage) Create(ctx context.Context, noteID string, content string) error {
+	start := time.Now()
+	err := s.Storage.Create(ctx, noteID, content)
+	s.observe("create", start, err)
+	if err == nil && ValidateNoteID(noteID) {
+		s.metrics.ObserveNoteSize("write", len(content))
+	}
+	return err
+}
+
+// Delete implements Storage
+func (s *InstrumentedStorage) Delete(ctx context.Context, noteID string) error {
+	start := time.Now()
+	err := s.Storage.Delete(ctx, noteID)
+	s.observe("delete", start, err)
+	return err
+}
+
+// List implements Storage
+func (s *InstrumentedStorage) List(ctx context.Context, namespace string) ([]string, error) {
+	start := time.Now()
+	names, err := s.Storage.List(ctx, namespace)
+	s.observe("list", start, err)
+	return names, err
+}
+
+// ReadVersion implements VersionedStorage
+func (s *InstrumentedStorage) ReadVersion(ctx context.Context, noteID string) (string, int64, error) {
+	vs, ok := s.Storage.(VersionedStorage)
+	if !ok {
+		return "", 0, errors.ErrUnsupported
+	}
+	start := time.Now()
+	content, version, err := vs.ReadVersion(ctx, noteID)
+	s.observe("read", start, err)
+	if err == nil && content != "" && ValidateNoteID(noteID) {
+		s.metrics.ObserveNoteSize("read", len(content))
+	}
+	return content, version, err
+}
+
+// WriteVersion implements VersionedStorage
+func (s *InstrumentedStorage) WriteVersion(ctx context.Context, noteID string, content string, version int64) (int64, error) {
+	vs, ok := s.Storage.(VersionedStorage)
+	if !ok {
+		return 0, errors.ErrUnsupported
+	}
+	start := time.Now()
+	saved, err := vs.WriteVersion(ctx, noteID, content, version)
+	s.observe("write", start, err)
+	if err == nil && ValidateNoteID(noteID) {
+		s.metrics.ObserveNoteSize("write", len(content))
+	}
+	return saved, err
+}
+
+// ListByOwner implements OwnerIndex
+func (s *InstrumentedStorage) ListByOwner(ctx context.Context, owner string) ([]string, error) {
+	index, ok := s.Storage.(OwnerIndex)
+	if !ok {
+		return nil, errors.ErrUnsupported
+	}
+	start := time.Now()
+	ids, err := index.ListByOwner(ctx, owner)
+	s.observe("list_owner", start, err)
+	return ids, err
+}
+
+// AddCounter implements CounterStorage
+func (s *InstrumentedStorage) AddCounter(ctx context.Context, key string, delta int64, limit int64) (int64, error) {
+	counters, ok := s.Storage.(CounterStorage)
+	if !ok {
+		return 0, errors.ErrUnsupported
+	}
+	start := time.Now()
+	value, err := counters.AddCounter(ctx, key, delta, limit)
+	s.observe("add_counter", start, err)
+	return value, err
+}
+
+// ReadCounter implements CounterStorage
+func (s *InstrumentedStorage) ReadCounter(ctx context.Context, key string) (int64, error) {
+	counters, ok := s.Storage.(CounterStorage)
+	if !ok {
+		return 0, errors.ErrUnsupported
+	}
+	start := time.Now()
+	value, err := counters.ReadCounter(ctx, key)
+	s.observe("read_counter", start, err)
+	return value, err
+}
+
+// Unwrap returns the wrapped storage
+func (s *InstrumentedStorage) Unwrap() Storage {
+	return s.Storage
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// failingStorage is a Storage whose operations all fail
+type failingStorage struct {
+	Storage
+}
+
+func (failingStorage) Read(ctx context.Context, noteID string) (string, error) {
+	return "", errors.New("backend down")
+}
+
+// TestPrometheusMetrics tests request, storage and build metrics on /metrics
+func TestPrometheusMetrics(t *testing.T) {
+	metrics := NewPrometheusMetrics()
+	storage := InstrumentStorage(NewMockStorage(), metrics)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.HandleFunc("/r/", HandleReadOnly(storage))
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			HandleGet(storage)(w, r)
+		} else {
+			HandlePost(storage)(w, r)
+		}
+	})
+	handler := MetricsMiddleware(metrics, mux, mux)
+
+	do := func(method string, path string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	do("POST", "/", `{"content":"hello metrics"}`)
+	do("GET", "/noteid/abc12", "")
+	do("GET", "/r/missing", "")
+	do("X-RANDOM-1", "/r/missing", "")
+	do("get", "/r/missing", "")
+	_, _ = InstrumentStorage(failingStorage{}, metrics).Read(context.Background(), "abc12")
+
+	rec := do("GET", "/metrics", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected metrics endpoint to respond, got %d", rec.Code)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		`note_http_requests_total{code="200",method="POST",route="/"} 1`,
+		`note_http_requests_total{code="200",method="GET",route="/noteid/"} 1`,
+		`note_http_requests_total{code="404",method="GET",route="/r/"} 1`,
+		`note_http_request_duration_seconds_count{method="POST",route="/"} 1`,
+		`note_http_request_duration_seconds_count{method="OTHER",route="/r/"} 2`,
+		`note_storage_operation_duration_seconds_count{operation="create"}`,
+		`note_storage_errors_total{operation="read"} 1`,
+		`note_size_bytes_sum{operation="write"} 13`,
+		`note_build_info{build_time="` + BuildTime + `",commit="` + CommitHash + `",version="` + Version + `"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected metrics to contain %s", want)
+		}
+	}
+	if strings.Contains(body, "X-RANDOM") || strings.Contains(body, `method="get"`) {
+		t.Errorf("Expected nonstandard methods to be labelled OTHER")
+	}
+}
+
+// TestEMFMetrics tests embedded metric format output for Lambda mode
+func TestEMFMetrics(t *testing.T) {
+	var buf bytes.Buffer
+	metrics := NewEMFMetrics(&buf, "")
+	metrics.now = func() time.Time { return time.UnixMilli(1700000000000) }
+	metrics.ObserveRequest("/r/", "GET", 404, 1500*time.Microsecond)
+
+	var doc struct {
+		Route      string
+		StatusCode string
+		Requests   int
+		Latency    float64
+		Version    string
+		AWS        struct {
+			Timestamp         int64
+			CloudWatchMetrics []struct {
+				Namespace  string
+				Dimensions [][]string
+				Metrics    []emfMetric
+			}
+		} `json:"_aws"`
+	}
+	line, err := io.ReadAll(&buf)
+	if err != nil || json.Unmarshal(line, &doc) != nil {
+		t.Fatalf("Expected one JSON document, got %q", line)
+	}
+	if doc.Route != "/r/" || doc.StatusCode != "404" || doc.Requests != 1 || doc.Latency != 1.5 || doc.Version != Version {
+		t.Errorf("Unexpected EMF values: %+v", doc)
+	}
+	if doc.AWS.Timestamp != 1700000000000 || len(doc.AWS.CloudWatchMetrics) != 1 {
+		t.Fatalf("Unexpected EMF metadata: %+v", doc.AWS)
+	}
+	directive := doc.AWS.CloudWatchMetrics[0]
+	if directive.Namespace != DefaultMetricsNamespace || len(directive.Dimensions) != 1 || len(directive.Dimensions[0]) != 3 || len(directive.Metrics) != 2 {
+		t.Errorf("Unexpected EMF directive: %+v", directive)
+	}
+}
+
+// TestInstrumentStorageDisabled tests that storage is left alone without metrics
+func TestInstrumentStorageDisabled(t *testing.T) {
+	storage := NewMockStorage()
+	if InstrumentStorage(storage, nil) != Storage(storage) {
+		t.Errorf("Expected storage to be returned unchanged")
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// extractNamespace extracts the namespace from a path of the form /.../ns/{namespace}
+func extractNamespace(r *http.Request) string {
+	path := r.URL.Path
+	if idx := strings.Index(path, "/ns/"); idx != -1 {
+		return strings.Trim(path[idx+len("/ns/"):], "/")
+	}
+	return ""
+}
+
+// HandleList handles GET requests listing the notes and sub-namespaces of a namespace
+func HandleList(storage Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		namespace := extractNamespace(r)
+		clientIP := ClientIP(r)
+		slog.InfoContext(r.Context(), "Listing namespace", "namespace", namespace, "ip", clientIP)
+
+		if !ValidateNamespace(namespace) {
+			slog.WarnContext(r.Context(), "Invalid namespace format", "namespace", namespace)
+			http.Error(w, "Invalid namespace format", http.StatusBadRequest)
+			return
+		}
+
+		entries, err := storage.List(r.Context(), namespace)
+		if err == nil {
+			entries, err = visibleEntries(r.Context(), storage, namespace, entries, PrincipalFromContext(r.Context()))
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to list namespace", "namespace", namespace, "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if isCurlRequest(r) {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			for _, e := range entries {
+				_, _ = fmt.Fprintln(w, e)
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		renderListHTML(w, namespace, entries, r)
+	}
+}
+
+// HandleMine handles GET requests listing the notes created by the signed-in
+// user, most recently saved first. Only storages with an owner index, such as
+// DynamoDB, support it.
+func HandleMine(storage Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		principal := PrincipalFromContext(r.Context())
+		if principal == nil {
+			http.Error(w, "Sign in to list your notes", http.StatusUnauthorized)
+			return
+		}
+		index, ok := ownerIndex(storage)
+		if !ok {
+			http.Error(w, "Listing notes by owner is not supported by this storage", http.StatusNotImplemented)
+			return
+		}
+
+		// Sharing may have been narrowed by another editor since
+		ids, err := index.ListByOwner(r.Context(), principal.Name)
+		if err == nil {
+			ids, err = visibleEntries(r.Context(), storage, "", ids, principal)
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to list notes of owner", "principal", principal.String(), "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if isCurlRequest(r) {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			for _, id := range ids {
+				_, _ = fmt.Fprintln(w, id)
+			}
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string][]string{"notes": ids})
+	}
+}
+
+// visibleEntries filters a listing down to the sub-namespaces and the notes
+// that p may see listed
+func visibleEntries(ctx context.Context, storage Storage, namespace string, entries []string, p *Principal) ([]string, error) {
+	prefix := ""
+	if namespace != "" {
+		prefix = namespace + "/"
+	}
+	visible := []string{}
+	for _, e := range entries {
+		if !strings.HasSuffix(e, "/") {
+			meta, err := ReadNoteMeta(ctx, storage, prefix+e)
+			if err != nil {
+				return nil, err
+			}
+			if !meta.AllowsList(p) {
+				continue
+			}
+		}
+		visible = append(visible, e)
+	}
+	return visible, nil
+}
+
+// renderListHTML renders a directory listing of a namespace
+func renderListHTML(w http.ResponseWriter, namespace string, entries []string, r *http.Request) {
+	base := appPath(r)
+	prefix := ""
+	if namespace != "" {
+		prefix = namespace + "/"
+	}
+
+	// Breadcrumbs: / infra / dns
+	var crumbs strings.Builder
+	crumbs.WriteString(`<a href="` + EscapeHTML(base) + `ns/">/</a>`)
+	if namespace != "" {
+		parts := strings.Split(namespace, "/")
+		for i, part := range parts {
+			crumbs.WriteString(` <a href="` + EscapeHTML(base+"ns/"+strings.Join(parts[:i+1], "/")) + `/">` + EscapeHTML(part) + `</a> /`)
+		}
+	}
+
+	var items strings.Builder
+	if len(entries) == 0 {
+		items.WriteString(`<li class="empty">This namespace is empty</li>`)
+	}
+	for _, e := range entries {
+		if strings.HasSuffix(e, "/") {
+			items.WriteString(`<li class="ns"><a href="` + EscapeHTML(base+"ns/"+prefix+e) + `">` + EscapeHTML(e) + `</a></li>`)
+		} else {
+			items.WriteString(`<li class="note"><a href="` + EscapeHTML(base+"noteid/"+prefix+e) + `">` + EscapeHTML(e) + `</a></li>`)
+		}
+	}
+
+	html := `<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <link rel="icon" href="/favicon.ico" type="image/x-icon">
+    <title>Note - /` + EscapeHTML(namespace) + `</title>
+    <style>
+        body {
+            font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", "Inter", "Roboto", "Helvetica Neue", sans-serif;
+            margin: 0;
+            color: #1E293B;
+            -webkit-font-smoothing: antialiased;
+        }
+
+        .header {
+            padding: 12px 20px;
+            border-bottom: 1px solid #E2E8F0;
+            box-shadow: 0 1px 2px rgba(0,0,0,0.05);
+            font-size: 20px;
+            font-weight: 700;
+        }
+
+        .header .logo-icon { color: #2563EB; }
+
+        .path {
+            padding: 12px 20px;
+            background: #EFF6FF;
+            font-family: "SF Mono", "Monaco", "Menlo", "Consolas", monospace;
+            font-size: 13px;
+        }
+
+        a { color: #2563EB; text-decoration: none; }
+        a:hover { text-decoration: underline; }
+
+        ul { list-style: none; margin: 0; padding: 8px 20px; }
+        li { padding: 6px 0; border-bottom: 1px solid #E2E8F0; font-family: "SF Mono", "Monaco", "Menlo", "Consolas", monospace; font-size: 14px; }
+        li.ns a { font-weight: 600; }
+        li.empty { color: #94A3B8; }
+    </style>
+</head>
+<body>
+    <div class="header"><span class="logo-icon">✎</span> Note</div>
+    <div class="path">` + crumbs.String() + `</div>
+    <ul>` + items.String() + `</ul>
+</body>
+</html>`
+
+	_, _ = fmt.Fprint(w, html)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestHandleList tests namespace listings in HTML and plain text
+func TestHandleList(t *testing.T) {
+	storage := NewMockStorage()
+	ctx := context.Background()
+	_ = storage.Write(ctx, "infra/dns/cutover", "plan")
+	_ = storage.Write(ctx, "infra/readme", "hello")
+	_ = storage.Write(ctx, "ABCDE", "top level")
+
+	req := httptest.NewRequest("GET", "/app/ns/infra", nil)
+	rec := httptest.NewRecorder()
+	HandleList(storage)(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+	body := rec.Body.String()
+	if !strings.Contains(body, `href="/app/ns/infra/dns/"`) {
+		t.Errorf("Expected link to sub-namespace in listing")
+	}
+	if !strings.Contains(body, `href="/app/noteid/infra/readme"`) {
+		t.Errorf("Expected link to note in listing")
+	}
+	if strings.Contains(body, "ABCDE") {
+		t.Errorf("Listing should only contain entries of the namespace")
+	}
+
+	req = httptest.NewRequest("GET", "/ns/", nil)
+	req.Header.Set("User-Agent", "curl/8.0")
+	rec = httptest.NewRecorder()
+	HandleList(storage)(rec, req)
+
+	if got := rec.Body.String(); got != "ABCDE\ninfra/\n" {
+		t.Errorf("Unexpected plain text listing: %q", got)
+	}
+
+	req = httptest.NewRequest("GET", "/ns/infra/..secret", nil)
+	rec = httptest.NewRecorder()
+	HandleList(storage)(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for invalid namespace, got %d", rec.Code)
+	}
+}
+
+// TestHandleGetNamespacedNote tests path-style note IDs end to end
+func TestHandleGetNamespacedNote(t *testing.T) {
+	storage := NewMockStorage()
+	_ = storage.Write(context.Background(), "infra/dns/cutover", "cutover plan")
+
+	req := httptest.NewRequest("GET", "/noteid/infra/dns/cutover", nil)
+	req.Header.Set("User-Agent", "curl/8.0")
+	rec := httptest.NewRecorder()
+	HandleGet(storage)(rec, req)
+
+	if rec.Code != http.StatusOK || rec.Body.String() != "cutover plan" {
+		t.Errorf("Unexpected response: %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+// TestHandleListAccess tests that listings only show notes the caller may
+// find by name
+func TestHandleListAccess(t *testing.T) {
+	storage := NewMockStorage()
+	ctx := context.Background()
+	for id, mode := range map[string]AccessMode{
+		"team/linked":  AccessLink,
+		"team/private": AccessPrivate,
+		"team/members": AccessTeam,
+		"team/open":    AccessPublic,
+	} {
+		_ = storage.Write(ctx, id, "content")
+		_ = WriteNoteMeta(ctx, storage, id, &NoteMeta{EditToken: "tok", Owner: testOwner.Name, Access: mode, Readers: []string{testReader.Name}})
+	}
+	_ = storage.Write(ctx, "team/legacy", "content")
+	_ = WriteNoteMeta(ctx, storage, "team/shared", &NoteMeta{EditToken: "tok", Owner: testOwner.Name, Access: AccessPrivate, Readers: []string{testOther.Name}})
+	_ = storage.Write(ctx, "team/shared", "content")
+
+	tests := []struct {
+		principal *Principal
+		expected  string
+	}{
+		{nil, "legacy\nopen\n"},
+		{testOther, "legacy\nmembers\nopen\nshared\n"},
+		{testOwner, "legacy\nlinked\nmembers\nopen\nprivate\nshared\n"},
+		{testAdmin, "legacy\nlinked\nmembers\nopen\nprivate\nshared\n"},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/ns/team", nil)
+		req.Header.Set("User-Agent", "curl/8.0")
+		req = req.WithContext(withPrincipal(req.Context(), test.principal))
+		rec := httptest.NewRecorder()
+		HandleList(storage)(rec, req)
+
+		if got := rec.Body.String(); got != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.principal.String(), test.expected, got)
+		}
+	}
+}
+
+// TestHandleMine tests listing the notes of the signed-in user
+func TestHandleMine(t *testing.T) {
+	storage := newVersionedMockStorage()
+	ctx := context.Background()
+	storage.owners[testOwner.Name] = []string{"team/newer", "team/taken"}
+	_ = WriteNoteMeta(ctx, storage, "team/newer", &NoteMeta{EditToken: "tok", Owner: testOwner.Name, Access: AccessPrivate})
+	_ = WriteNoteMeta(ctx, storage, "team/taken", &NoteMeta{EditToken: "tok", Owner: testOther.Name, Access: AccessPrivate})
+
+	tests := []struct {
+		storage   Storage
+		principal *Principal
+		status    int
+		expected  string
+	}{
+		{storage, nil, http.StatusUnauthorized, ""},
+		{NewMockStorage(), testOwner, http.StatusNotImplemented, ""},
+		{storage, testOwner, http.StatusOK, "team/newer\n"},
+		{storage, testOther, http.StatusOK, ""},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/mine", nil)
+		req.Header.Set("User-Agent", "curl/8.0")
+		req = req.WithContext(withPrincipal(req.Context(), test.principal))
+		rec := httptest.NewRecorder()
+		HandleMine(test.storage)(rec, req)
+
+		if rec.Code != test.status {
+			t.Errorf("%s: expected %d, got %d", test.principal.String(), test.status, rec.Code)
+		} else if got := rec.Body.String(); test.status == http.StatusOK && got != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.principal.String(), test.expected, got)
+		}
+	}
+}
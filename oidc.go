@@ -0,0 +1,398 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// Cookie names used by the OIDC login
+const (
+	loginCookieName   = "note_login"
+	sessionCookieName = "note_session"
+)
+
+// loginTTL bounds how long a user may take to complete the provider login
+const loginTTL = 10 * time.Minute
+
+// DefaultSessionTTL is the default lifetime of a login session
+const DefaultSessionTTL = 12 * time.Hour
+
+// OIDCConfig configures OpenID Connect login for the web editor; it is
+// enabled when Issuer is set
+type OIDCConfig struct {
+	Issuer         string        `yaml:"issuer"`
+	ClientID       string        `yaml:"client_id"`
+	ClientSecret   string        `yaml:"client_secret"`
+	RedirectURL    string        `yaml:"redirect_url"` // derived from the request URL when empty
+	Scopes         []string      `yaml:"scopes"`
+	AllowedDomains []string      `yaml:"allowed_domains"` // email domains allowed to sign in; empty allows all
+	AllowedGroups  []string      `yaml:"allowed_groups"`  // groups allowed to sign in; empty allows all
+	GroupsClaim    string        `yaml:"groups_claim"`
+	SessionSecret  string        `yaml:"session_secret"`
+	SessionTTL     time.Duration `yaml:"session_ttl"`
+}
+
+// splitList splits a comma-separated list, dropping empty entries
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// OIDCLogin implements the OpenID Connect authorization-code flow with PKCE
+// and keeps the resulting identity in a signed session cookie
+type OIDCLogin struct {
+	config   OIDCConfig
+	oauth    oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+// loginState is kept in a signed cookie between the login redirect and the callback
+type loginState struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	Return   string `json:"return"`
+	Expires  int64  `json:"exp"`
+}
+
+// session is the signed identity stored in the session cookie
+type session struct {
+	Subject string `json:"sub"`
+	Name    string `json:"name"`
+	Expires int64  `json:"exp"`
+}
+
+// identityClaims are the ID token claims used to identify and authorize users
+type identityClaims struct {
+	Subject       string   `json:"sub"`
+	Email         string   `json:"email"`
+	EmailVerified *bool    `json:"email_verified"`
+	Groups        []string `json:"-"` // read from the configured groups claim
+}
+
+// NewOIDCLogin discovers the provider configuration and creates the login flow
+func NewOIDCLogin(ctx context.Context, cfg OIDCConfig) (*OIDCLogin, error) {
+	provider, err := oidc.NewProvider(ctx, cfg.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover OIDC provider %s: %w", cfg.Issuer, err)
+	}
+
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{oidc.ScopeOpenID, "email", "profile"}
+	}
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = "groups"
+	}
+	if cfg.SessionTTL <= 0 {
+		cfg.SessionTTL = DefaultSessionTTL
+	}
+	if cfg.SessionSecret == "" {
+		// Sessions won't survive restarts or be shared between instances
+		slog.WarnContext(ctx, "Session secret is not set, using a random session key")
+		cfg.SessionSecret = rand.Text()
+	}
+
+	return &OIDCLogin{
+		config: cfg,
+		oauth: oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			Endpoint:     provider.Endpoint(),
+			RedirectURL:  cfg.RedirectURL,
+			Scopes:       cfg.Scopes,
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: cfg.ClientID}),
+	}, nil
+}
+
+// ServeHTTP handles the /auth/login, /auth/callback and /auth/logout routes
+func (o *OIDCLogin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/auth/login"):
+		o.handleLogin(w, r)
+	case strings.HasSuffix(r.URL.Path, "/auth/callback"):
+		o.handleCallback(w, r)
+	case strings.HasSuffix(r.URL.Path, "/auth/logout"):
+		o.clearCookie(w, r, sessionCookieName)
+		http.Redirect(w, r, appPath(r), http.StatusFound)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// oauthConfig returns the OAuth2 configuration for a request, deriving the
+// redirect URL from the request when none is configured
+func (o *OIDCLogin) oauthConfig(r *http.Request) *oauth2.Config {
+	cfg := o.oauth
+	if cfg.RedirectURL == "" {
+		cfg.RedirectURL = getBaseURL(r) + "auth/callback"
+	}
+	return &cfg
+}
+
+// handleLogin redirects the browser to the provider's authorization endpoint
+func (o *OIDCLogin) handleLogin(w http.ResponseWriter, r *http.Request) {
+	state := loginState{
+		State:    rand.Text(),
+		Nonce:    rand.Text(),
+		Verifier: oauth2.GenerateVerifier(),
+		Return:   safeReturnPath(r.URL.Query().Get("return"), appPath(r)),
+		Expires:  time.Now().Add(loginTTL).Unix(),
+	}
+	o.setCookie(w, r, loginCookieName, state, loginTTL)
+
+	authURL := o.oauthConfig(r).AuthCodeURL(state.State,
+		oauth2.S256ChallengeOption(state.Verifier), oidc.Nonce(state.Nonce))
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// handleCallback completes the login and starts a session
+func (o *OIDCLogin) handleCallback(w http.ResponseWriter, r *http.Request) {
+	clientIP := ClientIP(r)
+	var state loginState
+	if !o.readCookie(r, loginCookieName, &state) || time.Now().Unix() > state.Expires {
+		http.Error(w, "Login expired, please try again", http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	if errCode := query.Get("error"); errCode != "" {
+		slog.WarnContext(r.Context(), "OIDC login failed", "ip", clientIP, "error", errCode, "description", query.Get("error_description"))
+		http.Error(w, "Login failed", http.StatusUnauthorized)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(state.State)) != 1 {
+		slog.WarnContext(r.Context(), "OIDC state mismatch", "ip", clientIP)
+		http.Error(w, "Invalid login state", http.StatusBadRequest)
+		return
+	}
+
+	claims, err := o.exchange(r.Context(), o.oauthConfig(r), query.Get("code"), state)
+	if err != nil {
+		slog.WarnContext(r.Context(), "OIDC login failed", "ip", clientIP, "error", err)
+		http.Error(w, "Login failed", http.StatusUnauthorized)
+		return
+	}
+	name := claims.displayName()
+	if err := o.authorize(claims); err != nil {
+		slog.WarnContext(r.Context(), "OIDC login denied", "user", name, "ip", clientIP, "error", err)
+		http.Error(w, "You are not allowed to use this application", http.StatusForbidden)
+		return
+	}
+
+	// The session cookie is set first so it survives runtimes that only keep one Set-Cookie
+	o.setCookie(w, r, sessionCookieName, session{
+		Subject: claims.Subject,
+		Name:    name,
+		Expires: time.Now().Add(o.config.SessionTTL).Unix(),
+	}, o.config.SessionTTL)
+	o.clearCookie(w, r, loginCookieName)
+
+	slog.InfoContext(r.Context(), "OIDC login", "user", name, "ip", clientIP)
+	http.Redirect(w, r, state.Return, http.StatusFound)
+}
+
+// exchange redeems the authorization code and verifies the returned ID token
+func (o *OIDCLogin) exchange(ctx context.Context, cfg *oauth2.Config, code string, state loginState) (*identityClaims, error) {
+	token, err := cfg.Exchange(ctx, code, oauth2.VerifierOption(state.Verifier))
+	if err != nil {
+		return nil, fmt.Errorf("code exchange failed: %w", err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("token response has no id_token")
+	}
+	idToken, err := o.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+	if subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(state.Nonce)) != 1 {
+		return nil, errors.New("ID token nonce mismatch")
+	}
+
+	var claims identityClaims
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("invalid ID token claims: %w", err)
+	}
+	var raw map[string]json.RawMessage
+	if err := idToken.Claims(&raw); err != nil {
+		return nil, fmt.Errorf("invalid ID token claims: %w", err)
+	}
+	if groups, ok := raw[o.config.GroupsClaim]; ok {
+		_ = json.Unmarshal(groups, &claims.Groups)
+	}
+	return &claims, nil
+}
+
+// authorize applies the allowed domain and group checks
+func (o *OIDCLogin) authorize(claims *identityClaims) error {
+	if len(o.config.AllowedDomains) > 0 {
+		_, domain, ok := strings.Cut(claims.verifiedEmail(), "@")
+		if !ok {
+			return errors.New("no verified email address")
+		}
+		if !containsFold(o.config.AllowedDomains, domain) {
+			return fmt.Errorf("email domain %s is not allowed", domain)
+		}
+	}
+	if len(o.config.AllowedGroups) > 0 {
+		for _, group := range claims.Groups {
+			if containsFold(o.config.AllowedGroups, group) {
+				return nil
+			}
+		}
+		return errors.New("not a member of an allowed group")
+	}
+	return nil
+}
+
+// verifiedEmail returns the email address if the provider says it was
+// verified. A missing email_verified claim does not count: anyone could have
+// entered the address.
+func (c *identityClaims) verifiedEmail() string {
+	if c.EmailVerified == nil || !*c.EmailVerified {
+		return ""
+	}
+	return c.Email
+}
+
+// displayName returns the name recorded for the user: the verified email
+// address, or else the subject. Other claims such as preferred_username can
+// often be chosen freely and would let users pass for note owners.
+func (c *identityClaims) displayName() string {
+	if email := c.verifiedEmail(); email != "" {
+		return email
+	}
+	return c.Subject
+}
+
+// containsFold reports whether list contains s, ignoring case
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Principal returns the principal of the request's session cookie, or nil if
+// there is no valid session
+func (o *OIDCLogin) Principal(r *http.Request) *Principal {
+	var s session
+	if !o.readCookie(r, sessionCookieName, &s) || time.Now().Unix() > s.Expires || s.Subject == "" || s.Name == "" {
+		return nil
+	}
+	return &Principal{Name: s.Name, Method: "oidc", Scopes: defaultScopes}
+}
+
+// LoginURL returns the login URL that returns to the current page afterwards
+func (o *OIDCLogin) LoginURL(r *http.Request) string {
+	return appPath(r) + "auth/login?return=" + url.QueryEscape(r.URL.RequestURI())
+}
+
+// safeReturnPath only allows local absolute paths as post-login redirects
+func safeReturnPath(path string, fallback string) string {
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || strings.Contains(path, `\`) {
+		return fallback
+	}
+	return path
+}
+
+// sign returns value as base64url(JSON).base64url(HMAC-SHA256). The purpose
+// is part of the signature so that one cookie can't be replayed as another.
+func (o *OIDCLogin) sign(purpose string, value any) (string, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(o.mac(purpose, payload)), nil
+}
+
+// mac returns the HMAC-SHA256 of a payload signed for purpose
+func (o *OIDCLogin) mac(purpose string, payload string) []byte {
+	mac := hmac.New(sha256.New, []byte(o.config.SessionSecret))
+	mac.Write([]byte(purpose + "\x00" + payload))
+	return mac.Sum(nil)
+}
+
+// verify checks a value produced by sign for the same purpose and decodes it
+// into out
+func (o *OIDCLogin) verify(purpose string, signed string, out any) bool {
+	payload, sig, ok := strings.Cut(signed, ".")
+	if !ok {
+		return false
+	}
+	got, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	if !hmac.Equal(got, o.mac(purpose, payload)) {
+		return false
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return false
+	}
+	return json.Unmarshal(data, out) == nil
+}
+
+// setCookie writes a signed cookie scoped to the app path
+func (o *OIDCLogin) setCookie(w http.ResponseWriter, r *http.Request, name string, value any, ttl time.Duration) {
+	signed, err := o.sign(name, value)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to sign cookie", "cookie", name, "error", err)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    signed,
+		Path:     appPath(r),
+		MaxAge:   int(ttl.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// readCookie reads and verifies a signed cookie
+func (o *OIDCLogin) readCookie(r *http.Request, name string, out any) bool {
+	c, err := r.Cookie(name)
+	if err != nil {
+		return false
+	}
+	return o.verify(name, c.Value, out)
+}
+
+// clearCookie removes a cookie set by setCookie
+func (o *OIDCLogin) clearCookie(w http.ResponseWriter, r *http.Request, name string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Path:     appPath(r),
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+}
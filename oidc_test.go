@@ -0,0 +1,366 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+// mockOIDCProvider is a minimal OpenID provider supporting the
+// authorization-code flow with PKCE
+type mockOIDCProvider struct {
+	server   *httptest.Server
+	key      *rsa.PrivateKey
+	clientID string
+	claims   map[string]any // extra claims added to issued ID tokens
+
+	mu    sync.Mutex
+	codes map[string]mockAuthRequest
+}
+
+// mockAuthRequest is a pending authorization code
+type mockAuthRequest struct {
+	challenge string
+	nonce     string
+}
+
+func newMockOIDCProvider(t *testing.T, claims map[string]any) *mockOIDCProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	p := &mockOIDCProvider{key: key, clientID: "note-app", claims: claims, codes: make(map[string]mockAuthRequest)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                p.server.URL,
+			"authorization_endpoint":                p.server.URL + "/authorize",
+			"token_endpoint":                        p.server.URL + "/token",
+			"jwks_uri":                              p.server.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: &p.key.PublicKey, KeyID: "test", Algorithm: "RS256", Use: "sig"},
+		}})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("client_id") != p.clientID || q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+			http.Error(w, "invalid authorization request", http.StatusBadRequest)
+			return
+		}
+		code := rand.Text()
+		p.mu.Lock()
+		p.codes[code] = mockAuthRequest{challenge: q.Get("code_challenge"), nonce: q.Get("nonce")}
+		p.mu.Unlock()
+		http.Redirect(w, r, q.Get("redirect_uri")+"?code="+code+"&state="+url.QueryEscape(q.Get("state")), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		p.mu.Lock()
+		req, ok := p.codes[r.PostForm.Get("code")]
+		delete(p.codes, r.PostForm.Get("code"))
+		p.mu.Unlock()
+
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != req.challenge {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     p.idToken(t, req.nonce),
+		})
+	})
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+// idToken issues a signed ID token
+func (p *mockOIDCProvider) idToken(t *testing.T, nonce string) string {
+	claims := map[string]any{
+		"iss":   p.server.URL,
+		"aud":   p.clientID,
+		"sub":   "user-1",
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nonce": nonce,
+	}
+	for k, v := range p.claims {
+		claims[k] = v
+	}
+	payload, _ := json.Marshal(claims)
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: p.key},
+		(&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", "test"))
+	if err != nil {
+		t.Errorf("Failed to create signer: %v", err)
+		return ""
+	}
+	signed, err := signer.Sign(payload)
+	if err != nil {
+		t.Errorf("Failed to sign ID token: %v", err)
+		return ""
+	}
+	raw, _ := signed.CompactSerialize()
+	return raw
+}
+
+// newOIDCTestApp starts the app protected by OIDC login against provider
+func newOIDCTestApp(t *testing.T, provider *mockOIDCProvider, cfg OIDCConfig, storage Storage) (*httptest.Server, *http.Client) {
+	t.Helper()
+	cfg.Issuer = provider.server.URL
+	cfg.ClientID = provider.clientID
+	cfg.SessionSecret = "test-session-secret"
+	login, err := NewOIDCLogin(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Failed to create OIDC login: %v", err)
+	}
+	auth := NewAuthenticator("Note")
+	auth.SetOIDC(login)
+
+	mux := http.NewServeMux()
+	mux.Handle("/auth/", auth.LoginHandler())
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			HandleGet(storage)(w, r)
+		} else {
+			HandlePost(storage)(w, r)
+		}
+	})
+	app := httptest.NewServer(auth.Middleware(mux))
+	t.Cleanup(app.Close)
+
+	jar, _ := cookiejar.New(nil)
+	return app, &http.Client{Jar: jar}
+}
+
+// browserGet performs a GET request the way a browser would
+func browserGet(t *testing.T, client *http.Client, url string) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest("GET", url, nil)
+	req.Header.Set("Accept", "text/html")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("GET %s failed: %v", url, err)
+	}
+	_ = resp.Body.Close()
+	return resp
+}
+
+// TestOIDCLoginFlow tests the full login flow and owner recording
+func TestOIDCLoginFlow(t *testing.T) {
+	provider := newMockOIDCProvider(t, map[string]any{"email": "alice@example.com", "email_verified": true})
+	storage := NewMockStorage()
+	_ = storage.Write(context.Background(), "ABCDE", "hello")
+	app, client := newOIDCTestApp(t, provider, OIDCConfig{AllowedDomains: []string{"example.com"}}, storage)
+
+	// Anonymous API clients are challenged rather than redirected
+	resp, err := http.Get(app.URL + "/noteid/ABCDE")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 for anonymous API request, got %d", resp.StatusCode)
+	}
+
+	// A browser is sent through the provider and back to the note
+	resp = browserGet(t, client, app.URL+"/noteid/ABCDE?token=x")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200 after login, got %d", resp.StatusCode)
+	}
+	if resp.Request.URL.Path != "/noteid/ABCDE" || resp.Request.URL.Query().Get("token") != "x" {
+		t.Errorf("Expected to return to the original page, got %s", resp.Request.URL)
+	}
+
+	// The session identifies the owner of new notes
+	req, _ := http.NewRequest("POST", app.URL+"/", strings.NewReader(`{"content":"mine"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("POST failed: %v", err)
+	}
+	var created NoteResponse
+	_ = json.NewDecoder(resp.Body).Decode(&created)
+	_ = resp.Body.Close()
+
+	meta, _ := ReadNoteMeta(context.Background(), storage, created.NoteID)
+	if meta == nil || meta.Owner != "alice@example.com" {
+		t.Errorf("Expected note owner alice@example.com, got %#v", meta)
+	}
+
+	// Logging out ends the session
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	browserGet(t, client, app.URL+"/auth/logout")
+	resp = browserGet(t, client, app.URL+"/noteid/ABCDE")
+	if resp.StatusCode != http.StatusFound || !strings.Contains(resp.Header.Get("Location"), "/auth/login") {
+		t.Errorf("Expected redirect to login after logout, got %d %s", resp.StatusCode, resp.Header.Get("Location"))
+	}
+}
+
+// TestOIDCLoginDenied tests the allowed domain and group checks
+func TestOIDCLoginDenied(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims map[string]any
+		config OIDCConfig
+		status int
+	}{
+		{"other domain", map[string]any{"email": "eve@evil.com"}, OIDCConfig{AllowedDomains: []string{"example.com"}}, http.StatusForbidden},
+		{"unverified email", map[string]any{"email": "eve@example.com", "email_verified": false}, OIDCConfig{AllowedDomains: []string{"example.com"}}, http.StatusForbidden},
+		{"unconfirmed email", map[string]any{"email": "eve@example.com"}, OIDCConfig{AllowedDomains: []string{"example.com"}}, http.StatusForbidden},
+		{"missing group", map[string]any{"groups": []string{"sales"}}, OIDCConfig{AllowedGroups: []string{"eng"}}, http.StatusForbidden},
+		{"allowed group", map[string]any{"roles": []string{"eng"}}, OIDCConfig{AllowedGroups: []string{"eng"}, GroupsClaim: "roles"}, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		provider := newMockOIDCProvider(t, test.claims)
+		storage := NewMockStorage()
+		_ = storage.Write(context.Background(), "ABCDE", "hello")
+		app, client := newOIDCTestApp(t, provider, test.config, storage)
+
+		resp := browserGet(t, client, app.URL+"/noteid/ABCDE")
+		if resp.StatusCode != test.status {
+			t.Errorf("%s: expected status %d, got %d", test.name, test.status, resp.StatusCode)
+		}
+	}
+}
+
+// TestOIDCDisplayName tests that only verified email addresses name users
+func TestOIDCDisplayName(t *testing.T) {
+	verified, unverified := true, false
+	tests := []struct {
+		claims identityClaims
+		name   string
+	}{
+		{identityClaims{Subject: "user-1", Email: "alice@example.com", EmailVerified: &verified}, "alice@example.com"},
+		{identityClaims{Subject: "user-1", Email: "alice@example.com", EmailVerified: &unverified}, "user-1"},
+		{identityClaims{Subject: "user-1", Email: "alice@example.com"}, "user-1"},
+		{identityClaims{Subject: "user-1"}, "user-1"},
+	}
+	for _, test := range tests {
+		if name := test.claims.displayName(); name != test.name {
+			t.Errorf("%#v: expected name %q, got %q", test.claims, test.name, name)
+		}
+	}
+}
+
+// TestOIDCCallbackRejectsForgedState tests that the callback requires the login cookie and state
+func TestOIDCCallbackRejectsForgedState(t *testing.T) {
+	provider := newMockOIDCProvider(t, nil)
+	app, client := newOIDCTestApp(t, provider, OIDCConfig{}, NewMockStorage())
+
+	resp := browserGet(t, client, app.URL+"/auth/callback?code=abc&state=xyz")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status 400 without login cookie, got %d", resp.StatusCode)
+	}
+
+	// Start a login but don't follow it, then call back with a different state
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	browserGet(t, client, app.URL+"/auth/login")
+	resp = browserGet(t, client, app.URL+"/auth/callback?code=abc&state=forged")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for forged state, got %d", resp.StatusCode)
+	}
+}
+
+// TestOIDCLoginCookieReplay tests that the login cookie is not accepted as a
+// session
+func TestOIDCLoginCookieReplay(t *testing.T) {
+	provider := newMockOIDCProvider(t, nil)
+	storage := NewMockStorage()
+	_ = storage.Write(context.Background(), "ABCDE", "hello")
+	app, client := newOIDCTestApp(t, provider, OIDCConfig{}, storage)
+
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	resp := browserGet(t, client, app.URL+"/auth/login")
+	var login *http.Cookie
+	for _, c := range resp.Cookies() {
+		if c.Name == loginCookieName {
+			login = c
+		}
+	}
+	if login == nil {
+		t.Fatalf("Expected login cookie, got %v", resp.Cookies())
+	}
+
+	req, _ := http.NewRequest("GET", app.URL+"/noteid/ABCDE", nil)
+	req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: login.Value})
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 for replayed login cookie, got %d", resp.StatusCode)
+	}
+}
+
+// TestSignedCookies tests that tampered session values are rejected
+func TestSignedCookies(t *testing.T) {
+	o := &OIDCLogin{config: OIDCConfig{SessionSecret: "secret"}}
+	signed, err := o.sign(sessionCookieName, session{Subject: "u", Name: "alice", Expires: time.Now().Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatalf("Failed to sign: %v", err)
+	}
+
+	var s session
+	if !o.verify(sessionCookieName, signed, &s) || s.Name != "alice" {
+		t.Fatalf("Expected valid signature to verify, got %#v", s)
+	}
+
+	forged, _ := json.Marshal(session{Subject: "u", Name: "admin", Expires: s.Expires})
+	_, sig, _ := strings.Cut(signed, ".")
+	if o.verify(sessionCookieName, base64.RawURLEncoding.EncodeToString(forged)+"."+sig, &s) {
+		t.Errorf("Expected tampered payload to be rejected")
+	}
+
+	other := &OIDCLogin{config: OIDCConfig{SessionSecret: "other"}}
+	if other.verify(sessionCookieName, signed, &s) {
+		t.Errorf("Expected value signed with another key to be rejected")
+	}
+	if o.verify(loginCookieName, signed, &s) {
+		t.Errorf("Expected value signed for another purpose to be rejected")
+	}
+
+	// Sessions must name their user
+	for _, incomplete := range []session{{Name: "alice", Expires: s.Expires}, {Subject: "u", Expires: s.Expires}} {
+		value, _ := o.sign(sessionCookieName, incomplete)
+		req := httptest.NewRequest("GET", "/", nil)
+		req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: value})
+		if p := o.Principal(req); p != nil {
+			t.Errorf("Expected session %#v to be rejected, got %s", incomplete, p.String())
+		}
+	}
+
+	for _, path := range []string{"//evil.com/", "https://evil.com/", `/\evil.com`, "noteid/x"} {
+		if got := safeReturnPath(path, "/"); got != "/" {
+			t.Errorf("safeReturnPath(%q) = %q, expected fallback", path, got)
+		}
+	}
+}
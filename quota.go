@@ -0,0 +1,393 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultMaxNoteSize is the default maximum size of a single note
+const DefaultMaxNoteSize = 1 << 20
+
+// maxSmallBodySize bounds request bodies of the JSON endpoints other than note saves
+const maxSmallBodySize = 64 << 10
+
+// maxNoteSize is the maximum size of a single note in bytes
+var maxNoteSize int64 = DefaultMaxNoteSize
+
+// ErrQuotaExceeded is returned when a write would exceed a storage quota
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+// usageTotalKey is the storage key of the total usage record
+const usageTotalKey = ".usage.total"
+
+// usageClientPrefix starts the keys of the usage records of single clients
+const usageClientPrefix = ".usage.client."
+
+// usageReleasedPrefix starts the keys of records marking expiries whose bytes
+// were released
+const usageReleasedPrefix = ".usage.released."
+
+// ParseSize parses a byte size such as 512, 64KB, 10MB or 1GB. Units are
+// binary, so 1KB is 1024 bytes.
+func ParseSize(spec string) (int64, error) {
+	spec = strings.ToUpper(strings.TrimSpace(spec))
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		size   int64
+	}{
+		{"KIB", 1 << 10}, {"MIB", 1 << 20}, {"GIB", 1 << 30},
+		{"KB", 1 << 10}, {"MB", 1 << 20}, {"GB", 1 << 30},
+		{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30}, {"B", 1},
+	} {
+		if trimmed, ok := strings.CutSuffix(spec, unit.suffix); ok {
+			spec, multiplier = strings.TrimSpace(trimmed), unit.size
+			break
+		}
+	}
+	n, err := strconv.ParseInt(spec, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", spec)
+	}
+	return n * multiplier, nil
+}
+
+// formatSize formats a byte size for error messages
+func formatSize(n int64) string {
+	switch {
+	case n >= 1<<30 && n%(1<<30) == 0:
+		return fmt.Sprintf("%d GB", n>>30)
+	case n >= 1<<20 && n%(1<<20) == 0:
+		return fmt.Sprintf("%d MB", n>>20)
+	case n >= 1<<10 && n%(1<<10) == 0:
+		return fmt.Sprintf("%d KB", n>>10)
+	default:
+		return fmt.Sprintf("%d bytes", n)
+	}
+}
+
+// MaxNoteSizeFromConfig parses the configured maximum note size
+func MaxNoteSizeFromConfig(cfg LimitsConfig) (int64, error) {
+	size, err := ParseSize(cfg.MaxNoteSize)
+	if err != nil || size == 0 {
+		return 0, fmt.Errorf("invalid maximum note size %q", cfg.MaxNoteSize)
+	}
+	return size, nil
+}
+
+// maxRequestSize bounds the body of a note save. Form and JSON encoding can
+// inflate the content, so the body may be larger than the note itself; the
+// decoded content is checked against maxNoteSize separately.
+func maxRequestSize() int64 {
+	return 3*maxNoteSize + maxSmallBodySize
+}
+
+type quotaSubjectContextKey struct{}
+
+// withQuotaSubject returns a copy of ctx charging writes to subject
+func withQuotaSubject(ctx context.Context, subject string) context.Context {
+	return context.WithValue(ctx, quotaSubjectContextKey{}, subject)
+}
+
+// quotaSubjectFromContext returns the subject writes are charged to, if any
+func quotaSubjectFromContext(ctx context.Context) string {
+	subject, _ := ctx.Value(quotaSubjectContextKey{}).(string)
+	return subject
+}
+
+// quotaSubject returns the subject a request's writes are charged to: the
+// authenticated user, or else the client IP
+func quotaSubject(r *http.Request) string {
+	if p := PrincipalFromContext(r.Context()); p != nil {
+		return "user:" + p.Name
+	}
+	return "ip:" + rateLimitKey(ClientIP(r))
+}
+
+// QuotaStorage wraps a Storage and enforces a global quota on the total size
+// of notes and a per-subject quota on the bytes each user or client IP has
+// added. Usage is kept in internal records in the wrapped storage, so it
+// survives restarts; it is counted from when quotas were enabled. With S3 and
+// DynamoDB the records are atomic counters shared by all instances; local and
+// memory storage serve a single instance.
+type QuotaStorage struct {
+	Storage
+	totalLimit   int64      // maximum bytes of all notes; 0 means unlimited
+	subjectLimit int64      // maximum bytes added by one subject; 0 means unlimited
+	mu           sync.Mutex // serializes usage updates of storages without atomic counters
+}
+
+// usageRecord is the stored usage of the whole storage or of one subject
+type usageRecord struct {
+	Bytes int64 `json:"bytes"`
+}
+
+// NewQuotaStorage wraps storage with the given quotas
+func NewQuotaStorage(storage Storage, totalLimit int64, subjectLimit int64) *QuotaStorage {
+	return &QuotaStorage{Storage: storage, totalLimit: totalLimit, subjectLimit: subjectLimit}
+}
+
+// WithQuotas wraps storage with the configured quotas, returning it unchanged
+// when neither is set
+func WithQuotas(storage Storage, cfg LimitsConfig) (Storage, error) {
+	limits := make([]int64, 2)
+	for i, spec := range []string{cfg.QuotaTotal, cfg.QuotaPerClient} {
+		if spec != "" {
+			size, err := ParseSize(spec)
+			if err != nil {
+				return nil, fmt.Errorf("quota: %w", err)
+			}
+			limits[i] = size
+		}
+	}
+	if limits[0] == 0 && limits[1] == 0 {
+		return storage, nil
+	}
+	slog.Info("Storage quotas enabled", "total_bytes", limits[0], "per_client_bytes", limits[1])
+	return NewQuotaStorage(storage, limits[0], limits[1]), nil
+}
+
+// subjectUsageKey returns the storage key of a subject's usage record. The
+// subject is hashed to keep user names and addresses out of storage keys.
+func subjectUsageKey(subject string) string {
+	sum := sha256.Sum256([]byte(subject))
+	return usageClientPrefix + hex.EncodeToString(sum[:16])
+}
+
+// Write implements Storage
+func (q *QuotaStorage) Write(ctx context.Context, noteID string, content string) error {
+	return q.apply(ctx, noteID, int64(len(content)), true, func() error {
+		return q.Storage.Write(ctx, noteID, content)
+	})
+}
+
+// Create implements Storage
+func (q *QuotaStorage) Create(ctx context.Context, noteID string, content string) error {
+	return q.apply(ctx, noteID, int64(len(content)), false, func() error {
+		return q.Storage.Create(ctx, noteID, content)
+	})
+}
+
+// Delete implements Storage
+func (q *QuotaStorage) Delete(ctx context.Context, noteID string) error {
+	return q.apply(ctx, noteID, 0, true, func() error {
+		return q.Storage.Delete(ctx, noteID)
+	})
+}
+
+// ReadVersion implements VersionedStorage
+func (q *QuotaStorage) ReadVersion(ctx context.Context, noteID string) (string, int64, error) {
+	vs, ok := q.Storage.(VersionedStorage)
+	if !ok {
+		return "", 0, errors.ErrUnsupported
+	}
+	return vs.ReadVersion(ctx, noteID)
+}
+
+// WriteVersion implements VersionedStorage
+func (q *QuotaStorage) WriteVersion(ctx context.Context, noteID string, content string, version int64) (int64, error) {
+	vs, ok := q.Storage.(VersionedStorage)
+	if !ok {
+		return 0, errors.ErrUnsupported
+	}
+	var saved int64
+	err := q.apply(ctx, noteID, int64(len(content)), version != 0, func() (err error) {
+		saved, err = vs.WriteVersion(ctx, noteID, content, version)
+		return err
+	})
+	return saved, err
+}
+
+// ListByOwner implements OwnerIndex
+func (q *QuotaStorage) ListByOwner(ctx context.Context, owner string) ([]string, error) {
+	index, ok := q.Storage.(OwnerIndex)
+	if !ok {
+		return nil, errors.ErrUnsupported
+	}
+	return index.ListByOwner(ctx, owner)
+}
+
+// Unwrap returns the wrapped storage
+func (q *QuotaStorage) Unwrap() Storage {
+	return q.Storage
+}
+
+// Usage returns the total bytes of notes and the bytes added by subject
+func (q *QuotaStorage) Usage(ctx context.Context, subject string) (int64, int64, error) {
+	total, err := q.readUsage(ctx, usageTotalKey)
+	if err != nil || subject == "" {
+		return total, 0, err
+	}
+	used, err := q.readUsage(ctx, subjectUsageKey(subject))
+	return total, used, err
+}
+
+// apply checks the quotas for changing a note to newSize bytes, runs op and
+// records the change in usage. Growth is reserved before op runs and given
+// back if it fails, so concurrent saves cannot exceed a quota together.
+// Internal records are not counted.
+func (q *QuotaStorage) apply(ctx context.Context, noteID string, newSize int64, exists bool, op func() error) error {
+	if !ValidateNoteID(noteID) {
+		return op()
+	}
+
+	var oldSize int64
+	if exists {
+		old, err := q.Storage.Read(ctx, noteID)
+		if err != nil {
+			return err
+		}
+		oldSize = int64(len(old))
+	}
+	delta := newSize - oldSize
+	subject := quotaSubjectFromContext(ctx)
+
+	if delta > 0 {
+		if err := q.reserve(ctx, subject, delta); err != nil {
+			return err
+		}
+	}
+	if err := op(); err != nil {
+		if delta > 0 {
+			q.release(ctx, subject, delta)
+		}
+		return err
+	}
+	if delta < 0 {
+		q.release(ctx, subject, -delta)
+	}
+	return nil
+}
+
+// reserve adds bytes to the usage of subject and of the whole storage, unless
+// that exceeds a quota
+func (q *QuotaStorage) reserve(ctx context.Context, subject string, bytes int64) error {
+	if subject != "" {
+		err := q.addUsage(ctx, subjectUsageKey(subject), bytes, q.subjectLimit)
+		if errors.Is(err, ErrCounterLimit) {
+			return fmt.Errorf("%w: limit of %s per client reached", ErrQuotaExceeded, formatSize(q.subjectLimit))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	err := q.addUsage(ctx, usageTotalKey, bytes, q.totalLimit)
+	if err == nil {
+		return nil
+	}
+	if subject != "" {
+		q.releaseKey(ctx, subjectUsageKey(subject), bytes)
+	}
+	if errors.Is(err, ErrCounterLimit) {
+		return fmt.Errorf("%w: storage is full", ErrQuotaExceeded)
+	}
+	return err
+}
+
+// release takes bytes off the usage of subject and of the whole storage. The
+// notes are changed at this point, so errors are only logged.
+func (q *QuotaStorage) release(ctx context.Context, subject string, bytes int64) {
+	q.releaseKey(ctx, usageTotalKey, bytes)
+	if subject != "" {
+		q.releaseKey(ctx, subjectUsageKey(subject), bytes)
+	}
+}
+
+// releaseKey takes bytes off one usage record, logging errors
+func (q *QuotaStorage) releaseKey(ctx context.Context, key string, bytes int64) {
+	if err := q.addUsage(ctx, key, -bytes, 0); err != nil {
+		slog.ErrorContext(ctx, "Failed to record storage usage", "key", key, "error", err)
+	}
+}
+
+// NoteChanged releases the bytes of expired notes, which leave the storage
+// without a deletion passing through the quota. They are taken off the total
+// and, as if it had deleted the note, off the client that saved it last,
+// which DynamoDB records with the note.
+// Lambda retries stream records, so the release of an expiry is claimed with
+// a marker record first and only given back if the release fails.
+func (q *QuotaStorage) NoteChanged(ctx context.Context, event NoteEvent) error {
+	if event.Type != NoteDeleted || event.Source != "ttl" || event.Size == 0 {
+		return nil
+	}
+	var marker string
+	if event.ID != "" {
+		marker = usageReleasedPrefix + event.ID
+		err := q.Storage.Create(ctx, marker, "1")
+		if errors.Is(err, ErrNoteExists) {
+			slog.DebugContext(ctx, "Usage of expired note already released", "note", event.NoteID)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := q.addUsage(ctx, usageTotalKey, -event.Size, 0); err != nil {
+		if marker != "" {
+			_ = q.Storage.Delete(ctx, marker)
+		}
+		return err
+	}
+	if strings.HasPrefix(event.UsageKey, usageClientPrefix) {
+		q.releaseKey(ctx, event.UsageKey, event.Size)
+	}
+	return nil
+}
+
+// addUsage adds delta to a usage record, refusing growth over limit (0 for
+// none) with ErrCounterLimit. Storages with atomic counters, such as S3 and
+// DynamoDB, keep usage exact across instances. Others fall back to a
+// read-modify-write under a mutex, which is only exact for a single instance.
+func (q *QuotaStorage) addUsage(ctx context.Context, key string, delta int64, limit int64) error {
+	if counters, ok := counterStorage(q.Storage); ok {
+		_, err := counters.AddCounter(ctx, key, delta, limit)
+		return err
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	used, err := q.readUsage(ctx, key)
+	if err != nil {
+		return err
+	}
+	if delta > 0 && limit > 0 && used+delta > limit {
+		return ErrCounterLimit
+	}
+	data, _ := json.Marshal(usageRecord{Bytes: max(used+delta, 0)})
+	return q.Storage.Write(ctx, key, string(data))
+}
+
+// readUsage loads a usage record, returning 0 if it does not exist
+func (q *QuotaStorage) readUsage(ctx context.Context, key string) (int64, error) {
+	if counters, ok := counterStorage(q.Storage); ok {
+		return counters.ReadCounter(ctx, key)
+	}
+	data, err := q.Storage.Read(ctx, key)
+	if err != nil || data == "" {
+		return 0, err
+	}
+	var record usageRecord
+	if err := json.Unmarshal([]byte(data), &record); err != nil {
+		slog.WarnContext(ctx, "Ignoring corrupt usage record", "key", key, "error", err)
+		return 0, nil
+	}
+	return record.Bytes, nil
+}
+
+// writeSaveError writes the JSON error response for a failed note save,
+// reporting exceeded quotas with 507
+func writeSaveError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrQuotaExceeded) {
+		writeJSONError(w, http.StatusInsufficientStorage, "Storage quota exceeded: "+strings.TrimPrefix(err.Error(), ErrQuotaExceeded.Error()+": "))
+		return
+	}
+	writeJSONError(w, http.StatusInternalServerError, "Failed to save note")
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestParseSize tests parsing of byte sizes
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		spec  string
+		size  int64
+		valid bool
+	}{
+		{"512", 512, true},
+		{"512B", 512, true},
+		{"64KB", 64 << 10, true},
+		{"64k", 64 << 10, true},
+		{"10 MB", 10 << 20, true},
+		{"1MiB", 1 << 20, true},
+		{"2G", 2 << 30, true},
+		{"", 0, false},
+		{"MB", 0, false},
+		{"-1MB", 0, false},
+		{"1TB", 0, false},
+	}
+
+	for _, test := range tests {
+		size, err := ParseSize(test.spec)
+		if (err == nil) != test.valid || size != test.size {
+			t.Errorf("ParseSize(%q) = %d, %v; expected %d, valid=%v", test.spec, size, err, test.size, test.valid)
+		}
+	}
+}
+
+// TestQuotaStorage tests global and per-client quotas and usage accounting
+func TestQuotaStorage(t *testing.T) {
+	storage := NewQuotaStorage(NewMockStorage(), 30, 20)
+	alice := withQuotaSubject(context.Background(), "ip:203.0.113.5")
+	bob := withQuotaSubject(context.Background(), "ip:203.0.113.6")
+
+	if err := storage.Create(alice, "note1", strings.Repeat("a", 15)); err != nil {
+		t.Fatalf("Expected create within quota to succeed: %v", err)
+	}
+	err := storage.Write(alice, "note2", strings.Repeat("a", 10))
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Expected per-client quota to be exceeded, got %v", err)
+	}
+	if content, _ := storage.Read(alice, "note2"); content != "" {
+		t.Errorf("Expected rejected note not to be stored")
+	}
+
+	// Shrinking a note frees quota; internal records are not counted
+	if err := storage.Write(alice, "note1", strings.Repeat("a", 5)); err != nil {
+		t.Fatalf("Expected shrinking write to succeed: %v", err)
+	}
+	if err := storage.Write(alice, "note1.meta", strings.Repeat("m", 100)); err != nil {
+		t.Errorf("Expected internal record to bypass quotas: %v", err)
+	}
+	if err := storage.Write(alice, "note2", strings.Repeat("a", 15)); err != nil {
+		t.Errorf("Expected write within freed quota to succeed: %v", err)
+	}
+
+	// The global quota applies across clients
+	if err := storage.Create(bob, "note3", strings.Repeat("b", 15)); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Expected global quota to be exceeded, got %v", err)
+	}
+	if err := storage.Delete(alice, "note2"); err != nil {
+		t.Fatalf("Failed to delete note: %v", err)
+	}
+	if err := storage.Create(bob, "note3", strings.Repeat("b", 15)); err != nil {
+		t.Errorf("Expected create after delete to succeed: %v", err)
+	}
+
+	total, used, err := storage.Usage(context.Background(), "ip:203.0.113.5")
+	if err != nil || total != 20 || used != 5 {
+		t.Errorf("Expected usage 20 total and 5 for client, got %d %d %v", total, used, err)
+	}
+}
+
+// TestQuotaExpiredNotes tests that expired notes free the quota, once
+func TestQuotaExpiredNotes(t *testing.T) {
+	storage := NewQuotaStorage(NewMockStorage(), 30, 0)
+	ctx := withQuotaSubject(context.Background(), "ip:203.0.113.5")
+	if err := storage.Create(ctx, "note1", strings.Repeat("a", 25)); err != nil {
+		t.Fatalf("Expected create within quota to succeed: %v", err)
+	}
+
+	// Expiry removes the note without the quota seeing a deletion
+	_ = storage.Storage.Delete(ctx, "note1")
+	events := []NoteEvent{
+		{Type: NoteDeleted, NoteID: "note1", Size: 25, Source: "s3"},
+		{Type: NoteUpdated, NoteID: "note1", Size: 25, Source: "ttl"},
+	}
+	for _, event := range events {
+		_ = storage.NoteChanged(ctx, event)
+	}
+	if total, _, _ := storage.Usage(ctx, ""); total != 25 {
+		t.Errorf("Expected only expiries to free quota, got %d", total)
+	}
+
+	// A retried stream record does not release the bytes again
+	_ = storage.Create(ctx, "note2", "bbbbb")
+	expiry := NoteEvent{Type: NoteDeleted, NoteID: "note1", Size: 25, Source: "ttl", ID: "c81e728d", UsageKey: subjectUsageKey("ip:203.0.113.5")}
+	for range 2 {
+		if err := storage.NoteChanged(ctx, expiry); err != nil {
+			t.Fatalf("NoteChanged failed: %v", err)
+		}
+	}
+	if total, used, _ := storage.Usage(ctx, "ip:203.0.113.5"); total != 5 || used != 5 {
+		t.Errorf("Expected expiry to be released once from the total and the client, got %d and %d", total, used)
+	}
+	if err := storage.Create(ctx, "note3", strings.Repeat("c", 25)); err != nil {
+		t.Errorf("Expected create after expiry to succeed: %v", err)
+	}
+}
+
+// counterMemoryStorage adds atomic counters to MemoryStorage, as S3 and
+// DynamoDB have
+type counterMemoryStorage struct {
+	*MemoryStorage
+	mu       sync.Mutex
+	counters map[string]int64
+}
+
+func (cs *counterMemoryStorage) AddCounter(ctx context.Context, key string, delta int64, limit int64) (int64, error) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if delta > 0 && limit > 0 && cs.counters[key]+delta > limit {
+		return cs.counters[key], ErrCounterLimit
+	}
+	cs.counters[key] = max(cs.counters[key]+delta, 0)
+	return cs.counters[key], nil
+}
+
+func (cs *counterMemoryStorage) ReadCounter(ctx context.Context, key string) (int64, error) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	return cs.counters[key], nil
+}
+
+// TestQuotaConcurrentSaves tests that concurrent saves reserve usage before
+// saving, so together they never exceed a quota, and that failed saves give
+// their reservation back
+func TestQuotaConcurrentSaves(t *testing.T) {
+	counters := &counterMemoryStorage{MemoryStorage: NewMemoryStorage(), counters: map[string]int64{}}
+	storage := NewQuotaStorage(counters, 100, 0)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	saved := 0
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx := withQuotaSubject(context.Background(), fmt.Sprintf("ip:203.0.113.%d", i))
+			if err := storage.Create(ctx, fmt.Sprintf("note%d", i), strings.Repeat("a", 10)); err == nil {
+				mu.Lock()
+				saved++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if total, _, _ := storage.Usage(context.Background(), ""); saved != 10 || total != 100 {
+		t.Errorf("Expected 10 saves using 100 bytes, got %d using %d", saved, total)
+	}
+
+	// Usage is kept in the counters rather than in records
+	if record, _ := counters.Read(context.Background(), usageTotalKey); record != "" {
+		t.Errorf("Expected no usage record, got %q", record)
+	}
+	ctx := context.Background()
+	for i := range 20 {
+		_ = storage.Delete(ctx, fmt.Sprintf("note%d", i))
+	}
+	if err := storage.Create(ctx, "note0", strings.Repeat("a", 10)); err != nil {
+		t.Fatalf("Expected create after deletions to succeed: %v", err)
+	}
+	if err := storage.Create(ctx, "note0", strings.Repeat("b", 10)); !errors.Is(err, ErrNoteExists) {
+		t.Fatalf("Expected ErrNoteExists, got %v", err)
+	}
+	if total, _, _ := storage.Usage(ctx, ""); total != 10 {
+		t.Errorf("Expected failed create to give its reservation back, got %d", total)
+	}
+}
+
+// TestHandlePostSizeLimits tests 413 for oversize notes and 507 for exceeded quotas
+func TestHandlePostSizeLimits(t *testing.T) {
+	defer func(size int64) { maxNoteSize = size }(maxNoteSize)
+	maxNoteSize = 100
+
+	storage := NewQuotaStorage(NewMockStorage(), 0, 150)
+	rec, _ := postNote(t, storage, NoteRequest{Content: strings.Repeat("x", 101)})
+	if rec.Code != http.StatusRequestEntityTooLarge || !strings.Contains(rec.Body.String(), "Note too large (max 100 bytes)") {
+		t.Errorf("Expected 413 for note over the size limit, got %d %s", rec.Code, rec.Body.String())
+	}
+	rec, _ = postNote(t, storage, NoteRequest{Content: strings.Repeat("x", 1000)})
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413 for body over the request limit, got %d", rec.Code)
+	}
+
+	rec, created := postNote(t, storage, NoteRequest{Content: strings.Repeat("x", 100)})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected note within limits to be saved, got %d", rec.Code)
+	}
+	rec, _ = postNote(t, storage, NoteRequest{Content: strings.Repeat("y", 60)})
+	if rec.Code != http.StatusInsufficientStorage || !strings.Contains(rec.Body.String(), "Storage quota exceeded") {
+		t.Errorf("Expected 507 when the client quota is used up, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	// Replacing content within the quota still works
+	rec, _ = postNote(t, storage, NoteRequest{NoteID: created.NoteID, Content: strings.Repeat("z", 100), EditToken: created.EditToken})
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected same-size update to succeed, got %d", rec.Code)
+	}
+}
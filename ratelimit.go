@@ -0,0 +1,291 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default rate limits per client IP
+const (
+	DefaultReadLimit      = "600/m"
+	DefaultWriteLimit     = "120/m"
+	DefaultCreateLimit    = "30/m"
+	DefaultMaxRateClients = 10000
+)
+
+// RateLimit is a token-bucket budget: Burst requests at once, refilled at Rate per second
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// ParseRateLimit parses a budget of the form N/unit (e.g. 60/m), where unit is
+// s, m or h. The full N requests may be used in one burst. "0" or "off" means
+// unlimited and returns a zero RateLimit.
+func ParseRateLimit(spec string) (RateLimit, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "0" || strings.EqualFold(spec, "off") {
+		return RateLimit{}, nil
+	}
+	count, unit, ok := strings.Cut(spec, "/")
+	n, err := strconv.Atoi(count)
+	if !ok || err != nil || n <= 0 {
+		return RateLimit{}, fmt.Errorf("invalid rate limit %q (expected e.g. 60/m)", spec)
+	}
+	var period time.Duration
+	switch unit {
+	case "s":
+		period = time.Second
+	case "m":
+		period = time.Minute
+	case "h":
+		period = time.Hour
+	default:
+		return RateLimit{}, fmt.Errorf("invalid rate limit unit %q (use s, m or h)", unit)
+	}
+	return RateLimit{Rate: float64(n) / period.Seconds(), Burst: n}, nil
+}
+
+// unlimited reports whether the budget is disabled
+func (l RateLimit) unlimited() bool {
+	return l.Burst <= 0
+}
+
+// RateLimitStore keeps token buckets by key
+type RateLimitStore interface {
+	// Take removes one token from the bucket of key. When the bucket is empty
+	// it returns false and how long until a token is available.
+	Take(key string, limit RateLimit, now time.Time) (bool, time.Duration)
+}
+
+// tokenBucket is the state of one client's budget
+type tokenBucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+}
+
+// MemoryRateLimitStore keeps token buckets in memory, evicting the least
+// recently used bucket once maxKeys is reached. An evicted client starts again
+// with a full bucket, so memory stays bounded at the cost of some leniency
+// under very high client counts.
+type MemoryRateLimitStore struct {
+	mu      sync.Mutex
+	maxKeys int
+	buckets map[string]*list.Element
+	lru     *list.List // front is most recently used
+}
+
+// NewMemoryRateLimitStore creates a store holding at most maxKeys buckets
+func NewMemoryRateLimitStore(maxKeys int) *MemoryRateLimitStore {
+	if maxKeys <= 0 {
+		maxKeys = DefaultMaxRateClients
+	}
+	return &MemoryRateLimitStore{
+		maxKeys: maxKeys,
+		buckets: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// Take implements RateLimitStore
+func (s *MemoryRateLimitStore) Take(key string, limit RateLimit, now time.Time) (bool, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var b *tokenBucket
+	if elem, ok := s.buckets[key]; ok {
+		s.lru.MoveToFront(elem)
+		b = elem.Value.(*tokenBucket)
+		elapsed := now.Sub(b.last).Seconds()
+		if elapsed > 0 {
+			b.tokens = math.Min(float64(limit.Burst), b.tokens+elapsed*limit.Rate)
+			b.last = now
+		}
+	} else {
+		if s.lru.Len() >= s.maxKeys {
+			oldest := s.lru.Back()
+			s.lru.Remove(oldest)
+			delete(s.buckets, oldest.Value.(*tokenBucket).key)
+		}
+		b = &tokenBucket{key: key, tokens: float64(limit.Burst), last: now}
+		s.buckets[key] = s.lru.PushFront(b)
+	}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+	return false, wait
+}
+
+// Len returns the number of tracked buckets
+func (s *MemoryRateLimitStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lru.Len()
+}
+
+// RateLimiter throttles clients by IP with separate budgets for reads, writes
+// and the creation of new notes
+type RateLimiter struct {
+	store     RateLimitStore
+	read      RateLimit
+	write     RateLimit
+	create    RateLimit
+	allowlist []netip.Prefix
+	now       func() time.Time
+}
+
+// globalRateLimiter throttles all routes; nil disables rate limiting
+var globalRateLimiter *RateLimiter
+
+// NewRateLimiter creates a rate limiter backed by store
+func NewRateLimiter(store RateLimitStore, read, write, create RateLimit, allowlist []netip.Prefix) *RateLimiter {
+	return &RateLimiter{
+		store:     store,
+		read:      read,
+		write:     write,
+		create:    create,
+		allowlist: allowlist,
+		now:       time.Now,
+	}
+}
+
+// NewRateLimiterFromConfig builds the rate limiter with an in-memory store.
+// It returns nil when rate limiting is disabled.
+func NewRateLimiterFromConfig(cfg RateLimitConfig) (*RateLimiter, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	limits := make([]RateLimit, 3)
+	for i, spec := range []string{cfg.Read, cfg.Write, cfg.Create} {
+		limit, err := ParseRateLimit(spec)
+		if err != nil {
+			return nil, err
+		}
+		limits[i] = limit
+	}
+
+	allowlist, err := ParsePrefixes(strings.Join(cfg.Allowlist, ","))
+	if err != nil {
+		return nil, fmt.Errorf("rate limit allowlist: %w", err)
+	}
+	if cfg.MaxClients <= 0 {
+		return nil, fmt.Errorf("invalid number of rate limited clients %d", cfg.MaxClients)
+	}
+
+	slog.Info("Rate limiting enabled", "allowlisted_ranges", len(allowlist), "max_clients", cfg.MaxClients)
+	return NewRateLimiter(NewMemoryRateLimitStore(cfg.MaxClients), limits[0], limits[1], limits[2], allowlist), nil
+}
+
+// ParsePrefixes parses a comma-separated list of CIDRs or single IP addresses
+func ParsePrefixes(spec string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, item := range splitList(spec) {
+		if !strings.Contains(item, "/") {
+			addr, err := netip.ParseAddr(item)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address %q", item)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", item)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// prefixesContain reports whether ip lies in one of prefixes
+func prefixesContain(prefixes []netip.Prefix, ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// rateLimitKey returns the bucket key of a client IP. IPv6 clients are grouped
+// by /64, since a single host can typically use any address in its subnet.
+func rateLimitKey(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil || addr.Unmap().Is4() {
+		return ip
+	}
+	prefix, err := addr.WithZone("").Prefix(64)
+	if err != nil {
+		return ip
+	}
+	return prefix.String()
+}
+
+// take charges one request against a budget and reports whether it is allowed
+func (l *RateLimiter) take(kind string, limit RateLimit, r *http.Request) (bool, time.Duration) {
+	if l == nil || limit.unlimited() {
+		return true, 0
+	}
+	clientIP := ClientIP(r)
+	if prefixesContain(l.allowlist, clientIP) {
+		return true, 0
+	}
+	ok, wait := l.store.Take(kind+":"+rateLimitKey(clientIP), limit, l.now())
+	if !ok {
+		slog.WarnContext(r.Context(), "Rate limit budget exhausted", "budget", kind, "ip", clientIP, "method", r.Method, "path", r.URL.Path)
+	}
+	return ok, wait
+}
+
+// AllowCreate charges the creation of a new note to the client's create budget
+func (l *RateLimiter) AllowCreate(r *http.Request) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	return l.take("create", l.create, r)
+}
+
+// Middleware charges every request to the client's read or write budget and
+// rejects it with 429 once the budget is exhausted. A nil RateLimiter passes
+// every request through unchanged.
+func (l *RateLimiter) Middleware(next http.Handler) http.Handler {
+	if l == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		kind, limit := "write", l.write
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			kind, limit = "read", l.read
+		}
+		if ok, wait := l.take(kind, limit, r); !ok {
+			setRetryAfter(w, wait)
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// setRetryAfter sets the Retry-After header, rounding up to whole seconds
+func setRetryAfter(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestParseRateLimit tests parsing of rate limit budgets
+func TestParseRateLimit(t *testing.T) {
+	tests := []struct {
+		spec  string
+		limit RateLimit
+		valid bool
+	}{
+		{"60/m", RateLimit{Rate: 1, Burst: 60}, true},
+		{"10/s", RateLimit{Rate: 10, Burst: 10}, true},
+		{"3600/h", RateLimit{Rate: 1, Burst: 3600}, true},
+		{"off", RateLimit{}, true},
+		{"0", RateLimit{}, true},
+		{"60", RateLimit{}, false},
+		{"-1/m", RateLimit{}, false},
+		{"10/d", RateLimit{}, false},
+	}
+
+	for _, test := range tests {
+		limit, err := ParseRateLimit(test.spec)
+		if (err == nil) != test.valid || limit != test.limit {
+			t.Errorf("ParseRateLimit(%s) = %v, %v; expected %v, valid=%v", test.spec, limit, err, test.limit, test.valid)
+		}
+	}
+}
+
+// TestMemoryRateLimitStore tests token refill and bounded memory
+func TestMemoryRateLimitStore(t *testing.T) {
+	store := NewMemoryRateLimitStore(2)
+	limit := RateLimit{Rate: 1, Burst: 2}
+	now := time.Unix(1000, 0)
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := store.Take("a", limit, now); !ok {
+			t.Fatalf("Expected request %d within burst to pass", i+1)
+		}
+	}
+	ok, wait := store.Take("a", limit, now)
+	if ok || wait != time.Second {
+		t.Errorf("Expected exhausted bucket with 1s wait, got %v %v", ok, wait)
+	}
+	if ok, _ := store.Take("a", limit, now.Add(time.Second)); !ok {
+		t.Errorf("Expected a token to be refilled after 1s")
+	}
+
+	store.Take("b", limit, now)
+	store.Take("c", limit, now)
+	if store.Len() != 2 {
+		t.Errorf("Expected store to hold at most 2 buckets, got %d", store.Len())
+	}
+	// "a" was least recently used and got evicted, so it starts with a full bucket
+	if ok, _ := store.Take("a", limit, now.Add(time.Second)); !ok {
+		t.Errorf("Expected evicted client to start with a full bucket")
+	}
+}
+
+// TestRateLimiterMiddleware tests per-IP budgets, 429 responses and the allowlist
+func TestRateLimiterMiddleware(t *testing.T) {
+	allowlist, err := ParsePrefixes("10.0.0.0/8, 192.0.2.1")
+	if err != nil {
+		t.Fatalf("Failed to parse allowlist: %v", err)
+	}
+	limiter := NewRateLimiter(NewMemoryRateLimitStore(0),
+		RateLimit{Rate: 1, Burst: 3}, RateLimit{Rate: 0.5, Burst: 1}, RateLimit{}, allowlist)
+	now := time.Unix(1000, 0)
+	limiter.now = func() time.Time { return now }
+	handler := limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	do := func(method string, remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/", nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i := 0; i < 3; i++ {
+		if rec := do("GET", "203.0.113.5:1234"); rec.Code != http.StatusOK {
+			t.Fatalf("Expected read %d to pass, got %d", i+1, rec.Code)
+		}
+	}
+	rec := do("GET", "203.0.113.5:1234")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "1" {
+		t.Errorf("Expected 429 with Retry-After 1, got %d %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	// Writes have their own budget and other clients are unaffected
+	if rec := do("POST", "203.0.113.5:1234"); rec.Code != http.StatusOK {
+		t.Errorf("Expected write to use a separate budget, got %d", rec.Code)
+	}
+	rec = do("POST", "203.0.113.5:1234")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "2" {
+		t.Errorf("Expected 429 with Retry-After 2, got %d %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec := do("GET", "203.0.113.6:1234"); rec.Code != http.StatusOK {
+		t.Errorf("Expected another client to have its own budget, got %d", rec.Code)
+	}
+
+	// IPv6 clients share a budget per /64
+	for i := 0; i < 3; i++ {
+		do("GET", "[2001:db8::"+strconv.Itoa(i+1)+"]:1234")
+	}
+	if rec := do("GET", "[2001:db8::ff]:1234"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected IPv6 /64 to share a budget, got %d", rec.Code)
+	}
+
+	// Allowlisted clients are never throttled
+	for i := 0; i < 10; i++ {
+		for _, addr := range []string{"10.1.2.3:1234", "192.0.2.1:1234"} {
+			if rec := do("POST", addr); rec.Code != http.StatusOK {
+				t.Fatalf("Expected allowlisted client %s to pass, got %d", addr, rec.Code)
+			}
+		}
+	}
+}
+
+// TestHandlePostCreateLimit tests the separate budget for new notes
+func TestHandlePostCreateLimit(t *testing.T) {
+	globalRateLimiter = NewRateLimiter(NewMemoryRateLimitStore(0), RateLimit{}, RateLimit{}, RateLimit{Rate: 0.1, Burst: 1}, nil)
+	defer func() { globalRateLimiter = nil }()
+
+	storage := NewMockStorage()
+	rec, created := postNote(t, storage, NoteRequest{Content: "first"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected first note to be created, got %d", rec.Code)
+	}
+
+	rec, _ = postNote(t, storage, NoteRequest{Content: "second"})
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "10" {
+		t.Errorf("Expected 429 with Retry-After 10 for second note, got %d %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if !strings.Contains(rec.Body.String(), "Too many new notes") {
+		t.Errorf("Expected JSON error message, got %s", rec.Body.String())
+	}
+
+	// Updating an existing note is not a creation
+	rec, _ = postNote(t, storage, NoteRequest{NoteID: created.NoteID, Content: "edit", EditToken: created.EditToken})
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected update to be allowed, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+)
+
+// RouterConfig holds the parts of the router that differ between runtimes
+type RouterConfig struct {
+	StorageType string       // backend reported by /version, e.g. "local" or "s3"
+	Metrics     http.Handler // serves /metrics; nil leaves the route out
+}
+
+// NewRouter returns all routes of the app wrapped in request IDs, tracing,
+// metrics, rate limiting and authentication. The HTTP server and Lambda
+// serve requests through the same router.
+func NewRouter(storage Storage, cfg RouterConfig) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/favicon.ico", serveFavicon)
+	mux.HandleFunc("/healthz", HandleHealth)
+	mux.HandleFunc("/readyz", HandleReady(storage))
+	mux.HandleFunc("/version", HandleVersion(cfg.StorageType))
+	mux.HandleFunc("/alias", HandleAlias(storage))
+	mux.HandleFunc("/acl", HandleACL(storage))
+	mux.HandleFunc("/ns/", HandleList(storage))
+	mux.HandleFunc("/mine", HandleMine(storage))
+	mux.HandleFunc("/r/", HandleReadOnly(storage))
+	mux.HandleFunc("/webhooks", HandleWebhooks)
+	mux.Handle("/auth/", globalAuth.LoginHandler())
+	if cfg.Metrics != nil {
+		mux.Handle("/metrics", cfg.Metrics)
+	}
+	mux.HandleFunc("/", handleNote(storage))
+	return withMiddleware(mux)
+}
+
+// handleNote serves the editor for GET and HEAD and saves notes for POST,
+// answering CORS preflight requests with the save handler
+func handleNote(storage Storage) http.HandlerFunc {
+	get, post := HandleGet(storage), HandlePost(storage)
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			get(w, r)
+		case http.MethodPost, http.MethodOptions:
+			post(w, r)
+		default:
+			w.Header().Set("Allow", "GET, HEAD, POST, OPTIONS")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// withMiddleware wraps the routes of mux in request IDs, tracing, metrics,
+// rate limiting and authentication, outermost first
+func withMiddleware(mux *http.ServeMux) http.Handler {
+	return RequestIDMiddleware(TracingMiddleware(mux, MetricsMiddleware(globalMetrics, mux,
+		globalRateLimiter.Middleware(globalAuth.Middleware(mux)))))
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// TestRouterMethods tests the method handling of the editor route
+func TestRouterMethods(t *testing.T) {
+	router := NewRouter(NewMockStorage(), RouterConfig{StorageType: "local"})
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+	}{
+		{"GET", "/", http.StatusOK},
+		{"HEAD", "/", http.StatusOK},
+		{"OPTIONS", "/", http.StatusOK},
+		{"DELETE", "/noteid/ABCDE", http.StatusMethodNotAllowed},
+		{"PUT", "/", http.StatusMethodNotAllowed},
+		{"GET", "/favicon.ico", http.StatusOK},
+		{"GET", "/metrics", http.StatusOK}, // served by the editor route without a metrics handler
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(test.method, test.path, nil))
+		if rec.Code != test.status {
+			t.Errorf("%s %s: expected status %d, got %d", test.method, test.path, test.status, rec.Code)
+		}
+		if rec.Code == http.StatusMethodNotAllowed && rec.Header().Get("Allow") != "GET, HEAD, POST, OPTIONS" {
+			t.Errorf("%s %s: expected Allow header, got %q", test.method, test.path, rec.Header().Get("Allow"))
+		}
+	}
+
+	metrics := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	rec := httptest.NewRecorder()
+	NewRouter(NewMockStorage(), RouterConfig{Metrics: metrics}).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Code != http.StatusTeapot {
+		t.Errorf("Expected /metrics to be served by the metrics handler, got %d", rec.Code)
+	}
+}
+
+// TestLambdaRouter tests that Lambda events are served with the same routes
+// and method handling as the HTTP server
+func TestLambdaRouter(t *testing.T) {
+	lambdaRouter = NewRouter(NewMockStorage(), RouterConfig{StorageType: "s3"})
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+	}{
+		{"GET", "/favicon.ico", http.StatusOK},
+		{"GET", "/version", http.StatusOK},
+		{"OPTIONS", "/", http.StatusOK},
+		{"DELETE", "/noteid/ABCDE", http.StatusMethodNotAllowed},
+		{"HEAD", "/healthz", http.StatusOK},
+	}
+	for _, test := range tests {
+		v2 := events.APIGatewayV2HTTPRequest{RawPath: test.path}
+		v2.RequestContext.HTTP.Method = test.method
+		resp2, err := handleAPIGatewayV2(context.Background(), v2)
+		if err != nil || resp2.StatusCode != test.status {
+			t.Errorf("v2 %s %s: expected status %d, got %d %v", test.method, test.path, test.status, resp2.StatusCode, err)
+		}
+		if test.method == "HEAD" && resp2.Body != "" {
+			t.Errorf("v2 HEAD %s: expected empty body, got %q", test.path, resp2.Body)
+		}
+
+		v1 := events.APIGatewayProxyRequest{HTTPMethod: test.method, Path: test.path}
+		resp1, err := handleAPIGatewayV1(context.Background(), v1)
+		if err != nil || resp1.StatusCode != test.status {
+			t.Errorf("v1 %s %s: expected status %d, got %d %v", test.method, test.path, test.status, resp1.StatusCode, err)
+		}
+	}
+}
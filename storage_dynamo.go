@@ -0,0 +1,492 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// DynamoDB table layout: items are keyed by the storage key in "id". The
+// namespace index lists the entries of a namespace, the owner index lists
+// the notes of an owner, newest first.
+const (
+	dynamoNamespaceIndex = "namespace-index" // ns (hash), name (range)
+	dynamoOwnerIndex     = "owner-index"     // owner (hash), updated (range)
+	dynamoRootNamespace  = "/"               // ns of root entries; key attributes cannot be empty
+)
+
+// maxDynamoContent is the largest note kept in a DynamoDB item, leaving room
+// for the other attributes within the 400 KB item limit. Larger notes are
+// offloaded to S3.
+const maxDynamoContent = 350 * 1024
+
+// DynamoStorage implements Storage using a DynamoDB table. Every save is a
+// conditional update that increments the item's version, so concurrent
+// editors can be detected with ReadVersion and WriteVersion. With a TTL,
+// notes expire after their last save using DynamoDB's native TTL on the
+// "expires" attribute.
+//
+// Namespaces are recorded as marker items ("infra/" in the root namespace)
+// when their first note is saved, so they can be listed without scanning.
+type DynamoStorage struct {
+	client  *dynamodb.Client
+	table   string
+	ttl     time.Duration
+	offload *S3Storage // stores notes over maxDynamoContent; nil rejects them
+	now     func() time.Time
+}
+
+// NewDynamoStorage creates a new DynamoStorage instance. A ttl of 0 keeps
+// notes forever; offload may be nil.
+func NewDynamoStorage(client *dynamodb.Client, table string, ttl time.Duration, offload *S3Storage) *DynamoStorage {
+	return &DynamoStorage{
+		client:  client,
+		table:   table,
+		ttl:     ttl,
+		offload: offload,
+		now:     time.Now,
+	}
+}
+
+// splitKey splits a storage key into its namespace and name
+func splitKey(key string) (string, string) {
+	i := strings.LastIndex(key, "/")
+	if i == -1 {
+		return dynamoRootNamespace, key
+	}
+	return key[:i], key[i+1:]
+}
+
+// dynamoExpression collects the attribute names and values of an expression,
+// as DynamoDB rejects unused ones
+type dynamoExpression struct {
+	names  map[string]string
+	values map[string]types.AttributeValue
+}
+
+func newDynamoExpression() *dynamoExpression {
+	return &dynamoExpression{names: map[string]string{}, values: map[string]types.AttributeValue{}}
+}
+
+// name returns the placeholder of an attribute name; names such as "name"
+// and "size" are reserved words
+func (e *dynamoExpression) name(attr string) string {
+	e.names["#"+attr] = attr
+	return "#" + attr
+}
+
+// value returns the placeholder of a value
+func (e *dynamoExpression) value(placeholder string, v types.AttributeValue) string {
+	e.values[":"+placeholder] = v
+	return ":" + placeholder
+}
+
+func (e *dynamoExpression) attributeValues() map[string]types.AttributeValue {
+	if len(e.values) == 0 {
+		return nil
+	}
+	return e.values
+}
+
+func dynamoString(s string) types.AttributeValue {
+	return &types.AttributeValueMemberS{Value: s}
+}
+
+func dynamoNumber(n int64) types.AttributeValue {
+	return &types.AttributeValueMemberN{Value: strconv.FormatInt(n, 10)}
+}
+
+// stringAttr returns a string attribute of an item
+func stringAttr(item map[string]types.AttributeValue, attr string) string {
+	if v, ok := item[attr].(*types.AttributeValueMemberS); ok {
+		return v.Value
+	}
+	return ""
+}
+
+// numberAttr returns a number attribute of an item
+func numberAttr(item map[string]types.AttributeValue, attr string) int64 {
+	if v, ok := item[attr].(*types.AttributeValueMemberN); ok {
+		n, _ := strconv.ParseInt(v.Value, 10, 64)
+		return n
+	}
+	return 0
+}
+
+// expired reports whether an item's TTL passed. DynamoDB deletes expired
+// items only within a few days, so they are hidden until then.
+func (ds *DynamoStorage) expired(item map[string]types.AttributeValue) bool {
+	expires := numberAttr(item, "expires")
+	return expires != 0 && expires <= ds.now().Unix()
+}
+
+func (ds *DynamoStorage) key(key string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{"id": dynamoString(key)}
+}
+
+// Read retrieves note content from DynamoDB, or from S3 if it was offloaded
+func (ds *DynamoStorage) Read(ctx context.Context, noteID string) (string, error) {
+	content, _, err := ds.ReadVersion(ctx, noteID)
+	return content, err
+}
+
+// ReadVersion retrieves note content with its version, which is 0 for a
+// missing note
+func (ds *DynamoStorage) ReadVersion(ctx context.Context, noteID string) (string, int64, error) {
+	out, err := ds.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(ds.table),
+		Key:            ds.key(noteID),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to read note from DynamoDB: %w", err)
+	}
+	if out.Item == nil || ds.expired(out.Item) {
+		return "", 0, nil
+	}
+	version := numberAttr(out.Item, "version")
+	offloaded := stringAttr(out.Item, "offloaded")
+	if offloaded == "" {
+		return stringAttr(out.Item, "content"), version, nil
+	}
+	if ds.offload == nil {
+		return "", 0, fmt.Errorf("note %s is stored in S3, but no S3 bucket is configured", noteID)
+	}
+	content, err := ds.offload.Read(ctx, offloaded)
+	return content, version, err
+}
+
+// Write saves note content
+func (ds *DynamoStorage) Write(ctx context.Context, noteID string, content string) error {
+	_, err := ds.save(ctx, noteID, content, false, 0)
+	return err
+}
+
+// Create saves note content only if the note does not exist or has expired
+func (ds *DynamoStorage) Create(ctx context.Context, noteID string, content string) error {
+	_, err := ds.save(ctx, noteID, content, true, 0)
+	if errors.Is(err, ErrVersionConflict) {
+		return ErrNoteExists
+	}
+	return err
+}
+
+// WriteVersion saves note content only if the note is still at version, as
+// returned by ReadVersion, and returns its new version or ErrVersionConflict
+func (ds *DynamoStorage) WriteVersion(ctx context.Context, noteID string, content string, version int64) (int64, error) {
+	return ds.save(ctx, noteID, content, version == 0, version)
+}
+
+// save updates the item of a note and returns its new version. With create,
+// the note must be absent or expired, and it becomes the signed-in user's;
+// otherwise a version other than 0 must match. A failed condition is
+// returned as ErrVersionConflict.
+func (ds *DynamoStorage) save(ctx context.Context, noteID string, content string, create bool, version int64) (int64, error) {
+	e := newDynamoExpression()
+	now := ds.now()
+	ns, name := splitKey(noteID)
+	// Offloaded content gets a new internal key on every save, so a failed
+	// condition never touches the content of the saved note
+	offloaded := ""
+	if len(content) > maxDynamoContent {
+		if ds.offload == nil {
+			return 0, fmt.Errorf("note of %s exceeds the DynamoDB item limit and no S3 bucket is configured", formatSize(int64(len(content))))
+		}
+		offloaded = noteID + ".content." + strings.ToLower(rand.Text())
+		// Store the content first, so the item never points to a missing object
+		if err := ds.offload.Write(ctx, offloaded, content); err != nil {
+			return 0, err
+		}
+	}
+
+	set := []string{
+		e.name("ns") + " = " + e.value("ns", dynamoString(ns)),
+		e.name("name") + " = " + e.value("name", dynamoString(name)),
+		e.name("size") + " = " + e.value("size", dynamoNumber(int64(len(content)))),
+		e.name("updated") + " = " + e.value("updated", dynamoString(now.UTC().Format(time.RFC3339Nano))),
+	}
+	var remove []string
+	if offloaded != "" {
+		set = append(set, e.name("offloaded")+" = "+e.value("offloaded", dynamoString(offloaded)))
+		remove = append(remove, e.name("content"))
+	} else {
+		set = append(set, e.name("content")+" = "+e.value("content", dynamoString(content)))
+		remove = append(remove, e.name("offloaded"))
+	}
+	// Only notes expire; the table's stream reports them, so their metadata
+	// and read-only links are removed along with them. The quota's markers of
+	// released expiries only guard against retries and expire too.
+	if ds.ttl > 0 && (ValidateNoteID(noteID) || strings.HasPrefix(noteID, usageReleasedPrefix)) {
+		set = append(set, e.name("expires")+" = "+e.value("ttl", dynamoNumber(now.Add(ds.ttl).Unix())))
+	}
+	// The quota releases an expired note from the client that saved it last
+	if ds.ttl > 0 && ValidateNoteID(noteID) {
+		if subject := quotaSubjectFromContext(ctx); subject != "" {
+			set = append(set, e.name("client")+" = "+e.value("client", dynamoString(subjectUsageKey(subject))))
+		} else {
+			remove = append(remove, e.name("client"))
+		}
+	}
+	// A note recreated after expiry must not stay listed for its old owner
+	p := PrincipalFromContext(ctx)
+	switch {
+	case !ValidateNoteID(noteID):
+	case p != nil && create:
+		set = append(set, e.name("owner")+" = "+e.value("owner", dynamoString(p.Name)))
+	case p != nil:
+		set = append(set, fmt.Sprintf("%s = if_not_exists(%[1]s, %s)", e.name("owner"), e.value("owner", dynamoString(p.Name))))
+	case create:
+		remove = append(remove, e.name("owner"))
+	}
+	update := "SET " + strings.Join(set, ", ") + " REMOVE " + strings.Join(remove, ", ") +
+		" ADD " + e.name("version") + " " + e.value("one", dynamoNumber(1))
+
+	input := &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(ds.table),
+		Key:                       ds.key(noteID),
+		UpdateExpression:          aws.String(update),
+		ExpressionAttributeNames:  e.names,
+		ExpressionAttributeValues: e.attributeValues(),
+		ReturnValues:              types.ReturnValueAllOld,
+	}
+	switch {
+	case create:
+		input.ConditionExpression = aws.String(fmt.Sprintf("attribute_not_exists(%s) OR %s <= %s",
+			e.name("id"), e.name("expires"), e.value("now", dynamoNumber(now.Unix()))))
+	case version != 0:
+		input.ConditionExpression = aws.String(e.name("version") + " = " + e.value("expected", dynamoNumber(version)))
+	}
+	out, err := ds.client.UpdateItem(ctx, input)
+	if err != nil && offloaded != "" {
+		_ = ds.offload.Delete(ctx, offloaded)
+	}
+	if err != nil {
+		var conflict *types.ConditionalCheckFailedException
+		if errors.As(err, &conflict) {
+			return 0, ErrVersionConflict
+		}
+		return 0, fmt.Errorf("failed to write note to DynamoDB: %w", err)
+	}
+
+	// The previous content was replaced, so its S3 object is stale
+	if previous := stringAttr(out.Attributes, "offloaded"); previous != "" && ds.offload != nil {
+		_ = ds.offload.Delete(ctx, previous)
+	}
+	// The version is added to, so an expired item's version carries on
+	saved := numberAttr(out.Attributes, "version") + 1
+	if out.Attributes == nil && ns != dynamoRootNamespace {
+		return saved, ds.addNamespaces(ctx, ns)
+	}
+	return saved, nil
+}
+
+// addNamespaces records marker items for a namespace and its parents
+func (ds *DynamoStorage) addNamespaces(ctx context.Context, namespace string) error {
+	for namespace != dynamoRootNamespace {
+		parent, name := splitKey(namespace)
+		_, err := ds.client.PutItem(ctx, &dynamodb.PutItemInput{
+			TableName: aws.String(ds.table),
+			Item: map[string]types.AttributeValue{
+				"id":   dynamoString(namespace + "/"),
+				"ns":   dynamoString(parent),
+				"name": dynamoString(name + "/"),
+			},
+			ConditionExpression: aws.String("attribute_not_exists(id)"),
+		})
+		var exists *types.ConditionalCheckFailedException
+		if err != nil && !errors.As(err, &exists) {
+			return fmt.Errorf("failed to record namespace in DynamoDB: %w", err)
+		}
+		namespace = parent
+	}
+	return nil
+}
+
+// Delete removes a note, along with its offloaded content
+func (ds *DynamoStorage) Delete(ctx context.Context, noteID string) error {
+	out, err := ds.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName:    aws.String(ds.table),
+		Key:          ds.key(noteID),
+		ReturnValues: types.ReturnValueAllOld,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete note from DynamoDB: %w", err)
+	}
+	if offloaded := stringAttr(out.Attributes, "offloaded"); offloaded != "" && ds.offload != nil {
+		return ds.offload.Delete(ctx, offloaded)
+	}
+	return nil
+}
+
+// entries queries the namespace index for the items directly inside a namespace
+func (ds *DynamoStorage) entries(ctx context.Context, namespace string, visit func(item map[string]types.AttributeValue) (bool, error)) error {
+	if namespace == "" {
+		namespace = dynamoRootNamespace
+	}
+	e := newDynamoExpression()
+	paginator := dynamodb.NewQueryPaginator(ds.client, &dynamodb.QueryInput{
+		TableName:                 aws.String(ds.table),
+		IndexName:                 aws.String(dynamoNamespaceIndex),
+		KeyConditionExpression:    aws.String(e.name("ns") + " = " + e.value("ns", dynamoString(namespace))),
+		ExpressionAttributeNames:  e.names,
+		ExpressionAttributeValues: e.values,
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list notes in DynamoDB: %w", err)
+		}
+		for _, item := range page.Items {
+			if more, err := visit(item); !more || err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// hasNotes reports whether a namespace or one of its sub-namespaces holds a
+// note. Namespace markers are kept when their last note is deleted, so empty
+// namespaces are hidden here.
+func (ds *DynamoStorage) hasNotes(ctx context.Context, namespace string) (bool, error) {
+	found := false
+	err := ds.entries(ctx, namespace, func(item map[string]types.AttributeValue) (bool, error) {
+		name := stringAttr(item, "name")
+		if sub, ok := strings.CutSuffix(name, "/"); ok {
+			var err error
+			found, err = ds.hasNotes(ctx, namespace+"/"+sub)
+			return !found, err
+		}
+		found = ValidateNoteID(name) && !ds.expired(item)
+		return !found, nil
+	})
+	return found, err
+}
+
+// List returns notes and sub-namespaces directly inside a namespace. The
+// namespace index is eventually consistent, so a note saved a moment ago
+// may be missing.
+func (ds *DynamoStorage) List(ctx context.Context, namespace string) ([]string, error) {
+	names := []string{}
+	err := ds.entries(ctx, namespace, func(item map[string]types.AttributeValue) (bool, error) {
+		name := stringAttr(item, "name")
+		sub, isNamespace := strings.CutSuffix(name, "/")
+		switch {
+		case isNamespace && ValidateNoteID(sub):
+			path := sub
+			if namespace != "" {
+				path = namespace + "/" + sub
+			}
+			nonEmpty, err := ds.hasNotes(ctx, path)
+			if nonEmpty {
+				names = append(names, name)
+			}
+			return true, err
+		case ValidateNoteID(name) && !ds.expired(item):
+			// Skip internal records (aliases, metadata) and anything else that is not a note
+			names = append(names, name)
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// AddCounter implements CounterStorage with an atomic ADD to the "count"
+// attribute of the key's item, conditional on the limit
+func (ds *DynamoStorage) AddCounter(ctx context.Context, key string, delta int64, limit int64) (int64, error) {
+	if delta > 0 && limit > 0 && delta > limit {
+		return 0, ErrCounterLimit
+	}
+	e := newDynamoExpression()
+	input := &dynamodb.UpdateItemInput{
+		TableName:        aws.String(ds.table),
+		Key:              ds.key(key),
+		UpdateExpression: aws.String("ADD " + e.name("count") + " " + e.value("delta", dynamoNumber(delta))),
+		ReturnValues:     types.ReturnValueUpdatedNew,
+	}
+	switch {
+	case delta > 0 && limit > 0:
+		input.ConditionExpression = aws.String(fmt.Sprintf("attribute_not_exists(%s) OR %[1]s <= %s", e.name("count"), e.value("max", dynamoNumber(limit-delta))))
+	case delta < 0:
+		input.ConditionExpression = aws.String(fmt.Sprintf("%s >= %s", e.name("count"), e.value("min", dynamoNumber(-delta))))
+	}
+	input.ExpressionAttributeNames = e.names
+	input.ExpressionAttributeValues = e.attributeValues()
+
+	out, err := ds.client.UpdateItem(ctx, input)
+	var conflict *types.ConditionalCheckFailedException
+	switch {
+	case errors.As(err, &conflict) && delta > 0:
+		return 0, ErrCounterLimit
+	case errors.As(err, &conflict):
+		// Subtracting more than is left, e.g. usage counted from before
+		// quotas were enabled, leaves the counter at zero
+		_, err = ds.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+			TableName:                 aws.String(ds.table),
+			Key:                       ds.key(key),
+			UpdateExpression:          aws.String("SET #count = :zero"),
+			ExpressionAttributeNames:  map[string]string{"#count": "count"},
+			ExpressionAttributeValues: map[string]types.AttributeValue{":zero": dynamoNumber(0)},
+		})
+		if err != nil {
+			return 0, fmt.Errorf("failed to reset counter in DynamoDB: %w", err)
+		}
+		return 0, nil
+	case err != nil:
+		return 0, fmt.Errorf("failed to update counter in DynamoDB: %w", err)
+	}
+	return numberAttr(out.Attributes, "count"), nil
+}
+
+// ReadCounter implements CounterStorage
+func (ds *DynamoStorage) ReadCounter(ctx context.Context, key string) (int64, error) {
+	out, err := ds.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(ds.table),
+		Key:            ds.key(key),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to read counter from DynamoDB: %w", err)
+	}
+	return numberAttr(out.Item, "count"), nil
+}
+
+// ListByOwner returns the IDs of the notes created by owner, most recently
+// saved first
+func (ds *DynamoStorage) ListByOwner(ctx context.Context, owner string) ([]string, error) {
+	e := newDynamoExpression()
+	paginator := dynamodb.NewQueryPaginator(ds.client, &dynamodb.QueryInput{
+		TableName:                 aws.String(ds.table),
+		IndexName:                 aws.String(dynamoOwnerIndex),
+		KeyConditionExpression:    aws.String(e.name("owner") + " = " + e.value("owner", dynamoString(owner))),
+		ExpressionAttributeNames:  e.names,
+		ExpressionAttributeValues: e.values,
+		ScanIndexForward:          aws.Bool(false),
+	})
+	ids := []string{}
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list notes of owner in DynamoDB: %w", err)
+		}
+		for _, item := range page.Items {
+			if !ds.expired(item) {
+				ids = append(ids, stringAttr(item, "id"))
+			}
+		}
+	}
+	return ids, nil
+}
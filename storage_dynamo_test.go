@@ -0,0 +1,423 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// dynamoRequest is a request received by the fake DynamoDB endpoint
+type dynamoRequest struct {
+	op    string
+	input map[string]any
+}
+
+// fakeDynamo answers DynamoDB requests with canned responses and records them
+type fakeDynamo struct {
+	requests []dynamoRequest
+	respond  func(op string, input map[string]any) (int, string)
+}
+
+func (f *fakeDynamo) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	req := dynamoRequest{op: strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "DynamoDB_20120810.")}
+	_ = json.Unmarshal(body, &req.input)
+	f.requests = append(f.requests, req)
+
+	status, response := http.StatusOK, "{}"
+	if f.respond != nil {
+		status, response = f.respond(req.op, req.input)
+	}
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+	w.WriteHeader(status)
+	_, _ = io.WriteString(w, response)
+}
+
+// ops returns the operations received
+func (f *fakeDynamo) ops() []string {
+	ops := []string{}
+	for _, r := range f.requests {
+		ops = append(ops, r.op)
+	}
+	return ops
+}
+
+// newFakeDynamoStorage returns a DynamoStorage talking to a fake endpoint at
+// a fixed time
+func newFakeDynamoStorage(t *testing.T, ttl time.Duration) (*DynamoStorage, *fakeDynamo) {
+	fake := &fakeDynamo{}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	client := dynamodb.New(dynamodb.Options{
+		Region:           "us-east-1",
+		BaseEndpoint:     aws.String(server.URL),
+		Credentials:      aws.AnonymousCredentials{},
+		RetryMaxAttempts: 1,
+	})
+	ds := NewDynamoStorage(client, "notes", ttl, nil)
+	ds.now = func() time.Time { return time.Unix(1700000000, 0) }
+	return ds, fake
+}
+
+const dynamoConditionFailed = `{"__type":"com.amazonaws.dynamodb.v20120810#ConditionalCheckFailedException","message":"The conditional request failed"}`
+
+// TestDynamoSplitKey tests the mapping of storage keys to namespace and name
+func TestDynamoSplitKey(t *testing.T) {
+	tests := []struct {
+		key, ns, name string
+	}{
+		{"ABCDE", "/", "ABCDE"},
+		{".alias.runbook", "/", ".alias.runbook"},
+		{"infra/dns/cutover", "infra/dns", "cutover"},
+		{"infra/dns/cutover.meta", "infra/dns", "cutover.meta"},
+		{"infra/", "infra", ""},
+	}
+	for _, test := range tests {
+		if ns, name := splitKey(test.key); ns != test.ns || name != test.name {
+			t.Errorf("%s: expected %q %q, got %q %q", test.key, test.ns, test.name, ns, name)
+		}
+	}
+}
+
+// TestDynamoStorageRead tests reading notes, versions and expired items
+func TestDynamoStorageRead(t *testing.T) {
+	ds, fake := newFakeDynamoStorage(t, 0)
+	ctx := context.Background()
+
+	item := `{"Item":{"id":{"S":"ABCDE"},"content":{"S":"hello"},"version":{"N":"3"}}}`
+	fake.respond = func(op string, input map[string]any) (int, string) { return http.StatusOK, item }
+	content, version, err := ds.ReadVersion(ctx, "ABCDE")
+	if err != nil || content != "hello" || version != 3 {
+		t.Errorf("Expected hello at version 3, got %q %d %v", content, version, err)
+	}
+	if consistent, _ := fake.requests[0].input["ConsistentRead"].(bool); !consistent {
+		t.Errorf("Expected a consistent read")
+	}
+
+	// Expired items are hidden until DynamoDB deletes them
+	item = `{"Item":{"id":{"S":"ABCDE"},"content":{"S":"hello"},"version":{"N":"3"},"expires":{"N":"1699999999"}}}`
+	if content, version, err := ds.ReadVersion(ctx, "ABCDE"); err != nil || content != "" || version != 0 {
+		t.Errorf("Expected expired note to be missing, got %q %d %v", content, version, err)
+	}
+
+	item = `{}`
+	if content, err := ds.Read(ctx, "ABCDE"); err != nil || content != "" {
+		t.Errorf("Expected missing note, got %q %v", content, err)
+	}
+
+	// Offloaded content needs an S3 bucket
+	item = `{"Item":{"id":{"S":"ABCDE"},"offloaded":{"S":"ABCDE.content.XYZ"},"version":{"N":"1"}}}`
+	if _, err := ds.Read(ctx, "ABCDE"); err == nil {
+		t.Errorf("Expected offloaded note without bucket to fail")
+	}
+}
+
+// TestDynamoStorageWrite tests the updates sent for saved notes
+func TestDynamoStorageWrite(t *testing.T) {
+	ds, fake := newFakeDynamoStorage(t, 24*time.Hour)
+	ctx := withQuotaSubject(withPrincipal(context.Background(), &Principal{Name: "alice"}), "user:alice")
+
+	if err := ds.Write(ctx, "infra/dns/cutover", "hello"); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	// A new note records its namespaces
+	if ops := fake.ops(); !slices.Equal(ops, []string{"UpdateItem", "PutItem", "PutItem"}) {
+		t.Fatalf("Unexpected requests %v", ops)
+	}
+	update := fake.requests[0].input
+	expression := update["UpdateExpression"].(string)
+	for _, placeholder := range []string{":content", ":ttl", ":owner", ":one"} {
+		if !strings.Contains(expression, placeholder) {
+			t.Errorf("Expected %s in %q", placeholder, expression)
+		}
+	}
+	if _, ok := update["ConditionExpression"]; ok {
+		t.Errorf("Expected unconditional write")
+	}
+	values := update["ExpressionAttributeValues"].(map[string]any)
+	if ttl := values[":ttl"].(map[string]any)["N"]; ttl != "1700086400" {
+		t.Errorf("Expected expiry a day later, got %v", ttl)
+	}
+	if client := values[":client"].(map[string]any)["S"]; client != subjectUsageKey("user:alice") {
+		t.Errorf("Expected the saving client's usage key, got %v", client)
+	}
+	// DynamoDB rejects unused names and values, so check each one is used
+	for name := range update["ExpressionAttributeNames"].(map[string]any) {
+		if !strings.Contains(expression, name) {
+			t.Errorf("Unused attribute name %s", name)
+		}
+	}
+	for value := range values {
+		if !strings.Contains(expression, value) {
+			t.Errorf("Unused attribute value %s", value)
+		}
+	}
+	var markers []string
+	for _, r := range fake.requests[1:] {
+		item := r.input["Item"].(map[string]any)
+		markers = append(markers, item["ns"].(map[string]any)["S"].(string)+" "+item["name"].(map[string]any)["S"].(string))
+	}
+	if !slices.Equal(markers, []string{"infra dns/", "/ infra/"}) {
+		t.Errorf("Unexpected namespace markers %v", markers)
+	}
+
+	// Saving an existing note leaves its namespaces alone, and metadata never expires
+	fake.requests = nil
+	fake.respond = func(op string, input map[string]any) (int, string) {
+		return http.StatusOK, `{"Attributes":{"id":{"S":"infra/dns/cutover.meta"},"version":{"N":"1"}}}`
+	}
+	if err := ds.Write(ctx, "infra/dns/cutover.meta", "{}"); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if ops := fake.ops(); !slices.Equal(ops, []string{"UpdateItem"}) {
+		t.Errorf("Unexpected requests %v", ops)
+	}
+	if expression := fake.requests[0].input["UpdateExpression"].(string); strings.Contains(expression, ":ttl") || strings.Contains(expression, ":owner") {
+		t.Errorf("Expected metadata without expiry and owner, got %q", expression)
+	}
+
+	// The quota's markers of released expiries expire like notes
+	fake.requests = nil
+	if err := ds.Create(ctx, usageReleasedPrefix+"c81e728d", "1"); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if expression := fake.requests[0].input["UpdateExpression"].(string); !strings.Contains(expression, ":ttl") {
+		t.Errorf("Expected release marker to expire, got %q", expression)
+	}
+
+	// Notes over the item limit need an S3 bucket
+	if err := ds.Write(ctx, "big", strings.Repeat("x", maxDynamoContent+1)); err == nil {
+		t.Errorf("Expected oversized note without bucket to fail")
+	}
+}
+
+// TestDynamoStorageConditions tests conditional saves
+func TestDynamoStorageConditions(t *testing.T) {
+	ds, fake := newFakeDynamoStorage(t, 0)
+	ctx := context.Background()
+	fake.respond = func(op string, input map[string]any) (int, string) {
+		return http.StatusBadRequest, dynamoConditionFailed
+	}
+
+	if err := ds.Create(ctx, "ABCDE", "hello"); !errors.Is(err, ErrNoteExists) {
+		t.Errorf("Expected ErrNoteExists, got %v", err)
+	}
+	if condition := fake.requests[0].input["ConditionExpression"]; condition != "attribute_not_exists(#id) OR #expires <= :now" {
+		t.Errorf("Unexpected create condition %v", condition)
+	}
+	// A note recreated after expiry loses its previous owner
+	if expression := fake.requests[0].input["UpdateExpression"].(string); !strings.Contains(expression, "REMOVE #offloaded, #owner") {
+		t.Errorf("Expected owner to be removed, got %q", expression)
+	}
+
+	if _, err := ds.WriteVersion(ctx, "ABCDE", "hello", 2); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("Expected ErrVersionConflict, got %v", err)
+	}
+	request := fake.requests[1].input
+	if condition := request["ConditionExpression"]; condition != "#version = :expected" {
+		t.Errorf("Unexpected version condition %v", condition)
+	}
+	if expected := request["ExpressionAttributeValues"].(map[string]any)[":expected"].(map[string]any)["N"]; expected != "2" {
+		t.Errorf("Expected version 2, got %v", expected)
+	}
+
+	fake.respond = nil
+	if err := ds.Create(ctx, "ABCDE", "hello"); err != nil {
+		t.Errorf("Create failed: %v", err)
+	}
+
+	// The new version follows the one replaced
+	fake.respond = func(op string, input map[string]any) (int, string) {
+		return http.StatusOK, `{"Attributes":{"id":{"S":"ABCDE"},"version":{"N":"2"}}}`
+	}
+	if version, err := ds.WriteVersion(ctx, "ABCDE", "hello", 2); err != nil || version != 3 {
+		t.Errorf("Expected version 3, got %d %v", version, err)
+	}
+}
+
+// TestDynamoStorageCounter tests the atomic updates of counters
+func TestDynamoStorageCounter(t *testing.T) {
+	ds, fake := newFakeDynamoStorage(t, 0)
+	ctx := context.Background()
+	fake.respond = func(op string, input map[string]any) (int, string) {
+		return http.StatusOK, `{"Attributes":{"count":{"N":"42"}}}`
+	}
+	if value, err := ds.AddCounter(ctx, ".usage.total", 10, 100); err != nil || value != 42 {
+		t.Errorf("Expected 42, got %d %v", value, err)
+	}
+	request := fake.requests[0].input
+	if request["UpdateExpression"] != "ADD #count :delta" || request["ConditionExpression"] != "attribute_not_exists(#count) OR #count <= :max" {
+		t.Errorf("Unexpected update %v", request)
+	}
+	if limit := request["ExpressionAttributeValues"].(map[string]any)[":max"].(map[string]any)["N"]; limit != "90" {
+		t.Errorf("Expected at most 90 before adding, got %v", limit)
+	}
+
+	fake.respond = func(op string, input map[string]any) (int, string) {
+		return http.StatusBadRequest, dynamoConditionFailed
+	}
+	if _, err := ds.AddCounter(ctx, ".usage.total", 10, 100); !errors.Is(err, ErrCounterLimit) {
+		t.Errorf("Expected ErrCounterLimit, got %v", err)
+	}
+
+	// Subtracting more than is left resets the counter
+	fake.requests = nil
+	fake.respond = func(op string, input map[string]any) (int, string) {
+		if _, conditional := input["ConditionExpression"]; conditional {
+			return http.StatusBadRequest, dynamoConditionFailed
+		}
+		return http.StatusOK, `{}`
+	}
+	if value, err := ds.AddCounter(ctx, ".usage.total", -10, 0); err != nil || value != 0 {
+		t.Errorf("Expected reset to 0, got %d %v", value, err)
+	}
+	if len(fake.requests) != 2 || fake.requests[1].input["UpdateExpression"] != "SET #count = :zero" {
+		t.Errorf("Expected a reset, got %v", fake.requests)
+	}
+}
+
+// TestDynamoStorageList tests listing notes and non-empty namespaces
+func TestDynamoStorageList(t *testing.T) {
+	ds, fake := newFakeDynamoStorage(t, 0)
+	namespaces := map[string]string{
+		"infra":       `[{"name":{"S":"cutover"}},{"name":{"S":"cutover.meta"}},{"name":{"S":"old"},"expires":{"N":"1600000000"}},{"name":{"S":"dns/"}},{"name":{"S":"empty/"}}]`,
+		"infra/dns":   `[{"name":{"S":"records"}}]`,
+		"infra/empty": `[{"name":{"S":"gone"},"expires":{"N":"1600000000"}}]`,
+	}
+	fake.respond = func(op string, input map[string]any) (int, string) {
+		ns := input["ExpressionAttributeValues"].(map[string]any)[":ns"].(map[string]any)["S"].(string)
+		items, ok := namespaces[ns]
+		if !ok {
+			items = "[]"
+		}
+		return http.StatusOK, `{"Items":` + items + `}`
+	}
+
+	names, err := ds.List(context.Background(), "infra")
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if !slices.Equal(names, []string{"cutover", "dns/"}) {
+		t.Errorf("Unexpected listing %v", names)
+	}
+	if index := fake.requests[0].input["IndexName"]; index != dynamoNamespaceIndex {
+		t.Errorf("Expected query of %s, got %v", dynamoNamespaceIndex, index)
+	}
+
+	if names, err := ds.List(context.Background(), ""); err != nil || len(names) != 0 {
+		t.Errorf("Expected empty root, got %v %v", names, err)
+	}
+}
+
+// TestDynamoStorageLocal runs against DynamoDB Local, e.g.
+// docker run -p 8000:8000 amazon/dynamodb-local, with
+// DYNAMODB_TEST_ENDPOINT=http://localhost:8000
+func TestDynamoStorageLocal(t *testing.T) {
+	endpoint := os.Getenv("DYNAMODB_TEST_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("DYNAMODB_TEST_ENDPOINT not set")
+	}
+	ctx := context.Background()
+	client := dynamodb.New(dynamodb.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(endpoint),
+		Credentials: aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "local", SecretAccessKey: "local"}, nil
+		}),
+	})
+	table := fmt.Sprintf("notes-%d", time.Now().UnixNano())
+	index := func(name, hash, rangeKey string) types.GlobalSecondaryIndex {
+		return types.GlobalSecondaryIndex{
+			IndexName: aws.String(name),
+			KeySchema: []types.KeySchemaElement{
+				{AttributeName: aws.String(hash), KeyType: types.KeyTypeHash},
+				{AttributeName: aws.String(rangeKey), KeyType: types.KeyTypeRange},
+			},
+			Projection: &types.Projection{ProjectionType: types.ProjectionTypeInclude, NonKeyAttributes: []string{"expires"}},
+		}
+	}
+	var attributes []types.AttributeDefinition
+	for _, name := range []string{"id", "ns", "name", "owner", "updated"} {
+		attributes = append(attributes, types.AttributeDefinition{AttributeName: aws.String(name), AttributeType: types.ScalarAttributeTypeS})
+	}
+	_, err := client.CreateTable(ctx, &dynamodb.CreateTableInput{
+		TableName:              aws.String(table),
+		BillingMode:            types.BillingModePayPerRequest,
+		AttributeDefinitions:   attributes,
+		KeySchema:              []types.KeySchemaElement{{AttributeName: aws.String("id"), KeyType: types.KeyTypeHash}},
+		GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{index(dynamoNamespaceIndex, "ns", "name"), index(dynamoOwnerIndex, "owner", "updated")},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	t.Cleanup(func() {
+		_, _ = client.DeleteTable(context.Background(), &dynamodb.DeleteTableInput{TableName: aws.String(table)})
+	})
+
+	ds := NewDynamoStorage(client, table, time.Hour, nil)
+	owned := withPrincipal(ctx, &Principal{Name: "alice"})
+	if err := ds.Create(owned, "infra/dns/cutover", "v1"); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if err := ds.Create(ctx, "infra/dns/cutover", "other"); !errors.Is(err, ErrNoteExists) {
+		t.Errorf("Expected ErrNoteExists, got %v", err)
+	}
+
+	content, version, err := ds.ReadVersion(ctx, "infra/dns/cutover")
+	if err != nil || content != "v1" || version != 1 {
+		t.Fatalf("Expected v1 at version 1, got %q %d %v", content, version, err)
+	}
+	if saved, err := ds.WriteVersion(ctx, "infra/dns/cutover", "v2", version); err != nil || saved != 2 {
+		t.Errorf("Expected version 2, got %d %v", saved, err)
+	}
+	if _, err := ds.WriteVersion(ctx, "infra/dns/cutover", "stale", version); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("Expected ErrVersionConflict, got %v", err)
+	}
+	_ = ds.Write(ctx, "infra/dns/cutover.meta", "{}")
+
+	if names, err := ds.List(ctx, ""); err != nil || !slices.Equal(names, []string{"infra/"}) {
+		t.Errorf("Unexpected root listing %v %v", names, err)
+	}
+	if names, err := ds.List(ctx, "infra/dns"); err != nil || !slices.Equal(names, []string{"cutover"}) {
+		t.Errorf("Unexpected namespace listing %v %v", names, err)
+	}
+	if ids, err := ds.ListByOwner(ctx, "alice"); err != nil || !slices.Equal(ids, []string{"infra/dns/cutover"}) {
+		t.Errorf("Unexpected notes of owner %v %v", ids, err)
+	}
+
+	// Expired notes are gone, and their ID can be used again
+	ds.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	if content, _ := ds.Read(ctx, "infra/dns/cutover"); content != "" {
+		t.Errorf("Expected expired note to be missing, got %q", content)
+	}
+	if meta, _ := ds.Read(ctx, "infra/dns/cutover.meta"); meta != "{}" {
+		t.Errorf("Expected metadata to be kept, got %q", meta)
+	}
+	if err := ds.Create(ctx, "infra/dns/cutover", "v3"); err != nil {
+		t.Errorf("Expected expired note to be replaced, got %v", err)
+	}
+	if ids, err := ds.ListByOwner(ctx, "alice"); err != nil || len(ids) != 0 {
+		t.Errorf("Expected recreated note to lose its owner, got %v %v", ids, err)
+	}
+
+	if err := ds.Delete(ctx, "infra/dns/cutover"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if names, err := ds.List(ctx, ""); err != nil || len(names) != 0 {
+		t.Errorf("Expected empty namespaces to be hidden, got %v %v", names, err)
+	}
+}
@@ -4,19 +4,115 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
 )
 
+// ErrNoteExists is returned by Storage.Create when the note ID is already taken
+var ErrNoteExists = errors.New("note already exists")
+
+// ErrVersionConflict is returned by VersionedStorage.WriteVersion when the
+// note was saved by someone else since its version was read
+var ErrVersionConflict = errors.New("note was changed concurrently")
+
+// ErrCounterLimit is returned by CounterStorage.AddCounter when an addition
+// would take a counter over its limit
+var ErrCounterLimit = errors.New("counter limit reached")
+
+// errInvalidKey is returned for storage keys that would escape the storage root
+var errInvalidKey = errors.New("invalid storage key")
+
 // Storage defines the interface for note storage
 type Storage interface {
 	Read(ctx context.Context, noteID string) (string, error)
 	Write(ctx context.Context, noteID string, content string) error
+	// Create saves a note only if it does not exist yet, returning ErrNoteExists otherwise
+	Create(ctx context.Context, noteID string, content string) error
 	Delete(ctx context.Context, noteID string) error
+	// List returns the sorted names of notes and sub-namespaces (with a trailing
+	// slash) directly inside a namespace; "" is the root namespace
+	List(ctx context.Context, namespace string) ([]string, error)
+}
+
+// VersionedStorage is a Storage whose notes carry a version that every save
+// increments, so concurrent editors can be detected
+type VersionedStorage interface {
+	Storage
+	// ReadVersion retrieves note content with its version, 0 for a missing note
+	ReadVersion(ctx context.Context, noteID string) (string, int64, error)
+	// WriteVersion saves a note only if it is still at version, returning its
+	// new version, or ErrVersionConflict otherwise; version 0 creates the note
+	WriteVersion(ctx context.Context, noteID string, content string, version int64) (int64, error)
+}
+
+// OwnerIndex is a Storage that lists the notes created by signed-in users
+type OwnerIndex interface {
+	Storage
+	// ListByOwner returns the IDs of the notes of owner, most recently saved first
+	ListByOwner(ctx context.Context, owner string) ([]string, error)
+}
+
+// CounterStorage is a Storage that updates counters atomically, so instances
+// sharing the storage never lose each other's updates
+type CounterStorage interface {
+	Storage
+	// AddCounter adds delta to the counter under key and returns its new
+	// value, which never drops below zero. A positive delta that would take
+	// the counter over limit (0 for none) is refused with ErrCounterLimit.
+	AddCounter(ctx context.Context, key string, delta int64, limit int64) (int64, error)
+	// ReadCounter returns a counter, 0 if it does not exist
+	ReadCounter(ctx context.Context, key string) (int64, error)
+}
+
+// unwrapStorage returns the storage at the bottom of a chain of wrappers
+// such as QuotaStorage
+func unwrapStorage(storage Storage) Storage {
+	for {
+		wrapper, ok := storage.(interface{ Unwrap() Storage })
+		if !ok {
+			return storage
+		}
+		storage = wrapper.Unwrap()
+	}
+}
+
+// versionedStorage returns storage as a VersionedStorage if the storage it
+// wraps keeps versions. Wrappers always have the methods, so asserting the
+// interface alone is not enough.
+func versionedStorage(storage Storage) (VersionedStorage, bool) {
+	if _, ok := unwrapStorage(storage).(VersionedStorage); !ok {
+		return nil, false
+	}
+	vs, ok := storage.(VersionedStorage)
+	return vs, ok
+}
+
+// counterStorage returns storage as a CounterStorage if the storage it wraps
+// keeps atomic counters
+func counterStorage(storage Storage) (CounterStorage, bool) {
+	if _, ok := unwrapStorage(storage).(CounterStorage); !ok {
+		return nil, false
+	}
+	counters, ok := storage.(CounterStorage)
+	return counters, ok
 }
 
-// LocalStorage implements Storage using the local filesystem
+// ownerIndex returns storage as an OwnerIndex if the storage it wraps lists
+// notes by owner
+func ownerIndex(storage Storage) (OwnerIndex, bool) {
+	if _, ok := unwrapStorage(storage).(OwnerIndex); !ok {
+		return nil, false
+	}
+	index, ok := storage.(OwnerIndex)
+	return index, ok
+}
+
+// LocalStorage implements Storage using the local filesystem. Namespaced note
+// IDs such as infra/dns/cutover are stored in subdirectories.
 type LocalStorage struct {
 	dir string
 }
@@ -25,51 +121,163 @@ type LocalStorage struct {
 func NewLocalStorage(dir string) (*LocalStorage, error) {
 	// Create directory if it doesn't exist
 	if err := os.MkdirAll(dir, 0755); err != nil {
-		log.Printf("[ERROR] Failed to create note directory %s: %v", dir, err)
+		slog.Error("Failed to create note directory", "directory", dir, "error", err)
 		return nil, fmt.Errorf("failed to create note directory: %w", err)
 	}
-	log.Printf("[INFO] LocalStorage initialized at: %s", dir)
+	slog.Info("LocalStorage initialized", "directory", dir)
 	return &LocalStorage{dir: dir}, nil
 }
 
+// path maps a storage key to a file path, rejecting keys that are absolute or
+// contain ".." segments so nothing outside the note directory can be touched
+func (ls *LocalStorage) path(ctx context.Context, key string) (string, error) {
+	if !filepath.IsLocal(key) || strings.Contains(key, `\`) {
+		slog.ErrorContext(ctx, "Rejected storage key outside of note directory", "directory", ls.dir, "key", key)
+		return "", fmt.Errorf("%w: %q", errInvalidKey, key)
+	}
+	return filepath.Join(ls.dir, filepath.FromSlash(key)), nil
+}
+
 // Read retrieves note content from disk
 func (ls *LocalStorage) Read(ctx context.Context, noteID string) (string, error) {
-	filePath := filepath.Join(ls.dir, noteID)
+	filePath, err := ls.path(ctx, noteID)
+	if err != nil {
+		return "", err
+	}
 	content, err := os.ReadFile(filePath)
 	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			log.Printf("[INFO] Note %s does not exist at %s", noteID, filePath)
+		// A namespace directory or a path below a plain note is not a note either
+		if errors.Is(err, os.ErrNotExist) || errors.Is(err, syscall.EISDIR) || errors.Is(err, syscall.ENOTDIR) {
+			slog.DebugContext(ctx, "Note does not exist", "note", noteID, "path", filePath)
 			return "", nil // Return empty string for missing note
 		}
-		log.Printf("[ERROR] Failed to read note %s from %s: %v", noteID, filePath, err)
+		slog.ErrorContext(ctx, "Failed to read note", "note", noteID, "path", filePath, "error", err)
 		return "", fmt.Errorf("failed to read note: %w", err)
 	}
-	log.Printf("[DEBUG] Note %s read successfully from %s (%d bytes)", noteID, filePath, len(content))
+	slog.DebugContext(ctx, "Note read", "note", noteID, "path", filePath, "size", len(content))
 	return string(content), nil
 }
 
 // Write saves note content to disk
 func (ls *LocalStorage) Write(ctx context.Context, noteID string, content string) error {
-	filePath := filepath.Join(ls.dir, noteID)
+	filePath, err := ls.path(ctx, noteID)
+	if err != nil {
+		return err
+	}
+	if err := ls.ensureParent(ctx, noteID, filePath); err != nil {
+		return err
+	}
 	if err := os.WriteFile(filePath, []byte(content), 0644); err != nil {
-		log.Printf("[ERROR] Failed to write note %s to %s: %v (Check directory permissions: %s, Disk space, File permissions)", noteID, filePath, err, ls.dir)
+		slog.ErrorContext(ctx, "Failed to write note; check directory permissions, disk space and file permissions", "note", noteID, "path", filePath, "directory", ls.dir, "error", err)
 		return fmt.Errorf("failed to write note: %w", err)
 	}
-	log.Printf("[DEBUG] Note %s written successfully to %s (%d bytes)", noteID, filePath, len(content))
+	slog.DebugContext(ctx, "Note written", "note", noteID, "path", filePath, "size", len(content))
 	return nil
 }
 
-// Delete removes a note from disk
+// Create saves note content to disk, failing with ErrNoteExists if the file already exists
+func (ls *LocalStorage) Create(ctx context.Context, noteID string, content string) error {
+	filePath, err := ls.path(ctx, noteID)
+	if err != nil {
+		return err
+	}
+	if err := ls.ensureParent(ctx, noteID, filePath); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			slog.DebugContext(ctx, "Note already exists", "note", noteID, "path", filePath)
+			return ErrNoteExists
+		}
+		slog.ErrorContext(ctx, "Failed to create note; check directory permissions", "note", noteID, "path", filePath, "directory", ls.dir, "error", err)
+		return fmt.Errorf("failed to create note: %w", err)
+	}
+	if _, err := f.WriteString(content); err != nil {
+		_ = f.Close()
+		_ = os.Remove(filePath)
+		slog.ErrorContext(ctx, "Failed to write new note", "note", noteID, "path", filePath, "error", err)
+		return fmt.Errorf("failed to create note: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(filePath)
+		return fmt.Errorf("failed to create note: %w", err)
+	}
+	slog.DebugContext(ctx, "Note created", "note", noteID, "path", filePath, "size", len(content))
+	return nil
+}
+
+// Delete removes a note from disk, along with namespace directories left empty
 func (ls *LocalStorage) Delete(ctx context.Context, noteID string) error {
-	filePath := filepath.Join(ls.dir, noteID)
+	filePath, err := ls.path(ctx, noteID)
+	if err != nil {
+		return err
+	}
 	if err := os.Remove(filePath); err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			log.Printf("[INFO] Note %s does not exist at %s, nothing to delete", noteID, filePath)
+		if errors.Is(err, os.ErrNotExist) || errors.Is(err, syscall.ENOTDIR) {
+			slog.DebugContext(ctx, "Note does not exist, nothing to delete", "note", noteID, "path", filePath)
 			return nil // Silently ignore if already deleted
 		}
-		log.Printf("[ERROR] Failed to delete note %s from %s: %v (Check file permissions)", noteID, filePath, err)
+		slog.ErrorContext(ctx, "Failed to delete note; check file permissions", "note", noteID, "path", filePath, "error", err)
 		return fmt.Errorf("failed to delete note: %w", err)
 	}
-	log.Printf("[DEBUG] Note %s deleted successfully from %s", noteID, filePath)
+	slog.DebugContext(ctx, "Note deleted", "note", noteID, "path", filePath)
+	ls.pruneEmptyDirs(filepath.Dir(filePath))
 	return nil
 }
+
+// List returns notes and sub-namespaces directly inside a namespace directory
+func (ls *LocalStorage) List(ctx context.Context, namespace string) ([]string, error) {
+	dirPath := ls.dir
+	if namespace != "" {
+		var err error
+		if dirPath, err = ls.path(ctx, namespace); err != nil {
+			return nil, err
+		}
+	}
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) || errors.Is(err, syscall.ENOTDIR) {
+			return []string{}, nil
+		}
+		slog.ErrorContext(ctx, "Failed to list namespace", "namespace", namespace, "path", dirPath, "error", err)
+		return nil, fmt.Errorf("failed to list namespace: %w", err)
+	}
+	names := []string{}
+	for _, e := range entries {
+		// Skip internal records (aliases, metadata) and anything else that is not a note
+		if !ValidateNoteID(e.Name()) {
+			continue
+		}
+		if e.IsDir() {
+			names = append(names, e.Name()+"/")
+		} else {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// ensureParent creates the namespace directories for a note
+func (ls *LocalStorage) ensureParent(ctx context.Context, noteID string, filePath string) error {
+	if !strings.Contains(noteID, "/") {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		slog.ErrorContext(ctx, "Failed to create namespace directory; a note may already use part of this path", "note", noteID, "error", err)
+		return fmt.Errorf("failed to create namespace: %w", err)
+	}
+	return nil
+}
+
+// pruneEmptyDirs removes empty namespace directories up to the storage root
+func (ls *LocalStorage) pruneEmptyDirs(dir string) {
+	root := filepath.Clean(ls.dir)
+	for dir != root && strings.HasPrefix(dir, root+string(filepath.Separator)) {
+		if err := os.Remove(dir); err != nil {
+			return // not empty or not removable
+		}
+		dir = filepath.Dir(dir)
+	}
+}
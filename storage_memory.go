@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MemoryStorage implements Storage in memory. Notes are lost on exit, which
+// suits development and tests.
+type MemoryStorage struct {
+	mu   sync.RWMutex
+	data map[string]string
+}
+
+// NewMemoryStorage creates an empty MemoryStorage
+func NewMemoryStorage() *MemoryStorage {
+	slog.Info("MemoryStorage initialized")
+	return &MemoryStorage{data: make(map[string]string)}
+}
+
+// Read retrieves note content, or "" for a missing note
+func (ms *MemoryStorage) Read(ctx context.Context, noteID string) (string, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	return ms.data[noteID], nil
+}
+
+// Write saves note content
+func (ms *MemoryStorage) Write(ctx context.Context, noteID string, content string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.data[noteID] = content
+	slog.DebugContext(ctx, "Note written", "note", noteID, "size", len(content))
+	return nil
+}
+
+// Create saves note content, failing with ErrNoteExists if the note exists
+func (ms *MemoryStorage) Create(ctx context.Context, noteID string, content string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if _, ok := ms.data[noteID]; ok {
+		return ErrNoteExists
+	}
+	ms.data[noteID] = content
+	slog.DebugContext(ctx, "Note created", "note", noteID, "size", len(content))
+	return nil
+}
+
+// Delete removes a note
+func (ms *MemoryStorage) Delete(ctx context.Context, noteID string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	delete(ms.data, noteID)
+	slog.DebugContext(ctx, "Note deleted", "note", noteID)
+	return nil
+}
+
+// List returns notes and sub-namespaces directly inside a namespace
+func (ms *MemoryStorage) List(ctx context.Context, namespace string) ([]string, error) {
+	prefix := ""
+	if namespace != "" {
+		prefix = namespace + "/"
+	}
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	seen := make(map[string]bool)
+	names := []string{}
+	for key := range ms.data {
+		name, ok := strings.CutPrefix(key, prefix)
+		if !ok {
+			continue
+		}
+		// Skip internal records (aliases, metadata) and anything else that is not a note
+		entry, _, isNamespace := strings.Cut(name, "/")
+		if !ValidateNoteID(entry) {
+			continue
+		}
+		if isNamespace {
+			entry += "/"
+		}
+		if !seen[entry] {
+			seen[entry] = true
+			names = append(names, entry)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+)
+
+// TestMemoryStorage tests create, namespaces and listing of the in-memory storage
+func TestMemoryStorage(t *testing.T) {
+	storage := NewMemoryStorage()
+	ctx := context.Background()
+
+	if err := storage.Create(ctx, "test123", "first"); err != nil {
+		t.Fatalf("Failed to create note: %v", err)
+	}
+	if err := storage.Create(ctx, "test123", "second"); !errors.Is(err, ErrNoteExists) {
+		t.Fatalf("Expected ErrNoteExists, got %v", err)
+	}
+	if content, _ := storage.Read(ctx, "test123"); content != "first" {
+		t.Errorf("Expected first, got %s", content)
+	}
+
+	_ = storage.Write(ctx, "infra/dns/cutover", "plan")
+	_ = storage.Write(ctx, "infra/readme", "hello")
+	_ = storage.Write(ctx, ".alias.infra-dns", `{"noteId":"infra/dns/cutover"}`)
+	_ = storage.Write(ctx, "test123.meta", `{}`)
+
+	if content, _ := storage.Read(ctx, "infra/dns"); content != "" {
+		t.Errorf("Expected namespace read to behave as missing note, got %q", content)
+	}
+	if root, _ := storage.List(ctx, ""); !slices.Equal(root, []string{"infra/", "test123"}) {
+		t.Errorf("Expected [infra/ test123], got %v", root)
+	}
+	if infra, _ := storage.List(ctx, "infra"); !slices.Equal(infra, []string{"dns/", "readme"}) {
+		t.Errorf("Expected [dns/ readme], got %v", infra)
+	}
+
+	_ = storage.Delete(ctx, "infra/dns/cutover")
+	if infra, _ := storage.List(ctx, "infra"); !slices.Equal(infra, []string{"readme"}) {
+		t.Errorf("Expected [readme] after delete, got %v", infra)
+	}
+}
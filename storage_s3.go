@@ -2,21 +2,33 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
 )
 
-// S3Storage implements Storage using AWS S3
+// S3Storage implements Storage using AWS S3. Namespaced note IDs such as
+// infra/dns/cutover map directly to key prefixes.
 type S3Storage struct {
 	client *s3.Client
 	bucket string
 	prefix string
 }
 
+// s3WriterMetadata is the object metadata marking notes written by the app.
+// The app reports its own changes, so S3 notifications of them are skipped.
+const s3WriterMetadata = "note-writer"
+
+// appWriter tags the objects written by the app
+var appWriter = map[string]string{s3WriterMetadata: "app"}
+
 // NewS3Storage creates a new S3Storage instance
 func NewS3Storage(client *s3.Client, bucket string, prefix string) *S3Storage {
 	return &S3Storage{
@@ -31,6 +43,17 @@ func (ss *S3Storage) objectKey(noteID string) string {
 	return fmt.Sprintf("%s/%s", strings.TrimSuffix(ss.prefix, "/"), noteID)
 }
 
+// noteIDFromKey returns the note stored under an S3 object key, the inverse
+// of objectKey. It reports false for keys outside the prefix and for internal
+// records such as metadata and aliases.
+func (ss *S3Storage) noteIDFromKey(key string) (string, bool) {
+	noteID, ok := strings.CutPrefix(key, ss.objectKey(""))
+	if !ok || !ValidateNoteID(noteID) {
+		return "", false
+	}
+	return noteID, true
+}
+
 // Read retrieves note content from S3
 func (ss *S3Storage) Read(ctx context.Context, noteID string) (string, error) {
 	input := &s3.GetObjectInput{
@@ -61,9 +84,10 @@ func (ss *S3Storage) Read(ctx context.Context, noteID string) (string, error) {
 // Write saves note content to S3
 func (ss *S3Storage) Write(ctx context.Context, noteID string, content string) error {
 	input := &s3.PutObjectInput{
-		Bucket: aws.String(ss.bucket),
-		Key:    aws.String(ss.objectKey(noteID)),
-		Body:   strings.NewReader(content),
+		Bucket:   aws.String(ss.bucket),
+		Key:      aws.String(ss.objectKey(noteID)),
+		Body:     strings.NewReader(content),
+		Metadata: appWriter,
 	}
 
 	_, err := ss.client.PutObject(ctx, input)
@@ -74,6 +98,129 @@ func (ss *S3Storage) Write(ctx context.Context, noteID string, content string) e
 	return nil
 }
 
+// Create saves note content to S3 using a conditional put (If-None-Match: *)
+// so an existing object is never overwritten
+func (ss *S3Storage) Create(ctx context.Context, noteID string, content string) error {
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(ss.bucket),
+		Key:         aws.String(ss.objectKey(noteID)),
+		Body:        strings.NewReader(content),
+		IfNoneMatch: aws.String("*"),
+		Metadata:    appWriter,
+	}
+
+	_, err := ss.client.PutObject(ctx, input)
+	if err != nil {
+		if preconditionFailed(err) {
+			return ErrNoteExists
+		}
+		return fmt.Errorf("failed to create note in S3: %w", err)
+	}
+
+	return nil
+}
+
+// writtenByApp reports whether the object under key, in the given version if
+// set, was written by the app. An object that no longer exists counts as
+// written by the app: its removal is notified on its own.
+func (ss *S3Storage) writtenByApp(ctx context.Context, key string, versionID string) (bool, error) {
+	input := &s3.HeadObjectInput{
+		Bucket: aws.String(ss.bucket),
+		Key:    aws.String(key),
+	}
+	if versionID != "" {
+		input.VersionId = aws.String(versionID)
+	}
+
+	result, err := ss.client.HeadObject(ctx, input)
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && (apiErr.ErrorCode() == "NotFound" || apiErr.ErrorCode() == "NoSuchKey" || apiErr.ErrorCode() == "NoSuchVersion") {
+			return true, nil
+		}
+		return false, fmt.Errorf("failed to read object metadata from S3: %w", err)
+	}
+	return result.Metadata[s3WriterMetadata] == "app", nil
+}
+
+// maxCounterAttempts is how often AddCounter retries an update that raced
+// with another one
+const maxCounterAttempts = 10
+
+// AddCounter implements CounterStorage. Counters are stored as decimal text
+// and updated with conditional puts on the object's ETag, retried when
+// another update came first.
+func (ss *S3Storage) AddCounter(ctx context.Context, key string, delta int64, limit int64) (int64, error) {
+	for attempt := 1; attempt <= maxCounterAttempts; attempt++ {
+		value, etag, err := ss.readCounter(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+		if delta > 0 && limit > 0 && value+delta > limit {
+			return value, ErrCounterLimit
+		}
+		value = max(value+delta, 0)
+
+		input := &s3.PutObjectInput{
+			Bucket:   aws.String(ss.bucket),
+			Key:      aws.String(ss.objectKey(key)),
+			Body:     strings.NewReader(strconv.FormatInt(value, 10)),
+			Metadata: appWriter,
+		}
+		if etag != "" {
+			input.IfMatch = aws.String(etag)
+		} else {
+			input.IfNoneMatch = aws.String("*")
+		}
+		_, err = ss.client.PutObject(ctx, input)
+		if err == nil {
+			return value, nil
+		}
+		if !preconditionFailed(err) {
+			return 0, fmt.Errorf("failed to update counter in S3: %w", err)
+		}
+	}
+	return 0, fmt.Errorf("counter %s is updated too often, gave up after %d attempts", key, maxCounterAttempts)
+}
+
+// ReadCounter implements CounterStorage
+func (ss *S3Storage) ReadCounter(ctx context.Context, key string) (int64, error) {
+	value, _, err := ss.readCounter(ctx, key)
+	return value, err
+}
+
+// readCounter returns a counter with the ETag of its object, which is "" if
+// the counter does not exist
+func (ss *S3Storage) readCounter(ctx context.Context, key string) (int64, string, error) {
+	result, err := ss.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(ss.bucket),
+		Key:    aws.String(ss.objectKey(key)),
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), "NoSuchKey") {
+			return 0, "", nil
+		}
+		return 0, "", fmt.Errorf("failed to read counter from S3: %w", err)
+	}
+	defer func() {
+		_ = result.Body.Close()
+	}()
+	data, err := io.ReadAll(io.LimitReader(result.Body, 64))
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to read counter from S3: %w", err)
+	}
+	// A corrupt counter starts over rather than blocking every update
+	value, _ := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	return value, aws.ToString(result.ETag), nil
+}
+
+// preconditionFailed reports whether a conditional put failed because the
+// object changed or another conditional write is in progress
+func preconditionFailed(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && (apiErr.ErrorCode() == "PreconditionFailed" || apiErr.ErrorCode() == "ConditionalRequestConflict")
+}
+
 // Delete removes a note from S3
 func (ss *S3Storage) Delete(ctx context.Context, noteID string) error {
 	input := &s3.DeleteObjectInput{
@@ -88,3 +235,39 @@ func (ss *S3Storage) Delete(ctx context.Context, noteID string) error {
 
 	return nil
 }
+
+// List returns notes and sub-namespaces directly under a namespace key prefix
+func (ss *S3Storage) List(ctx context.Context, namespace string) ([]string, error) {
+	prefix := strings.TrimSuffix(ss.prefix, "/") + "/"
+	if namespace != "" {
+		prefix += namespace + "/"
+	}
+
+	names := []string{}
+	paginator := s3.NewListObjectsV2Paginator(ss.client, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(ss.bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list notes in S3: %w", err)
+		}
+		for _, p := range page.CommonPrefixes {
+			name := strings.TrimSuffix(strings.TrimPrefix(aws.ToString(p.Prefix), prefix), "/")
+			if ValidateNoteID(name) {
+				names = append(names, name+"/")
+			}
+		}
+		for _, obj := range page.Contents {
+			// Skip internal records (aliases, metadata) and anything else that is not a note
+			name := strings.TrimPrefix(aws.ToString(obj.Key), prefix)
+			if ValidateNoteID(name) {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
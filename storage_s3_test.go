@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// fakeS3 serves objects with ETags and conditional puts
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string]string
+	etags   map[string]int
+	puts    int
+	race    func() // runs before the next conditional put is checked
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/note-bucket/")
+	switch r.Method {
+	case http.MethodGet:
+		f.mu.Lock()
+		content, ok := f.objects[key]
+		etag := f.etags[key]
+		f.mu.Unlock()
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = io.WriteString(w, `<Error><Code>NoSuchKey</Code></Error>`)
+			return
+		}
+		w.Header().Set("ETag", fmt.Sprintf(`"%d"`, etag))
+		_, _ = io.WriteString(w, content)
+	case http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		if race := f.race; race != nil {
+			f.race = nil
+			race()
+		}
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.puts++
+		_, exists := f.objects[key]
+		if r.Header.Get("If-None-Match") == "*" && exists || r.Header.Get("If-Match") != "" && r.Header.Get("If-Match") != fmt.Sprintf(`"%d"`, f.etags[key]) {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			_, _ = io.WriteString(w, `<Error><Code>PreconditionFailed</Code></Error>`)
+			return
+		}
+		f.objects[key] = string(body)
+		f.etags[key]++
+	}
+}
+
+// newFakeS3Storage returns an S3Storage backed by a fakeS3
+func newFakeS3Storage(t *testing.T) (*S3Storage, *fakeS3) {
+	fake := &fakeS3{objects: map[string]string{}, etags: map[string]int{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	client := s3.New(s3.Options{
+		Region:           "us-east-1",
+		BaseEndpoint:     aws.String(server.URL),
+		Credentials:      aws.AnonymousCredentials{},
+		UsePathStyle:     true,
+		RetryMaxAttempts: 1,
+	})
+	return NewS3Storage(client, "note-bucket", "note"), fake
+}
+
+// TestS3StorageCounter tests counters updated with conditional puts
+func TestS3StorageCounter(t *testing.T) {
+	ss, fake := newFakeS3Storage(t)
+	ctx := context.Background()
+
+	if value, err := ss.AddCounter(ctx, ".usage.total", 30, 100); err != nil || value != 30 {
+		t.Fatalf("Expected 30, got %d %v", value, err)
+	}
+	if _, err := ss.AddCounter(ctx, ".usage.total", 80, 100); !errors.Is(err, ErrCounterLimit) {
+		t.Errorf("Expected ErrCounterLimit, got %v", err)
+	}
+
+	// An update racing with another one is retried on the new value
+	fake.race = func() {
+		_, _ = ss.AddCounter(ctx, ".usage.total", 5, 0)
+	}
+	fake.puts = 0
+	if value, err := ss.AddCounter(ctx, ".usage.total", 10, 100); err != nil || value != 45 {
+		t.Errorf("Expected 45, got %d %v", value, err)
+	}
+	if fake.puts != 3 {
+		t.Errorf("Expected a retried put, got %d puts", fake.puts)
+	}
+
+	// Counters never drop below zero
+	if value, err := ss.AddCounter(ctx, ".usage.total", -100, 0); err != nil || value != 0 {
+		t.Errorf("Expected 0, got %d %v", value, err)
+	}
+	if value, err := ss.ReadCounter(ctx, ".usage.total"); err != nil || value != 0 {
+		t.Errorf("Expected 0, got %d %v", value, err)
+	}
+}
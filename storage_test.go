@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"os"
 	"path/filepath"
 	"testing"
@@ -83,6 +84,30 @@ func TestLocalStorageWrite(t *testing.T) {
 	}
 }
 
+func TestLocalStorageCreate(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	storage, err := NewLocalStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create local storage: %v", err)
+	}
+
+	if err := storage.Create(context.Background(), "test123", "first"); err != nil {
+		t.Fatalf("Failed to create note: %v", err)
+	}
+
+	// A second create must not overwrite the existing note
+	err = storage.Create(context.Background(), "test123", "second")
+	if !errors.Is(err, ErrNoteExists) {
+		t.Fatalf("Expected ErrNoteExists, got %v", err)
+	}
+
+	content, _ := storage.Read(context.Background(), "test123")
+	if content != "first" {
+		t.Errorf("Expected first, got %s", content)
+	}
+}
+
 func TestLocalStorageDelete(t *testing.T) {
 	tmpDir := t.TempDir()
 
@@ -135,3 +160,86 @@ func TestLocalStorageCreateDir(t *testing.T) {
 		t.Fatalf("Failed to write to created directory: %v", err)
 	}
 }
+
+func TestLocalStorageNamespaces(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	storage, err := NewLocalStorage(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create local storage: %v", err)
+	}
+	ctx := context.Background()
+
+	if err := storage.Write(ctx, "infra/dns/cutover", "plan"); err != nil {
+		t.Fatalf("Failed to write namespaced note: %v", err)
+	}
+	if err := storage.Create(ctx, "infra/readme", "hello"); err != nil {
+		t.Fatalf("Failed to create namespaced note: %v", err)
+	}
+	_ = storage.Write(ctx, ".alias.infra-dns", `{"noteId":"infra/dns/cutover"}`)
+
+	// Namespaces map to subdirectories
+	if _, err := os.Stat(filepath.Join(tmpDir, "infra", "dns", "cutover")); err != nil {
+		t.Fatalf("Namespaced note not stored in subdirectory: %v", err)
+	}
+
+	content, _ := storage.Read(ctx, "infra/dns/cutover")
+	if content != "plan" {
+		t.Errorf("Expected plan, got %s", content)
+	}
+
+	// A namespace is not a note
+	if content, err := storage.Read(ctx, "infra/dns"); err != nil || content != "" {
+		t.Errorf("Expected namespace read to behave as missing note, got %q %v", content, err)
+	}
+
+	root, _ := storage.List(ctx, "")
+	if len(root) != 1 || root[0] != "infra/" {
+		t.Errorf("Expected [infra/], got %v", root)
+	}
+	infra, _ := storage.List(ctx, "infra")
+	if len(infra) != 2 || infra[0] != "dns/" || infra[1] != "readme" {
+		t.Errorf("Expected [dns/ readme], got %v", infra)
+	}
+
+	// Deleting the last note of a namespace removes the empty directory
+	if err := storage.Delete(ctx, "infra/dns/cutover"); err != nil {
+		t.Fatalf("Failed to delete note: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "infra", "dns")); err == nil {
+		t.Errorf("Empty namespace directory was not removed")
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "infra")); err != nil {
+		t.Errorf("Non-empty namespace directory was removed")
+	}
+}
+
+func TestLocalStoragePathTraversal(t *testing.T) {
+	tmpDir := t.TempDir()
+	noteDir := filepath.Join(tmpDir, "note")
+
+	storage, err := NewLocalStorage(noteDir)
+	if err != nil {
+		t.Fatalf("Failed to create local storage: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "secret"), []byte("secret"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	ctx := context.Background()
+
+	for _, key := range []string{"../secret", "a/../../secret", "/etc/passwd", "", `..\secret`} {
+		if _, err := storage.Read(ctx, key); !errors.Is(err, errInvalidKey) {
+			t.Errorf("Read(%q): expected errInvalidKey, got %v", key, err)
+		}
+		if err := storage.Write(ctx, key, "x"); !errors.Is(err, errInvalidKey) {
+			t.Errorf("Write(%q): expected errInvalidKey, got %v", key, err)
+		}
+		if err := storage.Delete(ctx, key); !errors.Is(err, errInvalidKey) {
+			t.Errorf("Delete(%q): expected errInvalidKey, got %v", key, err)
+		}
+	}
+
+	if content, _ := os.ReadFile(filepath.Join(tmpDir, "secret")); string(content) != "secret" {
+		t.Errorf("File outside the note directory was modified")
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// certCheckInterval is how often the certificate files are checked for changes
+const certCheckInterval = 10 * time.Second
+
+// TLSConfig configures HTTPS in HTTP server mode; it is enabled when a
+// certificate and key are set
+type TLSConfig struct {
+	CertFile     string `yaml:"cert_file"`
+	KeyFile      string `yaml:"key_file"`
+	ClientCAFile string `yaml:"client_ca_file"` // CA bundle verifying client certificates
+	ClientAuth   string `yaml:"client_auth"`    // none, optional or require
+	RedirectPort int    `yaml:"redirect_port"`  // plain HTTP port redirecting to HTTPS; 0 disables
+}
+
+// Enabled reports whether HTTPS is configured
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != "" || c.KeyFile != ""
+}
+
+// clientAuthType maps the client_auth setting to the crypto/tls policy
+func (c TLSConfig) clientAuthType() (tls.ClientAuthType, error) {
+	switch strings.ToLower(c.ClientAuth) {
+	case "", "none":
+		return tls.NoClientCert, nil
+	case "optional":
+		return tls.VerifyClientCertIfGiven, nil
+	case "require":
+		return tls.RequireAndVerifyClientCert, nil
+	default:
+		return 0, fmt.Errorf("invalid client auth %q (use none, optional or require)", c.ClientAuth)
+	}
+}
+
+// validate checks that the TLS settings are complete and consistent
+func (c TLSConfig) validate(port int) error {
+	if !c.Enabled() {
+		if c.ClientCAFile != "" || c.RedirectPort != 0 {
+			return errors.New("a certificate and key are required for client certificates and redirects")
+		}
+		return nil
+	}
+	if c.CertFile == "" || c.KeyFile == "" {
+		return errors.New("both a certificate and a key file are required")
+	}
+	clientAuth, err := c.clientAuthType()
+	if err != nil {
+		return err
+	}
+	if clientAuth != tls.NoClientCert && c.ClientCAFile == "" {
+		return errors.New("a client CA file is required to verify client certificates")
+	}
+	if c.RedirectPort < 0 || c.RedirectPort > 65535 || (c.RedirectPort != 0 && c.RedirectPort == port) {
+		return fmt.Errorf("invalid redirect port %d", c.RedirectPort)
+	}
+	return nil
+}
+
+// NewServerTLSConfig creates the TLS configuration of the HTTPS server. The
+// certificate is reloaded when its files change, client certificates are
+// verified against the client CA and HTTP/2 is offered.
+func NewServerTLSConfig(cfg TLSConfig) (*tls.Config, error) {
+	reloader, err := NewCertReloader(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	clientAuth, err := cfg.clientAuthType()
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+		NextProtos:     []string{"h2", "http/1.1"},
+		ClientAuth:     clientAuth,
+	}
+	if cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(cfg.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA file: %w", err)
+		}
+		tlsConfig.ClientCAs = x509.NewCertPool()
+		if !tlsConfig.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in client CA file %s", cfg.ClientCAFile)
+		}
+		if clientAuth == tls.NoClientCert {
+			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+	return tlsConfig, nil
+}
+
+// CertReloader serves a certificate loaded from files and reloads it when
+// they change, so renewed certificates are picked up without a restart
+type CertReloader struct {
+	certFile string
+	keyFile  string
+	interval time.Duration
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	loaded  time.Time // latest modification time of the loaded files
+	checked time.Time
+	now     func() time.Time
+}
+
+// NewCertReloader loads the certificate and key, failing when they are invalid
+func NewCertReloader(certFile string, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{certFile: certFile, keyFile: keyFile, interval: certCheckInterval, now: time.Now}
+	modTime, err := r.modTime()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.load(modTime); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// modTime returns the latest modification time of the certificate and key
+func (r *CertReloader) modTime() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to read TLS certificate: %w", err)
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// load reads the certificate and key
+func (r *CertReloader) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	r.cert, r.loaded = &cert, modTime
+	return nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate. A certificate that
+// fails to reload, e.g. while only one of the files has been replaced, is
+// retried at the next check and the previous one is served meanwhile.
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if now := r.now(); now.Sub(r.checked) >= r.interval {
+		r.checked = now
+		modTime, err := r.modTime()
+		if err == nil && !modTime.Equal(r.loaded) {
+			err = r.load(modTime)
+			if err == nil {
+				slog.Info("TLS certificate reloaded", "cert", r.certFile)
+			}
+		}
+		if err != nil {
+			slog.Warn("Keeping previous TLS certificate", "error", err)
+		}
+	}
+	return r.cert, nil
+}
+
+// HTTPSRedirect redirects every request to the same URL over HTTPS on
+// httpsPort
+func HTTPSRedirect(httpsPort int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if strings.Contains(host, ":") {
+			host = "[" + host + "]" // IPv6 literal
+		}
+		if httpsPort != 443 {
+			host += ":" + strconv.Itoa(httpsPort)
+		}
+		// 308 keeps the method and body of saves
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
+
+// clientCertPrincipal returns the principal of a verified client certificate,
+// named after its common name or else its first email address
+func clientCertPrincipal(r *http.Request) *Principal {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	name := cert.Subject.CommonName
+	if name == "" && len(cert.EmailAddresses) > 0 {
+		name = cert.EmailAddresses[0]
+	}
+	if name == "" {
+		return nil
+	}
+	return &Principal{Name: name, Method: "mtls", Scopes: defaultScopes}
+}
+
+// startRedirectServer serves redirects to HTTPS on httpsPort from ln in the
+// background and returns a function shutting it down. A nil ln disables
+// redirects.
+func startRedirectServer(ln net.Listener, httpsPort int) func(ctx context.Context) {
+	if ln == nil {
+		return func(context.Context) {}
+	}
+	server := &http.Server{
+		Handler:      HTTPSRedirect(httpsPort),
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 5 * time.Second,
+	}
+	go func() {
+		slog.Info("Redirecting HTTP to HTTPS", "address", ln.Addr().String())
+		if err := server.Serve(ln); err != nil && err != http.ErrServerClosed {
+			fatal("Redirect server error", "error", err)
+		}
+	}()
+	return func(ctx context.Context) {
+		if err := server.Shutdown(ctx); err != nil {
+			slog.Error("Redirect server shutdown error", "error", err)
+		}
+	}
+}
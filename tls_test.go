@@ -0,0 +1,218 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCert is a certificate and key generated for tests
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+// newTestCert creates a certificate signed by parent, or a self-signed CA
+// when parent is nil
+func newTestCert(t *testing.T, name string, parent *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA, template.BasicConstraintsValid = true, true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCert{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// keyPEM encodes the private key
+func (c *testCert) keyPEM(t *testing.T) []byte {
+	t.Helper()
+	der, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatalf("Failed to encode key: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+}
+
+// tlsKeyPair converts the certificate for a TLS client
+func (c *testCert) tlsKeyPair(t *testing.T) tls.Certificate {
+	t.Helper()
+	pair, err := tls.X509KeyPair(c.pem, c.keyPEM(t))
+	if err != nil {
+		t.Fatalf("Failed to build key pair: %v", err)
+	}
+	return pair
+}
+
+// writeCertFiles writes the certificate and key into dir at the given time
+func writeCertFiles(t *testing.T, dir string, c *testCert, modTime time.Time) (string, string) {
+	t.Helper()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	for path, data := range map[string][]byte{certFile: c.pem, keyFile: c.keyPEM(t)} {
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+		_ = os.Chtimes(path, modTime, modTime)
+	}
+	return certFile, keyFile
+}
+
+// TestCertReloader tests that renewed certificates are picked up and broken
+// ones are ignored
+func TestCertReloader(t *testing.T) {
+	ca := newTestCert(t, "Test CA", nil)
+	first, second := newTestCert(t, "first", ca), newTestCert(t, "second", ca)
+	dir := t.TempDir()
+	start := time.Now().Add(-time.Minute)
+	certFile, keyFile := writeCertFiles(t, dir, first, start)
+
+	reloader, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("Failed to load certificate: %v", err)
+	}
+	now := time.Now()
+	reloader.now = func() time.Time { return now }
+	served := func() string {
+		cert, err := reloader.GetCertificate(nil)
+		if err != nil {
+			t.Fatalf("GetCertificate failed: %v", err)
+		}
+		return cert.Leaf.Subject.CommonName
+	}
+	if name := served(); name != "first" {
+		t.Fatalf("Expected first certificate, got %s", name)
+	}
+
+	writeCertFiles(t, dir, second, start.Add(time.Second))
+	if name := served(); name != "first" {
+		t.Errorf("Expected files to be checked only every %s, got %s", certCheckInterval, name)
+	}
+	now = now.Add(certCheckInterval)
+	if name := served(); name != "second" {
+		t.Errorf("Expected renewed certificate, got %s", name)
+	}
+
+	// A half-written renewal keeps the previous certificate
+	_ = os.WriteFile(certFile, []byte("garbage"), 0600)
+	_ = os.Chtimes(certFile, start.Add(2*time.Second), start.Add(2*time.Second))
+	now = now.Add(certCheckInterval)
+	if name := served(); name != "second" {
+		t.Errorf("Expected previous certificate to be kept, got %s", name)
+	}
+
+	if _, err := NewCertReloader(certFile, keyFile); err == nil {
+		t.Errorf("Expected invalid certificate to fail at startup")
+	}
+}
+
+// TestTLSServer tests HTTP/2 and client certificate authentication
+func TestTLSServer(t *testing.T) {
+	ca := newTestCert(t, "Test CA", nil)
+	dir := t.TempDir()
+	certFile, keyFile := writeCertFiles(t, dir, newTestCert(t, "server", ca), time.Now())
+	caFile := filepath.Join(dir, "ca.pem")
+	_ = os.WriteFile(caFile, ca.pem, 0600)
+
+	tlsConfig, err := NewServerTLSConfig(TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile, ClientAuth: "optional"})
+	if err != nil {
+		t.Fatalf("Failed to create TLS config: %v", err)
+	}
+	auth, err := NewAuthenticatorFromConfig(AuthConfig{ClientCerts: true})
+	if err != nil {
+		t.Fatalf("Failed to create authenticator: %v", err)
+	}
+	handler := auth.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Proto+" "+PrincipalFromContext(r.Context()).String())
+	}))
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	server := &http.Server{Handler: handler, TLSConfig: tlsConfig}
+	go func() { _ = server.ServeTLS(ln, "", "") }()
+	t.Cleanup(func() { _ = server.Close() })
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	get := func(certs ...tls.Certificate) (int, string) {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{RootCAs: roots, Certificates: certs},
+			ForceAttemptHTTP2: true,
+		}}
+		resp, err := client.Get("https://" + ln.Addr().String() + "/noteid/ABCDE")
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		defer func() {
+			_ = resp.Body.Close()
+		}()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	if status, body := get(newTestCert(t, "alice", ca).tlsKeyPair(t)); status != http.StatusOK || body != "HTTP/2.0 alice (mtls)" {
+		t.Errorf("Expected client certificate to authenticate over HTTP/2, got %d %q", status, body)
+	}
+	if status, _ := get(); status != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without a client certificate, got %d", status)
+	}
+}
+
+// TestHTTPSRedirect tests redirects of the plain HTTP port
+func TestHTTPSRedirect(t *testing.T) {
+	tests := []struct {
+		port     int
+		host     string
+		target   string
+		expected string
+	}{
+		{443, "note.example.com", "/noteid/ABCDE?raw=1", "https://note.example.com/noteid/ABCDE?raw=1"},
+		{443, "note.example.com:80", "/", "https://note.example.com/"},
+		{8443, "note.example.com:8080", "/ns/team", "https://note.example.com:8443/ns/team"},
+		{8443, "[::1]:8080", "/", "https://[::1]:8443/"},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("POST", test.target, nil)
+		req.Host = test.host
+		rec := httptest.NewRecorder()
+		HTTPSRedirect(test.port).ServeHTTP(rec, req)
+		if rec.Code != http.StatusPermanentRedirect || rec.Header().Get("Location") != test.expected {
+			t.Errorf("%s%s: expected 308 to %s, got %d %s", test.host, test.target, test.expected, rec.Code, rec.Header().Get("Location"))
+		}
+	}
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation scope of the app's spans
+const tracerName = "note"
+
+// tracerProvider is the SDK provider installed by InitTracingFromEnv; nil when
+// tracing is disabled
+var tracerProvider *sdktrace.TracerProvider
+
+// tracingEnabled reports whether the standard OTEL_* variables ask for OTLP
+// trace export. An endpoint must be configured, so nothing is exported by
+// default.
+func tracingEnabled() bool {
+	if strings.EqualFold(os.Getenv("OTEL_SDK_DISABLED"), "true") {
+		return false
+	}
+	switch strings.ToLower(os.Getenv("OTEL_TRACES_EXPORTER")) {
+	case "none":
+		return false
+	case "otlp":
+		return true
+	}
+	return os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != ""
+}
+
+// InitTracingFromEnv installs the W3C trace-context propagator and, when
+// configured, an OTLP/HTTP exporter. Endpoint, headers, sampling and
+// resource attributes come from the standard OTEL_* variables.
+func InitTracingFromEnv(ctx context.Context) error {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if !tracingEnabled() {
+		return nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return err
+	}
+	// Attributes from OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES take precedence
+	res, err := resource.New(ctx,
+		resource.WithAttributes(
+			attribute.String("service.name", tracerName),
+			attribute.String("service.version", Version),
+		),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil && !errors.Is(err, resource.ErrPartialResource) {
+		return err
+	}
+	tracerProvider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(tracerProvider)
+	slog.Info("Tracing enabled", "exporter", "otlp")
+	return nil
+}
+
+// flushTraces exports pending spans, for runtimes that may freeze between
+// requests such as Lambda
+func flushTraces(ctx context.Context) {
+	if tracerProvider == nil {
+		return
+	}
+	if err := tracerProvider.ForceFlush(ctx); err != nil {
+		slog.WarnContext(ctx, "Failed to flush traces", "error", err)
+	}
+}
+
+// shutdownTracing flushes and stops the tracer provider
+func shutdownTracing(ctx context.Context) {
+	if tracerProvider == nil {
+		return
+	}
+	if err := tracerProvider.Shutdown(ctx); err != nil {
+		slog.WarnContext(ctx, "Failed to shut down tracing", "error", err)
+	}
+}
+
+// startSpan starts a span as a child of the span in ctx
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.GetTracerProvider().Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan records err on span, if any, and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// extractTraceContext continues the trace of the incoming headers, unless ctx
+// already carries a span (such as the Lambda invocation span)
+func extractTraceContext(ctx context.Context, carrier propagation.TextMapCarrier) context.Context {
+	if trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, carrier)
+}
+
+// TracingMiddleware wraps every request but probes in a server span named
+// after the route it is served by in mux
+func TracingMiddleware(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isProbePath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		route, method := routeLabel(mux, r), methodLabel(r)
+		ctx := extractTraceContext(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := otel.GetTracerProvider().Tracer(tracerName).Start(ctx, method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", method),
+				attribute.String("http.route", route),
+				attribute.String("url.path", r.URL.Path),
+				attribute.String("client.address", ClientIP(r)),
+			),
+		)
+		defer span.End()
+		if id := RequestIDFromContext(ctx); id != "" {
+			span.SetAttributes(attribute.String("request.id", id))
+		}
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		span.SetAttributes(attribute.Int("http.response.status_code", rec.status))
+		if rec.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rec.status))
+		}
+	})
+}
+
+// TracedStorage wraps a Storage and records a span for every operation
+type TracedStorage struct {
+	Storage
+}
+
+// TraceStorage wraps storage with spans, returning it unchanged when tracing
+// is disabled
+func TraceStorage(storage Storage) Storage {
+	if tracerProvider == nil {
+		return storage
+	}
+	return &TracedStorage{Storage: storage}
+}
+
+// storageSpan starts the span of a storage operation on key
+func storageSpan(ctx context.Context, op string, key string) (context.Context, trace.Span) {
+	return startSpan(ctx, "Storage."+op,
+		attribute.String("note.storage.operation", strings.ToLower(op)),
+		attribute.String("note.storage.key", key),
+	)
+}
+
+// Read implements Storage
+func (s *TracedStorage) Read(ctx context.Context, noteID string) (string, error) {
+	ctx, span := storageSpan(ctx, "Read", noteID)
+	content, err := s.Storage.Read(ctx, noteID)
+	span.SetAttributes(attribute.Int("note.size", len(content)))
+	endSpan(span, err)
+	return content, err
+}
+
+// Write implements Storage
+func (s *TracedStorage) Write(ctx context.Context, noteID string, content string) error {
+	ctx, span := storageSpan(ctx, "Write", noteID)
+	span.SetAttributes(attribute.Int("note.size", len(content)))
+	err := s.Storage.Write(ctx, noteID, content)
+	endSpan(span, err)
+	return err
+}
+
+// Create implements Storage
+func (s *TracedStorage) Create(ctx context.Context, noteID string, content string) error {
+	ctx, span := storageSpan(ctx, "Create", noteID)
+	span.SetAttributes(attribute.Int("note.size", len(content)))
+	err := s.Storage.Create(ctx, noteID, content)
+	if errors.Is(err, ErrNoteExists) {
+		// An expected outcome the caller retries, not a failure
+		span.SetAttributes(attribute.Bool("note.exists", true))
+		endSpan(span, nil)
+		return err
+	}
+	endSpan(span, err)
+	return err
+}
+
+// Delete implements Storage
+func (s *TracedStorage) Delete(ctx context.Context, noteID string) error {
+	ctx, span := storageSpan(ctx, "Delete", noteID)
+	err := s.Storage.Delete(ctx, noteID)
+	endSpan(span, err)
+	return err
+}
+
+// List implements Storage
+func (s *TracedStorage) List(ctx context.Context, namespace string) ([]string, error) {
+	ctx, span := storageSpan(ctx, "List", namespace)
+	names, err := s.Storage.List(ctx, namespace)
+	span.SetAttributes(attribute.Int("note.entries", len(names)))
+	endSpan(span, err)
+	return names, err
+}
+
+// ReadVersion implements VersionedStorage
+func (s *TracedStorage) ReadVersion(ctx context.Context, noteID string) (string, int64, error) {
+	vs, ok := s.Storage.(VersionedStorage)
+	if !ok {
+		return "", 0, errors.ErrUnsupported
+	}
+	ctx, span := storageSpan(ctx, "ReadVersion", noteID)
+	content, version, err := vs.ReadVersion(ctx, noteID)
+	span.SetAttributes(attribute.Int("note.size", len(content)), attribute.Int64("note.version", version))
+	endSpan(span, err)
+	return content, version, err
+}
+
+// WriteVersion implements VersionedStorage
+func (s *TracedStorage) WriteVersion(ctx context.Context, noteID string, content string, version int64) (int64, error) {
+	vs, ok := s.Storage.(VersionedStorage)
+	if !ok {
+		return 0, errors.ErrUnsupported
+	}
+	ctx, span := storageSpan(ctx, "WriteVersion", noteID)
+	span.SetAttributes(attribute.Int("note.size", len(content)), attribute.Int64("note.version", version))
+	saved, err := vs.WriteVersion(ctx, noteID, content, version)
+	if errors.Is(err, ErrVersionConflict) {
+		// An expected outcome reported to the editor, not a failure
+		span.SetAttributes(attribute.Bool("note.conflict", true))
+		endSpan(span, nil)
+		return saved, err
+	}
+	endSpan(span, err)
+	return saved, err
+}
+
+// ListByOwner implements OwnerIndex
+func (s *TracedStorage) ListByOwner(ctx context.Context, owner string) ([]string, error) {
+	index, ok := s.Storage.(OwnerIndex)
+	if !ok {
+		return nil, errors.ErrUnsupported
+	}
+	// User names are kept out of traces
+	ctx, span := storageSpan(ctx, "ListByOwner", "")
+	ids, err := index.ListByOwner(ctx, owner)
+	span.SetAttributes(attribute.Int("note.entries", len(ids)))
+	endSpan(span, err)
+	return ids, err
+}
+
+// AddCounter implements CounterStorage
+func (s *TracedStorage) AddCounter(ctx context.Context, key string, delta int64, limit int64) (int64, error) {
+	counters, ok := s.Storage.(CounterStorage)
+	if !ok {
+		return 0, errors.ErrUnsupported
+	}
+	ctx, span := storageSpan(ctx, "AddCounter", key)
+	span.SetAttributes(attribute.Int64("note.counter.delta", delta))
+	value, err := counters.AddCounter(ctx, key, delta, limit)
+	if errors.Is(err, ErrCounterLimit) {
+		span.SetAttributes(attribute.Bool("note.counter.limit", true))
+		endSpan(span, nil)
+		return value, err
+	}
+	endSpan(span, err)
+	return value, err
+}
+
+// ReadCounter implements CounterStorage
+func (s *TracedStorage) ReadCounter(ctx context.Context, key string) (int64, error) {
+	counters, ok := s.Storage.(CounterStorage)
+	if !ok {
+		return 0, errors.ErrUnsupported
+	}
+	ctx, span := storageSpan(ctx, "ReadCounter", key)
+	value, err := counters.ReadCounter(ctx, key)
+	endSpan(span, err)
+	return value, err
+}
+
+// Unwrap returns the wrapped storage
+func (s *TracedStorage) Unwrap() Storage {
+	return s.Storage
+}
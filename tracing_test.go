@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+const testTraceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+// useInMemoryTracing records spans in memory for the duration of the test
+func useInMemoryTracing(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	prevProvider, prevPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(prevProvider)
+		otel.SetTextMapPropagator(prevPropagator)
+	})
+	return exporter
+}
+
+// spansByName indexes the recorded spans by name
+func spansByName(exporter *tracetest.InMemoryExporter) map[string]tracetest.SpanStub {
+	spans := make(map[string]tracetest.SpanStub)
+	for _, span := range exporter.GetSpans() {
+		spans[span.Name] = span
+	}
+	return spans
+}
+
+// TestTracingHTTP tests spans of an autosave and propagation of the incoming trace
+func TestTracingHTTP(t *testing.T) {
+	exporter := useInMemoryTracing(t)
+	storage := &TracedStorage{Storage: NewMockStorage()}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", HandlePost(storage))
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`{"content":"traced"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("traceparent", testTraceParent)
+	rec := httptest.NewRecorder()
+	TracingMiddleware(mux, mux).ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected note to be saved, got %d", rec.Code)
+	}
+
+	spans := spansByName(exporter)
+	server, ok := spans["POST /"]
+	if !ok {
+		t.Fatalf("Expected server span, got %v", spans)
+	}
+	if server.SpanContext.TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" || server.Parent.SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("Expected server span to continue the incoming trace, got %s parent %s", server.SpanContext.TraceID(), server.Parent.SpanID())
+	}
+
+	parents := map[string]string{
+		"HandlePost":       "POST /",
+		"parseNoteRequest": "HandlePost",
+		"Storage.Create":   "HandlePost",
+	}
+	for name, parent := range parents {
+		span, ok := spans[name]
+		if !ok {
+			t.Errorf("Expected span %s", name)
+			continue
+		}
+		if span.Parent.SpanID() != spans[parent].SpanContext.SpanID() {
+			t.Errorf("Expected span %s to be a child of %s", name, parent)
+		}
+	}
+}
+
+// TestTracingMethod tests that nonstandard methods do not name spans
+func TestTracingMethod(t *testing.T) {
+	exporter := useInMemoryTracing(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {})
+
+	req := httptest.NewRequest("X-RANDOM-1", "/", nil)
+	TracingMiddleware(mux, mux).ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := spansByName(exporter)
+	server, ok := spans["OTHER /"]
+	if !ok {
+		t.Fatalf("Expected server span OTHER /, got %v", spans)
+	}
+	for _, attr := range server.Attributes {
+		if attr.Key == "http.request.method" && attr.Value.AsString() != "OTHER" {
+			t.Errorf("Expected method attribute OTHER, got %s", attr.Value.AsString())
+		}
+	}
+}
+
+// TestTracingLambda tests the invocation and event translation spans in Lambda mode
+func TestTracingLambda(t *testing.T) {
+	exporter := useInMemoryTracing(t)
+	lambdaRouter = NewRouter(NewMockStorage(), RouterConfig{})
+
+	event := events.APIGatewayV2HTTPRequest{
+		RawPath: "/r/missing",
+		Headers: map[string]string{"Traceparent": testTraceParent},
+	}
+	event.RequestContext.HTTP.Method = "GET"
+	payload, _ := json.Marshal(event)
+	if _, err := LambdaHandler(context.Background(), payload); err != nil {
+		t.Fatalf("Lambda handler failed: %v", err)
+	}
+
+	spans := spansByName(exporter)
+	invocation, ok := spans["LambdaHandler"]
+	if !ok || invocation.SpanContext.TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("Expected invocation span in the incoming trace, got %v", spans)
+	}
+	for _, name := range []string{"createRequestFromV2", "GET /r/"} {
+		if spans[name].Parent.SpanID() != invocation.SpanContext.SpanID() {
+			t.Errorf("Expected span %s to be a child of the invocation span", name)
+		}
+	}
+}
@@ -2,31 +2,36 @@ package main
 
 import (
 	"html"
-	"math/rand"
 	"net"
 	"net/http"
+	"net/netip"
 	"regexp"
 	"strings"
 )
 
-// ValidateNoteID checks if a note ID is valid (alphanumeric only)
+// Limits for path-style note IDs such as infra/dns/cutover
+const (
+	maxNoteIDLength   = 255
+	maxNamespaceDepth = 8
+)
+
+var noteIDPattern = regexp.MustCompile(`^[a-zA-Z0-9]+(/[a-zA-Z0-9]+)*$`)
+
+// ValidateNoteID checks if a note ID is valid: alphanumeric segments, optionally
+// separated by slashes to place the note in a namespace (e.g. infra/dns/cutover)
 func ValidateNoteID(noteID string) bool {
-	if noteID == "" {
+	if noteID == "" || len(noteID) > maxNoteIDLength {
 		return false
 	}
-	matched, _ := regexp.MatchString("^[a-zA-Z0-9]+$", noteID)
-	return matched
+	if !noteIDPattern.MatchString(noteID) {
+		return false
+	}
+	return strings.Count(noteID, "/") < maxNamespaceDepth
 }
 
-// GenerateNoteID creates a random 5-character alphanumeric note ID
-func GenerateNoteID() string {
-	const charset = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
-	const length = 5
-	b := make([]byte, length)
-	for i := range b {
-		b[i] = charset[rand.Intn(len(charset))]
-	}
-	return string(b)
+// ValidateNamespace checks if a namespace is valid; the empty string is the root namespace
+func ValidateNamespace(namespace string) bool {
+	return namespace == "" || ValidateNoteID(namespace)
 }
 
 // EscapeHTML escapes HTML special characters
@@ -34,61 +39,115 @@ func EscapeHTML(s string) string {
 	return html.EscapeString(s)
 }
 
-// ClientIP attempts to determine the real client IP when running behind proxies.
-// Preference order:
-// - Forwarded: for=...
-// - X-Forwarded-For: first IP in list
-// - X-Real-IP
-// - r.RemoteAddr
+// defaultTrustedProxies covers proxies on the same host. Private ranges are
+// not trusted by default, since peers on a shared network such as a Docker
+// bridge could otherwise spoof their address.
+const defaultTrustedProxies = "127.0.0.0/8, ::1/128"
+
+// trustedProxies are the peers whose forwarding headers ClientIP honours
+var trustedProxies, _ = ParsePrefixes(defaultTrustedProxies)
+
+// ParseTrustedProxies parses the configured CIDRs or addresses of trusted
+// proxies; an empty list trusts no proxy
+func ParseTrustedProxies(list []string) ([]netip.Prefix, error) {
+	return ParsePrefixes(strings.Join(list, ","))
+}
+
+// unixSocketPeer is the remote address of connections to a Unix socket. Only
+// local processes can connect, so they are trusted like loopback proxies.
+const unixSocketPeer = "@"
+
+// ClientIP determines the real client IP when running behind proxies.
+// Forwarding headers are only honoured when the direct peer is a trusted
+// proxy. The forwarded chain (Forwarded, else X-Forwarded-For, else X-Real-IP)
+// is then walked from the right, skipping trusted proxies, and the first
+// untrusted hop is the client. That hop may be an RFC 7239 obfuscated
+// identifier such as "_hidden" or "unknown".
 func ClientIP(r *http.Request) string {
 	if r == nil {
 		return ""
 	}
 
-	if fwd := r.Header.Get("Forwarded"); fwd != "" {
-		// Example: Forwarded: for=203.0.113.60;proto=https;by=203.0.113.43
-		parts := strings.Split(fwd, ";")
-		for _, p := range parts {
-			p = strings.TrimSpace(p)
-			if strings.HasPrefix(strings.ToLower(p), "for=") {
-				v := strings.TrimSpace(p[4:])
-				v = strings.Trim(v, "\"")
-				// Could be: ip, [ip]:port, ip:port
-				v = strings.TrimPrefix(v, "[")
-				v = strings.TrimSuffix(v, "]")
-				if host, _, err := net.SplitHostPort(v); err == nil {
-					return host
-				}
-				// Might be just an IP without port
-				if ip := net.ParseIP(v); ip != nil {
-					return v
+	remote := stripPort(r.RemoteAddr)
+	if !isTrustedProxy(remote) && !(remote == unixSocketPeer && len(trustedProxies) > 0) {
+		return remote
+	}
+
+	chain := forwardedChain(r)
+	for i := len(chain) - 1; i >= 0; i-- {
+		if !isTrustedProxy(chain[i]) {
+			return chain[i]
+		}
+	}
+	if len(chain) > 0 {
+		// Every hop is a trusted proxy; the leftmost one is the closest to the client
+		return chain[0]
+	}
+	return remote
+}
+
+// isTrustedProxy reports whether node is the address of a trusted proxy
+func isTrustedProxy(node string) bool {
+	return prefixesContain(trustedProxies, node)
+}
+
+// forwardedChain returns the client and proxy addresses recorded by proxies,
+// ordered from the original client to the nearest proxy
+func forwardedChain(r *http.Request) []string {
+	var chain []string
+	if values := r.Header.Values("Forwarded"); len(values) > 0 {
+		// Example: Forwarded: for=192.0.2.60;proto=https, for="[2001:db8::1]:4711"
+		for _, element := range splitHeaderList(strings.Join(values, ",")) {
+			for _, pair := range strings.Split(element, ";") {
+				name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(strings.TrimSpace(name), "for") {
+					chain = append(chain, stripPort(strings.Trim(strings.TrimSpace(value), `"`)))
 				}
-				return v
 			}
 		}
+		return chain
 	}
 
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		// First IP is the original client.
-		first := strings.TrimSpace(strings.Split(xff, ",")[0])
-		first = strings.Trim(first, "\"")
-		first = strings.TrimPrefix(first, "[")
-		first = strings.TrimSuffix(first, "]")
-		if host, _, err := net.SplitHostPort(first); err == nil {
-			return host
+	if values := r.Header.Values("X-Forwarded-For"); len(values) > 0 {
+		for _, hop := range strings.Split(strings.Join(values, ","), ",") {
+			if hop = strings.Trim(strings.TrimSpace(hop), `"`); hop != "" {
+				chain = append(chain, stripPort(hop))
+			}
 		}
-		return first
+		return chain
 	}
 
 	if xrip := strings.TrimSpace(r.Header.Get("X-Real-IP")); xrip != "" {
-		if host, _, err := net.SplitHostPort(xrip); err == nil {
-			return host
+		chain = append(chain, stripPort(xrip))
+	}
+	return chain
+}
+
+// splitHeaderList splits a comma-separated header value, ignoring commas inside quoted strings
+func splitHeaderList(value string) []string {
+	var items []string
+	start, quoted := 0, false
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '"':
+			quoted = !quoted
+		case '\\':
+			i++ // skip the escaped character
+		case ',':
+			if !quoted {
+				items = append(items, value[start:i])
+				start = i + 1
+			}
 		}
-		return xrip
 	}
+	return append(items, value[start:])
+}
 
-	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+// stripPort removes an optional port and IPv6 brackets from a node address:
+// ip, ip:port, [ip] or [ip]:port. Obfuscated identifiers are returned unchanged.
+func stripPort(node string) string {
+	if host, _, err := net.SplitHostPort(node); err == nil {
 		return host
 	}
-	return r.RemoteAddr
+	return strings.TrimSuffix(strings.TrimPrefix(node, "["), "]")
 }
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"net/http/httptest"
+	"net/netip"
+	"os"
 	"regexp"
 	"testing"
 )
@@ -22,6 +25,15 @@ func TestValidateNoteID(t *testing.T) {
 		{"abc def", false},
 		{"abc.def", false},
 		{"../etc", false},
+		{"infra/dns/cutover", true},
+		{"a/b", true},
+		{"infra//dns", false},
+		{"/infra", false},
+		{"infra/", false},
+		{"infra/../etc", false},
+		{"infra\\dns", false},
+		{"a/b/c/d/e/f/g/h", true},
+		{"a/b/c/d/e/f/g/h/i", false},
 	}
 
 	for _, test := range tests {
@@ -88,3 +100,65 @@ func TestEscapeHTML(t *testing.T) {
 		}
 	}
 }
+
+// TestClientIP tests client IP extraction and resistance to spoofed forwarding headers
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name    string
+		trusted string
+		remote  string
+		headers map[string][]string
+		want    string
+	}{
+		{"direct client", "", "203.0.113.7:5555", nil, "203.0.113.7"},
+		{"spoofed XFF from untrusted peer", "", "203.0.113.7:5555", map[string][]string{"X-Forwarded-For": {"1.2.3.4"}}, "203.0.113.7"},
+		{"spoofed Forwarded from untrusted peer", "", "203.0.113.7:5555", map[string][]string{"Forwarded": {"for=1.2.3.4"}}, "203.0.113.7"},
+		{"spoofed X-Real-IP from untrusted peer", "", "[2001:db8::7]:5555", map[string][]string{"X-Real-IP": {"1.2.3.4"}}, "2001:db8::7"},
+		{"trusted proxy XFF", "", "127.0.0.1:80", map[string][]string{"X-Forwarded-For": {"198.51.100.9"}}, "198.51.100.9"},
+		{"client prepends fake hop", "", "127.0.0.1:80", map[string][]string{"X-Forwarded-For": {"1.2.3.4, 198.51.100.9"}}, "198.51.100.9"},
+		{"proxy chain", "10.0.0.0/8", "10.0.0.2:80", map[string][]string{"X-Forwarded-For": {"1.2.3.4, 198.51.100.9, 10.1.1.1"}}, "198.51.100.9"},
+		{"untrusted proxy in chain", "10.0.0.0/8", "10.0.0.2:80", map[string][]string{"X-Forwarded-For": {"198.51.100.9, 203.0.113.50"}}, "203.0.113.50"},
+		{"multiple XFF headers", "10.0.0.0/8", "10.0.0.2:80", map[string][]string{"X-Forwarded-For": {"1.2.3.4", "198.51.100.9, 10.1.1.1"}}, "198.51.100.9"},
+		{"all hops trusted", "127.0.0.0/8, 10.0.0.0/8, 192.168.0.0/16", "127.0.0.1:80", map[string][]string{"X-Forwarded-For": {"192.168.1.5, 10.1.1.1"}}, "192.168.1.5"},
+		{"XFF with port", "", "[::1]:80", map[string][]string{"X-Forwarded-For": {"198.51.100.9:4711"}}, "198.51.100.9"},
+		{"Forwarded preferred over XFF", "", "127.0.0.1:80", map[string][]string{"Forwarded": {"for=198.51.100.9"}, "X-Forwarded-For": {"1.2.3.4"}}, "198.51.100.9"},
+		{"Forwarded multiple elements", "10.0.0.0/8", "10.0.0.2:80", map[string][]string{"Forwarded": {`for=1.2.3.4, for=198.51.100.9;proto=https, for=10.1.1.1;by=10.0.0.2`}}, "198.51.100.9"},
+		{"Forwarded multiple headers", "", "127.0.0.1:80", map[string][]string{"Forwarded": {"for=1.2.3.4", "For=198.51.100.9"}}, "198.51.100.9"},
+		{"Forwarded IPv6 with port", "", "127.0.0.1:80", map[string][]string{"Forwarded": {`for="[2001:db8:cafe::17]:4711"`}}, "2001:db8:cafe::17"},
+		{"Forwarded quoted comma", "", "127.0.0.1:80", map[string][]string{"Forwarded": {`for=1.2.3.4;ext="a,b", for=198.51.100.9`}}, "198.51.100.9"},
+		{"Forwarded obfuscated identifier", "", "127.0.0.1:80", map[string][]string{"Forwarded": {"for=198.51.100.9, for=_hidden"}}, "_hidden"},
+		{"Forwarded unknown", "", "127.0.0.1:80", map[string][]string{"Forwarded": {"for=unknown"}}, "unknown"},
+		{"no trusted proxies", "none", "127.0.0.1:80", map[string][]string{"X-Forwarded-For": {"198.51.100.9"}}, "127.0.0.1"},
+		{"public proxy configured", "203.0.113.0/24", "203.0.113.1:80", map[string][]string{"X-Forwarded-For": {"198.51.100.9"}}, "198.51.100.9"},
+		{"default ranges replaced", "203.0.113.0/24", "10.0.0.2:80", map[string][]string{"X-Forwarded-For": {"198.51.100.9"}}, "10.0.0.2"},
+		{"trusted proxy without headers", "", "127.0.0.1:80", nil, "127.0.0.1"},
+		{"private peer untrusted by default", "", "172.17.0.1:80", map[string][]string{"X-Forwarded-For": {"198.51.100.9"}}, "172.17.0.1"},
+		{"private proxy configured", "172.16.0.0/12", "172.17.0.1:80", map[string][]string{"X-Forwarded-For": {"198.51.100.9"}}, "198.51.100.9"},
+		{"remote without port", "", "198.51.100.9", nil, "198.51.100.9"},
+		{"Unix socket proxy", "", "@", map[string][]string{"X-Forwarded-For": {"198.51.100.9"}}, "198.51.100.9"},
+		{"Unix socket without trusted proxies", "none", "@", map[string][]string{"X-Forwarded-For": {"198.51.100.9"}}, "@"},
+	}
+
+	defer func(saved []netip.Prefix) { trustedProxies = saved }(trustedProxies)
+	for _, test := range tests {
+		t.Setenv("TRUSTED_PROXIES", test.trusted)
+		cfg, _, err := LoadConfig(nil, os.Getenv)
+		if err != nil {
+			t.Fatalf("%s: failed to load configuration: %v", test.name, err)
+		}
+		if trustedProxies, err = ParseTrustedProxies(cfg.TrustedProxies); err != nil {
+			t.Fatalf("%s: failed to parse trusted proxies: %v", test.name, err)
+		}
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = test.remote
+		for name, values := range test.headers {
+			for _, v := range values {
+				req.Header.Add(name, v)
+			}
+		}
+		if got := ClientIP(req); got != test.want {
+			t.Errorf("%s: ClientIP = %q, expected %q", test.name, got, test.want)
+		}
+	}
+}
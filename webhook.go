@@ -0,0 +1,533 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default webhook delivery settings
+const (
+	DefaultWebhookQueueSize   = 100
+	DefaultWebhookMaxAttempts = 5
+	DefaultWebhookTimeout     = 10 * time.Second
+)
+
+const (
+	webhookWorkers    = 4               // concurrent deliveries
+	webhookBackoff    = time.Second     // delay before the first retry, doubled for each further one
+	maxWebhookBackoff = 5 * time.Minute // longest delay between retries
+	webhookLogSize    = 100             // deliveries kept in the delivery log
+)
+
+// Headers of webhook requests
+const (
+	WebhookEventHeader     = "X-Note-Event"
+	WebhookDeliveryHeader  = "X-Note-Delivery"
+	WebhookSignatureHeader = "X-Note-Signature" // sha256=<hex HMAC-SHA256 of the body>
+)
+
+// WebhooksConfig configures outgoing webhooks; they are disabled without
+// subscriptions
+type WebhooksConfig struct {
+	Subscriptions []WebhookSubscription `yaml:"subscriptions"`
+	Secret        string                `yaml:"secret"`       // HMAC key of subscriptions without their own
+	QueueSize     int                   `yaml:"queue_size"`   // deliveries waiting for a worker; more are dropped
+	MaxAttempts   int                   `yaml:"max_attempts"` // attempts per delivery, including the first
+	Timeout       time.Duration         `yaml:"timeout"`      // per attempt
+}
+
+// WebhookSubscription sends the events of matching notes to a URL
+type WebhookSubscription struct {
+	URL     string          `yaml:"url"`
+	Notes   []string        `yaml:"notes,omitempty"`   // note IDs, or namespaces ending in "/"; empty for all notes
+	Events  []NoteEventType `yaml:"events,omitempty"`  // empty for all events
+	Sources []string        `yaml:"sources,omitempty"` // "app" or "s3"; empty for both
+	Secret  string          `yaml:"secret,omitempty"`  // overrides webhooks.secret
+}
+
+// matches reports whether event should be sent to the subscription
+func (s WebhookSubscription) matches(event NoteEvent) bool {
+	if len(s.Events) > 0 && !slices.Contains(s.Events, event.Type) {
+		return false
+	}
+	if len(s.Sources) > 0 && !slices.Contains(s.Sources, event.Source) {
+		return false
+	}
+	if len(s.Notes) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(s.Notes, func(note string) bool {
+		if strings.HasSuffix(note, "/") {
+			return strings.HasPrefix(event.NoteID, note)
+		}
+		return note == event.NoteID
+	})
+}
+
+// validate checks the URL and filters of the subscription
+func (s WebhookSubscription) validate() error {
+	if u, err := url.Parse(s.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid URL %q (expected http(s)://host[/path])", s.URL)
+	}
+	for _, note := range s.Notes {
+		if !ValidateNoteID(strings.TrimSuffix(note, "/")) {
+			return fmt.Errorf("invalid note ID or namespace %q", note)
+		}
+	}
+	for _, event := range s.Events {
+		if event != NoteCreated && event != NoteUpdated && event != NoteDeleted {
+			return fmt.Errorf("unknown event %q (expected created, updated or deleted)", event)
+		}
+	}
+	for _, source := range s.Sources {
+		if source != "app" && source != "s3" && source != "ttl" {
+			return fmt.Errorf("unknown source %q (expected app, s3 or ttl)", source)
+		}
+	}
+	return nil
+}
+
+// validate checks every subscription and the delivery settings
+func (c WebhooksConfig) validate() error {
+	var errs []error
+	for _, s := range c.Subscriptions {
+		if err := s.validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.QueueSize <= 0 {
+		errs = append(errs, fmt.Errorf("invalid queue size %d", c.QueueSize))
+	}
+	if c.MaxAttempts <= 0 {
+		errs = append(errs, fmt.Errorf("invalid number of attempts %d", c.MaxAttempts))
+	}
+	if c.Timeout <= 0 {
+		errs = append(errs, fmt.Errorf("invalid timeout %s", c.Timeout))
+	}
+	return errors.Join(errs...)
+}
+
+// ParseWebhookSubscriptions parses space-separated [notes=]url entries, where
+// notes is a comma-separated list of note IDs and namespaces ending in "/",
+// e.g. "infra/,runbook=https://ci.example.com/hook"
+func ParseWebhookSubscriptions(spec string) []WebhookSubscription {
+	subscriptions := []WebhookSubscription{}
+	for _, entry := range strings.Fields(spec) {
+		s := WebhookSubscription{URL: entry}
+		// A "=" before the scheme separates notes; later ones belong to the URL
+		if notes, rawURL, ok := strings.Cut(entry, "="); ok && !strings.Contains(notes, "://") {
+			s.URL = rawURL
+			s.Notes = splitList(notes)
+		}
+		subscriptions = append(subscriptions, s)
+	}
+	return subscriptions
+}
+
+// formatWebhookSubscriptions formats subscriptions as parsed by
+// ParseWebhookSubscriptions, leaving out events, sources and secrets
+func formatWebhookSubscriptions(subscriptions []WebhookSubscription) string {
+	entries := make([]string, len(subscriptions))
+	for i, s := range subscriptions {
+		entries[i] = s.URL
+		if len(s.Notes) > 0 {
+			entries[i] = strings.Join(s.Notes, ",") + "=" + s.URL
+		}
+	}
+	return strings.Join(entries, " ")
+}
+
+// WebhookDelivery is an entry of the delivery log
+type WebhookDelivery struct {
+	ID       string        `json:"id"`
+	URL      string        `json:"url"`
+	Event    NoteEventType `json:"event"`
+	NoteID   string        `json:"noteId"`
+	State    string        `json:"state"` // delivered, failed or dropped
+	Attempts int           `json:"attempts"`
+	Status   int           `json:"status,omitempty"` // of the last attempt
+	Error    string        `json:"error,omitempty"`
+	Time     time.Time     `json:"time"` // when the delivery finished
+}
+
+// webhookPayload is the JSON body sent to subscribers
+type webhookPayload struct {
+	ID string `json:"id"`
+	NoteEvent
+}
+
+// webhookJob is a delivery waiting in the queue
+type webhookJob struct {
+	ctx          context.Context // of the request that changed the note, without its cancellation
+	subscription *WebhookSubscription
+	payload      webhookPayload
+}
+
+// WebhookDispatcher sends note events to subscribed URLs. Events are queued
+// and delivered by background workers with retries, so saves never wait
+// for subscribers; when the queue is full, new deliveries are dropped.
+type WebhookDispatcher struct {
+	subscriptions []WebhookSubscription
+	secret        string
+	maxAttempts   int
+	backoff       time.Duration
+	client        *http.Client
+	queue         chan webhookJob
+	stop          chan struct{} // closed to abandon retries on shutdown
+	stopOnce      sync.Once
+	workers       sync.WaitGroup
+
+	mu       sync.Mutex
+	closed   bool
+	log      []WebhookDelivery // newest last
+	pending  int               // deliveries queued or being attempted; not those waiting to retry
+	idle     chan struct{}     // closed when pending drops to zero
+	failures []error           // deliveries that failed since the last Flush
+}
+
+// globalWebhooks delivers webhooks; nil when none are configured
+var globalWebhooks *WebhookDispatcher
+
+// NewWebhookDispatcher creates a dispatcher for the configured subscriptions
+// and starts its workers. It returns nil when no subscriptions are configured.
+func NewWebhookDispatcher(cfg WebhooksConfig) (*WebhookDispatcher, error) {
+	if len(cfg.Subscriptions) == 0 {
+		return nil, nil
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	d := &WebhookDispatcher{
+		subscriptions: cfg.Subscriptions,
+		secret:        cfg.Secret,
+		maxAttempts:   cfg.MaxAttempts,
+		backoff:       webhookBackoff,
+		client:        &http.Client{Timeout: cfg.Timeout},
+		queue:         make(chan webhookJob, cfg.QueueSize),
+		stop:          make(chan struct{}),
+	}
+	for range webhookWorkers {
+		d.workers.Add(1)
+		go d.work()
+	}
+	return d, nil
+}
+
+// deliveryID returns the ID of the delivery of event to s. Events with a source
+// ID get the same delivery ID when their notification is retried, so
+// receivers can drop the repeated delivery.
+func deliveryID(event NoteEvent, s *WebhookSubscription) string {
+	if event.ID == "" {
+		return strings.ToLower(rand.Text())
+	}
+	sum := sha256.Sum256([]byte(event.Source + "\x00" + event.ID + "\x00" + s.URL))
+	return hex.EncodeToString(sum[:13])
+}
+
+// NoteChanged queues a delivery of event for every matching subscription
+func (d *WebhookDispatcher) NoteChanged(ctx context.Context, event NoteEvent) error {
+	var dropped []webhookJob
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return nil
+	}
+	for i := range d.subscriptions {
+		s := &d.subscriptions[i]
+		if !s.matches(event) {
+			continue
+		}
+		job := webhookJob{
+			ctx:          context.WithoutCancel(ctx),
+			subscription: s,
+			payload:      webhookPayload{ID: deliveryID(event, s), NoteEvent: event},
+		}
+		select {
+		case d.queue <- job:
+			d.pending++
+		default:
+			dropped = append(dropped, job)
+		}
+	}
+	d.mu.Unlock()
+
+	for _, job := range dropped {
+		slog.WarnContext(ctx, "Webhook queue full, dropping delivery", "url", redactURL(job.subscription.URL), "note", event.NoteID, "event", event.Type)
+		d.record(job, "dropped", 0, 0, errors.New("queue full"))
+	}
+	return nil
+}
+
+// work delivers queued jobs until the queue is closed
+func (d *WebhookDispatcher) work() {
+	defer d.workers.Done()
+	for job := range d.queue {
+		d.deliver(job)
+		d.settle(-1)
+	}
+}
+
+// settle adds delta to the pending deliveries, waking up Flush once none are
+// left
+func (d *WebhookDispatcher) settle(delta int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.pending += delta
+	if d.pending == 0 && d.idle != nil {
+		close(d.idle)
+		d.idle = nil
+	}
+}
+
+// deliver sends a job, retrying failed attempts with exponential backoff
+func (d *WebhookDispatcher) deliver(job webhookJob) {
+	ctx := job.ctx
+	target := redactURL(job.subscription.URL)
+	body, err := json.Marshal(job.payload)
+	if err != nil {
+		d.record(job, "failed", 0, 0, err)
+		return
+	}
+
+	delay := d.backoff
+	for attempt := 1; ; attempt++ {
+		status, err := d.send(ctx, job, body)
+		if err == nil {
+			slog.InfoContext(ctx, "Webhook delivered", "url", target, "note", job.payload.NoteID, "event", job.payload.Type, "delivery", job.payload.ID, "attempts", attempt)
+			d.record(job, "delivered", attempt, status, nil)
+			return
+		}
+		if attempt >= d.maxAttempts || !retryable(status) {
+			slog.ErrorContext(ctx, "Webhook delivery failed", "url", target, "note", job.payload.NoteID, "event", job.payload.Type, "delivery", job.payload.ID, "attempts", attempt, "error", err)
+			d.record(job, "failed", attempt, status, err)
+			return
+		}
+		slog.WarnContext(ctx, "Webhook attempt failed, retrying", "url", target, "delivery", job.payload.ID, "attempt", attempt, "retry_in", delay, "error", err)
+
+		// Flush does not wait for the backoff; in Lambda the retry happens
+		// whenever the instance runs again
+		d.settle(-1)
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+			d.settle(1)
+		case <-d.stop:
+			timer.Stop()
+			d.settle(1)
+			d.record(job, "failed", attempt, status, fmt.Errorf("shut down before retrying: %w", err))
+			return
+		}
+		delay = min(delay*2, maxWebhookBackoff)
+	}
+}
+
+// send makes one delivery attempt, returning the response status
+func (d *WebhookDispatcher) send(ctx context.Context, job webhookJob, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, job.subscription.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "note-webhook/"+Version)
+	req.Header.Set(WebhookEventHeader, string(job.payload.Type))
+	req.Header.Set(WebhookDeliveryHeader, job.payload.ID)
+	secret := job.subscription.Secret
+	if secret == "" {
+		secret = d.secret
+	}
+	if secret != "" {
+		req.Header.Set(WebhookSignatureHeader, SignWebhook(secret, body))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxSmallBodySize))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// retryable reports whether an attempt ending with status may succeed later;
+// 0 stands for network errors
+func retryable(status int) bool {
+	return status == 0 || status == http.StatusRequestTimeout || status == http.StatusTooManyRequests || status >= 500
+}
+
+// SignWebhook returns the signature header of a webhook body
+func SignWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// record adds a finished delivery to the log, dropping the oldest entry
+// when it is full
+func (d *WebhookDispatcher) record(job webhookJob, state string, attempts int, status int, err error) {
+	entry := WebhookDelivery{
+		ID:       job.payload.ID,
+		URL:      redactURL(job.subscription.URL),
+		Event:    job.payload.Type,
+		NoteID:   job.payload.NoteID,
+		State:    state,
+		Attempts: attempts,
+		Status:   status,
+		Time:     time.Now(),
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.log) >= webhookLogSize {
+		d.log = slices.Delete(d.log, 0, len(d.log)-webhookLogSize+1)
+	}
+	d.log = append(d.log, entry)
+	if err != nil && len(d.failures) < webhookLogSize {
+		d.failures = append(d.failures, fmt.Errorf("webhook %s to %s %s: %w", entry.ID, entry.URL, state, err))
+	}
+}
+
+// Deliveries returns the delivery log, newest first
+func (d *WebhookDispatcher) Deliveries() []WebhookDelivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	deliveries := slices.Clone(d.log)
+	slices.Reverse(deliveries)
+	return deliveries
+}
+
+// Queued returns the number of deliveries waiting for a worker
+func (d *WebhookDispatcher) Queued() int {
+	if d == nil {
+		return 0
+	}
+	return len(d.queue)
+}
+
+// Flush waits until queued deliveries were attempted or ctx ends, and returns
+// the failures since the previous Flush. Retries waiting for their backoff
+// are not waited for. Lambda uses it to send deliveries before the instance
+// is frozen. A nil dispatcher has nothing to flush.
+func (d *WebhookDispatcher) Flush(ctx context.Context) error {
+	if d == nil {
+		return nil
+	}
+	d.mu.Lock()
+	var idle chan struct{}
+	if d.pending > 0 {
+		if d.idle == nil {
+			d.idle = make(chan struct{})
+		}
+		idle = d.idle
+	}
+	d.mu.Unlock()
+
+	var errs []error
+	if idle != nil {
+		select {
+		case <-idle:
+		case <-ctx.Done():
+			errs = append(errs, fmt.Errorf("webhook deliveries still pending: %w", ctx.Err()))
+		}
+	}
+	d.mu.Lock()
+	errs = append(errs, d.failures...)
+	d.failures = nil
+	d.mu.Unlock()
+	return errors.Join(errs...)
+}
+
+// Close stops accepting events and waits until queued deliveries are sent or
+// ctx ends, after which pending retries are abandoned. A nil dispatcher is
+// closed already.
+func (d *WebhookDispatcher) Close(ctx context.Context) error {
+	if d == nil {
+		return nil
+	}
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		close(d.queue)
+	}
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		d.stopOnce.Do(func() { close(d.stop) })
+		<-done
+		return ctx.Err()
+	}
+}
+
+// redactURL removes credentials and the query from a URL for logs, as
+// receivers often take a secret token there
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "invalid URL"
+	}
+	u.User = nil
+	if u.RawQuery != "" {
+		u.RawQuery = redactedValue
+	}
+	return u.String()
+}
+
+// webhookLogResponse is the response of GET /webhooks
+type webhookLogResponse struct {
+	Queued     int               `json:"queued"`
+	Deliveries []WebhookDelivery `json:"deliveries"`
+}
+
+// HandleWebhooks serves the webhook delivery log to admins
+func HandleWebhooks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if principal := PrincipalFromContext(r.Context()); !principal.HasScope(ScopeAdmin) {
+		slog.WarnContext(r.Context(), "Webhook log denied", "principal", principal.String(), "ip", ClientIP(r))
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	resp := webhookLogResponse{Deliveries: []WebhookDelivery{}}
+	if globalWebhooks != nil {
+		resp.Queued = globalWebhooks.Queued()
+		resp.Deliveries = globalWebhooks.Deliveries()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookReceiver records webhook requests, failing the first ones
+type webhookReceiver struct {
+	mu       sync.Mutex
+	failures int // requests answered with 503 before succeeding
+	requests []*http.Request
+	bodies   [][]byte
+	release  chan struct{} // when set, requests wait for it to close
+	waiting  int
+}
+
+func (rc *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if rc.release != nil {
+		rc.mu.Lock()
+		rc.waiting++
+		rc.mu.Unlock()
+		<-rc.release
+	}
+	body, _ := io.ReadAll(r.Body)
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.requests = append(rc.requests, r)
+	rc.bodies = append(rc.bodies, body)
+	if len(rc.requests) <= rc.failures {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+}
+
+// newTestWebhooks returns a dispatcher for subscriptions with fast retries,
+// closed at the end of the test
+func newTestWebhooks(t *testing.T, cfg WebhooksConfig) *WebhookDispatcher {
+	t.Helper()
+	if cfg.QueueSize == 0 {
+		cfg.QueueSize = DefaultWebhookQueueSize
+	}
+	cfg.MaxAttempts = max(cfg.MaxAttempts, 1)
+	cfg.Timeout = time.Second
+	d, err := NewWebhookDispatcher(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create dispatcher: %v", err)
+	}
+	d.backoff = time.Millisecond
+	t.Cleanup(func() { _ = d.Close(context.Background()) })
+	return d
+}
+
+// TestParseWebhookSubscriptions tests the WEBHOOKS format
+func TestParseWebhookSubscriptions(t *testing.T) {
+	spec := "https://chat.example.com/hook?token=a=b infra/,runbook=https://ci.example.com/hook"
+	subscriptions := ParseWebhookSubscriptions(spec)
+	if len(subscriptions) != 2 {
+		t.Fatalf("Expected two subscriptions, got %+v", subscriptions)
+	}
+	if s := subscriptions[0]; s.URL != "https://chat.example.com/hook?token=a=b" || len(s.Notes) != 0 {
+		t.Errorf("Unexpected global subscription %+v", s)
+	}
+	if s := subscriptions[1]; s.URL != "https://ci.example.com/hook" || strings.Join(s.Notes, " ") != "infra/ runbook" {
+		t.Errorf("Unexpected note subscription %+v", s)
+	}
+	if formatted := formatWebhookSubscriptions(subscriptions); formatted != spec {
+		t.Errorf("Expected %q, got %q", spec, formatted)
+	}
+}
+
+// TestWebhookSubscriptionMatches tests the note, event and source filters
+func TestWebhookSubscriptionMatches(t *testing.T) {
+	s := WebhookSubscription{Notes: []string{"infra/", "runbook"}, Events: []NoteEventType{NoteUpdated}, Sources: []string{"app"}}
+	tests := []struct {
+		event NoteEvent
+		match bool
+	}{
+		{NoteEvent{Type: NoteUpdated, NoteID: "runbook", Source: "app"}, true},
+		{NoteEvent{Type: NoteUpdated, NoteID: "infra/dns/cutover", Source: "app"}, true},
+		{NoteEvent{Type: NoteUpdated, NoteID: "runbook2", Source: "app"}, false},
+		{NoteEvent{Type: NoteUpdated, NoteID: "infrastructure", Source: "app"}, false},
+		{NoteEvent{Type: NoteDeleted, NoteID: "runbook", Source: "app"}, false},
+		{NoteEvent{Type: NoteUpdated, NoteID: "runbook", Source: "s3"}, false},
+	}
+	for _, test := range tests {
+		if match := s.matches(test.event); match != test.match {
+			t.Errorf("%+v: expected match %v", test.event, test.match)
+		}
+	}
+	if !(WebhookSubscription{}).matches(NoteEvent{Type: NoteCreated, NoteID: "ABCDE", Source: "s3"}) {
+		t.Errorf("Expected subscription without filters to match every event")
+	}
+}
+
+// TestWebhookDelivery tests signed payloads, retries and the delivery log
+func TestWebhookDelivery(t *testing.T) {
+	receiver := &webhookReceiver{failures: 2}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	d := newTestWebhooks(t, WebhooksConfig{
+		Subscriptions: []WebhookSubscription{{URL: server.URL + "/hook?token=secret"}},
+		Secret:        "signing-key",
+		MaxAttempts:   3,
+	})
+	event := NoteEvent{Type: NoteUpdated, NoteID: "infra/dns/cutover", Size: 5, Source: "app", Time: time.Now()}
+	if err := d.NoteChanged(context.Background(), event); err != nil {
+		t.Fatalf("NoteChanged failed: %v", err)
+	}
+	_ = d.Close(context.Background())
+
+	if len(receiver.requests) != 3 {
+		t.Fatalf("Expected delivery on the third attempt, got %d requests", len(receiver.requests))
+	}
+	req, body := receiver.requests[2], receiver.bodies[2]
+	if req.Header.Get(WebhookSignatureHeader) != SignWebhook("signing-key", body) || !strings.HasPrefix(req.Header.Get(WebhookSignatureHeader), "sha256=") {
+		t.Errorf("Invalid signature %q", req.Header.Get(WebhookSignatureHeader))
+	}
+	var payload webhookPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatalf("Invalid payload %s: %v", body, err)
+	}
+	if payload.NoteID != event.NoteID || payload.Type != NoteUpdated || payload.ID == "" || payload.ID != req.Header.Get(WebhookDeliveryHeader) {
+		t.Errorf("Unexpected payload %s", body)
+	}
+	// Every attempt is the same delivery
+	if !bytes.Equal(receiver.bodies[0], body) {
+		t.Errorf("Expected retries to resend the payload")
+	}
+
+	deliveries := d.Deliveries()
+	if len(deliveries) != 1 {
+		t.Fatalf("Expected one logged delivery, got %+v", deliveries)
+	}
+	if entry := deliveries[0]; entry.State != "delivered" || entry.Attempts != 3 || entry.Status != http.StatusOK || strings.Contains(entry.URL, "secret") {
+		t.Errorf("Unexpected log entry %+v", entry)
+	}
+}
+
+// TestWebhookFailure tests that deliveries give up after the last attempt
+// and on client errors
+func TestWebhookFailure(t *testing.T) {
+	receiver := &webhookReceiver{failures: 10}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	rejecting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer rejecting.Close()
+
+	d := newTestWebhooks(t, WebhooksConfig{
+		Subscriptions: []WebhookSubscription{{URL: server.URL}, {URL: rejecting.URL}},
+		MaxAttempts:   2,
+	})
+	_ = d.NoteChanged(context.Background(), NoteEvent{Type: NoteDeleted, NoteID: "ABCDE", Source: "app"})
+	_ = d.Close(context.Background())
+
+	if len(receiver.requests) != 2 || receiver.requests[0].Header.Get(WebhookSignatureHeader) != "" {
+		t.Errorf("Expected two unsigned attempts, got %d", len(receiver.requests))
+	}
+	for _, entry := range d.Deliveries() {
+		expected := map[int]int{http.StatusServiceUnavailable: 2, http.StatusBadRequest: 1}[entry.Status]
+		if entry.State != "failed" || entry.Attempts != expected || entry.Error == "" {
+			t.Errorf("Unexpected log entry %+v", entry)
+		}
+	}
+}
+
+// TestWebhookFlushSkipsRetries tests that Flush waits for first attempts but
+// not for retries waiting for their backoff
+func TestWebhookFlushSkipsRetries(t *testing.T) {
+	receiver := &webhookReceiver{failures: 1}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	d := newTestWebhooks(t, WebhooksConfig{
+		Subscriptions: []WebhookSubscription{{URL: server.URL}},
+		MaxAttempts:   2,
+	})
+	d.backoff = time.Hour
+	_ = d.NoteChanged(context.Background(), NoteEvent{Type: NoteUpdated, NoteID: "ABCDE", Source: "app"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := d.Flush(ctx); err != nil {
+		t.Errorf("Expected flush without failures, got %v", err)
+	}
+	receiver.mu.Lock()
+	attempts := len(receiver.requests)
+	receiver.mu.Unlock()
+	if attempts != 1 || ctx.Err() != nil {
+		t.Errorf("Expected flush after the first attempt, got %d attempts, %v", attempts, ctx.Err())
+	}
+	// Shutting down abandons the retry
+	cancel()
+	_ = d.Close(ctx)
+}
+
+// TestWebhookQueueFull tests that a slow receiver never blocks saves
+func TestWebhookQueueFull(t *testing.T) {
+	receiver := &webhookReceiver{release: make(chan struct{})}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	release := sync.OnceFunc(func() { close(receiver.release) })
+	defer release()
+
+	d := newTestWebhooks(t, WebhooksConfig{
+		Subscriptions: []WebhookSubscription{{URL: server.URL}},
+		QueueSize:     1,
+	})
+	notify := func() {
+		_ = d.NoteChanged(context.Background(), NoteEvent{Type: NoteUpdated, NoteID: "ABCDE", Source: "app"})
+	}
+	// Every worker gets stuck on a delivery
+	start := time.Now()
+	for i := 1; i <= webhookWorkers; i++ {
+		notify()
+		for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+			receiver.mu.Lock()
+			waiting := receiver.waiting
+			receiver.mu.Unlock()
+			if waiting == i {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected %d deliveries in progress, got %d", i, waiting)
+			}
+		}
+	}
+	// One more event waits in the queue, the rest is dropped
+	for range 3 {
+		notify()
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected events to be queued without waiting, took %s", elapsed)
+	}
+	if queued := d.Queued(); queued != 1 {
+		t.Errorf("Expected one queued delivery, got %d", queued)
+	}
+	release()
+	_ = d.Close(context.Background())
+
+	states := map[string]int{}
+	for _, entry := range d.Deliveries() {
+		states[entry.State]++
+	}
+	if states["delivered"] != webhookWorkers+1 || states["dropped"] != 2 {
+		t.Errorf("Unexpected deliveries %v", states)
+	}
+}
+
+// TestHandlePostNotifies tests that saves report created, updated and
+// deleted notes
+func TestHandlePostNotifies(t *testing.T) {
+	observer := &recordingObserver{}
+	noteObservers = []NoteObserver{observer}
+	t.Cleanup(func() { noteObservers = nil })
+	storage := NewMockStorage()
+	handler := HandlePost(storage)
+
+	var saved NoteResponse
+	for _, payload := range []NoteRequest{
+		{NoteID: "runbook", Content: "first"},
+		{NoteID: "runbook", Content: "second"},
+		{NoteID: "runbook", Content: ""},
+	} {
+		payload.EditToken = saved.EditToken
+		body, _ := json.Marshal(payload)
+		req := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Save failed with %d: %s", rec.Code, rec.Body.String())
+		}
+		if saved.EditToken == "" {
+			_ = json.Unmarshal(rec.Body.Bytes(), &saved)
+		}
+	}
+
+	expected := []NoteEventType{NoteCreated, NoteUpdated, NoteDeleted}
+	if len(observer.events) != len(expected) {
+		t.Fatalf("Expected %v, got %+v", expected, observer.events)
+	}
+	for i, event := range observer.events {
+		if event.Type != expected[i] || event.NoteID != "runbook" || event.Source != "app" {
+			t.Errorf("Unexpected event %+v", event)
+		}
+	}
+	if observer.events[1].Size != int64(len("second")) || observer.events[2].Size != 0 {
+		t.Errorf("Unexpected sizes %+v", observer.events)
+	}
+}
+
+// TestHandleWebhooks tests that only admins see the delivery log
+func TestHandleWebhooks(t *testing.T) {
+	tests := []struct {
+		principal *Principal
+		status    int
+	}{
+		{nil, http.StatusForbidden},
+		{&Principal{Name: "ci", Scopes: []Scope{ScopeRead, ScopeWrite}}, http.StatusForbidden},
+		{&Principal{Name: "ops", Scopes: []Scope{ScopeAdmin}}, http.StatusOK},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/webhooks", nil)
+		req = req.WithContext(withPrincipal(req.Context(), test.principal))
+		rec := httptest.NewRecorder()
+		HandleWebhooks(rec, req)
+		if rec.Code != test.status {
+			t.Errorf("%s: expected %d, got %d", test.principal.String(), test.status, rec.Code)
+		}
+		if test.status == http.StatusOK && !strings.Contains(rec.Body.String(), `"deliveries":[]`) {
+			t.Errorf("Unexpected log %s", rec.Body.String())
+		}
+	}
+}